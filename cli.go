@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	controller "github.com/nirmal/crm/controllers"
+	helper "github.com/nirmal/crm/helpers"
+)
+
+const cliUsage = `usage:
+  %[1]s                                       run the API server
+  %[1]s backup [-org ORG_ID] [-o FILE]        write a backup archive
+  %[1]s restore [-force] [-new-org NAME] [-dry-run] FILE
+                                              restore a backup archive
+  %[1]s assign-org [-org ORG_ID | -name NAME]  give records without an organization to
+                                              one, "Default" created when missing
+  %[1]s create-super-admin -name NAME -email EMAIL
+                                              create a SUPER_ADMIN, the password is
+                                              read from SUPER_ADMIN_PASSWORD
+`
+
+// runCommand : run a maintenance command given on the command line and return the exit code
+func runCommand(args []string) int {
+	switch args[0] {
+	case "backup":
+		return backupCommand(args[1:])
+	case "restore":
+		return restoreCommand(args[1:])
+	case "assign-org":
+		return assignOrgCommand(args[1:])
+	case "create-super-admin":
+		return createSuperAdminCommand(args[1:])
+	}
+	fmt.Fprintf(os.Stderr, cliUsage, os.Args[0])
+	return 2
+}
+
+func backupCommand(args []string) int {
+	flags := flag.NewFlagSet("backup", flag.ContinueOnError)
+	orgId := flags.String("org", "", "only back up this organization")
+	output := flags.String("o", helper.BackupFileName(time.Now()), "archive file to write")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	file, err := os.Create(*output)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	manifest, err := helper.WriteBackup(context.Background(), file, *orgId)
+	if err == nil {
+		err = file.Close()
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(*output)
+		fmt.Fprintln(os.Stderr, "backup failed:", err)
+		return 1
+	}
+
+	for _, collection := range manifest.Collections {
+		fmt.Printf("%-16s %d\n", collection.Name, collection.Count)
+	}
+	fmt.Println("backup written to", *output)
+	return 0
+}
+
+func restoreCommand(args []string) int {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	var opts helper.RestoreOptions
+	flags.BoolVar(&opts.Force, "force", false, "replace records already in the restore scope")
+	flags.StringVar(&opts.NewOrgName, "new-org", "", "restore an organization backup as a new organization with this name")
+	flags.BoolVar(&opts.DryRun, "dry-run", false, "only validate the archive and the target")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, cliUsage, os.Args[0])
+		return 2
+	}
+	if opts.Force && opts.NewOrgName != "" {
+		fmt.Fprintln(os.Stderr, "-force cannot be combined with -new-org")
+		return 2
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer file.Close()
+
+	result, err := helper.RestoreBackup(context.Background(), file, opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "restore failed:", err)
+		return 1
+	}
+	out, _ := json.MarshalIndent(result, "", "  ")
+	fmt.Println(string(out))
+	return 0
+}
+
+func createSuperAdminCommand(args []string) int {
+	flags := flag.NewFlagSet("create-super-admin", flag.ContinueOnError)
+	name := flags.String("name", "", "name of the super admin")
+	email := flags.String("email", "", "email the super admin signs in with")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	// the password stays out of the shell history and the process list
+	password := os.Getenv("SUPER_ADMIN_PASSWORD")
+	if *name == "" || *email == "" || password == "" {
+		fmt.Fprintln(os.Stderr, "-name, -email and SUPER_ADMIN_PASSWORD are required")
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	user, err := controller.CreateSuperAdmin(ctx, *name, *email, password)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "create-super-admin failed:", err)
+		return 1
+	}
+	fmt.Println("super admin created with user_id", user.UserId)
+	return 0
+}
+
+func assignOrgCommand(args []string) int {
+	flags := flag.NewFlagSet("assign-org", flag.ContinueOnError)
+	orgId := flags.String("org", "", "existing organization to assign the records to")
+	name := flags.String("name", helper.DEFAULT_ORG_NAME, "organization to assign the records to, created when missing")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	result, err := helper.AssignOrganization(context.Background(), *orgId, *name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "assign-org failed:", err)
+		return 1
+	}
+	out, _ := json.MarshalIndent(result, "", "  ")
+	fmt.Println(string(out))
+	return 0
+}
@@ -0,0 +1,712 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/nirmal/crm/database"
+	helper "github.com/nirmal/crm/helpers"
+	"github.com/nirmal/crm/models"
+	"github.com/nirmal/crm/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxAccountDepth : deepest parent chain followed when checking an account hierarchy
+const maxAccountDepth = 32
+
+var AccountValidate = validator.New()
+var AccountCollection *mongo.Collection = database.OpenCollection("Cluster0", "accounts")
+
+// normalizeAccount : trim the name and reduce the domain to its lower case host name,
+// so "https://www.Acme.com/" and "acme.com" are the same account
+func normalizeAccount(account *models.Account) {
+	if account.Name != nil {
+		name := strings.TrimSpace(*account.Name)
+		account.Name = &name
+	}
+	if account.Domain != nil {
+		domain := strings.ToLower(strings.TrimSpace(*account.Domain))
+		domain = strings.TrimPrefix(strings.TrimPrefix(domain, "https://"), "http://")
+		domain, _, _ = strings.Cut(domain, "/")
+		domain = strings.TrimPrefix(domain, "www.")
+		account.Domain = &domain
+		if domain == "" {
+			account.Domain = nil
+		}
+	}
+}
+
+// checkAccountUnique : names (ignoring case) and domains identify one account per organization
+func checkAccountUnique(ctx context.Context, account models.Account) error {
+	conditions := bson.A{bson.M{"name": primitive.Regex{Pattern: "^" + regexp.QuoteMeta(*account.Name) + "$", Options: "i"}}}
+	if account.Domain != nil {
+		conditions = append(conditions, bson.M{"domain": *account.Domain})
+	}
+	filter := helper.NotDeleted(bson.M{utils.ORG_ID: account.OrgId, utils.ACCOUNT_ID: bson.M{"$ne": account.AccountId}, "$or": conditions})
+	var existing models.Account
+	err := AccountCollection.FindOne(ctx, filter).Decode(&existing)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("account %s already exists as %s", *account.Name, existing.AccountId)
+}
+
+// checkParentAccount : the parent must be a live account of the same organization, and
+// account may not end up among its own ancestors
+func checkParentAccount(ctx context.Context, account models.Account) error {
+	parentId := *account.ParentAccountId
+	for depth := 0; parentId != ""; depth++ {
+		if parentId == account.AccountId {
+			return fmt.Errorf("account cannot be its own parent")
+		}
+		if depth == maxAccountDepth {
+			return fmt.Errorf("account hierarchy is deeper than %d levels", maxAccountDepth)
+		}
+		var parent models.Account
+		if err := AccountCollection.FindOne(ctx, helper.NotDeleted(bson.M{utils.ORG_ID: account.OrgId, utils.ACCOUNT_ID: parentId})).Decode(&parent); err != nil {
+			return fmt.Errorf("parent account %s not found", parentId)
+		}
+		parentId = ""
+		if parent.ParentAccountId != nil {
+			parentId = *parent.ParentAccountId
+		}
+	}
+	return nil
+}
+
+// checkAccountOwner : the owner of an account must be a live user of its organization
+func checkAccountOwner(ctx context.Context, account models.Account) error {
+	count, err := UserCollection.CountDocuments(ctx, helper.NotDeleted(bson.M{utils.ORG_ID: account.OrgId, utils.USER_ID: *account.OwnerId}))
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return fmt.Errorf("owner %s not found", *account.OwnerId)
+	}
+	return nil
+}
+
+// accountTree : account and, with children, every live account below it
+func accountTree(ctx context.Context, account models.Account, children bool) ([]string, error) {
+	ids := []string{account.AccountId}
+	level := ids
+	for depth := 0; children && len(level) > 0 && depth < maxAccountDepth; depth++ {
+		opts := options.Find().SetProjection(bson.M{utils.ACCOUNT_ID: 1})
+		cursor, err := AccountCollection.Find(ctx, helper.NotDeleted(bson.M{utils.ORG_ID: account.OrgId, "parent_account_id": bson.M{"$in": level}}), opts)
+		if err != nil {
+			return nil, err
+		}
+		var docs []models.Account
+		if err := cursor.All(ctx, &docs); err != nil {
+			return nil, err
+		}
+		level = nil
+		for _, doc := range docs {
+			level = append(level, doc.AccountId)
+		}
+		ids = append(ids, level...)
+	}
+	return ids, nil
+}
+
+// findAccount : live account of the caller's organization by the :account_id parameter
+func findAccount(ctx context.Context, c *gin.Context) (models.Account, bson.M, error) {
+	filter := helper.TenantFilter(c, helper.NotDeleted(bson.M{utils.ACCOUNT_ID: c.Param(utils.ACCOUNT_ID)}))
+	var account models.Account
+	err := AccountCollection.FindOne(ctx, filter).Decode(&account)
+	return account, filter, err
+}
+
+// CreateAccount : Create an account (only admin can access)
+func CreateAccount() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var account models.Account
+		if err := c.BindJSON(&account); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		normalizeAccount(&account)
+		if account.ParentAccountId != nil && *account.ParentAccountId == "" {
+			account.ParentAccountId = nil
+		}
+		if account.OwnerId != nil && *account.OwnerId == "" {
+			account.OwnerId = nil
+		}
+
+		if validationErr := AccountValidate.Struct(account); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		account.OrgId = helper.TenantId(c)
+		if account.OrgId == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "org_id is required"})
+			return
+		}
+		account.ID = primitive.NewObjectID()
+		account.AccountId = account.ID.Hex()
+
+		if err := checkAccountUnique(ctx, account); err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if account.ParentAccountId != nil {
+			if err := checkParentAccount(ctx, account); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		if account.OwnerId != nil {
+			if err := checkAccountOwner(ctx, account); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		account.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		account.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		account.Version = 1
+
+		if _, err := AccountCollection.InsertOne(ctx, account); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Account was not created"})
+			return
+		}
+		helper.RecordAudit(c, models.AuditLog{
+			Action:     utils.ACTION_CREATE,
+			Resource:   utils.RESOURCE_ACCOUNTS,
+			ResourceId: account.AccountId,
+		}, nil, account)
+
+		c.JSON(http.StatusCreated, account)
+	}
+}
+
+// GetAccounts : List accounts, filtered by ?search= (name or domain), ?industry= and ?parent_account_id=
+func GetAccounts() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckStaff(c); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		filter := bson.M{}
+		if search := c.Query("search"); search != "" {
+			pattern := primitive.Regex{Pattern: regexp.QuoteMeta(search), Options: "i"}
+			filter["$or"] = bson.A{bson.M{"name": pattern}, bson.M{"domain": pattern}}
+		}
+		if industry := c.Query("industry"); industry != "" {
+			filter["industry"] = primitive.Regex{Pattern: "^" + regexp.QuoteMeta(industry) + "$", Options: "i"}
+		}
+		if parentId, ok := c.GetQuery("parent_account_id"); ok {
+			// an empty parent lists the top level accounts
+			filter["parent_account_id"] = parentId
+			if parentId == "" {
+				filter["parent_account_id"] = bson.M{"$exists": false}
+			}
+		}
+
+		opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}})
+		cursor, err := AccountCollection.Find(ctx, helper.TenantFilter(c, helper.NotDeleted(filter)), opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing accounts"})
+			return
+		}
+
+		var accounts []models.Account
+		if err = cursor.All(ctx, &accounts); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while decoding account data"})
+			return
+		}
+
+		if len(accounts) == 0 {
+			c.JSON(http.StatusOK, gin.H{"error": "no accounts available"})
+			return
+		}
+
+		c.JSON(http.StatusOK, accounts)
+	}
+}
+
+// GetAccount : Get an account by ID
+func GetAccount() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckStaff(c); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		account, _, err := findAccount(ctx, c)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "account not found"})
+			return
+		}
+
+		etag := helper.ETag(account.AccountId, account.Version)
+		c.Header("ETag", etag)
+		if helper.IfNoneMatch(c, etag) {
+			c.Status(http.StatusNotModified)
+			return
+		}
+
+		c.JSON(http.StatusOK, account)
+	}
+}
+
+// UpdateAccount : Update an account, an empty parent_account_id makes it a top level account (only admin can access)
+func UpdateAccount() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		before, filter, err := findAccount(ctx, c)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "account not found"})
+			return
+		}
+		if !helper.IfMatch(c, helper.ETag(before.AccountId, before.Version)) {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": helper.ErrVersionConflict.Error()})
+			return
+		}
+
+		var changes models.Account
+		if err := c.BindJSON(&changes); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		normalizeAccount(&changes)
+
+		// the account as it will be stored, to validate it whole
+		account := before
+		updateObj := bson.M{"updated_at": time.Now()}
+		unset := bson.M{}
+		if changes.Name != nil {
+			account.Name = changes.Name
+			updateObj["name"] = changes.Name
+		}
+		if changes.Domain != nil {
+			account.Domain = changes.Domain
+			updateObj["domain"] = changes.Domain
+		}
+		if changes.Industry != nil {
+			account.Industry = changes.Industry
+			updateObj["industry"] = changes.Industry
+		}
+		if changes.Address != nil {
+			account.Address = changes.Address
+			updateObj["address"] = changes.Address
+		}
+		if changes.ParentAccountId != nil {
+			account.ParentAccountId = changes.ParentAccountId
+			if *changes.ParentAccountId == "" {
+				unset["parent_account_id"] = ""
+			} else {
+				updateObj["parent_account_id"] = changes.ParentAccountId
+			}
+		}
+		if changes.OwnerId != nil {
+			account.OwnerId = changes.OwnerId
+			if *changes.OwnerId == "" {
+				unset["owner_id"] = ""
+			} else {
+				updateObj["owner_id"] = changes.OwnerId
+			}
+		}
+
+		if validationErr := AccountValidate.Struct(account); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+		if err := checkAccountUnique(ctx, account); err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if _, ok := updateObj["parent_account_id"]; ok {
+			if err := checkParentAccount(ctx, account); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		if _, ok := updateObj["owner_id"]; ok {
+			if err := checkAccountOwner(ctx, account); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		update := bson.M{"$set": updateObj, "$inc": bson.M{"version": 1}}
+		if len(unset) > 0 {
+			update["$unset"] = unset
+		}
+		result, err := AccountCollection.UpdateOne(ctx, helper.VersionFilter(filter, before.Version), update)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while updating account"})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": helper.ErrVersionConflict.Error()})
+			return
+		}
+
+		var after models.Account
+		if err := AccountCollection.FindOne(ctx, filter).Decode(&after); err == nil {
+			c.Header("ETag", helper.ETag(after.AccountId, after.Version))
+			helper.RecordAudit(c, models.AuditLog{
+				Action:     utils.ACTION_UPDATE,
+				Resource:   utils.RESOURCE_ACCOUNTS,
+				ResourceId: after.AccountId,
+			}, before, after)
+		}
+
+		c.JSON(http.StatusOK, after)
+	}
+}
+
+// DeleteAccount : Move an account to the trash, refused while it has child accounts.
+// Contacts keep their link, so restoring the account from the trash brings it back whole (only admin can access)
+func DeleteAccount() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		before, filter, err := findAccount(ctx, c)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "account not found"})
+			return
+		}
+		if !helper.IfMatch(c, helper.ETag(before.AccountId, before.Version)) {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": helper.ErrVersionConflict.Error()})
+			return
+		}
+
+		count, err := AccountCollection.CountDocuments(ctx, helper.NotDeleted(bson.M{utils.ORG_ID: before.OrgId, "parent_account_id": before.AccountId}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while checking child accounts"})
+			return
+		}
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "account still has child accounts"})
+			return
+		}
+
+		result, err := AccountCollection.UpdateOne(ctx, helper.VersionFilter(filter, before.Version), helper.SoftDeleteUpdate(c))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while deleting account"})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": helper.ErrVersionConflict.Error()})
+			return
+		}
+		helper.RecordAudit(c, models.AuditLog{
+			Action:     utils.ACTION_DELETE,
+			Resource:   utils.RESOURCE_ACCOUNTS,
+			ResourceId: before.AccountId,
+		}, before, nil)
+
+		c.JSON(http.StatusOK, gin.H{"message": "account deleted successfully"})
+	}
+}
+
+// GetAccountContacts : List the customers linked to an account
+func GetAccountContacts() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckStaff(c); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		account, _, err := findAccount(ctx, c)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "account not found"})
+			return
+		}
+
+		opts := options.Find().SetProjection(bson.M{"password": 0, "token": 0}).SetSort(bson.D{{Key: "name", Value: 1}})
+		cursor, err := CustomerCollection.Find(ctx, helper.NotDeleted(bson.M{utils.ORG_ID: account.OrgId, utils.ACCOUNT_ID: account.AccountId}), opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing contacts"})
+			return
+		}
+
+		var customers []models.Customer
+		if err = cursor.All(ctx, &customers); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while decoding customer data"})
+			return
+		}
+
+		if len(customers) == 0 {
+			c.JSON(http.StatusOK, gin.H{"error": "no contacts available"})
+			return
+		}
+
+		c.JSON(http.StatusOK, customers)
+	}
+}
+
+// accountContactsRequest : customers to link to an account
+type accountContactsRequest struct {
+	CustomerIds []string `json:"customer_ids"`
+	// MatchDomain links the unlinked customers whose email is at the account's domain
+	MatchDomain bool `json:"match_domain"`
+}
+
+// LinkAccountContacts : Link customers to an account as its contacts, moving them from any
+// account they were linked to before (only admin can access)
+func LinkAccountContacts() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		account, _, err := findAccount(ctx, c)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "account not found"})
+			return
+		}
+
+		var request accountContactsRequest
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var conditions bson.A
+		if len(request.CustomerIds) > 0 {
+			conditions = append(conditions, bson.M{utils.CUSTOMER_ID: bson.M{"$in": request.CustomerIds}})
+		}
+		if request.MatchDomain {
+			if account.Domain == nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "account has no domain"})
+				return
+			}
+			conditions = append(conditions, bson.M{
+				"email":          primitive.Regex{Pattern: "@" + regexp.QuoteMeta(*account.Domain) + "$", Options: "i"},
+				utils.ACCOUNT_ID: bson.M{"$exists": false},
+			})
+		}
+		if len(conditions) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "customer_ids or match_domain is required"})
+			return
+		}
+
+		filter := helper.NotDeleted(bson.M{utils.ORG_ID: account.OrgId, "$or": conditions})
+		cursor, err := CustomerCollection.Find(ctx, filter, options.Find().SetProjection(bson.M{utils.CUSTOMER_ID: 1, utils.ACCOUNT_ID: 1}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while finding customers"})
+			return
+		}
+		var customers []models.Customer
+		if err = cursor.All(ctx, &customers); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while decoding customer data"})
+			return
+		}
+		if found := len(customers); found < len(request.CustomerIds) && !request.MatchDomain {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("%d of %d customers not found", len(request.CustomerIds)-found, len(request.CustomerIds))})
+			return
+		}
+
+		var linked []primitive.ObjectID
+		for _, customer := range customers {
+			if customer.AccountId != nil && *customer.AccountId == account.AccountId {
+				continue
+			}
+			update := bson.M{"$set": bson.M{utils.ACCOUNT_ID: account.AccountId, "updated_at": time.Now()}, "$inc": bson.M{"version": 1}}
+			if _, err := CustomerCollection.UpdateOne(ctx, bson.M{"_id": customer.ID}, update); err != nil {
+				rescoreCustomers(ctx, c, linked...)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while linking customers", "linked": len(linked)})
+				return
+			}
+			helper.RecordAudit(c, models.AuditLog{
+				Action:     utils.ACTION_UPDATE,
+				Resource:   utils.RESOURCE_CUSTOMERS,
+				ResourceId: customer.CustomerId,
+			}, bson.M{utils.ACCOUNT_ID: customer.AccountId}, bson.M{utils.ACCOUNT_ID: account.AccountId})
+			linked = append(linked, customer.ID)
+		}
+		// segment rules of scoring may test account_id
+		if len(linked) > 0 {
+			rescoreCustomers(ctx, c, linked...)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"linked": len(linked), "message": "contacts linked successfully"})
+	}
+}
+
+// UnlinkAccountContact : Remove a customer from an account's contacts (only admin can access)
+func UnlinkAccountContact() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		account, _, err := findAccount(ctx, c)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "account not found"})
+			return
+		}
+
+		customerId := c.Param(utils.CUSTOMER_ID)
+		filter := helper.NotDeleted(bson.M{utils.ORG_ID: account.OrgId, utils.CUSTOMER_ID: customerId, utils.ACCOUNT_ID: account.AccountId})
+		update := bson.M{"$unset": bson.M{utils.ACCOUNT_ID: ""}, "$set": bson.M{"updated_at": time.Now()}, "$inc": bson.M{"version": 1}}
+		result, err := CustomerCollection.UpdateOne(ctx, filter, update)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while unlinking customer"})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "customer is not a contact of this account"})
+			return
+		}
+		helper.RecordAudit(c, models.AuditLog{
+			Action:     utils.ACTION_UPDATE,
+			Resource:   utils.RESOURCE_CUSTOMERS,
+			ResourceId: customerId,
+		}, bson.M{utils.ACCOUNT_ID: account.AccountId}, bson.M{utils.ACCOUNT_ID: nil})
+		if id, err := primitive.ObjectIDFromHex(customerId); err == nil {
+			rescoreCustomers(ctx, c, id)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "contact unlinked successfully"})
+	}
+}
+
+// GetAccountSummary : Roll-up of an account's contacts, their interactions and tickets,
+// ?include_children=true adds the accounts below it
+func GetAccountSummary() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckStaff(c); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		account, _, err := findAccount(ctx, c)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "account not found"})
+			return
+		}
+
+		summary, err := accountSummary(ctx, account, c.Query("include_children") == "true")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, summary)
+	}
+}
+
+// accountSummary : count the contacts of the accounts and the interactions and tickets of those contacts
+func accountSummary(ctx context.Context, account models.Account, children bool) (models.AccountSummary, error) {
+	summary := models.AccountSummary{AccountId: account.AccountId, Name: *account.Name, TicketsByStatus: map[string]int64{}}
+
+	var err error
+	if summary.AccountIds, err = accountTree(ctx, account, children); err != nil {
+		return summary, err
+	}
+
+	opts := options.Find().SetProjection(bson.M{"_id": 1})
+	cursor, err := CustomerCollection.Find(ctx, helper.NotDeleted(bson.M{utils.ORG_ID: account.OrgId, utils.ACCOUNT_ID: bson.M{"$in": summary.AccountIds}}), opts)
+	if err != nil {
+		return summary, err
+	}
+	var contacts []models.Customer
+	if err := cursor.All(ctx, &contacts); err != nil {
+		return summary, err
+	}
+	summary.Contacts = int64(len(contacts))
+	if len(contacts) == 0 {
+		return summary, nil
+	}
+
+	contactIds := make([]primitive.ObjectID, len(contacts))
+	for i, contact := range contacts {
+		contactIds[i] = contact.ID
+	}
+	byContact := helper.NotDeleted(bson.M{utils.CUSTOMER_ID: bson.M{"$in": contactIds}})
+
+	if summary.Interactions, err = InteractionCollection.CountDocuments(ctx, byContact); err != nil {
+		return summary, err
+	}
+	var last models.Interaction
+	err = InteractionCollection.FindOne(ctx, byContact, options.FindOne().SetSort(bson.D{{Key: "start_time", Value: -1}})).Decode(&last)
+	if err == nil && !last.StartTime.IsZero() {
+		summary.LastInteraction = &last.StartTime
+	} else if err != nil && err != mongo.ErrNoDocuments {
+		return summary, err
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: helper.NotDeleted(bson.M{utils.CUSTOMER_ID: bson.M{"$in": contactIds}})}},
+		{{Key: "$group", Value: bson.M{"_id": "$status", "count": bson.M{"$sum": 1}}}},
+	}
+	cursor, err = TicketCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return summary, err
+	}
+	var groups []struct {
+		Status *string `bson:"_id"`
+		Count  int64   `bson:"count"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return summary, err
+	}
+	for _, group := range groups {
+		status := "none"
+		if group.Status != nil {
+			status = *group.Status
+		}
+		summary.TicketsByStatus[status] += group.Count
+		summary.Tickets += group.Count
+	}
+	return summary, nil
+}
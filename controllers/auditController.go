@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	helper "github.com/nirmal/crm/helpers"
+	"github.com/nirmal/crm/models"
+	"github.com/nirmal/crm/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GetAuditLogs : List audit entries (only admin can access)
+// filters: actor, resource, resource_id, action, from, to (RFC3339), limit
+func GetAuditLogs() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		filter := helper.TenantFilter(c, bson.M{})
+		if actor := c.Query("actor"); actor != "" {
+			filter["actor_id"] = actor
+		}
+		if resource := c.Query("resource"); resource != "" {
+			filter["resource"] = resource
+		}
+		if resourceId := c.Query("resource_id"); resourceId != "" {
+			filter["resource_id"] = resourceId
+		}
+		if action := c.Query("action"); action != "" {
+			filter["action"] = action
+		}
+
+		createdAt := bson.M{}
+		if from := c.Query("from"); from != "" {
+			fromTime, err := time.Parse(time.RFC3339, from)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from time, expected RFC3339"})
+				return
+			}
+			createdAt["$gte"] = fromTime
+		}
+		if to := c.Query("to"); to != "" {
+			toTime, err := time.Parse(time.RFC3339, to)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to time, expected RFC3339"})
+				return
+			}
+			createdAt["$lte"] = toTime
+		}
+		if len(createdAt) > 0 {
+			filter["created_at"] = createdAt
+		}
+
+		limit := int64(100)
+		if l, err := strconv.ParseInt(c.Query("limit"), 10, 64); err == nil && l > 0 && l <= 1000 {
+			limit = l
+		}
+		opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(limit)
+
+		cursor, err := helper.AuditCollection.Find(ctx, filter, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing audit logs"})
+			return
+		}
+
+		var logs []models.AuditLog
+		if err = cursor.All(ctx, &logs); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while decoding audit logs"})
+			return
+		}
+
+		if len(logs) == 0 {
+			c.JSON(http.StatusOK, gin.H{"error": "no audit logs available"})
+			return
+		}
+
+		c.JSON(http.StatusOK, logs)
+	}
+}
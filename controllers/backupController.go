@@ -0,0 +1,197 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	helper "github.com/nirmal/crm/helpers"
+	"github.com/nirmal/crm/models"
+	"github.com/nirmal/crm/utils"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// backups and restores walk whole collections, they get more time than a request
+const backupTimeout = 30 * time.Minute
+
+// backupFormat : format recorded on backup and restore jobs
+const backupFormat = "tar.gz"
+
+// Backup : Download a backup archive of the caller's organization. SUPER_ADMIN gets every
+// organization, or one with ?org_id=. ?async=true builds the archive as a background job.
+// Archives hold password hashes and must be stored as carefully as the database (only admin can access)
+func Backup() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		orgId := helper.TenantId(c)
+		if c.Query("async") == "true" {
+			job, err := startJob(c, utils.JOB_EXPORT, utils.RESOURCE_BACKUP, backupFormat, func(ctx context.Context, c *gin.Context, run *jobRun) error {
+				return runBackupJob(ctx, c, orgId, run)
+			})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			respondJobStarted(c, job)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), backupTimeout)
+		defer cancel()
+
+		c.Header("Content-Type", "application/gzip")
+		c.Header("Content-Disposition", "attachment;filename="+helper.BackupFileName(time.Now()))
+		manifest, err := helper.WriteBackup(ctx, c.Writer, orgId)
+		if err != nil {
+			// the archive is already streaming, the client gets a truncated file that fails to restore
+			log.Printf("error writing backup: %v", err)
+			return
+		}
+		recordBackupAudit(c, utils.ACTION_EXPORT, orgId, manifest, nil)
+	}
+}
+
+func runBackupJob(ctx context.Context, c *gin.Context, orgId string, run *jobRun) error {
+	file, err := run.createResult(helper.BackupFileName(time.Now()))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	manifest, err := helper.WriteBackup(ctx, file, orgId)
+	if err != nil {
+		return err
+	}
+	recordBackupAudit(c, utils.ACTION_EXPORT, orgId, manifest, nil)
+
+	counts := backupCounts(manifest)
+	for _, count := range counts {
+		run.job.Processed += count
+	}
+	run.job.Summary = map[string]interface{}{"collections": counts}
+	return file.Close()
+}
+
+// Restore : Restore an uploaded backup archive (multipart "file" or the request body).
+// Collections that already hold records in the archive's scope are refused unless ?force=true,
+// which replaces them. ?new_org=NAME restores an organization backup as a new organization,
+// ?dry_run=true only validates, ?async=true runs as a background job (only super admin can access)
+func Restore() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_SUPER_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		opts := helper.RestoreOptions{
+			Force:      c.Query("force") == "true",
+			NewOrgName: c.Query("new_org"),
+			DryRun:     c.Query("dry_run") == "true",
+		}
+		if opts.Force && opts.NewOrgName != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "force cannot be combined with new_org"})
+			return
+		}
+
+		var input io.Reader = c.Request.Body
+		if file, _, err := c.Request.FormFile("file"); err == nil {
+			defer file.Close()
+			input = file
+		}
+
+		// the archive is read several times, so it is always spooled to disk
+		upload, err := spoolJobUpload(input)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		removeUpload := func() {
+			upload.Close()
+			os.Remove(upload.Name())
+		}
+
+		if c.Query("async") == "true" {
+			job, err := startJob(c, utils.JOB_IMPORT, utils.RESOURCE_BACKUP, backupFormat, func(ctx context.Context, c *gin.Context, run *jobRun) error {
+				defer removeUpload()
+				result, err := helper.RestoreBackup(ctx, upload, opts)
+				if err != nil {
+					return err
+				}
+				if !opts.DryRun {
+					recordBackupAudit(c, utils.ACTION_RESTORE, result.OrgId, result.Manifest, restoreAuditOptions(opts))
+				}
+				for _, count := range result.Restored {
+					run.job.Processed += count
+				}
+				run.job.Summary = map[string]interface{}{"org_id": result.OrgId, "restored": result.Restored, "dry_run": opts.DryRun}
+				return nil
+			})
+			if err != nil {
+				removeUpload()
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			respondJobStarted(c, job)
+			return
+		}
+		defer removeUpload()
+
+		ctx, cancel := context.WithTimeout(context.Background(), backupTimeout)
+		defer cancel()
+
+		result, err := helper.RestoreBackup(ctx, upload, opts)
+		if err != nil {
+			status := http.StatusInternalServerError
+			switch {
+			case errors.Is(err, helper.ErrBackupInvalid):
+				status = http.StatusBadRequest
+			case errors.Is(err, helper.ErrBackupTargetNotEmpty):
+				status = http.StatusConflict
+			case errors.Is(err, helper.ErrBackupTooLarge):
+				status = http.StatusRequestEntityTooLarge
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+
+		if !opts.DryRun {
+			recordBackupAudit(c, utils.ACTION_RESTORE, result.OrgId, result.Manifest, restoreAuditOptions(opts))
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// recordBackupAudit : audit a backup or restore, with the per collection counts of the archive
+func recordBackupAudit(c *gin.Context, action, orgId string, manifest helper.BackupManifest, opts bson.M) {
+	after := bson.M{"created_at": manifest.CreatedAt, "collections": backupCounts(manifest)}
+	if opts != nil {
+		after["options"] = opts
+	}
+	helper.RecordAudit(c, models.AuditLog{
+		Action:     action,
+		Resource:   utils.RESOURCE_BACKUP,
+		ResourceId: orgId,
+		OrgId:      orgId,
+	}, nil, after)
+}
+
+func restoreAuditOptions(opts helper.RestoreOptions) bson.M {
+	return bson.M{"force": opts.Force, "new_org": opts.NewOrgName}
+}
+
+func backupCounts(manifest helper.BackupManifest) map[string]int64 {
+	counts := map[string]int64{}
+	for _, collection := range manifest.Collections {
+		counts[collection.Name] = collection.Count
+	}
+	return counts
+}
@@ -0,0 +1,525 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/nirmal/crm/database"
+	helper "github.com/nirmal/crm/helpers"
+	"github.com/nirmal/crm/models"
+	"github.com/nirmal/crm/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Custom field types
+const (
+	FIELD_TEXT    = "text"
+	FIELD_NUMBER  = "number"
+	FIELD_DATE    = "date"
+	FIELD_ENUM    = "enum"
+	FIELD_BOOLEAN = "boolean"
+)
+
+// customFieldFilterPrefix : list endpoints filter by custom fields with ?cf.<key>=,
+// number and date fields also by ?cf.<key>.min= and ?cf.<key>.max=
+const customFieldFilterPrefix = "cf."
+
+var CustomFieldValidate = validator.New()
+var CustomFieldCollection *mongo.Collection = database.OpenCollection("Cluster0", "custom_fields")
+
+var customFieldKeyFormat = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// customFieldDefinitions : custom fields of resource in organization orgId by key. Fields are
+// always those of one organization, an empty orgId (SUPER_ADMIN without ?org_id=) has none.
+// Definitions are cached on c, an import looks them up once rather than per row. c is nil
+// outside of a request, as in scheduled exports.
+func customFieldDefinitions(ctx context.Context, c *gin.Context, orgId, resource string) (map[string]models.CustomField, error) {
+	cacheKey := "custom_fields:" + orgId + ":" + resource
+	if c != nil {
+		if cached, ok := c.Get(cacheKey); ok {
+			return cached.(map[string]models.CustomField), nil
+		}
+	}
+
+	if orgId == "" {
+		return map[string]models.CustomField{}, nil
+	}
+	cursor, err := CustomFieldCollection.Find(ctx, bson.M{utils.ORG_ID: orgId, "resource": resource})
+	if err != nil {
+		return nil, err
+	}
+	var fields []models.CustomField
+	if err := cursor.All(ctx, &fields); err != nil {
+		return nil, err
+	}
+
+	definitions := make(map[string]models.CustomField, len(fields))
+	for _, field := range fields {
+		definitions[field.Key] = field
+	}
+	if c != nil {
+		c.Set(cacheKey, definitions)
+	}
+	return definitions, nil
+}
+
+// checkCustomFields : custom field values of a resource written to orgId, validated and
+// converted to their stored types. On create every required field must be set, on update
+// (partial) only the given keys are checked and null clears a value. Imports keep values
+// without a definition as text (keepUnknown), API writes reject them.
+func checkCustomFields(ctx context.Context, c *gin.Context, orgId, resource string, values map[string]interface{}, partial, keepUnknown bool) (map[string]interface{}, error) {
+	definitions, err := customFieldDefinitions(ctx, c, orgId, resource)
+	if err != nil {
+		return nil, err
+	}
+
+	checked := make(map[string]interface{}, len(values))
+	for key, value := range values {
+		field, ok := definitions[key]
+		if !ok {
+			if !keepUnknown {
+				return nil, fmt.Errorf("unknown custom field %q", key)
+			}
+			checked[key] = value
+			continue
+		}
+		if value == nil || value == "" {
+			if field.Required {
+				return nil, fmt.Errorf("custom field %s is required", key)
+			}
+			if partial {
+				checked[key] = nil
+			}
+			continue
+		}
+		converted, err := customFieldValue(field, value)
+		if err != nil {
+			return nil, err
+		}
+		checked[key] = converted
+	}
+
+	if !partial {
+		for key, field := range definitions {
+			if _, ok := checked[key]; field.Required && !ok {
+				return nil, fmt.Errorf("custom field %s is required", key)
+			}
+		}
+	}
+	if len(checked) == 0 {
+		return nil, nil
+	}
+	return checked, nil
+}
+
+// customFieldValue : value as the stored type of field, checked against its rules.
+// Text from imports and query strings is accepted for every type.
+func customFieldValue(field models.CustomField, value interface{}) (interface{}, error) {
+	text, isText := value.(string)
+	switch field.Type {
+	case FIELD_NUMBER:
+		number, ok := value.(float64)
+		if isText {
+			var err error
+			number, err = strconv.ParseFloat(strings.TrimSpace(text), 64)
+			ok = err == nil
+		}
+		if !ok {
+			return nil, fmt.Errorf("custom field %s must be a number", field.Key)
+		}
+		if (field.Min != nil && number < *field.Min) || (field.Max != nil && number > *field.Max) {
+			return nil, fmt.Errorf("custom field %s is out of range", field.Key)
+		}
+		return number, nil
+
+	case FIELD_DATE:
+		if !isText {
+			return nil, fmt.Errorf("custom field %s must be a date", field.Key)
+		}
+		for _, layout := range importTimeLayouts {
+			if at, err := time.Parse(layout, strings.TrimSpace(text)); err == nil {
+				return at.UTC(), nil
+			}
+		}
+		if at, ok := helper.ExcelTime(text); ok {
+			return at, nil
+		}
+		return nil, fmt.Errorf("custom field %s must be a date (RFC3339 or 2006-01-02)", field.Key)
+
+	case FIELD_BOOLEAN:
+		flag, ok := value.(bool)
+		if isText {
+			var err error
+			flag, err = strconv.ParseBool(strings.TrimSpace(text))
+			ok = err == nil
+		}
+		if !ok {
+			return nil, fmt.Errorf("custom field %s must be true or false", field.Key)
+		}
+		return flag, nil
+
+	case FIELD_ENUM:
+		for _, option := range field.Options {
+			if isText && strings.EqualFold(option, strings.TrimSpace(text)) {
+				return option, nil
+			}
+		}
+		return nil, fmt.Errorf("custom field %s must be one of %s", field.Key, strings.Join(field.Options, ", "))
+	}
+
+	if !isText {
+		return nil, fmt.Errorf("custom field %s must be text", field.Key)
+	}
+	length := float64(len([]rune(text)))
+	if (field.Min != nil && length < *field.Min) || (field.Max != nil && length > *field.Max) {
+		return nil, fmt.Errorf("custom field %s has an invalid length", field.Key)
+	}
+	if field.Pattern != "" {
+		if matched, _ := regexp.MatchString(field.Pattern, text); !matched {
+			return nil, fmt.Errorf("custom field %s does not match %s", field.Key, field.Pattern)
+		}
+	}
+	return text, nil
+}
+
+// customFieldUpdate : $set and $unset documents of a partial custom field update
+func customFieldUpdate(values map[string]interface{}) (set bson.M, unset bson.M) {
+	set, unset = bson.M{}, bson.M{}
+	for key, value := range values {
+		if value == nil {
+			unset[customFieldsColumn+"."+key] = ""
+		} else {
+			set[customFieldsColumn+"."+key] = value
+		}
+	}
+	return set, unset
+}
+
+// customFieldFilter : add the ?cf.<key>= filters of the request to filter
+func customFieldFilter(ctx context.Context, c *gin.Context, resource string, filter bson.M) error {
+	var definitions map[string]models.CustomField
+	for param, values := range c.Request.URL.Query() {
+		name, ok := strings.CutPrefix(param, customFieldFilterPrefix)
+		if !ok || len(values) == 0 {
+			continue
+		}
+		if definitions == nil {
+			if helper.TenantId(c) == "" {
+				return fmt.Errorf("org_id is required to filter by custom fields")
+			}
+			var err error
+			if definitions, err = customFieldDefinitions(ctx, c, helper.TenantId(c), resource); err != nil {
+				return err
+			}
+		}
+
+		key, bound := name, ""
+		if i := strings.LastIndex(name, "."); i > 0 {
+			key, bound = name[:i], name[i+1:]
+		}
+		field, ok := definitions[key]
+		if !ok {
+			return fmt.Errorf("unknown custom field %q", key)
+		}
+		value, err := customFieldFilterValue(field, values[0])
+		if err != nil {
+			return err
+		}
+
+		column := customFieldsColumn + "." + key
+		switch bound {
+		case "":
+			if field.Type == FIELD_TEXT {
+				// text matches whole values, ignoring case
+				value = primitive.Regex{Pattern: "^" + regexp.QuoteMeta(values[0]) + "$", Options: "i"}
+			}
+			filter[column] = value
+		case "min", "max":
+			if field.Type != FIELD_NUMBER && field.Type != FIELD_DATE {
+				return fmt.Errorf("custom field %s has no range filter", key)
+			}
+			operator := map[string]string{"min": "$gte", "max": "$lte"}[bound]
+			condition, _ := filter[column].(bson.M)
+			if condition == nil {
+				condition = bson.M{}
+			}
+			condition[operator] = value
+			filter[column] = condition
+		default:
+			return fmt.Errorf("invalid filter %s", param)
+		}
+	}
+	return nil
+}
+
+// customFieldFilterValue : query value as the stored type, without the write rules
+func customFieldFilterValue(field models.CustomField, text string) (interface{}, error) {
+	field.Min, field.Max, field.Pattern, field.Required = nil, nil, "", false
+	return customFieldValue(field, text)
+}
+
+// withCustomFieldColumns : entity exported with a custom_fields.<key> column for each custom
+// field the organization of scope defines, after the fixed columns
+func withCustomFieldColumns(ctx context.Context, scope exportScope, entity dataEntity) (dataEntity, error) {
+	definitions, err := customFieldDefinitions(ctx, nil, scope.orgId, entity.resource)
+	if err != nil || len(definitions) == 0 {
+		return entity, err
+	}
+	keys := make([]string, 0, len(definitions))
+	for key := range definitions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	columns := append([]string{}, entity.columns...)
+	for _, key := range keys {
+		columns = append(columns, customFieldsColumn+"."+key)
+	}
+	entity.columns = columns
+
+	record := entity.record
+	entity.record = func(cursor *mongo.Cursor) (bson.D, error) {
+		row, err := record(cursor)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			var value interface{}
+			if raw, err := cursor.Current.LookupErr(customFieldsColumn, key); err == nil {
+				if err := raw.Unmarshal(&value); err != nil {
+					return nil, err
+				}
+			}
+			row = append(row, bson.E{Key: customFieldsColumn + "." + key, Value: value})
+		}
+		return row, nil
+	}
+	return entity, nil
+}
+
+// importCustomFieldValues : custom field columns of an import row into orgId, typed by their definitions
+func importCustomFieldValues(ctx context.Context, c *gin.Context, orgId, resource string, row map[string]string, partial bool) (map[string]interface{}, error) {
+	return checkCustomFields(ctx, c, orgId, resource, importCustomFields(row), partial, true)
+}
+
+// CreateCustomField : Define a custom field for customers, tickets or interactions (only admin can access)
+func CreateCustomField() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var field models.CustomField
+		if err := c.BindJSON(&field); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if validationErr := CustomFieldValidate.Struct(field); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+		if err := checkCustomFieldRules(field); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		field.OrgId = helper.TenantId(c)
+		if field.OrgId == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "org_id is required"})
+			return
+		}
+
+		count, err := CustomFieldCollection.CountDocuments(ctx, bson.M{utils.ORG_ID: field.OrgId, "resource": field.Resource, "key": field.Key})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while checking for custom field key"})
+			return
+		}
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "this custom field already exists"})
+			return
+		}
+
+		field.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		field.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		field.ID = primitive.NewObjectID()
+		field.FieldId = field.ID.Hex()
+
+		if _, err := CustomFieldCollection.InsertOne(ctx, field); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Custom field was not created"})
+			return
+		}
+
+		helper.RecordAudit(c, models.AuditLog{
+			Action:     utils.ACTION_CREATE,
+			Resource:   utils.RESOURCE_FIELDS,
+			ResourceId: field.FieldId,
+		}, nil, field)
+
+		c.JSON(http.StatusOK, field)
+	}
+}
+
+// checkCustomFieldRules : key format and rules that fit the field's type
+func checkCustomFieldRules(field models.CustomField) error {
+	if !customFieldKeyFormat.MatchString(field.Key) {
+		return fmt.Errorf("key must be lower case letters, digits and underscores, starting with a letter")
+	}
+	if field.Min != nil && field.Max != nil && *field.Min > *field.Max {
+		return fmt.Errorf("min is greater than max")
+	}
+	if (field.Min != nil || field.Max != nil) && field.Type != FIELD_NUMBER && field.Type != FIELD_TEXT {
+		return fmt.Errorf("min and max only apply to number and text fields")
+	}
+	if field.Pattern != "" {
+		if field.Type != FIELD_TEXT {
+			return fmt.Errorf("pattern only applies to text fields")
+		}
+		if _, err := regexp.Compile(field.Pattern); err != nil {
+			return fmt.Errorf("invalid pattern: %v", err)
+		}
+	}
+	if len(field.Options) > 0 && field.Type != FIELD_ENUM {
+		return fmt.Errorf("options only apply to enum fields")
+	}
+	return nil
+}
+
+// GetCustomFields : List the custom field definitions, optionally for one ?resource= (only admin can access)
+func GetCustomFields() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		filter := bson.M{}
+		if resource := c.Query("resource"); resource != "" {
+			filter["resource"] = resource
+		}
+
+		opts := options.Find().SetSort(bson.D{{Key: "resource", Value: 1}, {Key: "key", Value: 1}})
+		cursor, err := CustomFieldCollection.Find(ctx, helper.TenantFilter(c, filter), opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		defer cursor.Close(ctx)
+
+		var fields []models.CustomField
+		if err := cursor.All(ctx, &fields); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if len(fields) == 0 {
+			c.JSON(http.StatusOK, gin.H{"error": "no custom fields available"})
+			return
+		}
+
+		c.JSON(http.StatusOK, fields)
+	}
+}
+
+// UpdateCustomField : Change the label and rules of a custom field, its key, type and
+// resource stay fixed since values are already stored by them (only admin can access)
+func UpdateCustomField() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		filter := helper.TenantFilter(c, bson.M{"field_id": c.Param("field_id")})
+		var before models.CustomField
+		if err := CustomFieldCollection.FindOne(ctx, filter).Decode(&before); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "custom field not found"})
+			return
+		}
+
+		var field models.CustomField
+		if err := c.BindJSON(&field); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		field.ID, field.FieldId, field.OrgId = before.ID, before.FieldId, before.OrgId
+		field.Resource, field.Key, field.Type = before.Resource, before.Key, before.Type
+		if field.Label == nil {
+			field.Label = before.Label
+		}
+		field.CreatedAt = before.CreatedAt
+		field.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+		if validationErr := CustomFieldValidate.Struct(field); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+		if err := checkCustomFieldRules(field); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if _, err := CustomFieldCollection.ReplaceOne(ctx, bson.M{"_id": before.ID}, field); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while updating custom field"})
+			return
+		}
+
+		helper.RecordAudit(c, models.AuditLog{
+			Action:     utils.ACTION_UPDATE,
+			Resource:   utils.RESOURCE_FIELDS,
+			ResourceId: field.FieldId,
+		}, before, field)
+
+		c.JSON(http.StatusOK, field)
+	}
+}
+
+// DeleteCustomField : Delete a custom field definition, values already stored on records
+// are kept but no longer validated, filtered or exported (only admin can access)
+func DeleteCustomField() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var field models.CustomField
+		err := CustomFieldCollection.FindOneAndDelete(ctx, helper.TenantFilter(c, bson.M{"field_id": c.Param("field_id")})).Decode(&field)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "custom field not found"})
+			return
+		}
+
+		helper.RecordAudit(c, models.AuditLog{
+			Action:     utils.ACTION_DELETE,
+			Resource:   utils.RESOURCE_FIELDS,
+			ResourceId: field.FieldId,
+		}, field, nil)
+
+		c.JSON(http.StatusOK, gin.H{"message": "Custom field deleted successfully"})
+	}
+}
@@ -2,11 +2,13 @@ package controllers
 
 import (
 	"context"
+	"errors"
 
 	"fmt"
 
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -17,6 +19,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"github.com/nirmal/crm/utils"
 )
 
@@ -45,6 +48,20 @@ func CustomerSignUp() gin.HandlerFunc {
 			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
 			return
 		}
+		// Customers sign up into an existing organization
+		if !organizationExists(ctx, customer.OrgId) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "organization not found"})
+			return
+		}
+		// tags and custom fields are set by staff, required custom fields are not asked on sign up
+		customer.Tags, customer.CustomFields = nil, nil
+		// scores and merges are worked out by the service
+		customer.LeadScore, customer.ScoredAt, customer.HealthScore = nil, nil, nil
+		customer.MergedFrom, customer.MergedInto = nil, ""
+		// contacts are linked to accounts by staff
+		customer.AccountId = nil
+		// Signing up makes a customer, leads are recorded by staff
+		customer.Lifecycle = utils.LIFECYCLE_CUSTOMER
 		// Check if email already exists
 		count, err := CustomerCollection.CountDocuments(ctx, bson.M{"email": customer.Email})
 		if err != nil {
@@ -67,8 +84,10 @@ func CustomerSignUp() gin.HandlerFunc {
 
 		customer.ID = primitive.NewObjectID()
 		customer.CustomerId = customer.ID.Hex()
+		customer.History = []models.LifecycleChange{lifecycleChange("", customer.Lifecycle, "", customer.CustomerId)}
+		customer.Version = 1
 		// Generate customer token
-		token, _ := helper.GenerateCustomerToken(*customer.Email, *customer.Name, customer.CustomerId)
+		token, _ := helper.GenerateCustomerToken(*customer.Email, *customer.Name, customer.CustomerId, customer.OrgId)
 		customer.Token = &token
 
 		resultInsertionNumber, insertErr := CustomerCollection.InsertOne(ctx, customer)
@@ -77,6 +96,15 @@ func CustomerSignUp() gin.HandlerFunc {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
 			return
 		}
+		helper.RecordAudit(c, models.AuditLog{
+			OrgId:      customer.OrgId,
+			ActorId:    customer.CustomerId,
+			ActorType:  utils.ACTOR_CUSTOMER,
+			Action:     utils.ACTION_CREATE,
+			Resource:   utils.RESOURCE_CUSTOMERS,
+			ResourceId: customer.CustomerId,
+		}, nil, customer)
+		rescoreCustomers(ctx, c, customer.ID)
 
 		c.JSON(http.StatusCreated, gin.H{"insertId": resultInsertionNumber, "message": "Customer created successfully"})
 	}
@@ -97,8 +125,9 @@ func CustomerLogIn() gin.HandlerFunc {
 		}
 
 		//check the record with the email in DB
-		err := CustomerCollection.FindOne(ctx, bson.M{"email": customer.Email}).Decode(&foundCustomer)
-		if err != nil {
+		err := CustomerCollection.FindOne(ctx, helper.NotDeleted(bson.M{"email": customer.Email})).Decode(&foundCustomer)
+		// leads have no password until they are converted
+		if err != nil || customer.Password == nil || foundCustomer.Password == nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "email or password is incorrect"})
 			return
 		}
@@ -114,7 +143,7 @@ func CustomerLogIn() gin.HandlerFunc {
 			return
 		}
 		// Generate customer token
-		token, err := helper.GenerateCustomerToken(*foundCustomer.Email, *foundCustomer.Name, foundCustomer.CustomerId)
+		token, err := helper.GenerateCustomerToken(*foundCustomer.Email, *foundCustomer.Name, foundCustomer.CustomerId, foundCustomer.OrgId)
 		if err != nil || token == "" {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
@@ -127,20 +156,82 @@ func CustomerLogIn() gin.HandlerFunc {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		helper.RecordAudit(c, models.AuditLog{
+			OrgId:      foundCustomer.OrgId,
+			ActorId:    foundCustomer.CustomerId,
+			ActorType:  utils.ACTOR_CUSTOMER,
+			Action:     utils.ACTION_LOGIN,
+			Resource:   utils.RESOURCE_CUSTOMERS,
+			ResourceId: foundCustomer.CustomerId,
+		}, nil, nil)
 
 		c.JSON(http.StatusOK, gin.H{"customer": foundCustomer, "message": "Customer logged in successfully"})
 	}
 }
 
-// GetAllCustomers : Get all customers
+// customerSortFields : customer fields listings can be sorted by
+var customerSortFields = map[string]bool{"name": true, "created_at": true, "updated_at": true, "lead_score": true, "health_score": true}
+
+// customerSort : sort order of ?sort=field, or ?sort=-field for descending, fallback without one
+func customerSort(c *gin.Context, fallback bson.D) (bson.D, error) {
+	sort := c.Query("sort")
+	if sort == "" {
+		return fallback, nil
+	}
+	field, order := strings.TrimPrefix(sort, "-"), 1
+	if strings.HasPrefix(sort, "-") {
+		order = -1
+	}
+	if !customerSortFields[field] {
+		return nil, fmt.Errorf("cannot sort by %s", field)
+	}
+	return bson.D{{Key: field, Value: order}, {Key: "_id", Value: order}}, nil
+}
+
+// GetAllCustomers : Get all customers of the organization (only admin can access)
 func GetAllCustomers() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		// tags, segments, custom fields and lifecycle stages are staff data
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
 		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
+		// Filter by custom fields, ?cf.<key>=
+		filter := bson.M{}
+		if err := customFieldFilter(ctx, c, utils.RESOURCE_CUSTOMERS, filter); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		// Filter by tags, ?tag=, and segment, ?segment=
+		if err := customerTagSegmentFilter(ctx, c, helper.TenantFilter(c, bson.M{}), c.Request.URL.Query(), filter); err != nil {
+			c.JSON(segmentErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		// Filter by lifecycle stage, ?lifecycle=
+		if stage := c.Query("lifecycle"); stage != "" {
+			for key, value := range lifecycleFilter(stage) {
+				filter[key] = value
+			}
+		}
+		// Sort by ?sort=, e.g. -lead_score for the best leads first
+		sort, err := customerSort(c, nil)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		// never list the credentials of customers
+		opts := options.Find().SetProjection(bson.M{"password": 0, "token": 0, "refresh_token": 0})
+		if sort != nil {
+			opts.SetSort(sort)
+		}
+
 		var customers []models.Customer
 		// Find all customers
-		cursor, err := CustomerCollection.Find(ctx, bson.M{})
+		cursor, err := findCustomers(ctx, helper.TenantFilter(c, helper.NotDeleted(filter)), opts)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing customers"})
 			return
@@ -160,12 +251,14 @@ func GetAllCustomers() gin.HandlerFunc {
 	}
 }
 
-// GetCustomer : Get a customer by ID
+// GetCustomer : Get a customer by ID, staff also get the notes on it they may see
 func GetCustomer() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		customerId := c.Param(utils.CUSTOMER_ID)
+		// Staff read the customers of their organization, customers only themselves
+		staff := c.GetString("role") != ""
 		// Match customer type to utils.CUSTOMER_ID
-		if err := helper.MatchCustomerTypeToCid(c, customerId); err != nil {
+		if err := helper.MatchCustomerTypeToCid(c, customerId); err != nil && !staff {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
@@ -174,7 +267,7 @@ func GetCustomer() gin.HandlerFunc {
 		defer cancel()
 		// Find customer by utils.CUSTOMER_ID
 		var customer models.Customer
-		err := CustomerCollection.FindOne(ctx, bson.M{utils.CUSTOMER_ID: customerId}).Decode(&customer)
+		err := CustomerCollection.FindOne(ctx, helper.TenantFilter(c, helper.NotDeleted(bson.M{utils.CUSTOMER_ID: customerId}))).Decode(&customer)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		}
@@ -183,6 +276,24 @@ func GetCustomer() gin.HandlerFunc {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "customer not found"})
 			return
 		}
+		// Let clients skip the body when they already hold this version
+		etag := helper.ETag(customer.CustomerId, customer.Version)
+		c.Header("ETag", etag)
+		// notes change without the customer's version, so staff always get the body
+		if !staff && helper.IfNoneMatch(c, etag) {
+			c.Status(http.StatusNotModified)
+			return
+		}
+		if staff {
+			reader, err := currentNoteReader(ctx, c)
+			if err == nil {
+				customer.Notes, err = resourceNotes(ctx, c, reader, utils.RESOURCE_CUSTOMERS, customer.CustomerId)
+			}
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while reading notes"})
+				return
+			}
+		}
 
 		c.JSON(http.StatusOK, customer)
 	}
@@ -233,14 +344,54 @@ func UpdateCustomer() gin.HandlerFunc {
 
 		updateObj["updated_at"] = time.Now()
 
-		filter := bson.M{utils.CUSTOMER_ID: bson.M{"$eq": customerId}}
-		update := bson.M{"$set": updateObj}
-		// Update customer
-		_, err := CustomerCollection.UpdateOne(ctx, filter, update)
+		filter := helper.TenantFilter(c, helper.NotDeleted(bson.M{utils.CUSTOMER_ID: bson.M{"$eq": customerId}}))
+		update := bson.M{"$set": updateObj, "$inc": bson.M{"version": 1}}
+		// Keep the previous state for the audit trail
+		var before models.Customer
+		if err := CustomerCollection.FindOne(ctx, filter).Decode(&before); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "customer not found"})
+			return
+		}
+		if !helper.IfMatch(c, helper.ETag(before.CustomerId, before.Version)) {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": helper.ErrVersionConflict.Error()})
+			return
+		}
+		// Custom fields are set one by one by staff, null clears a value
+		if customer.CustomFields != nil && helper.CheckStaff(c) == nil {
+			customFields, err := checkCustomFields(ctx, c, before.OrgId, utils.RESOURCE_CUSTOMERS, customer.CustomFields, true, false)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			set, unset := customFieldUpdate(customFields)
+			for key, value := range set {
+				updateObj[key] = value
+			}
+			if len(unset) > 0 {
+				update["$unset"] = unset
+			}
+		}
+		// Update customer, only if nobody changed it since it was read
+		result, err := CustomerCollection.UpdateOne(ctx, helper.VersionFilter(filter, before.Version), update)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while updating customer"})
 			return
 		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": helper.ErrVersionConflict.Error()})
+			return
+		}
+
+		var after models.Customer
+		if err := CustomerCollection.FindOne(ctx, filter).Decode(&after); err == nil {
+			c.Header("ETag", helper.ETag(after.CustomerId, after.Version))
+			helper.RecordAudit(c, models.AuditLog{
+				Action:     utils.ACTION_UPDATE,
+				Resource:   utils.RESOURCE_CUSTOMERS,
+				ResourceId: customerId,
+			}, before, after)
+		}
+		rescoreCustomers(ctx, c, before.ID)
 
 		c.JSON(http.StatusOK, gin.H{"message": "customer updated successfully"})
 	}
@@ -258,13 +409,75 @@ func DeleteCustomer() gin.HandlerFunc {
 		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
-		filter := bson.M{utils.CUSTOMER_ID: bson.M{"$eq": customerId}}
-		// Delete customer
-		_, err := CustomerCollection.DeleteOne(ctx, filter)
+		filter := helper.TenantFilter(c, helper.NotDeleted(bson.M{utils.CUSTOMER_ID: bson.M{"$eq": customerId}}))
+		// Keep the deleted state for the audit trail
+		var before models.Customer
+		if err := CustomerCollection.FindOne(ctx, filter).Decode(&before); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "customer not found"})
+			return
+		}
+		if !helper.IfMatch(c, helper.ETag(before.CustomerId, before.Version)) {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": helper.ErrVersionConflict.Error()})
+			return
+		}
+		// Work out what happens to the customer's interactions and tickets
+		reassignTo, err := resolveReassignTarget(ctx, c, CustomerCollection, utils.CUSTOMER_ID, customerId)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		relations := customerDeleteRelations()
+		impacts, err := planDelete(ctx, relations, before.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while checking related records"})
+			return
+		}
+		planErr := checkDeletePlan(impacts, reassignTo)
+
+		if c.Query("dry_run") == "true" {
+			response := gin.H{"dry_run": true, "allowed": planErr == nil, "affected": impacts}
+			if planErr != nil {
+				response["reason"] = planErr.Error()
+			}
+			c.JSON(http.StatusOK, response)
+			return
+		}
+		if planErr != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": planErr.Error(), "affected": impacts})
+			return
+		}
+
+		// Move customer to the trash together with its related records, all with one stamp
+		softDelete := helper.SoftDeleteUpdate(c)
+		err = database.WithTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+			result, err := CustomerCollection.UpdateOne(sessCtx, helper.VersionFilter(filter, before.Version), softDelete)
+			if err != nil {
+				return err
+			}
+			if result.MatchedCount == 0 {
+				return helper.ErrVersionConflict
+			}
+			return applyDeleteRelations(sessCtx, c, relations, before.ID, reassignTo, softDelete)
+		})
+		if errors.Is(err, helper.ErrVersionConflict) {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()})
+			return
+		}
+		// records added since the plan was checked
+		if errors.Is(err, errDeleteBlocked) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while deleting customer"})
 			return
 		}
+		helper.RecordAudit(c, models.AuditLog{
+			Action:     utils.ACTION_DELETE,
+			Resource:   utils.RESOURCE_CUSTOMERS,
+			ResourceId: customerId,
+		}, before, nil)
+		auditDeleteImpacts(c, utils.CUSTOMER_ID, customerId, impacts, reassignTo)
 
 		c.JSON(http.StatusOK, gin.H{"message": "customer deleted successfully"})
 	}
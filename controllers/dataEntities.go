@@ -0,0 +1,582 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	helper "github.com/nirmal/crm/helpers"
+	"github.com/nirmal/crm/models"
+	"github.com/nirmal/crm/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// dataEntity : how a collection is exported and imported.
+// References to other records are exported and imported as their stable hex ids.
+type dataEntity struct {
+	resource   string
+	collection *mongo.Collection
+	idField    string
+	columns    []string
+	record     func(cursor *mongo.Cursor) (bson.D, error)
+	parse      func(ctx context.Context, c *gin.Context, row map[string]string) (interface{}, error)
+	// matchKeys are the columns an import row can be matched to an existing record by,
+	// the first is the default. Entities without them only support insert_only.
+	matchKeys []string
+	// mergeFields are the fields an import may update, with their validation tag
+	mergeFields map[string]string
+	// uniqueFields must stay unique across the collection when updated
+	uniqueFields []string
+	// filter selects the exported records from the query of the export scope, all when nil
+	filter func(ctx context.Context, scope exportScope) (bson.M, error)
+	// filterParams are the query parameters filter reads
+	filterParams []string
+}
+
+var customerDataEntity = dataEntity{
+	resource:   utils.RESOURCE_CUSTOMERS,
+	collection: CustomerCollection,
+	idField:    utils.CUSTOMER_ID,
+	columns:    customerExportColumns,
+	record: func(cursor *mongo.Cursor) (bson.D, error) {
+		var customer models.Customer
+		if err := cursor.Decode(&customer); err != nil {
+			return nil, err
+		}
+		return customerExportRecord(customer), nil
+	},
+	parse:     parseCustomerRow,
+	matchKeys: []string{"email", "external_id"},
+	mergeFields: map[string]string{
+		"name":        "required",
+		"email":       "email",
+		"company":     "",
+		"phone":       "",
+		"external_id": "",
+		// custom_fields.<key> columns, merged one key at a time
+		customFieldsColumn: "",
+	},
+	uniqueFields: []string{"email"},
+	filter:       customerExportFilter,
+	filterParams: []string{"search", "company", "created_from", "created_to", "tag", "segment"},
+}
+
+var userDataEntity = dataEntity{
+	resource:   utils.RESOURCE_USERS,
+	collection: UserCollection,
+	idField:    utils.USER_ID,
+	columns:    []string{"id", "user_id", "org_id", "name", "email", "role", "company", "phone_no", "created_at", "updated_at"},
+	record: func(cursor *mongo.Cursor) (bson.D, error) {
+		var user models.User
+		if err := cursor.Decode(&user); err != nil {
+			return nil, err
+		}
+		return bson.D{
+			{Key: "id", Value: user.ID},
+			{Key: "user_id", Value: user.UserId},
+			{Key: "org_id", Value: user.OrgId},
+			{Key: "name", Value: user.Name},
+			{Key: "email", Value: user.Email},
+			{Key: "role", Value: user.Role},
+			{Key: "company", Value: user.Company},
+			{Key: "phone_no", Value: user.PhoneNo},
+			{Key: "created_at", Value: user.CreatedAt},
+			{Key: "updated_at", Value: user.UpdatedAt},
+		}, nil
+	},
+	parse: parseUserRow,
+}
+
+var interactionDataEntity = dataEntity{
+	resource:   utils.RESOURCE_INTERACTIONS,
+	collection: InteractionCollection,
+	idField:    "interaction_id",
+	columns:    []string{"id", "interaction_id", "org_id", "user_id", "customer_id", "title", "description", "start_time", "created_at", "updated_at"},
+	record: func(cursor *mongo.Cursor) (bson.D, error) {
+		var interaction models.Interaction
+		if err := cursor.Decode(&interaction); err != nil {
+			return nil, err
+		}
+		return bson.D{
+			{Key: "id", Value: interaction.ID},
+			{Key: "interaction_id", Value: interaction.InteractionId},
+			{Key: "org_id", Value: interaction.OrgId},
+			{Key: "user_id", Value: interaction.UserID},
+			{Key: "customer_id", Value: interaction.CustomerID},
+			{Key: "title", Value: interaction.Title},
+			{Key: "description", Value: interaction.Description},
+			{Key: "start_time", Value: interaction.StartTime},
+			{Key: "created_at", Value: interaction.CreatedAt},
+			{Key: "updated_at", Value: interaction.UpdatedAt},
+		}, nil
+	},
+	parse: parseInteractionRow,
+}
+
+var ticketDataEntity = dataEntity{
+	resource:   utils.RESOURCE_TICKETS,
+	collection: TicketCollection,
+	idField:    "ticket_id",
+	columns:    []string{"id", "ticket_id", "org_id", "interaction_id", "customer_id", "status", "description", "created_at", "updated_at", "resolved_at"},
+	record: func(cursor *mongo.Cursor) (bson.D, error) {
+		var ticket models.Ticket
+		if err := cursor.Decode(&ticket); err != nil {
+			return nil, err
+		}
+		return bson.D{
+			{Key: "id", Value: ticket.ID},
+			{Key: "ticket_id", Value: ticket.TicketId},
+			{Key: "org_id", Value: ticket.OrgId},
+			{Key: "interaction_id", Value: ticket.InteractionID},
+			{Key: "customer_id", Value: ticket.CustomerID},
+			{Key: "status", Value: ticket.Status},
+			{Key: "description", Value: ticket.Description},
+			{Key: "created_at", Value: ticket.CreatedAt},
+			{Key: "updated_at", Value: ticket.UpdatedAt},
+			{Key: "resolved_at", Value: ticket.ResolvedAt},
+		}, nil
+	},
+	parse: parseTicketRow,
+}
+
+var accountDataEntity = dataEntity{
+	resource:   utils.RESOURCE_ACCOUNTS,
+	collection: AccountCollection,
+	idField:    utils.ACCOUNT_ID,
+	columns:    []string{"id", "account_id", "org_id", "name", "domain", "industry", "street", "city", "state", "postal_code", "country", "parent_account_id", "owner_id", "created_at", "updated_at"},
+	record: func(cursor *mongo.Cursor) (bson.D, error) {
+		var account models.Account
+		if err := cursor.Decode(&account); err != nil {
+			return nil, err
+		}
+		var address models.Address
+		if account.Address != nil {
+			address = *account.Address
+		}
+		return bson.D{
+			{Key: "id", Value: account.ID},
+			{Key: "account_id", Value: account.AccountId},
+			{Key: "org_id", Value: account.OrgId},
+			{Key: "name", Value: account.Name},
+			{Key: "domain", Value: account.Domain},
+			{Key: "industry", Value: account.Industry},
+			{Key: "street", Value: address.Street},
+			{Key: "city", Value: address.City},
+			{Key: "state", Value: address.State},
+			{Key: "postal_code", Value: address.PostalCode},
+			{Key: "country", Value: address.Country},
+			{Key: "parent_account_id", Value: account.ParentAccountId},
+			{Key: "owner_id", Value: account.OwnerId},
+			{Key: "created_at", Value: account.CreatedAt},
+			{Key: "updated_at", Value: account.UpdatedAt},
+		}, nil
+	},
+	parse: parseAccountRow,
+}
+
+// findDataEntity : exportable entity by resource name
+func findDataEntity(resource string) (dataEntity, bool) {
+	for _, entity := range []dataEntity{customerDataEntity, userDataEntity, ticketDataEntity, interactionDataEntity, accountDataEntity} {
+		if entity.resource == resource {
+			return entity, true
+		}
+	}
+	return dataEntity{}, false
+}
+
+// customerExportFilter : customers matching ?search= (name, email or company), ?company=,
+// ?created_from= / ?created_to= (RFC3339), ?tag= and ?segment=
+func customerExportFilter(ctx context.Context, scope exportScope) (bson.M, error) {
+	filter := bson.M{}
+	if search := scope.query.Get("search"); search != "" {
+		pattern := primitive.Regex{Pattern: regexp.QuoteMeta(search), Options: "i"}
+		filter["$or"] = bson.A{bson.M{"name": pattern}, bson.M{"email": pattern}, bson.M{"company": pattern}}
+	}
+	if company := scope.query.Get("company"); company != "" {
+		filter["company"] = primitive.Regex{Pattern: "^" + regexp.QuoteMeta(company) + "$", Options: "i"}
+	}
+
+	created := bson.M{}
+	for param, operator := range map[string]string{"created_from": "$gte", "created_to": "$lte"} {
+		value := scope.query.Get(param)
+		if value == "" {
+			continue
+		}
+		at, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s, expected RFC3339", param)
+		}
+		created[operator] = at
+	}
+	if len(created) > 0 {
+		filter["created_at"] = created
+	}
+	if err := customerTagSegmentFilter(ctx, nil, scope.tenantFilter(bson.M{}), scope.query, filter); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+// parseCustomerRow : customer from an import row, the row must carry an initial password
+// unless its lifecycle is lead, mql or sql
+func parseCustomerRow(ctx context.Context, c *gin.Context, row map[string]string) (interface{}, error) {
+	id, err := importObjectId(ctx, CustomerCollection, utils.CUSTOMER_ID, row)
+	if err != nil {
+		return nil, err
+	}
+
+	customer := models.Customer{
+		ID:         id,
+		CustomerId: id.Hex(),
+		OrgId:      helper.TenantId(c),
+		Name:       importString(row, "name"),
+		Email:      importString(row, "email"),
+		Password:   importString(row, "password"),
+		Company:    importString(row, "company"),
+		Phone:      importString(row, "phone"),
+		ExternalId: importString(row, "external_id"),
+		AccountId:  importString(row, utils.ACCOUNT_ID),
+		Lifecycle:  strings.ToLower(strings.TrimSpace(row["lifecycle"])),
+		Version:    1,
+	}
+	if customer.Lifecycle == "" {
+		customer.Lifecycle = utils.LIFECYCLE_CUSTOMER
+	}
+	if !isLeadStage(customer.Lifecycle) && customer.Lifecycle != utils.LIFECYCLE_CUSTOMER && customer.Lifecycle != utils.LIFECYCLE_CHURNED {
+		return nil, fmt.Errorf("lifecycle %s is not one of lead, mql, sql, customer or churned", customer.Lifecycle)
+	}
+	customer.History = []models.LifecycleChange{lifecycleChange("", customer.Lifecycle, "imported", c.GetString("uid"))}
+	if customer.AccountId != nil && !importReferenceExists(ctx, c, AccountCollection, bson.M{utils.ACCOUNT_ID: *customer.AccountId}) {
+		return nil, fmt.Errorf("account %s not found", *customer.AccountId)
+	}
+	if customer.CustomFields, err = importCustomFieldValues(ctx, c, customer.OrgId, utils.RESOURCE_CUSTOMERS, row, false); err != nil {
+		return nil, err
+	}
+	if tags := splitTags(row["tags"]); len(tags) > 0 {
+		if customer.Tags, err = normalizeTags(tags); err != nil {
+			return nil, err
+		}
+	}
+	// leads get their password when converted
+	if isLeadStage(customer.Lifecycle) && customer.Password == nil {
+		err = customerValidate.StructExcept(customer, "Password")
+	} else {
+		err = customerValidate.Struct(customer)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := CustomerCollection.CountDocuments(ctx, bson.M{"email": customer.Email})
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, fmt.Errorf("email %s already exists", *customer.Email)
+	}
+
+	if customer.Password != nil {
+		password := HashPassword(*customer.Password)
+		customer.Password = &password
+	}
+	customer.CreatedAt = importTime(row, "created_at")
+	customer.UpdatedAt = time.Now()
+	return customer, nil
+}
+
+// parseUserRow : user from an import row, the row must carry an initial password
+func parseUserRow(ctx context.Context, c *gin.Context, row map[string]string) (interface{}, error) {
+	id, err := importObjectId(ctx, UserCollection, utils.USER_ID, row)
+	if err != nil {
+		return nil, err
+	}
+
+	user := models.User{
+		ID:       id,
+		UserId:   id.Hex(),
+		OrgId:    helper.TenantId(c),
+		Name:     importString(row, "name"),
+		Email:    importString(row, "email"),
+		Password: importString(row, "password"),
+		Role:     importString(row, "role"),
+		Company:  importString(row, "company"),
+		PhoneNo:  importString(row, "phone_no"),
+		Version:  1,
+	}
+	if user.Role == nil {
+		role := utils.ROLE_USER
+		user.Role = &role
+	}
+	if *user.Role == utils.ROLE_SUPER_ADMIN {
+		return nil, fmt.Errorf("role SUPER_ADMIN cannot be imported")
+	}
+	if err := userValidate.Struct(user); err != nil {
+		return nil, err
+	}
+
+	count, err := UserCollection.CountDocuments(ctx, bson.M{"email": user.Email})
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, fmt.Errorf("email %s already exists", *user.Email)
+	}
+
+	password := HashPassword(*user.Password)
+	user.Password = &password
+	user.CreatedAt = importTime(row, "created_at")
+	user.UpdatedAt = time.Now()
+	return user, nil
+}
+
+// parseAccountRow : account from an import row, unique by name and domain in the organization.
+// Parent accounts and owners must already exist.
+func parseAccountRow(ctx context.Context, c *gin.Context, row map[string]string) (interface{}, error) {
+	id, err := importObjectId(ctx, AccountCollection, utils.ACCOUNT_ID, row)
+	if err != nil {
+		return nil, err
+	}
+
+	account := models.Account{
+		ID:              id,
+		AccountId:       id.Hex(),
+		OrgId:           helper.TenantId(c),
+		Name:            importString(row, "name"),
+		Domain:          importString(row, "domain"),
+		Industry:        importString(row, "industry"),
+		ParentAccountId: importString(row, "parent_account_id"),
+		OwnerId:         importString(row, "owner_id"),
+		Version:         1,
+	}
+	address := models.Address{
+		Street:     strings.TrimSpace(row["street"]),
+		City:       strings.TrimSpace(row["city"]),
+		State:      strings.TrimSpace(row["state"]),
+		PostalCode: strings.TrimSpace(row["postal_code"]),
+		Country:    strings.TrimSpace(row["country"]),
+	}
+	if address != (models.Address{}) {
+		account.Address = &address
+	}
+	normalizeAccount(&account)
+	if err := AccountValidate.Struct(account); err != nil {
+		return nil, err
+	}
+	if err := checkAccountUnique(ctx, account); err != nil {
+		return nil, err
+	}
+	if account.ParentAccountId != nil {
+		if err := checkParentAccount(ctx, account); err != nil {
+			return nil, err
+		}
+	}
+	if account.OwnerId != nil {
+		if err := checkAccountOwner(ctx, account); err != nil {
+			return nil, err
+		}
+	}
+
+	account.CreatedAt = importTime(row, "created_at")
+	account.UpdatedAt = time.Now()
+	return account, nil
+}
+
+// parseInteractionRow : interaction from an import row, linked to an existing customer and user
+func parseInteractionRow(ctx context.Context, c *gin.Context, row map[string]string) (interface{}, error) {
+	id, err := importObjectId(ctx, InteractionCollection, "interaction_id", row)
+	if err != nil {
+		return nil, err
+	}
+
+	customerId, err := importInteractionCustomer(ctx, c, row)
+	if err != nil {
+		return nil, err
+	}
+
+	// interactions without an owner are assigned to the importing user
+	userIdStr := row["user_id"]
+	if userIdStr == "" {
+		userIdStr = c.GetString("uid")
+	}
+	userId, err := primitive.ObjectIDFromHex(userIdStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid user_id %q", userIdStr)
+	}
+	if !importReferenceExists(ctx, c, UserCollection, bson.M{"_id": userId}) {
+		return nil, fmt.Errorf("user %s not found", userIdStr)
+	}
+
+	customFields, err := importCustomFieldValues(ctx, c, helper.TenantId(c), utils.RESOURCE_INTERACTIONS, row, false)
+	if err != nil {
+		return nil, err
+	}
+
+	interaction := models.Interaction{
+		ID:            id,
+		InteractionId: id.Hex(),
+		OrgId:         helper.TenantId(c),
+		UserID:        userId,
+		CustomerID:    customerId,
+		Title:         importString(row, "title"),
+		Description:   importString(row, "description"),
+		StartTime:     importTime(row, "start_time"),
+		CustomFields:  customFields,
+		CreatedAt:     importTime(row, "created_at"),
+		UpdatedAt:     time.Now(),
+	}
+	if err := InteractionValidate.Struct(interaction); err != nil {
+		return nil, err
+	}
+	return interaction, nil
+}
+
+// importInteractionCustomer : customer of an interaction row, by customer_id or else by the
+// customer_email or customer_external_id of a contact imported from another CRM
+func importInteractionCustomer(ctx context.Context, c *gin.Context, row map[string]string) (primitive.ObjectID, error) {
+	if row["customer_id"] != "" || (row["customer_email"] == "" && row["customer_external_id"] == "") {
+		customerId, err := primitive.ObjectIDFromHex(row["customer_id"])
+		if err != nil {
+			return primitive.NilObjectID, fmt.Errorf("invalid customer_id %q", row["customer_id"])
+		}
+		if !importReferenceExists(ctx, c, CustomerCollection, bson.M{"_id": customerId}) {
+			return primitive.NilObjectID, fmt.Errorf("customer %s not found", row["customer_id"])
+		}
+		return customerId, nil
+	}
+
+	filter := bson.M{"external_id": row["customer_external_id"]}
+	reference := row["customer_external_id"]
+	if row["customer_external_id"] == "" {
+		// other CRMs often export the contact as "Name (email)"
+		email := importEmailPattern.FindString(row["customer_email"])
+		filter, reference = bson.M{"email": email}, row["customer_email"]
+	}
+	var customer models.Customer
+	if err := CustomerCollection.FindOne(ctx, helper.TenantFilter(c, helper.NotDeleted(filter))).Decode(&customer); err != nil {
+		return primitive.NilObjectID, fmt.Errorf("customer %s not found", reference)
+	}
+	return customer.ID, nil
+}
+
+var importEmailPattern = regexp.MustCompile(`[^\s()<>,;"]+@[^\s()<>,;"]+`)
+
+// parseTicketRow : ticket from an import row, re-linked to its interaction and that interaction's customer
+func parseTicketRow(ctx context.Context, c *gin.Context, row map[string]string) (interface{}, error) {
+	id, err := importObjectId(ctx, TicketCollection, "ticket_id", row)
+	if err != nil {
+		return nil, err
+	}
+
+	interactionId, err := primitive.ObjectIDFromHex(row["interaction_id"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid interaction_id %q", row["interaction_id"])
+	}
+	var interaction models.Interaction
+	err = InteractionCollection.FindOne(ctx, helper.TenantFilter(c, helper.NotDeleted(bson.M{"_id": interactionId}))).Decode(&interaction)
+	if err != nil {
+		return nil, fmt.Errorf("interaction %s not found", row["interaction_id"])
+	}
+	// the customer always comes from the interaction, a conflicting one is rejected
+	if customerId := row["customer_id"]; customerId != "" && customerId != interaction.CustomerID.Hex() {
+		return nil, fmt.Errorf("customer %s does not belong to interaction %s", customerId, row["interaction_id"])
+	}
+	customFields, err := importCustomFieldValues(ctx, c, interaction.OrgId, utils.RESOURCE_TICKETS, row, false)
+	if err != nil {
+		return nil, err
+	}
+
+	ticket := models.Ticket{
+		ID:            id,
+		TicketId:      id.Hex(),
+		OrgId:         interaction.OrgId,
+		InteractionID: interaction.ID,
+		CustomerID:    interaction.CustomerID,
+		Status:        importString(row, "status"),
+		Description:   importString(row, "description"),
+		CustomFields:  customFields,
+		CreatedAt:     importTime(row, "created_at"),
+		UpdatedAt:     time.Now(),
+		Version:       1,
+	}
+	// resolved tickets keep their resolved_at, or are resolved as they are imported
+	if ticketResolved(ticket.Status) {
+		resolvedAt := importTime(row, "resolved_at")
+		ticket.ResolvedAt = &resolvedAt
+	}
+	if err := TicketValidate.Struct(ticket); err != nil {
+		return nil, err
+	}
+	return ticket, nil
+}
+
+// importObjectId : keep the exported id of a record so references to it stay valid,
+// or allocate a new one when the row has none. Records already present are rejected.
+func importObjectId(ctx context.Context, collection *mongo.Collection, idField string, row map[string]string) (primitive.ObjectID, error) {
+	raw := row[idField]
+	if raw == "" {
+		raw = row["id"]
+	}
+	if raw == "" {
+		return primitive.NewObjectID(), nil
+	}
+
+	id, err := primitive.ObjectIDFromHex(raw)
+	if err != nil {
+		return primitive.NilObjectID, fmt.Errorf("invalid %s %q", idField, raw)
+	}
+	count, err := collection.CountDocuments(ctx, bson.M{"_id": id})
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	if count > 0 {
+		return primitive.NilObjectID, fmt.Errorf("%s %s already exists", idField, raw)
+	}
+	return id, nil
+}
+
+// importReferenceExists : check a referenced record is live and in the caller's organization
+func importReferenceExists(ctx context.Context, c *gin.Context, collection *mongo.Collection, filter bson.M) bool {
+	count, err := collection.CountDocuments(ctx, helper.TenantFilter(c, helper.NotDeleted(filter)))
+	return err == nil && count > 0
+}
+
+// importString : optional text column, empty cells become nil
+func importString(row map[string]string, column string) *string {
+	value, ok := row[column]
+	if !ok || value == "" {
+		return nil
+	}
+	return &value
+}
+
+// importTimeLayouts : accepted text dates, besides Excel serial dates
+// including the layouts HubSpot, Salesforce and Zoho export dates in
+var importTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05.000-0700",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"1/2/2006 3:04 PM",
+	"1/2/2006 15:04",
+	"1/2/2006",
+}
+
+// importTime : date column, defaulting to now when empty or unparsable
+func importTime(row map[string]string, column string) time.Time {
+	for _, layout := range importTimeLayouts {
+		if value, err := time.Parse(layout, row[column]); err == nil {
+			return value
+		}
+	}
+	if value, ok := helper.ExcelTime(row[column]); ok {
+		return value
+	}
+	now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	return now
+}
@@ -0,0 +1,620 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/nirmal/crm/database"
+	helper "github.com/nirmal/crm/helpers"
+	"github.com/nirmal/crm/models"
+	"github.com/nirmal/crm/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const defaultDealCurrency = "USD"
+
+var DealValidate = validator.New()
+var DealCollection *mongo.Collection = database.OpenCollection("Cluster0", "deals")
+
+// dealCurrency : currency of deals created without one, DEFAULT_CURRENCY (default USD)
+func dealCurrency() string {
+	if currency := os.Getenv("DEFAULT_CURRENCY"); len(currency) == 3 {
+		return strings.ToUpper(currency)
+	}
+	return defaultDealCurrency
+}
+
+// checkDealOwner : admins manage every deal of their organization, users the deals they own
+func checkDealOwner(c *gin.Context, deal models.Deal) error {
+	if helper.CheckUserType(c, utils.ROLE_ADMIN) == nil || c.GetString("uid") == deal.OwnerId {
+		return nil
+	}
+	return fmt.Errorf("UnAuthenticated to access this resource")
+}
+
+// checkDealLinks : the owner, customer and account of a deal must be live records of its
+// organization. A deal with a customer but no account takes the customer's account.
+func checkDealLinks(ctx context.Context, deal *models.Deal) error {
+	count, err := UserCollection.CountDocuments(ctx, helper.NotDeleted(bson.M{utils.ORG_ID: deal.OrgId, utils.USER_ID: deal.OwnerId}))
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return fmt.Errorf("owner %s not found", deal.OwnerId)
+	}
+
+	if deal.CustomerId != nil {
+		var customer models.Customer
+		err := CustomerCollection.FindOne(ctx, helper.NotDeleted(bson.M{utils.ORG_ID: deal.OrgId, utils.CUSTOMER_ID: *deal.CustomerId})).Decode(&customer)
+		if err != nil {
+			return fmt.Errorf("customer %s not found", *deal.CustomerId)
+		}
+		if deal.AccountId == nil {
+			deal.AccountId = customer.AccountId
+		}
+	}
+	if deal.AccountId != nil {
+		count, err := AccountCollection.CountDocuments(ctx, helper.NotDeleted(bson.M{utils.ORG_ID: deal.OrgId, utils.ACCOUNT_ID: *deal.AccountId}))
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return fmt.Errorf("account %s not found", *deal.AccountId)
+		}
+	}
+	return nil
+}
+
+// moveDealStage : put deal into stageId of pipeline, recording the move. Won and lost
+// stages close the deal; lost deals need a reason, taken from the pipeline's list when it has one.
+func moveDealStage(deal *models.Deal, pipeline models.Pipeline, stageId, reason, actorId string) error {
+	stage, ok := pipelineStage(pipeline, stageId)
+	if !ok {
+		return fmt.Errorf("stage %s is not part of pipeline %s", stageId, pipeline.PipelineId)
+	}
+	if stage.StageId == deal.StageId && deal.PipelineId == pipeline.PipelineId {
+		return fmt.Errorf("deal is already in stage %s", stage.Name)
+	}
+
+	reason = strings.TrimSpace(reason)
+	reasons := map[string][]string{utils.DEAL_WON: pipeline.WonReasons, utils.DEAL_LOST: pipeline.LostReasons}[stage.Type]
+	if stage.Type == utils.DEAL_LOST && reason == "" {
+		return fmt.Errorf("a reason is required to mark a deal lost")
+	}
+	if reason != "" && len(reasons) > 0 {
+		known := false
+		for _, allowed := range reasons {
+			if strings.EqualFold(allowed, reason) {
+				reason, known = allowed, true
+			}
+		}
+		if !known {
+			return fmt.Errorf("reason must be one of %s", strings.Join(reasons, ", "))
+		}
+	}
+
+	now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	deal.StageHistory = append(deal.StageHistory, models.DealStageChange{
+		FromStageId: deal.StageId,
+		ToStageId:   stage.StageId,
+		Reason:      reason,
+		ChangedBy:   actorId,
+		ChangedAt:   now,
+	})
+	deal.PipelineId = pipeline.PipelineId
+	deal.StageId = stage.StageId
+	deal.Status = stage.Type
+	probability := stage.Probability
+	deal.Probability = &probability
+	deal.CloseReason, deal.ClosedAt = nil, nil
+	if stage.Type != utils.DEAL_OPEN {
+		deal.ClosedAt = &now
+		if reason != "" {
+			deal.CloseReason = &reason
+		}
+	}
+	return nil
+}
+
+// prepareDeal : validate a new deal of the caller's organization and place it in its
+// pipeline stage, the default pipeline and its first stage unless given
+func prepareDeal(ctx context.Context, c *gin.Context, deal *models.Deal) error {
+	if err := DealValidate.Struct(deal); err != nil {
+		return err
+	}
+
+	deal.OrgId = helper.TenantId(c)
+	if deal.OrgId == "" {
+		return fmt.Errorf("org_id is required")
+	}
+	if deal.OwnerId == "" {
+		deal.OwnerId = c.GetString("uid")
+	}
+	if err := checkDealOwner(c, *deal); err != nil {
+		return err
+	}
+	if err := checkDealLinks(ctx, deal); err != nil {
+		return err
+	}
+
+	pipeline, err := findPipeline(ctx, deal.OrgId, deal.PipelineId)
+	if err != nil {
+		return err
+	}
+	stageId := deal.StageId
+	if stageId == "" {
+		stageId = pipeline.Stages[0].StageId
+	}
+	reason := ""
+	if deal.CloseReason != nil {
+		reason = *deal.CloseReason
+	}
+	deal.StageId, deal.StageHistory = "", nil
+	probability := deal.Probability
+	if err := moveDealStage(deal, pipeline, stageId, reason, c.GetString("uid")); err != nil {
+		return err
+	}
+	// an explicit probability overrides the stage's
+	if probability != nil && deal.Status == utils.DEAL_OPEN {
+		deal.Probability = probability
+	}
+
+	deal.Currency = strings.ToUpper(deal.Currency)
+	if deal.Currency == "" {
+		deal.Currency = dealCurrency()
+	}
+	deal.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	deal.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	deal.ID = primitive.NewObjectID()
+	deal.DealId = deal.ID.Hex()
+	deal.Version = 1
+	return nil
+}
+
+// CreateDeal : Create a deal in a pipeline stage, the default pipeline and its first stage
+// unless given. Users own the deals they create, admins may assign an owner_id.
+func CreateDeal() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var deal models.Deal
+		if err := c.BindJSON(&deal); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := prepareDeal(ctx, c, &deal); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if _, err := DealCollection.InsertOne(ctx, deal); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Deal was not created"})
+			return
+		}
+		helper.RecordAudit(c, models.AuditLog{
+			Action:     utils.ACTION_CREATE,
+			Resource:   utils.RESOURCE_DEALS,
+			ResourceId: deal.DealId,
+		}, nil, deal)
+
+		c.JSON(http.StatusCreated, deal)
+	}
+}
+
+// dealFilter : deals selected by ?pipeline_id=, ?stage_id=, ?owner_id= (me for the caller),
+// ?status=, ?customer_id= and ?account_id=
+func dealFilter(c *gin.Context) bson.M {
+	filter := bson.M{}
+	for _, param := range []string{"pipeline_id", "stage_id", "owner_id", "status", utils.CUSTOMER_ID, utils.ACCOUNT_ID} {
+		if value := c.Query(param); value != "" {
+			filter[param] = value
+		}
+	}
+	if filter["owner_id"] == "me" {
+		filter["owner_id"] = c.GetString("uid")
+	}
+	return helper.TenantFilter(c, helper.NotDeleted(filter))
+}
+
+// GetDeals : List deals, newest first
+func GetDeals() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckStaff(c); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+		cursor, err := DealCollection.Find(ctx, dealFilter(c), opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing deals"})
+			return
+		}
+
+		var deals []models.Deal
+		if err = cursor.All(ctx, &deals); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while decoding deal data"})
+			return
+		}
+
+		if len(deals) == 0 {
+			c.JSON(http.StatusOK, gin.H{"error": "no deals available"})
+			return
+		}
+
+		c.JSON(http.StatusOK, deals)
+	}
+}
+
+// GetDeal : Get a deal by ID, with its stage history
+func GetDeal() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckStaff(c); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var deal models.Deal
+		if err := DealCollection.FindOne(ctx, helper.TenantFilter(c, helper.NotDeleted(bson.M{"deal_id": c.Param("deal_id")}))).Decode(&deal); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "deal not found"})
+			return
+		}
+
+		etag := helper.ETag(deal.DealId, deal.Version)
+		c.Header("ETag", etag)
+		if helper.IfNoneMatch(c, etag) {
+			c.Status(http.StatusNotModified)
+			return
+		}
+
+		c.JSON(http.StatusOK, deal)
+	}
+}
+
+// dealUpdate : fields of a deal update, absent ones stay unchanged. An empty customer_id,
+// account_id or expected_close_date clears it.
+type dealUpdate struct {
+	Title             *string  `json:"title" validate:"omitempty,min=1,max=200"`
+	CustomerId        *string  `json:"customer_id"`
+	AccountId         *string  `json:"account_id"`
+	OwnerId           *string  `json:"owner_id"`
+	Amount            *float64 `json:"amount" validate:"omitempty,min=0"`
+	Currency          *string  `json:"currency" validate:"omitempty,len=3,alpha"`
+	ExpectedCloseDate *string  `json:"expected_close_date"`
+	Probability       *int     `json:"probability" validate:"omitempty,min=0,max=100"`
+	PipelineId        *string  `json:"pipeline_id"`
+	StageId           *string  `json:"stage_id"`
+	CloseReason       *string  `json:"close_reason"`
+}
+
+// UpdateDeal : Update a deal. A stage_id (and pipeline_id to move it to another pipeline)
+// moves the deal, closing it in a won or lost stage with close_reason (owner or admin)
+func UpdateDeal() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		filter := helper.TenantFilter(c, helper.NotDeleted(bson.M{"deal_id": c.Param("deal_id")}))
+		var before models.Deal
+		if err := DealCollection.FindOne(ctx, filter).Decode(&before); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "deal not found"})
+			return
+		}
+		if err := checkDealOwner(c, before); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !helper.IfMatch(c, helper.ETag(before.DealId, before.Version)) {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": helper.ErrVersionConflict.Error()})
+			return
+		}
+
+		var changes dealUpdate
+		if err := c.BindJSON(&changes); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := DealValidate.Struct(changes); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		deal := before
+		deal.StageHistory = append([]models.DealStageChange{}, before.StageHistory...)
+		if changes.Title != nil {
+			deal.Title = changes.Title
+		}
+		if changes.CustomerId != nil {
+			deal.CustomerId = emptyAsNil(*changes.CustomerId)
+		}
+		if changes.AccountId != nil {
+			deal.AccountId = emptyAsNil(*changes.AccountId)
+		}
+		if changes.OwnerId != nil && *changes.OwnerId != before.OwnerId {
+			// users may not hand their deals to someone else
+			if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "only an admin can change the owner"})
+				return
+			}
+			deal.OwnerId = *changes.OwnerId
+		}
+		if changes.Amount != nil {
+			deal.Amount = *changes.Amount
+		}
+		if changes.Currency != nil {
+			deal.Currency = strings.ToUpper(*changes.Currency)
+		}
+		if changes.ExpectedCloseDate != nil {
+			deal.ExpectedCloseDate = nil
+			if *changes.ExpectedCloseDate != "" {
+				closeDate, ok := parseDealDate(*changes.ExpectedCloseDate)
+				if !ok {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "expected_close_date must be RFC3339 or 2006-01-02"})
+					return
+				}
+				deal.ExpectedCloseDate = &closeDate
+			}
+		}
+		if err := checkDealLinks(ctx, &deal); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if changes.StageId != nil || changes.PipelineId != nil {
+			pipelineId := deal.PipelineId
+			if changes.PipelineId != nil {
+				pipelineId = *changes.PipelineId
+			}
+			pipeline, err := findPipeline(ctx, deal.OrgId, pipelineId)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			stageId := pipeline.Stages[0].StageId
+			if changes.StageId != nil {
+				stageId = *changes.StageId
+			}
+			reason := ""
+			if changes.CloseReason != nil {
+				reason = *changes.CloseReason
+			}
+			if err := moveDealStage(&deal, pipeline, stageId, reason, c.GetString("uid")); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		} else if changes.CloseReason != nil && deal.Status != utils.DEAL_OPEN {
+			deal.CloseReason = emptyAsNil(*changes.CloseReason)
+		}
+		if changes.Probability != nil && deal.Status == utils.DEAL_OPEN {
+			deal.Probability = changes.Probability
+		}
+		deal.UpdatedAt = time.Now()
+		deal.Version = before.Version + 1
+
+		result, err := DealCollection.ReplaceOne(ctx, helper.VersionFilter(filter, before.Version), deal)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while updating deal"})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": helper.ErrVersionConflict.Error()})
+			return
+		}
+
+		c.Header("ETag", helper.ETag(deal.DealId, deal.Version))
+		helper.RecordAudit(c, models.AuditLog{
+			Action:     utils.ACTION_UPDATE,
+			Resource:   utils.RESOURCE_DEALS,
+			ResourceId: deal.DealId,
+		}, before, deal)
+
+		c.JSON(http.StatusOK, deal)
+	}
+}
+
+// emptyAsNil : nil for an empty string, so clearing a reference unsets it
+func emptyAsNil(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
+
+// parseDealDate : date sent as RFC3339 or 2006-01-02
+func parseDealDate(value string) (time.Time, bool) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if at, err := time.Parse(layout, value); err == nil {
+			return at.UTC(), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// DeleteDeal : Move a deal to the trash (owner or admin)
+func DeleteDeal() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		filter := helper.TenantFilter(c, helper.NotDeleted(bson.M{"deal_id": c.Param("deal_id")}))
+		var before models.Deal
+		if err := DealCollection.FindOne(ctx, filter).Decode(&before); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "deal not found"})
+			return
+		}
+		if err := checkDealOwner(c, before); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !helper.IfMatch(c, helper.ETag(before.DealId, before.Version)) {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": helper.ErrVersionConflict.Error()})
+			return
+		}
+
+		result, err := DealCollection.UpdateOne(ctx, helper.VersionFilter(filter, before.Version), helper.SoftDeleteUpdate(c))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while deleting deal"})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": helper.ErrVersionConflict.Error()})
+			return
+		}
+		helper.RecordAudit(c, models.AuditLog{
+			Action:     utils.ACTION_DELETE,
+			Resource:   utils.RESOURCE_DEALS,
+			ResourceId: before.DealId,
+		}, before, nil)
+
+		c.JSON(http.StatusOK, gin.H{"message": "deal deleted successfully"})
+	}
+}
+
+// dealBoardColumn : a pipeline stage with its deals, totals are by currency
+type dealBoardColumn struct {
+	models.PipelineStage
+	Count int                `json:"count"`
+	Total map[string]float64 `json:"total"`
+	Deals []models.Deal      `json:"deals"`
+}
+
+// GetDealBoard : Deals of a pipeline (?pipeline_id=, the default one otherwise) grouped by
+// stage in pipeline order, filtered like the deal list
+func GetDealBoard() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckStaff(c); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		orgId := helper.TenantId(c)
+		pipeline, err := findPipeline(ctx, orgId, c.Query("pipeline_id"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+
+		filter := dealFilter(c)
+		filter[utils.ORG_ID] = pipeline.OrgId
+		filter["pipeline_id"] = pipeline.PipelineId
+		opts := options.Find().SetSort(bson.D{{Key: "updated_at", Value: -1}})
+		cursor, err := DealCollection.Find(ctx, filter, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing deals"})
+			return
+		}
+		var deals []models.Deal
+		if err = cursor.All(ctx, &deals); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while decoding deal data"})
+			return
+		}
+
+		columns := make([]dealBoardColumn, len(pipeline.Stages))
+		index := map[string]int{}
+		for i, stage := range pipeline.Stages {
+			columns[i] = dealBoardColumn{PipelineStage: stage, Total: map[string]float64{}, Deals: []models.Deal{}}
+			index[stage.StageId] = i
+		}
+		for _, deal := range deals {
+			i, ok := index[deal.StageId]
+			if !ok {
+				continue
+			}
+			columns[i].Count++
+			columns[i].Total[deal.Currency] += deal.Amount
+			columns[i].Deals = append(columns[i].Deals, deal)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"pipeline_id": pipeline.PipelineId, "name": pipeline.Name, "stages": columns})
+	}
+}
+
+// GetDealCloseReasons : Won and lost deals counted by close reason, closed between ?from= and ?to=
+func GetDealCloseReasons() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckStaff(c); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		filter := dealFilter(c)
+		if _, ok := filter["status"]; !ok {
+			filter["status"] = bson.M{"$in": bson.A{utils.DEAL_WON, utils.DEAL_LOST}}
+		}
+		closed := bson.M{}
+		for param, operator := range map[string]string{"from": "$gte", "to": "$lte"} {
+			if value := c.Query(param); value != "" {
+				at, ok := parseDealDate(value)
+				if !ok {
+					c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s must be RFC3339 or 2006-01-02", param)})
+					return
+				}
+				closed[operator] = at
+			}
+		}
+		if len(closed) > 0 {
+			filter["closed_at"] = closed
+		}
+
+		pipeline := mongo.Pipeline{
+			{{Key: "$match", Value: filter}},
+			{{Key: "$group", Value: bson.M{
+				"_id":    bson.M{"status": "$status", "reason": "$close_reason", "currency": "$currency"},
+				"count":  bson.M{"$sum": 1},
+				"amount": bson.M{"$sum": "$amount"},
+			}}},
+			{{Key: "$sort", Value: bson.D{{Key: "_id.status", Value: 1}, {Key: "count", Value: -1}}}},
+		}
+		cursor, err := DealCollection.Aggregate(ctx, pipeline)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while counting close reasons"})
+			return
+		}
+		var groups []struct {
+			Key struct {
+				Status   string  `bson:"status"`
+				Reason   *string `bson:"reason"`
+				Currency string  `bson:"currency"`
+			} `bson:"_id"`
+			Count  int64   `bson:"count"`
+			Amount float64 `bson:"amount"`
+		}
+		if err := cursor.All(ctx, &groups); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while decoding close reasons"})
+			return
+		}
+
+		reasons := []gin.H{}
+		for _, group := range groups {
+			reason := ""
+			if group.Key.Reason != nil {
+				reason = *group.Key.Reason
+			}
+			reasons = append(reasons, gin.H{"status": group.Key.Status, "reason": reason, "currency": group.Key.Currency, "count": group.Count, "amount": group.Amount})
+		}
+
+		c.JSON(http.StatusOK, reasons)
+	}
+}
@@ -0,0 +1,527 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/nirmal/crm/database"
+	helper "github.com/nirmal/crm/helpers"
+	"github.com/nirmal/crm/models"
+	"github.com/nirmal/crm/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Weights of what two customers have in common, adding up to their duplicate score
+const (
+	duplicateEmailWeight   = 0.6
+	duplicatePhoneWeight   = 0.4
+	duplicateNameWeight    = 0.4
+	duplicateCompanyWeight = 0.15
+	// duplicateNameSimilarity : how alike two names must be to count
+	duplicateNameSimilarity = 0.8
+)
+
+const (
+	defaultDuplicateMinScore = 0.5
+	defaultDuplicateLimit    = 100
+	maxDuplicateLimit        = 1000
+	// maxDuplicateBlock : customers sharing an email, phone or name word beyond which the
+	// word is too common to compare them all, such as a frequent first name
+	maxDuplicateBlock = 200
+)
+
+// Sources of a field of a merged customer
+const (
+	MERGE_SURVIVOR  = "survivor"
+	MERGE_DUPLICATE = "duplicate"
+)
+
+var DuplicateValidate = validator.New()
+var DuplicateDismissalCollection *mongo.Collection = database.OpenCollection("Cluster0", "duplicate_dismissals")
+
+// duplicateCandidate : customer with the normalized values it is compared by
+type duplicateCandidate struct {
+	customer models.Customer
+	email    string
+	phone    string
+	name     string
+	company  string
+}
+
+func newDuplicateCandidate(customer models.Customer) duplicateCandidate {
+	candidate := duplicateCandidate{customer: customer}
+	if customer.Email != nil {
+		candidate.email = helper.NormalizeEmail(*customer.Email)
+	}
+	if customer.Phone != nil {
+		candidate.phone = helper.NormalizePhone(*customer.Phone)
+	}
+	if customer.Name != nil {
+		candidate.name = *customer.Name
+	}
+	if customer.Company != nil {
+		candidate.company = strings.Join(helper.NameTokens(*customer.Company), " ")
+	}
+	return candidate
+}
+
+// duplicatePairKey : key of the pair of customers a and b, whatever their order
+func duplicatePairKey(a, b string) string {
+	if a > b {
+		a, b = b, a
+	}
+	return a + ":" + b
+}
+
+// scoreDuplicatePair : how alike a and b are from 0 to 1, and what matched
+func scoreDuplicatePair(a, b duplicateCandidate) (float64, []string) {
+	score, reasons := 0.0, []string{}
+	if a.email != "" && a.email == b.email {
+		score += duplicateEmailWeight
+		reasons = append(reasons, "email")
+	}
+	if a.phone != "" && a.phone == b.phone {
+		score += duplicatePhoneWeight
+		reasons = append(reasons, "phone")
+	}
+	if similarity := helper.NameSimilarity(a.name, b.name); similarity >= duplicateNameSimilarity {
+		score += duplicateNameWeight * similarity
+		reasons = append(reasons, fmt.Sprintf("name (%.0f%%)", similarity*100))
+	}
+	if a.company != "" && a.company == b.company {
+		score += duplicateCompanyWeight
+		reasons = append(reasons, "company")
+	}
+	return math.Min(1, math.Round(score*100)/100), reasons
+}
+
+// findDuplicates : pairs of live customers of orgId scoring minScore or more, best first,
+// only those including customer only unless it is nil. Only customers sharing a normalized
+// email, phone or name word are compared, and dismissed pairs are left out.
+func findDuplicates(ctx context.Context, orgId string, only *primitive.ObjectID, minScore float64) ([]models.DuplicatePair, error) {
+	opts := options.Find().SetProjection(bson.M{utils.CUSTOMER_ID: 1, utils.ORG_ID: 1, "name": 1, "email": 1, "phone": 1, "company": 1, "lifecycle": 1, "created_at": 1})
+	cursor, err := CustomerCollection.Find(ctx, helper.NotDeleted(bson.M{utils.ORG_ID: orgId}), opts)
+	if err != nil {
+		return nil, err
+	}
+	var customers []models.Customer
+	if err := cursor.All(ctx, &customers); err != nil {
+		return nil, err
+	}
+
+	dismissed := map[string]bool{}
+	cursor, err = DuplicateDismissalCollection.Find(ctx, bson.M{utils.ORG_ID: orgId}, options.Find().SetProjection(bson.M{"pair": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var dismissals []models.DuplicateDismissal
+	if err := cursor.All(ctx, &dismissals); err != nil {
+		return nil, err
+	}
+	for _, dismissal := range dismissals {
+		dismissed[dismissal.Pair] = true
+	}
+
+	candidates := make([]duplicateCandidate, len(customers))
+	blocks := map[string][]int{}
+	for i, customer := range customers {
+		candidates[i] = newDuplicateCandidate(customer)
+		keys := []string{}
+		if candidates[i].email != "" {
+			keys = append(keys, "email:"+candidates[i].email)
+		}
+		if candidates[i].phone != "" {
+			keys = append(keys, "phone:"+candidates[i].phone)
+		}
+		for _, token := range helper.NameTokens(candidates[i].name) {
+			if len([]rune(token)) >= 3 {
+				keys = append(keys, "name:"+token)
+			}
+		}
+		for _, key := range keys {
+			blocks[key] = append(blocks[key], i)
+		}
+	}
+
+	compared := map[string]bool{}
+	pairs := []models.DuplicatePair{}
+	for _, block := range blocks {
+		if len(block) < 2 || len(block) > maxDuplicateBlock {
+			continue
+		}
+		for x := 0; x < len(block); x++ {
+			for y := x + 1; y < len(block); y++ {
+				a, b := candidates[block[x]], candidates[block[y]]
+				if only != nil && a.customer.ID != *only && b.customer.ID != *only {
+					continue
+				}
+				key := duplicatePairKey(a.customer.CustomerId, b.customer.CustomerId)
+				if compared[key] || dismissed[key] {
+					continue
+				}
+				compared[key] = true
+				score, reasons := scoreDuplicatePair(a, b)
+				if score < minScore {
+					continue
+				}
+				if b.customer.CreatedAt.Before(a.customer.CreatedAt) {
+					a, b = b, a
+				}
+				pairs = append(pairs, models.DuplicatePair{Customers: []models.Customer{a.customer, b.customer}, Score: score, Reasons: reasons})
+			}
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].Score != pairs[j].Score {
+			return pairs[i].Score > pairs[j].Score
+		}
+		return pairs[i].Customers[0].CreatedAt.Before(pairs[j].Customers[0].CreatedAt)
+	})
+	return pairs, nil
+}
+
+// duplicateQuery : ?min_score= and ?limit= of a duplicate listing
+func duplicateQuery(c *gin.Context) (float64, int, error) {
+	minScore, limit := defaultDuplicateMinScore, defaultDuplicateLimit
+	if value := c.Query("min_score"); value != "" {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || parsed <= 0 || parsed > 1 {
+			return 0, 0, fmt.Errorf("min_score must be above 0 and at most 1")
+		}
+		minScore = parsed
+	}
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxDuplicateLimit {
+			return 0, 0, fmt.Errorf("limit must be between 1 and %d", maxDuplicateLimit)
+		}
+		limit = parsed
+	}
+	return minScore, limit, nil
+}
+
+// GetDuplicates : List pairs of customers suspected to be duplicates, best matches first,
+// filtered by ?min_score= (0.5 by default) and ?limit= (staff only)
+func GetDuplicates() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckStaff(c); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		minScore, limit, err := duplicateQuery(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		orgId := helper.TenantId(c)
+		if orgId == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "org_id is required"})
+			return
+		}
+
+		pairs, err := findDuplicates(ctx, orgId, nil, minScore)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while looking for duplicates"})
+			return
+		}
+		if len(pairs) == 0 {
+			c.JSON(http.StatusOK, gin.H{"error": "no duplicates available"})
+			return
+		}
+		if len(pairs) > limit {
+			pairs = pairs[:limit]
+		}
+
+		c.JSON(http.StatusOK, pairs)
+	}
+}
+
+// GetCustomerDuplicates : List the customers suspected to be duplicates of a customer,
+// filtered by ?min_score= (0.5 by default) and ?limit= (staff only)
+func GetCustomerDuplicates() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckStaff(c); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		minScore, limit, err := duplicateQuery(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		customer, _, err := findLifecycleCustomer(ctx, c)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "customer not found"})
+			return
+		}
+
+		pairs, err := findDuplicates(ctx, customer.OrgId, &customer.ID, minScore)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while looking for duplicates"})
+			return
+		}
+		if len(pairs) == 0 {
+			c.JSON(http.StatusOK, gin.H{"error": "no duplicates available"})
+			return
+		}
+		if len(pairs) > limit {
+			pairs = pairs[:limit]
+		}
+
+		c.JSON(http.StatusOK, pairs)
+	}
+}
+
+// DismissDuplicate : Record that two customers are different people, so they are no longer
+// suggested as duplicates (only admin can access)
+func DismissDuplicate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var request struct {
+			CustomerIds []string `json:"customer_ids" validate:"required,len=2,unique"`
+		}
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := DuplicateValidate.Struct(request); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+		orgId := helper.TenantId(c)
+		if orgId == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "org_id is required"})
+			return
+		}
+		count, err := CustomerCollection.CountDocuments(ctx, helper.NotDeleted(bson.M{utils.ORG_ID: orgId, utils.CUSTOMER_ID: bson.M{"$in": request.CustomerIds}}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while finding customers"})
+			return
+		}
+		if count != 2 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "customer not found"})
+			return
+		}
+
+		sort.Strings(request.CustomerIds)
+		dismissal := models.DuplicateDismissal{
+			OrgId:       orgId,
+			Pair:        duplicatePairKey(request.CustomerIds[0], request.CustomerIds[1]),
+			CustomerIds: request.CustomerIds,
+			DismissedBy: c.GetString("uid"),
+		}
+		dismissal.DismissedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		update := bson.M{"$set": dismissal, "$setOnInsert": bson.M{"_id": primitive.NewObjectID()}}
+		if _, err := DuplicateDismissalCollection.UpdateOne(ctx, bson.M{utils.ORG_ID: orgId, "pair": dismissal.Pair}, update, options.Update().SetUpsert(true)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Dismissal was not saved"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "customers are no longer suggested as duplicates"})
+	}
+}
+
+// mergeRequest : customers to merge, the duplicate into the survivor, and for each field
+// whose value to keep. Fields left out keep the survivor's value, or the duplicate's when
+// the survivor has none.
+type mergeRequest struct {
+	SurvivorId  string            `json:"survivor_id" validate:"required"`
+	DuplicateId string            `json:"duplicate_id" validate:"required,nefield=SurvivorId"`
+	Fields      map[string]string `json:"fields" validate:"dive,keys,oneof=name email company phone external_id account_id custom_fields,endkeys,oneof=survivor duplicate"`
+}
+
+// mergedValue : value of field kept by a merge, from the survivor or the duplicate
+func mergedValue(choice string, survivor, duplicate *string) *string {
+	if choice == MERGE_DUPLICATE || (choice == "" && survivor == nil) {
+		if duplicate != nil {
+			return duplicate
+		}
+	}
+	return survivor
+}
+
+// mergedCustomer : survivor as it is after duplicate is merged into it
+func mergedCustomer(survivor, duplicate models.Customer, fields map[string]string, actorId string) models.Customer {
+	merged := survivor
+	merged.Name = mergedValue(fields["name"], survivor.Name, duplicate.Name)
+	merged.Email = mergedValue(fields["email"], survivor.Email, duplicate.Email)
+	merged.Company = mergedValue(fields["company"], survivor.Company, duplicate.Company)
+	merged.Phone = mergedValue(fields["phone"], survivor.Phone, duplicate.Phone)
+	merged.ExternalId = mergedValue(fields["external_id"], survivor.ExternalId, duplicate.ExternalId)
+	merged.AccountId = mergedValue(fields["account_id"], survivor.AccountId, duplicate.AccountId)
+
+	// tags and custom fields of both are kept, custom_fields picks the side winning a conflict
+	merged.Tags, _ = normalizeTags(append(append([]string{}, survivor.Tags...), duplicate.Tags...))
+	merged.CustomFields = map[string]interface{}{}
+	first, second := duplicate.CustomFields, survivor.CustomFields
+	if fields["custom_fields"] == MERGE_DUPLICATE {
+		first, second = second, first
+	}
+	for _, values := range []map[string]interface{}{first, second} {
+		for key, value := range values {
+			merged.CustomFields[key] = value
+		}
+	}
+
+	// a lead merged with a customer keeps the customer's portal login
+	if survivor.Password == nil && duplicate.Password != nil {
+		merged.Password = duplicate.Password
+		if from := customerLifecycle(survivor); isLeadStage(from) {
+			merged.Lifecycle = customerLifecycle(duplicate)
+			merged.History = append(append([]models.LifecycleChange{}, survivor.History...), lifecycleChange(from, merged.Lifecycle, "merged", actorId))
+		}
+	}
+	merged.MergedFrom = append(append([]string{}, survivor.MergedFrom...), duplicate.CustomerId)
+	return merged
+}
+
+// MergeCustomers : Merge a duplicate customer into a survivor, moving the duplicate's
+// interactions, tickets, notes and deals to the survivor and trashing the duplicate
+// (only admin can access)
+func MergeCustomers() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var request mergeRequest
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := DuplicateValidate.Struct(request); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		survivorFilter := helper.TenantFilter(c, helper.NotDeleted(bson.M{utils.CUSTOMER_ID: request.SurvivorId}))
+		duplicateFilter := helper.TenantFilter(c, helper.NotDeleted(bson.M{utils.CUSTOMER_ID: request.DuplicateId}))
+		var survivor, duplicate models.Customer
+		if err := CustomerCollection.FindOne(ctx, survivorFilter).Decode(&survivor); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "survivor not found"})
+			return
+		}
+		if err := CustomerCollection.FindOne(ctx, duplicateFilter).Decode(&duplicate); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "duplicate not found"})
+			return
+		}
+		if survivor.OrgId != duplicate.OrgId {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "customers of different organizations cannot be merged"})
+			return
+		}
+		if !helper.IfMatch(c, helper.ETag(survivor.CustomerId, survivor.Version)) {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": helper.ErrVersionConflict.Error()})
+			return
+		}
+
+		merged := mergedCustomer(survivor, duplicate, request.Fields, c.GetString("uid"))
+		merged.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		merged.Version++
+		deleteDuplicate := helper.SoftDeleteUpdate(c)
+		deleteDuplicate["$set"].(bson.M)["merged_into"] = survivor.CustomerId
+
+		moved := map[string]int64{}
+		err := database.WithTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+			result, err := CustomerCollection.ReplaceOne(sessCtx, helper.VersionFilter(survivorFilter, survivor.Version), merged)
+			if err != nil {
+				return err
+			}
+			if result.MatchedCount == 0 {
+				return helper.ErrVersionConflict
+			}
+			result, err = CustomerCollection.UpdateOne(sessCtx, helper.VersionFilter(duplicateFilter, duplicate.Version), deleteDuplicate)
+			if err != nil {
+				return err
+			}
+			if result.MatchedCount == 0 {
+				return helper.ErrVersionConflict
+			}
+
+			// trashed records move as well, so restoring them finds the survivor
+			references := []struct {
+				resource   string
+				collection *mongo.Collection
+				filter     bson.M
+				set        bson.M
+			}{
+				{utils.RESOURCE_INTERACTIONS, InteractionCollection, bson.M{utils.CUSTOMER_ID: duplicate.ID}, bson.M{utils.CUSTOMER_ID: survivor.ID}},
+				{utils.RESOURCE_TICKETS, TicketCollection, bson.M{utils.CUSTOMER_ID: duplicate.ID}, bson.M{utils.CUSTOMER_ID: survivor.ID}},
+				{utils.RESOURCE_NOTES, NoteCollection, bson.M{"resource": utils.RESOURCE_CUSTOMERS, "resource_id": duplicate.CustomerId}, bson.M{"resource_id": survivor.CustomerId}},
+				{utils.RESOURCE_DEALS, DealCollection, bson.M{utils.CUSTOMER_ID: duplicate.CustomerId}, bson.M{utils.CUSTOMER_ID: survivor.CustomerId}},
+				{utils.RESOURCE_NOTIFICATIONS, NotificationCollection, bson.M{"resource": utils.RESOURCE_CUSTOMERS, "resource_id": duplicate.CustomerId}, bson.M{"resource_id": survivor.CustomerId}},
+				{utils.RESOURCE_HEALTH_SCORES, HealthScoreCollection, bson.M{utils.CUSTOMER_ID: duplicate.CustomerId}, bson.M{utils.CUSTOMER_ID: survivor.CustomerId}},
+			}
+			// the health history keeps one entry per customer and day, the survivor's wins
+			days, err := HealthScoreCollection.Distinct(sessCtx, "day", bson.M{utils.CUSTOMER_ID: survivor.CustomerId})
+			if err != nil {
+				return err
+			}
+			if _, err := HealthScoreCollection.DeleteMany(sessCtx, bson.M{utils.CUSTOMER_ID: duplicate.CustomerId, "day": bson.M{"$in": days}}); err != nil {
+				return err
+			}
+			for _, reference := range references {
+				result, err := reference.collection.UpdateMany(sessCtx, reference.filter, bson.M{"$set": reference.set})
+				if err != nil {
+					return err
+				}
+				moved[reference.resource] = result.ModifiedCount
+			}
+			return nil
+		})
+		if errors.Is(err, helper.ErrVersionConflict) {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while merging customers"})
+			return
+		}
+
+		helper.RecordAudit(c, models.AuditLog{
+			Action:     utils.ACTION_MERGE,
+			Resource:   utils.RESOURCE_CUSTOMERS,
+			ResourceId: survivor.CustomerId,
+		}, survivor, merged)
+		helper.RecordAudit(c, models.AuditLog{
+			Action:     utils.ACTION_MERGE,
+			Resource:   utils.RESOURCE_CUSTOMERS,
+			ResourceId: duplicate.CustomerId,
+		}, duplicate, bson.M{"merged_into": survivor.CustomerId, "moved": moved})
+		rescoreCustomers(ctx, c, survivor.ID)
+
+		c.Header("ETag", helper.ETag(merged.CustomerId, merged.Version))
+		merged.Password, merged.Token = nil, nil
+		c.JSON(http.StatusOK, gin.H{"customer": merged, "merged_into": survivor.CustomerId, "moved": moved})
+	}
+}
@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nirmal/crm/models"
+	"github.com/nirmal/crm/utils"
+)
+
+func TestMergedCustomer(t *testing.T) {
+	survivor := models.Customer{
+		CustomerId:   "s1",
+		Name:         stringPtr("Jane Doe"),
+		Email:        stringPtr("jane@example.com"),
+		Company:      stringPtr("Acme"),
+		Tags:         []string{"vip", "newsletter"},
+		CustomFields: map[string]interface{}{"plan": "pro", "seats": 5},
+		MergedFrom:   []string{"s0"},
+	}
+	duplicate := models.Customer{
+		CustomerId:   "d1",
+		Name:         stringPtr("Jane D."),
+		Email:        stringPtr("jane.doe@example.com"),
+		Company:      stringPtr("Acme Inc"),
+		Phone:        stringPtr("+1 555 0100"),
+		Tags:         []string{"Newsletter", "trial"},
+		CustomFields: map[string]interface{}{"plan": "free", "region": "eu"},
+	}
+
+	tests := []struct {
+		name         string
+		fields       map[string]string
+		wantName     string
+		wantEmail    string
+		wantCompany  string
+		customFields map[string]interface{}
+	}{
+		{
+			"survivor by default",
+			nil,
+			"Jane Doe", "jane@example.com", "Acme",
+			map[string]interface{}{"plan": "pro", "seats": 5, "region": "eu"},
+		},
+		{
+			"fields picked from the duplicate",
+			map[string]string{"name": MERGE_DUPLICATE, "company": MERGE_DUPLICATE, "email": MERGE_SURVIVOR, "custom_fields": MERGE_DUPLICATE},
+			"Jane D.", "jane@example.com", "Acme Inc",
+			map[string]interface{}{"plan": "free", "seats": 5, "region": "eu"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged := mergedCustomer(survivor, duplicate, tt.fields, "u1")
+			if *merged.Name != tt.wantName || *merged.Email != tt.wantEmail || *merged.Company != tt.wantCompany {
+				t.Errorf("name, email, company = %s, %s, %s, want %s, %s, %s", *merged.Name, *merged.Email, *merged.Company, tt.wantName, tt.wantEmail, tt.wantCompany)
+			}
+			// the survivor has no phone, the duplicate's is kept
+			if merged.Phone == nil || *merged.Phone != "+1 555 0100" {
+				t.Errorf("phone = %v, want the duplicate's", merged.Phone)
+			}
+			if want := []string{"vip", "newsletter", "trial"}; !reflect.DeepEqual(merged.Tags, want) {
+				t.Errorf("tags = %v, want %v", merged.Tags, want)
+			}
+			if !reflect.DeepEqual(merged.CustomFields, tt.customFields) {
+				t.Errorf("custom fields = %v, want %v", merged.CustomFields, tt.customFields)
+			}
+			if want := []string{"s0", "d1"}; !reflect.DeepEqual(merged.MergedFrom, want) {
+				t.Errorf("merged from = %v, want %v", merged.MergedFrom, want)
+			}
+		})
+	}
+
+	// merging leaves the records it was given alone
+	if len(survivor.MergedFrom) != 1 || survivor.CustomFields["plan"] != "pro" || len(survivor.Tags) != 2 {
+		t.Errorf("mergedCustomer changed the survivor: %+v", survivor)
+	}
+}
+
+func TestMergedCustomerKeepsPortalLogin(t *testing.T) {
+	password := stringPtr("hash")
+	tests := []struct {
+		name          string
+		survivor      models.Customer
+		duplicate     models.Customer
+		wantPassword  *string
+		wantLifecycle string
+		wantHistory   int
+	}{
+		{
+			"lead merged with a customer",
+			models.Customer{Lifecycle: utils.LIFECYCLE_SQL},
+			models.Customer{Password: password},
+			password, utils.LIFECYCLE_CUSTOMER, 1,
+		},
+		{
+			"customer without a login merged with a customer",
+			models.Customer{Lifecycle: utils.LIFECYCLE_CHURNED},
+			models.Customer{Password: password, Lifecycle: utils.LIFECYCLE_CUSTOMER},
+			password, utils.LIFECYCLE_CHURNED, 0,
+		},
+		{
+			"survivor login is kept",
+			models.Customer{Password: stringPtr("survivor"), Lifecycle: utils.LIFECYCLE_LEAD},
+			models.Customer{Password: password},
+			nil, utils.LIFECYCLE_LEAD, 0,
+		},
+		{
+			"two leads",
+			models.Customer{Lifecycle: utils.LIFECYCLE_LEAD},
+			models.Customer{Lifecycle: utils.LIFECYCLE_MQL},
+			nil, utils.LIFECYCLE_LEAD, 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged := mergedCustomer(tt.survivor, tt.duplicate, nil, "u1")
+			wantPassword := tt.wantPassword
+			if wantPassword == nil {
+				wantPassword = tt.survivor.Password
+			}
+			if merged.Password != wantPassword {
+				t.Errorf("password = %v, want %v", merged.Password, wantPassword)
+			}
+			if merged.Lifecycle != tt.wantLifecycle {
+				t.Errorf("lifecycle = %q, want %q", merged.Lifecycle, tt.wantLifecycle)
+			}
+			if len(merged.History) != tt.wantHistory {
+				t.Fatalf("history = %v, want %d changes", merged.History, tt.wantHistory)
+			}
+			if tt.wantHistory > 0 {
+				change := merged.History[0]
+				if change.From != tt.survivor.Lifecycle || change.To != tt.wantLifecycle || change.Reason != "merged" || change.ChangedBy != "u1" {
+					t.Errorf("history = %+v", change)
+				}
+			}
+		})
+	}
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
@@ -1,10 +1,20 @@
 package controllers
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
-	"encoding/csv"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -12,14 +22,65 @@ import (
 	"github.com/nirmal/crm/models"
 	"github.com/nirmal/crm/utils"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-// ExportData : Export data in csv or json format
+// customerExportColumns : exported customer fields, credentials are never exported
+var customerExportColumns = []string{"id", "customer_id", "org_id", "external_id", "account_id", "name", "email", "company", "phone", "tags", "lifecycle", "created_at", "updated_at"}
+
+// customerExportRecord : customer as an export row, in customerExportColumns order
+func customerExportRecord(customer models.Customer) bson.D {
+	return bson.D{
+		{Key: "id", Value: customer.ID},
+		{Key: "customer_id", Value: customer.CustomerId},
+		{Key: "org_id", Value: customer.OrgId},
+		{Key: "external_id", Value: customer.ExternalId},
+		{Key: "account_id", Value: customer.AccountId},
+		{Key: "name", Value: customer.Name},
+		{Key: "email", Value: customer.Email},
+		{Key: "company", Value: customer.Company},
+		{Key: "phone", Value: customer.Phone},
+		{Key: "tags", Value: strings.Join(customer.Tags, tagSeparator)},
+		{Key: "lifecycle", Value: customerLifecycle(customer)},
+		{Key: "created_at", Value: customer.CreatedAt},
+		{Key: "updated_at", Value: customer.UpdatedAt},
+	}
+}
+
+// ExportData : Export data in csv, json or ndjson format, streamed straight from the database
 func ExportCustomerData() gin.HandlerFunc {
+	return exportData(customerDataEntity)
+}
+
+// ExportUserData : Export users, passwords and tokens are never exported
+func ExportUserData() gin.HandlerFunc {
+	return exportData(userDataEntity)
+}
+
+// ExportAccountData : Export accounts with their parent account and owner ids
+func ExportAccountData() gin.HandlerFunc {
+	return exportData(accountDataEntity)
+}
+
+// ExportTicketData : Export tickets with their interaction and customer ids
+func ExportTicketData() gin.HandlerFunc {
+	return exportData(ticketDataEntity)
+}
+
+// ExportInteractionData : Export interactions with their user and customer ids
+func ExportInteractionData() gin.HandlerFunc {
+	return exportData(interactionDataEntity)
+}
+
+// exportData : admin only export of the caller's live records of entity.
+// With ?async=true the export runs as a background job and the file is downloaded from the job.
+func exportData(entity dataEntity) gin.HandlerFunc {
 	return func(c *gin.Context) {
 
 		format := c.Query("format")
-		// export data in csv or json format
+		// export data in csv, json, ndjson, xlsx or vcf format
 		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
@@ -28,145 +89,849 @@ func ExportCustomerData() gin.HandlerFunc {
 			return
 		}
 
-		cursor, err := CustomerCollection.Find(ctx, bson.M{})
+		if !helper.ValidExportFormat(format) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid format"})
+			return
+		}
+
+		params := exportParams{format: format, version: c.DefaultQuery("version", helper.VCARD_V3), compress: c.Query("gzip") == "true"}
+		if format == helper.FORMAT_VCARD {
+			if entity.resource != utils.RESOURCE_CUSTOMERS {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "vcf is only available for customers"})
+				return
+			}
+			if params.version != helper.VCARD_V3 && params.version != helper.VCARD_V4 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid vCard version, expected 3.0 or 4.0"})
+				return
+			}
+		}
+
+		// custom fields of the caller's organization are exported after the fixed columns
+		scope := requestExportScope(c)
+		entity, err := withCustomFieldColumns(ctx, scope, entity)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if params.filter, err = exportFilter(ctx, scope, entity); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if c.Query("async") == "true" {
+			job, err := startJob(c, utils.JOB_EXPORT, entity.resource, format, func(ctx context.Context, c *gin.Context, run *jobRun) error {
+				return runExportJob(ctx, scope, entity, params, run)
+			})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			respondJobStarted(c, job)
+			return
+		}
+
+		cursor, err := openExport(ctx, scope, entity, format, params.filter)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
 		defer cursor.Close(ctx)
 
-		var customers []models.Customer
-		if err = cursor.All(ctx, &customers); err != nil {
+		// once streaming has started the status is already sent, so failures are only
+		// logged and the download ends early
+		out, finish := helper.ExportResponse(c, entity.resource, format)
+		writer, err := newExportWriter(out, entity, params)
+		if err != nil {
+			log.Printf("error starting %s export: %v", entity.resource, err)
+			return
+		}
+		if _, err := writeExport(ctx, cursor, writer, entity.record, nil, 0); err != nil {
+			log.Printf("error exporting %s: %v", entity.resource, err)
+		}
+		if err := writer.Close(); err != nil {
+			log.Printf("error finishing %s export: %v", entity.resource, err)
+		}
+		if err := finish(); err != nil {
+			log.Printf("error compressing %s export: %v", entity.resource, err)
+		}
+	}
+}
+
+// ExportCustomerVCard : Export one customer as a vCard, ?version=3.0 (default) or 4.0
+func ExportCustomerVCard() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var customer models.Customer
+		err := CustomerCollection.FindOne(ctx, helper.TenantFilter(c, helper.NotDeleted(bson.M{utils.CUSTOMER_ID: c.Param(utils.CUSTOMER_ID)}))).Decode(&customer)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "customer not found"})
+			return
+		}
+
+		var card bytes.Buffer
+		writer, err := helper.NewVCardWriter(&card, c.DefaultQuery("version", helper.VCARD_V3))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := writer.WriteRecord(customerExportRecord(customer)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if err := writer.Close(); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
 
-		switch format {
-		case "json":
-			c.JSON(http.StatusOK, customers)
+		helper.RecordAudit(c, models.AuditLog{
+			Action:     utils.ACTION_EXPORT,
+			Resource:   utils.RESOURCE_CUSTOMERS,
+			ResourceId: customer.CustomerId,
+		}, nil, bson.M{"format": helper.FORMAT_VCARD})
 
-		case "csv":
-			c.Header("Content-Type", "text/csv")
-			c.Header("Content-Disposition", "attachment;filename=customers.csv")
+		c.Header("Content-Disposition", "attachment;filename="+customer.CustomerId+"."+helper.FORMAT_VCARD)
+		c.Data(http.StatusOK, helper.ExportContentType(helper.FORMAT_VCARD), card.Bytes())
+	}
+}
+
+// ExportWorkbook : Export several entities as the sheets of one Excel workbook,
+// ?sheets= lists them and defaults to customers, interactions and tickets
+func ExportWorkbook() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
 
-			writer := csv.NewWriter(c.Writer)
-			defer writer.Flush()
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 
-			// Writing CSV headers
-			err = writer.Write([]string{"ID", "CustomerId", "Name", "Email", "Password", "Company", "Phone", "CreatedAt", "UpdatedAt"})
+		scope := requestExportScope(c)
+		sheets := c.DefaultQuery("sheets", strings.Join([]string{utils.RESOURCE_CUSTOMERS, utils.RESOURCE_INTERACTIONS, utils.RESOURCE_TICKETS}, ","))
+		var entities []dataEntity
+		filters := map[string]bson.M{}
+		for _, resource := range strings.Split(sheets, ",") {
+			entity, ok := findDataEntity(strings.TrimSpace(resource))
+			if !ok {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown sheet %q", resource)})
+				return
+			}
+			entity, err := withCustomFieldColumns(ctx, scope, entity)
 			if err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 				return
 			}
+			filter, err := exportFilter(ctx, scope, entity)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			entities = append(entities, entity)
+			filters[entity.resource] = filter
+		}
 
-			// Writing CSV records
-			for _, customer := range customers {
-				err = writer.Write([]string{
-					customer.CustomerId,
-					safeString(customer.Name),
-					safeString(customer.Email),
-					HashPassword(safeString(customer.Password)),
-					safeString(customer.Company),
-					safeString(customer.Phone),
-					customer.CreatedAt.String(),
-					customer.UpdatedAt.String(),
-				})
+		if c.Query("async") == "true" {
+			job, err := startJob(c, utils.JOB_EXPORT, sheets, helper.FORMAT_XLSX, func(ctx context.Context, c *gin.Context, run *jobRun) error {
+				file, err := run.createResult(workbookExportName + "." + helper.FORMAT_XLSX)
 				if err != nil {
-					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-					continue
+					return err
 				}
+				defer file.Close()
+				if err := exportWorkbook(ctx, scope, entities, filters, file, run.progress); err != nil {
+					return err
+				}
+				run.job.Summary = map[string]interface{}{"exported": run.job.Processed}
+				return file.Close()
+			})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
 			}
+			respondJobStarted(c, job)
+			return
+		}
 
-		default:
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid format"})
+		out, finish := helper.ExportResponse(c, workbookExportName, helper.FORMAT_XLSX)
+		if err := exportWorkbook(ctx, scope, entities, filters, out, nil); err != nil {
+			log.Printf("error exporting workbook: %v", err)
+		}
+		if err := finish(); err != nil {
+			log.Printf("error compressing workbook export: %v", err)
 		}
 	}
 }
 
-// only admin can import data
+// workbookExportName : file name of multi sheet exports
+const workbookExportName = "crm"
+
+// exportWorkbook : write each entity as a sheet of one workbook to w, filters are by resource
+func exportWorkbook(ctx context.Context, scope exportScope, entities []dataEntity, filters map[string]bson.M, w io.Writer, progress func(processed, errors int)) error {
+	book, err := helper.NewXLSXWorkbook(w)
+	if err != nil {
+		return err
+	}
+
+	written := 0
+	for _, entity := range entities {
+		cursor, err := openExport(ctx, scope, entity, helper.FORMAT_XLSX, filters[entity.resource])
+		if err != nil {
+			return err
+		}
+		sheet, err := book.AddSheet(entity.resource, entity.columns)
+		if err == nil {
+			written, err = writeExport(ctx, cursor, sheet, entity.record, progress, written)
+		}
+		cursor.Close(ctx)
+		if err != nil {
+			return fmt.Errorf("%s: %w", entity.resource, err)
+		}
+		if err := sheet.Close(); err != nil {
+			return err
+		}
+	}
+	return book.Close()
+}
+
+// exportParams : how an entity export is written
+type exportParams struct {
+	format   string
+	version  string
+	compress bool
+	filter   bson.M
+}
+
+// exportScope : whose records an export reads, the query parameters selecting them and the
+// actor the export is audited as. Exports of a request and of a schedule build their own.
+type exportScope struct {
+	orgId string
+	// allOrgs : a SUPER_ADMIN exporting without ?org_id= reads every organization
+	allOrgs bool
+	query   url.Values
+	// actor : audit entry with the actor fields set, the export fills in the rest
+	actor models.AuditLog
+}
+
+// requestExportScope : scope of an export the caller asked for, the request's query selecting
+func requestExportScope(c *gin.Context) exportScope {
+	return exportScope{
+		orgId:   helper.TenantId(c),
+		allOrgs: c.GetString("role") == utils.ROLE_SUPER_ADMIN && helper.TenantId(c) == "",
+		query:   c.Request.URL.Query(),
+		actor:   helper.RequestAuditEntry(c, models.AuditLog{}),
+	}
+}
+
+// tenantFilter : restrict filter to the organization of the scope
+func (s exportScope) tenantFilter(filter bson.M) bson.M {
+	if !s.allOrgs {
+		filter[utils.ORG_ID] = s.orgId
+	}
+	return filter
+}
+
+// exportFilter : records of entity selected by the query of scope, all of them when
+// the entity has no export filters
+func exportFilter(ctx context.Context, scope exportScope, entity dataEntity) (bson.M, error) {
+	if entity.filter == nil {
+		return bson.M{}, nil
+	}
+	return entity.filter(ctx, scope)
+}
+
+// newExportWriter : writer for an entity export, vCards use the requested version
+func newExportWriter(w io.Writer, entity dataEntity, params exportParams) (helper.ExportWriter, error) {
+	if params.format == helper.FORMAT_VCARD {
+		return helper.NewVCardWriter(w, params.version)
+	}
+	return helper.NewExportWriter(params.format, w, entity.resource, entity.columns)
+}
+
+// openExport : cursor over the live records of entity in scope matching filter, and the
+// audit entry for the export
+func openExport(ctx context.Context, scope exportScope, entity dataEntity, format string, filter bson.M) (*mongo.Cursor, error) {
+	if filter == nil {
+		filter = bson.M{}
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	filter = scope.tenantFilter(helper.NotDeleted(filter))
+	var cursor *mongo.Cursor
+	var err error
+	if entity.collection == CustomerCollection {
+		// segment filters may test activity
+		cursor, err = findCustomers(ctx, filter, opts)
+	} else {
+		cursor, err = entity.collection.Find(ctx, filter, opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entry := scope.actor
+	entry.Action, entry.Resource = utils.ACTION_EXPORT, entity.resource
+	helper.WriteAudit(entry, nil, bson.M{"format": format})
+	return cursor, nil
+}
+
+// runExportJob : export entity into the job's result file
+func runExportJob(ctx context.Context, scope exportScope, entity dataEntity, params exportParams, run *jobRun) error {
+	cursor, err := openExport(ctx, scope, entity, params.format, params.filter)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	name := entity.resource + "." + params.format
+	if params.compress {
+		name += ".gz"
+	}
+	file, err := run.createResult(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var out io.Writer = file
+	var gz *gzip.Writer
+	if params.compress {
+		gz = gzip.NewWriter(file)
+		out = gz
+	}
+	writer, err := newExportWriter(out, entity, params)
+	if err != nil {
+		return err
+	}
+	if _, err := writeExport(ctx, cursor, writer, entity.record, run.progress, 0); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return err
+		}
+	}
+	run.job.Summary = map[string]interface{}{"exported": run.job.Processed}
+	return file.Close()
+}
+
+// writeExport : write every document of cursor to writer as it is read, counting on from
+// written and reporting the count to progress when it is set. The writer is left open.
+func writeExport(ctx context.Context, cursor *mongo.Cursor, writer helper.ExportWriter, toRecord func(cursor *mongo.Cursor) (bson.D, error), progress func(processed, errors int), written int) (int, error) {
+	for cursor.Next(ctx) {
+		record, err := toRecord(cursor)
+		if err != nil {
+			return written, fmt.Errorf("decoding record: %w", err)
+		}
+		if err := writer.WriteRecord(record); err != nil {
+			return written, err
+		}
+		written++
+		if progress != nil {
+			progress(written, 0)
+		}
+	}
+	return written, cursor.Err()
+}
+
+// ImportCustomerData : Import customers, every row needs an initial password
 func ImportCustomerData() gin.HandlerFunc {
+	return importData(customerDataEntity)
+}
+
+// ImportUserData : Import users, every row needs an initial password
+func ImportUserData() gin.HandlerFunc {
+	return importData(userDataEntity)
+}
+
+// ImportAccountData : Import accounts, unique by name and domain
+func ImportAccountData() gin.HandlerFunc {
+	return importData(accountDataEntity)
+}
+
+// ImportTicketData : Import tickets for existing interactions
+func ImportTicketData() gin.HandlerFunc {
+	return importData(ticketDataEntity)
+}
+
+// ImportInteractionData : Import interactions for existing customers and users
+func ImportInteractionData() gin.HandlerFunc {
+	return importData(interactionDataEntity)
+}
+
+// importData : admin only import of entity records into the caller's organization.
+// The data is read from the multipart "file" field or else the request body, and its
+// headers are renamed with the saved ?mapping= profile or a built in ?preset= for another
+// CRM's export. Rows matching an existing record
+// by ?key= are skipped, updated or merged depending on ?mode= and the merge rules. Every
+// row is validated, valid rows are written and invalid ones reported back with their row
+// number. With ?dry_run=true nothing is written, with ?report=csv the report is a CSV download.
+// With ?async=true the import runs as a background job and the CSV report is its result file.
+func importData(entity dataEntity) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// import data in csv or json
 		format := c.Query("format")
+		dryRun := c.Query("dry_run") == "true"
 		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
 		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
-			fmt.Println("Error:kdbv1", err)
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		switch format {
-		case "json":
-			var newCustomersData []models.Customer
-			if err := c.BindJSON(&newCustomersData); err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-				return
-			}
+		if helper.TenantId(c) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "org_id is required"})
+			return
+		}
 
-			var docs []interface{}
-			for _, customer := range newCustomersData {
-				docs = append(docs, customer)
-			}
+		if format == helper.FORMAT_VCARD && entity.resource != utils.RESOURCE_CUSTOMERS {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "vcf is only available for customers"})
+			return
+		}
+
+		opts, err := parseImportOptions(c, entity)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 
-			_, err := CustomerCollection.InsertMany(ctx, docs)
+		columns, err := importMappingColumns(ctx, c, entity.resource, c.Query("mapping"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		preset, err := findImportPreset(entity.resource, c.Query("preset"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if preset != nil && (columns != nil || format == helper.FORMAT_VCARD) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "preset cannot be combined with a mapping or vcf"})
+			return
+		}
+
+		var input io.Reader = c.Request.Body
+		if file, _, err := c.Request.FormFile("file"); err == nil {
+			defer file.Close()
+			input = file
+		}
+
+		async := c.Query("async") == "true"
+		if async {
+			upload, err := spoolJobUpload(input)
 			if err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 				return
 			}
-
-			c.JSON(http.StatusOK, gin.H{"message": "Data imported successfully"})
-
-		case "csv":
-			fmt.Println("Error:kdbv2")
-			file, _, err := c.Request.FormFile("file")
+			input = upload
+			// the upload only lives as long as the job, or this request if no job starts
+			removeUpload := func() {
+				upload.Close()
+				os.Remove(upload.Name())
+			}
+			reader, err := newImportReader(format, input, preset)
 			if err != nil {
-				fmt.Println("Error:kdbv3", err)
+				removeUpload()
 				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 				return
 			}
-
-			reader := csv.NewReader(file)
-			records, err := reader.ReadAll()
+			job, err := startJob(c, utils.JOB_IMPORT, entity.resource, format, func(ctx context.Context, c *gin.Context, run *jobRun) error {
+				defer removeUpload()
+				return runImportJob(ctx, c, entity, opts, columns, reader, dryRun, run)
+			})
 			if err != nil {
-				fmt.Println("Error:kdbv4", err)
-				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				removeUpload()
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 				return
 			}
+			respondJobStarted(c, job)
+			return
+		}
 
-			// Skipping header row records[0]
-			var docs []interface{}
-			for _, record := range records[1:] {
-				customer := models.Customer{
-					Name:      &record[1],
-					Email:     &record[2],
-					Company:   &record[3],
-					Phone:     &record[4],
-					CreatedAt: time.Now(),
-					UpdatedAt: time.Now(),
-				}
-				docs = append(docs, customer)
+		reader, err := newImportReader(format, input, preset)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		result, err := runImport(ctx, c, entity, opts, columns, reader, dryRun, nil)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.As(err, &importInputError{}) {
+				status = http.StatusBadRequest
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+
+		if c.Query("report") == helper.FORMAT_CSV {
+			c.Header("Content-Type", helper.ExportContentType(helper.FORMAT_CSV))
+			c.Header("Content-Disposition", "attachment;filename="+entity.resource+"_import_errors.csv")
+			if err := writeImportReport(c.Writer, result.rejected); err != nil {
+				log.Printf("error writing %s import report: %v", entity.resource, err)
 			}
+			return
+		}
+
+		c.JSON(http.StatusOK, result.summary(dryRun))
+	}
+}
+
+// newImportReader : reader for an import file, translated by preset when there is one.
+// Contacts from vCards carry no credentials, so each gets a random password the customer
+// never knew and must be replaced to sign in.
+func newImportReader(format string, input io.Reader, preset *importPreset) (helper.ImportReader, error) {
+	reader, err := helper.NewImportReader(format, input)
+	if err != nil {
+		return nil, err
+	}
+	if preset != nil {
+		return preset.reader(reader), nil
+	}
+	if format == helper.FORMAT_VCARD {
+		return contactImportReader{reader}, nil
+	}
+	return reader, nil
+}
+
+// contactImportReader : vCard contacts as customer rows with a generated password
+type contactImportReader struct {
+	helper.ImportReader
+}
+
+func (r contactImportReader) Next() (map[string]string, error) {
+	record, err := r.ImportReader.Next()
+	if err != nil {
+		return nil, err
+	}
+	if record["password"], err = importPassword(); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// importPassword : random initial password for contacts imported without one
+func importPassword() (string, error) {
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// importResult : what an import did with the rows of its file
+type importResult struct {
+	created  int
+	updated  int
+	skipped  int
+	rejected []importRejection
+}
+
+func (r importResult) summary(dryRun bool) gin.H {
+	summary := gin.H{"created": r.created, "updated": r.updated, "skipped": r.skipped, "rejected": r.rejected}
+	if dryRun {
+		summary["dry_run"] = true
+	}
+	return summary
+}
+
+// importInputError : the import file itself could not be read
+type importInputError struct {
+	row int
+	err error
+}
+
+func (e importInputError) Error() string {
+	return fmt.Sprintf("row %d: %v", e.row, e.err)
+}
 
-			_, err = CustomerCollection.InsertMany(ctx, docs)
+// runImport : validate every row of reader and, unless dryRun, write the valid ones.
+// progress, when set, is told how many rows have been processed and rejected so far.
+func runImport(ctx context.Context, c *gin.Context, entity dataEntity, opts importOptions, columns map[string]string, reader helper.ImportReader, dryRun bool, progress func(processed, errors int)) (importResult, error) {
+	result := importResult{rejected: []importRejection{}}
+	var docs []interface{}
+	var docRows []importRow
+	var updates []importUpdate
+	// ids and keys seen earlier in the file, the database cannot catch those yet
+	seen := map[string]bool{}
+	for row := 1; ; row++ {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		if progress != nil && row > 1 {
+			progress(row-1, len(result.rejected))
+		}
+
+		record, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return result, importInputError{row: row, err: err}
+		}
+		record = applyImportMapping(record, columns)
+
+		if opts.key != "" {
+			existing, err := findImportMatch(ctx, c, entity, opts.key, record)
+			if err == nil && existing != nil {
+				matchKey := opts.key + " " + record[opts.key]
+				if seen[matchKey] {
+					err = fmt.Errorf("duplicate %s in file", matchKey)
+				} else {
+					seen[matchKey] = true
+				}
+			}
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				return
+				result.rejected = append(result.rejected, newImportRejection(row, record, err))
+				continue
+			}
+			if existing != nil {
+				if opts.mode == utils.IMPORT_INSERT_ONLY {
+					result.skipped++
+					continue
+				}
+				set, err := mergeImportRow(ctx, c, entity, existing, record, opts.rules)
+				if err != nil {
+					result.rejected = append(result.rejected, newImportRejection(row, record, err))
+					continue
+				}
+				if len(set) == 0 {
+					result.skipped++
+					continue
+				}
+				updates = append(updates, importUpdate{importRow: importRow{row: row, values: record}, existing: existing, set: set})
+				continue
+			}
+			if opts.mode == utils.IMPORT_UPDATE_EXISTING {
+				result.skipped++
+				continue
 			}
+		}
 
-			c.JSON(http.StatusOK, gin.H{"message": "Data imported successfully"})
+		doc, err := entity.parse(ctx, c, record)
+		if err == nil {
+			for _, key := range importKeys(doc) {
+				if seen[key] {
+					err = fmt.Errorf("duplicate %s in file", key)
+					break
+				}
+			}
+		}
+		if err != nil {
+			result.rejected = append(result.rejected, newImportRejection(row, record, err))
+			continue
+		}
+		for _, key := range importKeys(doc) {
+			seen[key] = true
+		}
+		docs = append(docs, doc)
+		docRows = append(docRows, importRow{row: row, values: record})
+	}
 
-		default:
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid format"})
+	if dryRun {
+		result.created, result.updated = len(docs), len(updates)
+		return result, nil
+	}
+
+	if len(docs) > 0 {
+		// unordered, so one failing document does not stop the rest
+		inserted, err := entity.collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+		if inserted != nil {
+			result.created = len(inserted.InsertedIDs)
+		}
+		var bulkErr mongo.BulkWriteException
+		if errors.As(err, &bulkErr) {
+			for _, writeErr := range bulkErr.WriteErrors {
+				failed := docRows[writeErr.Index]
+				result.rejected = append(result.rejected, newImportRejection(failed.row, failed.values, writeErr))
+			}
+		} else if err != nil {
+			return result, err
+		}
+	}
+	for _, update := range updates {
+		if ctx.Err() != nil {
+			break
 		}
+		if err := applyImportUpdate(ctx, c, entity, update); err != nil {
+			result.rejected = append(result.rejected, newImportRejection(update.row, update.values, err))
+			continue
+		}
+		result.updated++
+	}
+	sort.Slice(result.rejected, func(i, j int) bool { return result.rejected[i].Row < result.rejected[j].Row })
+	// imported customers and activity change lead scores, large imports rescore the
+	// organization in the background
+	touched := importTouchedCustomers(entity, docs, updates)
+	if len(touched) > importRescoreLimit {
+		if orgId := helper.TenantId(c); orgId != "" {
+			rescoreOrganizationLater(orgId)
+		}
+	} else if len(touched) > 0 {
+		rescoreCustomers(ctx, c, touched...)
+	}
 
+	helper.RecordAudit(c, models.AuditLog{
+		Action:   utils.ACTION_IMPORT,
+		Resource: entity.resource,
+	}, nil, bson.M{"mode": opts.mode, "created": result.created, "updated": result.updated, "skipped": result.skipped, "rejected": len(result.rejected)})
+	return result, ctx.Err()
+}
+
+// importRescoreLimit : most customers an import rescores right away, beyond it the whole
+// organization is rescored in the background
+const importRescoreLimit = 1000
+
+// importTouchedCustomers : customers imported or updated, and those whose interactions or
+// tickets were imported
+func importTouchedCustomers(entity dataEntity, docs []interface{}, updates []importUpdate) []primitive.ObjectID {
+	seen := map[primitive.ObjectID]bool{}
+	var ids []primitive.ObjectID
+	add := func(id primitive.ObjectID) {
+		if !id.IsZero() && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	for _, doc := range docs {
+		switch v := doc.(type) {
+		case models.Customer:
+			add(v.ID)
+		case models.Interaction:
+			add(v.CustomerID)
+		case models.Ticket:
+			add(v.CustomerID)
+		}
+	}
+	for _, update := range updates {
+		if entity.resource != utils.RESOURCE_CUSTOMERS {
+			break
+		}
+		if id, ok := update.existing["_id"].(primitive.ObjectID); ok {
+			add(id)
+		}
+	}
+	return ids
+}
+
+// runImportJob : import in the background, the summary is kept on the job and the
+// rejected rows become its downloadable CSV report
+func runImportJob(ctx context.Context, c *gin.Context, entity dataEntity, opts importOptions, columns map[string]string, reader helper.ImportReader, dryRun bool, run *jobRun) error {
+	result, err := runImport(ctx, c, entity, opts, columns, reader, dryRun, run.progress)
+	summary := result.summary(dryRun)
+	delete(summary, "rejected")
+	summary["rejected"] = len(result.rejected)
+	run.job.Summary = summary
+	run.job.Errors = int64(len(result.rejected))
+	if err != nil || len(result.rejected) == 0 {
+		return err
+	}
+
+	file, err := run.createResult(entity.resource + "_import_errors.csv")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := writeImportReport(file, result.rejected); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// importRow : a parsed row waiting to be written, kept to report write failures
+type importRow struct {
+	row    int
+	values map[string]string
+}
+
+// importRejection : an import row that was not inserted and why
+type importRejection struct {
+	Row    int               `json:"row"`
+	Error  string            `json:"error"`
+	Values map[string]string `json:"values"`
+}
+
+// newImportRejection : rejection for row, credentials are left out of the report
+func newImportRejection(row int, values map[string]string, err error) importRejection {
+	reported := make(map[string]string, len(values))
+	for column, value := range values {
+		if column != "password" && column != "token" {
+			reported[column] = value
+		}
 	}
+	return importRejection{Row: row, Error: err.Error(), Values: reported}
 }
 
-func safeString(ptr *string) string {
-	if ptr != nil {
-		return *ptr
+// writeImportReport : the rejected rows as CSV with the reason next to the original
+// values, so the file can be fixed and imported again
+func writeImportReport(w io.Writer, rejected []importRejection) error {
+	columnSet := map[string]bool{}
+	for _, rejection := range rejected {
+		for column := range rejection.Values {
+			columnSet[column] = true
+		}
+	}
+	valueColumns := make([]string, 0, len(columnSet))
+	for column := range columnSet {
+		valueColumns = append(valueColumns, column)
+	}
+	sort.Strings(valueColumns)
+
+	writer, err := helper.NewExportWriter(helper.FORMAT_CSV, w, "import_errors", append([]string{"row", "error"}, valueColumns...))
+	if err != nil {
+		return err
+	}
+	for _, rejection := range rejected {
+		record := bson.D{{Key: "row", Value: rejection.Row}, {Key: "error", Value: rejection.Error}}
+		for _, column := range valueColumns {
+			record = append(record, bson.E{Key: column, Value: rejection.Values[column]})
+		}
+		if err := writer.WriteRecord(record); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+// importKeys : values of a parsed record that must be unique within one import file
+func importKeys(doc interface{}) []string {
+	switch v := doc.(type) {
+	case models.Customer:
+		keys := []string{"id " + v.CustomerId}
+		if v.Email != nil {
+			keys = append(keys, "email "+*v.Email)
+		}
+		if v.ExternalId != nil {
+			keys = append(keys, "external_id "+*v.ExternalId)
+		}
+		return keys
+	case models.User:
+		keys := []string{"id " + v.UserId}
+		if v.Email != nil {
+			keys = append(keys, "email "+*v.Email)
+		}
+		return keys
+	case models.Account:
+		keys := []string{"id " + v.AccountId}
+		if v.Name != nil {
+			keys = append(keys, "name "+strings.ToLower(*v.Name))
+		}
+		if v.Domain != nil {
+			keys = append(keys, "domain "+*v.Domain)
+		}
+		return keys
+	case models.Interaction:
+		return []string{"id " + v.InteractionId}
+	case models.Ticket:
+		return []string{"id " + v.TicketId}
 	}
-	return ""
+	return nil
 }
@@ -0,0 +1,554 @@
+package controllers
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/nirmal/crm/database"
+	helper "github.com/nirmal/crm/helpers"
+	"github.com/nirmal/crm/models"
+	"github.com/nirmal/crm/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultExportScheduleRetain = 30
+	// a scheduled export gets as long as a backup
+	exportScheduleTimeout = 30 * time.Minute
+)
+
+var ExportScheduleValidate = validator.New()
+var ExportScheduleCollection *mongo.Collection = database.OpenCollection("Cluster0", "export_schedules")
+var ExportRunCollection *mongo.Collection = database.OpenCollection("Cluster0", "export_runs")
+
+// exportScheduleDir : directory scheduled exports are written to, EXPORT_SCHEDULE_DIR.
+// Each schedule writes to <org_id>/<schedule_id> below it.
+func exportScheduleDir() string {
+	if dir := os.Getenv("EXPORT_SCHEDULE_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(os.TempDir(), "crm-exports")
+}
+
+// scheduleExportScope : scope of the runs of schedule, the records of its organization
+// selected by its filter. Exports are audited as the schedule itself, runId as the request.
+func scheduleExportScope(schedule models.ExportSchedule, runId string) exportScope {
+	query := url.Values{}
+	for param, value := range schedule.Filter {
+		query.Set(param, value)
+	}
+	return exportScope{
+		orgId: schedule.OrgId,
+		query: query,
+		actor: models.AuditLog{
+			ActorId:   schedule.ScheduleId,
+			ActorType: utils.ACTOR_SCHEDULE,
+			OrgId:     schedule.OrgId,
+			RequestId: runId,
+		},
+	}
+}
+
+// nextScheduleRun : next time schedule runs after t, nil when disabled or never
+func nextScheduleRun(schedule models.ExportSchedule, t time.Time) (*time.Time, error) {
+	cron, err := helper.ParseCron(*schedule.Cron)
+	if err != nil {
+		return nil, err
+	}
+	location, err := time.LoadLocation(schedule.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone %q", schedule.Timezone)
+	}
+	next := cron.Next(t.In(location))
+	if next.IsZero() {
+		return nil, fmt.Errorf("cron expression %q never runs", *schedule.Cron)
+	}
+	if schedule.Enabled != nil && !*schedule.Enabled {
+		return nil, nil
+	}
+	next = next.UTC()
+	return &next, nil
+}
+
+// checkExportSchedule : fill in the defaults of schedule and check its cron expression,
+// timezone and filter, setting the next run
+func checkExportSchedule(ctx context.Context, schedule *models.ExportSchedule) error {
+	if schedule.Timezone == "" {
+		schedule.Timezone = "UTC"
+	}
+	if schedule.Retain == 0 {
+		schedule.Retain = envInt("EXPORT_SCHEDULE_RETAIN", defaultExportScheduleRetain)
+	}
+	if schedule.Enabled == nil {
+		enabled := true
+		schedule.Enabled = &enabled
+	}
+
+	entity, ok := findDataEntity(schedule.Resource)
+	if !ok {
+		return fmt.Errorf("unknown resource %q", schedule.Resource)
+	}
+	for param := range schedule.Filter {
+		known := false
+		for _, filterParam := range entity.filterParams {
+			known = known || param == filterParam
+		}
+		if !known {
+			return fmt.Errorf("%s exports cannot be filtered by %q", schedule.Resource, param)
+		}
+	}
+	if _, err := exportFilter(ctx, scheduleExportScope(*schedule, ""), entity); err != nil {
+		return err
+	}
+
+	next, err := nextScheduleRun(*schedule, time.Now())
+	if err != nil {
+		return err
+	}
+	schedule.NextRunAt = next
+	return nil
+}
+
+// StartExportScheduler : run due export schedules every minute in the background
+func StartExportScheduler() {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		for {
+			RunDueExportSchedules()
+			<-ticker.C
+		}
+	}()
+}
+
+// RunDueExportSchedules : run every enabled schedule whose next run has come, one at a time.
+// A schedule is claimed by moving its next run first, so each run happens once even with
+// several servers, and runs missed while the server was down are caught up with a single run.
+// A schedule whose next run cannot be worked out is disabled with the error instead of run.
+func RunDueExportSchedules() {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	cursor, err := ExportScheduleCollection.Find(ctx, bson.M{"enabled": true, "next_run_at": bson.M{"$lte": now}})
+	if err != nil {
+		log.Printf("error finding due export schedules: %v", err)
+		return
+	}
+	var schedules []models.ExportSchedule
+	if err := cursor.All(ctx, &schedules); err != nil {
+		log.Printf("error decoding export schedules: %v", err)
+		return
+	}
+
+	for _, schedule := range schedules {
+		claim := bson.M{"_id": schedule.ID, "next_run_at": schedule.NextRunAt}
+		next, err := nextScheduleRun(schedule, now)
+		if err != nil {
+			log.Printf("error scheduling export %s, disabling it: %v", schedule.ScheduleId, err)
+			if _, err := ExportScheduleCollection.UpdateOne(context.Background(), claim, bson.M{
+				"$set":   bson.M{"enabled": false, "last_status": utils.JOB_FAILED, "last_error": err.Error()},
+				"$unset": bson.M{"next_run_at": ""},
+			}); err != nil {
+				log.Printf("error disabling export schedule %s: %v", schedule.ScheduleId, err)
+			}
+			continue
+		}
+		result, err := ExportScheduleCollection.UpdateOne(context.Background(), claim, bson.M{"$set": bson.M{"next_run_at": next}})
+		if err != nil || result.ModifiedCount == 0 {
+			continue
+		}
+		runExportSchedule(schedule)
+	}
+}
+
+// runExportSchedule : export the records of schedule to a timestamped file, record the run
+// and prune files beyond the schedule's retention
+func runExportSchedule(schedule models.ExportSchedule) {
+	ctx, cancel := context.WithTimeout(context.Background(), exportScheduleTimeout)
+	defer cancel()
+
+	run := models.ExportRun{
+		ScheduleId: schedule.ScheduleId,
+		OrgId:      schedule.OrgId,
+		Status:     utils.JOB_RUNNING,
+		StartedAt:  time.Now().UTC(),
+	}
+	run.ID = primitive.NewObjectID()
+	run.RunId = run.ID.Hex()
+	if _, err := ExportRunCollection.InsertOne(ctx, run); err != nil {
+		log.Printf("error recording export run of %s: %v", schedule.ScheduleId, err)
+		return
+	}
+
+	file, records, err := writeScheduledExport(ctx, schedule, run)
+	finished := time.Now().UTC()
+	set := bson.M{"status": utils.JOB_COMPLETED, "file": file, "records": records, "finished_at": finished}
+	if err != nil {
+		set["status"] = utils.JOB_FAILED
+		set["error"] = err.Error()
+		log.Printf("error running export schedule %s: %v", schedule.ScheduleId, err)
+	}
+	if _, err := ExportRunCollection.UpdateOne(ctx, bson.M{"run_id": run.RunId}, bson.M{"$set": set}); err != nil {
+		log.Printf("error recording export run %s: %v", run.RunId, err)
+	}
+	// last_error is cleared by a run that succeeds
+	if _, err := ExportScheduleCollection.UpdateOne(ctx, bson.M{"_id": schedule.ID},
+		bson.M{"$set": bson.M{"last_run_at": run.StartedAt, "last_status": set["status"], "last_error": set["error"]}}); err != nil {
+		log.Printf("error updating export schedule %s: %v", schedule.ScheduleId, err)
+	}
+
+	pruneExportRuns(ctx, schedule)
+}
+
+// writeScheduledExport : write the export of schedule, returning the file and record count.
+// The file only gets its final name once complete, so readers never pick up a partial one.
+func writeScheduledExport(ctx context.Context, schedule models.ExportSchedule, run models.ExportRun) (string, int64, error) {
+	entity, ok := findDataEntity(schedule.Resource)
+	if !ok {
+		return "", 0, fmt.Errorf("unknown resource %q", schedule.Resource)
+	}
+	scope := scheduleExportScope(schedule, run.RunId)
+	entity, err := withCustomFieldColumns(ctx, scope, entity)
+	if err != nil {
+		return "", 0, err
+	}
+	filter, err := exportFilter(ctx, scope, entity)
+	if err != nil {
+		return "", 0, err
+	}
+
+	dir := filepath.Join(exportScheduleDir(), schedule.OrgId, schedule.ScheduleId)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", 0, err
+	}
+	name := fmt.Sprintf("%s-%s.%s", schedule.Resource, run.StartedAt.Format("20060102T150405Z"), schedule.Format)
+	if schedule.Compress {
+		name += ".gz"
+	}
+	path := filepath.Join(dir, name)
+	partial := path + ".part"
+
+	written, err := func() (int, error) {
+		file, err := os.Create(partial)
+		if err != nil {
+			return 0, err
+		}
+		defer file.Close()
+
+		cursor, err := openExport(ctx, scope, entity, schedule.Format, filter)
+		if err != nil {
+			return 0, err
+		}
+		defer cursor.Close(ctx)
+
+		var out io.Writer = file
+		var gz *gzip.Writer
+		if schedule.Compress {
+			gz = gzip.NewWriter(file)
+			out = gz
+		}
+		writer, err := newExportWriter(out, entity, exportParams{format: schedule.Format})
+		if err != nil {
+			return 0, err
+		}
+		written, err := writeExport(ctx, cursor, writer, entity.record, nil, 0)
+		if err != nil {
+			return written, err
+		}
+		if err := writer.Close(); err != nil {
+			return written, err
+		}
+		if gz != nil {
+			if err := gz.Close(); err != nil {
+				return written, err
+			}
+		}
+		return written, file.Close()
+	}()
+	if err == nil {
+		err = os.Rename(partial, path)
+	}
+	if err != nil {
+		os.Remove(partial)
+		return "", int64(written), err
+	}
+	return path, int64(written), nil
+}
+
+// pruneExportRuns : remove the files of all but the newest schedule.Retain runs
+func pruneExportRuns(ctx context.Context, schedule models.ExportSchedule) {
+	opts := options.Find().SetSort(bson.D{{Key: "started_at", Value: -1}}).SetSkip(int64(schedule.Retain))
+	cursor, err := ExportRunCollection.Find(ctx, bson.M{"schedule_id": schedule.ScheduleId, "file": bson.M{"$nin": bson.A{"", nil}}}, opts)
+	if err != nil {
+		log.Printf("error finding export runs to prune: %v", err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var run models.ExportRun
+		if err := cursor.Decode(&run); err != nil {
+			log.Printf("error decoding export run: %v", err)
+			continue
+		}
+		if err := os.Remove(run.File); err != nil && !os.IsNotExist(err) {
+			log.Printf("error removing export %s: %v", run.File, err)
+			continue
+		}
+		if _, err := ExportRunCollection.UpdateOne(ctx, bson.M{"_id": run.ID}, bson.M{"$set": bson.M{"pruned": true}, "$unset": bson.M{"file": ""}}); err != nil {
+			log.Printf("error pruning export run %s: %v", run.RunId, err)
+		}
+	}
+}
+
+// CreateExportSchedule : Schedule a recurring export to the export directory (only admin can access)
+func CreateExportSchedule() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var schedule models.ExportSchedule
+		if err := c.BindJSON(&schedule); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if validationErr := ExportScheduleValidate.Struct(schedule); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		schedule.OrgId = helper.TenantId(c)
+		if schedule.OrgId == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "org_id is required"})
+			return
+		}
+		schedule.CreatedBy = c.GetString("uid")
+		if err := checkExportSchedule(ctx, &schedule); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		count, err := ExportScheduleCollection.CountDocuments(ctx, bson.M{utils.ORG_ID: schedule.OrgId, "name": schedule.Name})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while checking for schedule name"})
+			return
+		}
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "this schedule already exists"})
+			return
+		}
+
+		schedule.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		schedule.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		schedule.ID = primitive.NewObjectID()
+		schedule.ScheduleId = schedule.ID.Hex()
+
+		if _, err := ExportScheduleCollection.InsertOne(ctx, schedule); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Schedule was not created"})
+			return
+		}
+
+		helper.RecordAudit(c, models.AuditLog{
+			Action:     utils.ACTION_CREATE,
+			Resource:   utils.RESOURCE_SCHEDULES,
+			ResourceId: schedule.ScheduleId,
+		}, nil, schedule)
+
+		c.JSON(http.StatusOK, schedule)
+	}
+}
+
+// GetExportSchedules : List the export schedules (only admin can access)
+func GetExportSchedules() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}})
+		cursor, err := ExportScheduleCollection.Find(ctx, helper.TenantFilter(c, bson.M{}), opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		defer cursor.Close(ctx)
+
+		var schedules []models.ExportSchedule
+		if err := cursor.All(ctx, &schedules); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if len(schedules) == 0 {
+			c.JSON(http.StatusOK, gin.H{"error": "no schedules available"})
+			return
+		}
+
+		c.JSON(http.StatusOK, schedules)
+	}
+}
+
+// UpdateExportSchedule : Change an export schedule, the next run is recalculated (only admin can access)
+func UpdateExportSchedule() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		filter := helper.TenantFilter(c, bson.M{"schedule_id": c.Param("schedule_id")})
+		var before models.ExportSchedule
+		if err := ExportScheduleCollection.FindOne(ctx, filter).Decode(&before); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "schedule not found"})
+			return
+		}
+
+		// fields left out of the body keep their value, pointers are copied so
+		// decoding the body does not write through to before
+		schedule := before
+		name, cron, enabled := *before.Name, *before.Cron, before.Enabled == nil || *before.Enabled
+		schedule.Name, schedule.Cron, schedule.Enabled = &name, &cron, &enabled
+		schedule.Filter = nil
+		if err := c.BindJSON(&schedule); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if schedule.Filter == nil {
+			schedule.Filter = before.Filter
+		}
+		if validationErr := ExportScheduleValidate.Struct(schedule); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+		if err := checkExportSchedule(ctx, &schedule); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if *schedule.Name != *before.Name {
+			count, err := ExportScheduleCollection.CountDocuments(ctx, bson.M{utils.ORG_ID: before.OrgId, "name": schedule.Name})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while checking for schedule name"})
+				return
+			}
+			if count > 0 {
+				c.JSON(http.StatusConflict, gin.H{"error": "this schedule already exists"})
+				return
+			}
+		}
+
+		// what a schedule is and who it runs as cannot be changed by an update
+		schedule.ID, schedule.ScheduleId, schedule.OrgId = before.ID, before.ScheduleId, before.OrgId
+		schedule.CreatedBy, schedule.CreatedAt = before.CreatedBy, before.CreatedAt
+		schedule.LastRunAt, schedule.LastStatus, schedule.LastError = before.LastRunAt, before.LastStatus, before.LastError
+		schedule.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+		if _, err := ExportScheduleCollection.ReplaceOne(ctx, bson.M{"_id": before.ID}, schedule); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while updating schedule"})
+			return
+		}
+
+		helper.RecordAudit(c, models.AuditLog{
+			Action:     utils.ACTION_UPDATE,
+			Resource:   utils.RESOURCE_SCHEDULES,
+			ResourceId: schedule.ScheduleId,
+		}, before, schedule)
+
+		c.JSON(http.StatusOK, schedule)
+	}
+}
+
+// DeleteExportSchedule : Stop and delete an export schedule, its files and history are kept (only admin can access)
+func DeleteExportSchedule() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var schedule models.ExportSchedule
+		err := ExportScheduleCollection.FindOneAndDelete(ctx, helper.TenantFilter(c, bson.M{"schedule_id": c.Param("schedule_id")})).Decode(&schedule)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "schedule not found"})
+			return
+		}
+
+		helper.RecordAudit(c, models.AuditLog{
+			Action:     utils.ACTION_DELETE,
+			Resource:   utils.RESOURCE_SCHEDULES,
+			ResourceId: schedule.ScheduleId,
+		}, schedule, nil)
+
+		c.JSON(http.StatusOK, gin.H{"message": "Schedule deleted successfully"})
+	}
+}
+
+// GetExportRuns : History of an export schedule's runs, newest first (only admin can access)
+func GetExportRuns() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		filter := bson.M{"schedule_id": c.Param("schedule_id")}
+		if status := c.Query("status"); status != "" {
+			filter["status"] = status
+		}
+
+		opts := options.Find().SetSort(bson.D{{Key: "started_at", Value: -1}}).SetLimit(100)
+		cursor, err := ExportRunCollection.Find(ctx, helper.TenantFilter(c, filter), opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		defer cursor.Close(ctx)
+
+		var runs []models.ExportRun
+		if err := cursor.All(ctx, &runs); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if len(runs) == 0 {
+			c.JSON(http.StatusOK, gin.H{"error": "no runs available"})
+			return
+		}
+
+		c.JSON(http.StatusOK, runs)
+	}
+}
@@ -0,0 +1,670 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/nirmal/crm/database"
+	helper "github.com/nirmal/crm/helpers"
+	"github.com/nirmal/crm/models"
+	"github.com/nirmal/crm/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultCommitProbability   = 90
+	defaultBestCaseProbability = 50
+	// forecastSnapshotMonths : months from the start of the current quarter a snapshot covers
+	forecastSnapshotMonths = 12
+	defaultDriftWeeks      = 26
+)
+
+// Forecast groupings
+const (
+	FORECAST_BY_OWNER = "owner"
+	FORECAST_BY_TEAM  = "team"
+	FORECAST_BY_ORG   = "org"
+)
+
+var QuotaValidate = validator.New()
+var QuotaCollection *mongo.Collection = database.OpenCollection("Cluster0", "quotas")
+var ForecastSnapshotCollection *mongo.Collection = database.OpenCollection("Cluster0", "forecast_snapshots")
+
+// forecastRows : monthly forecast of every owner of organization orgId, from its open deals
+// expected to close and its deals won in [from, to). Open deals count towards committed at
+// FORECAST_COMMIT_PROBABILITY (default 90) and towards best case at FORECAST_BEST_CASE_PROBABILITY
+// (default 50) or more; won deals count towards all of them.
+func forecastRows(ctx context.Context, orgId, pipelineId string, from, to time.Time) ([]models.ForecastRow, error) {
+	commit := envInt("FORECAST_COMMIT_PROBABILITY", defaultCommitProbability)
+	bestCase := envInt("FORECAST_BEST_CASE_PROBABILITY", defaultBestCaseProbability)
+
+	// deleted users keep their team, their deals still have to be forecast somewhere
+	teams := map[string]string{}
+	cursor, err := UserCollection.Find(ctx, bson.M{utils.ORG_ID: orgId}, options.Find().SetProjection(bson.M{utils.USER_ID: 1, "team": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var users []models.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	for _, user := range users {
+		if user.Team != nil {
+			teams[user.UserId] = *user.Team
+		}
+	}
+
+	window := bson.M{"$gte": from, "$lt": to}
+	filter := bson.M{utils.ORG_ID: orgId, "$or": bson.A{
+		bson.M{"status": utils.DEAL_OPEN, "expected_close_date": window},
+		bson.M{"status": utils.DEAL_WON, "closed_at": window},
+	}}
+	if pipelineId != "" {
+		filter["pipeline_id"] = pipelineId
+	}
+	cursor, err = DealCollection.Find(ctx, helper.NotDeleted(filter))
+	if err != nil {
+		return nil, err
+	}
+	var deals []models.Deal
+	if err := cursor.All(ctx, &deals); err != nil {
+		return nil, err
+	}
+
+	rows := map[string]*models.ForecastRow{}
+	for _, deal := range deals {
+		closes := deal.ExpectedCloseDate
+		if deal.Status == utils.DEAL_WON {
+			closes = deal.ClosedAt
+		}
+		period := helper.PeriodOf(*closes, helper.PERIOD_MONTH)
+		key := period + "|" + deal.OwnerId + "|" + deal.Currency
+		row, ok := rows[key]
+		if !ok {
+			row = &models.ForecastRow{Period: period, OwnerId: deal.OwnerId, Team: teams[deal.OwnerId], Currency: deal.Currency}
+			rows[key] = row
+		}
+
+		row.Deals++
+		if deal.Status == utils.DEAL_WON {
+			row.Won += deal.Amount
+			row.Weighted += deal.Amount
+			row.Committed += deal.Amount
+			row.BestCase += deal.Amount
+			continue
+		}
+		probability := 0
+		if deal.Probability != nil {
+			probability = *deal.Probability
+		}
+		row.Pipeline += deal.Amount
+		row.Weighted += deal.Amount * float64(probability) / 100
+		if probability >= commit {
+			row.Committed += deal.Amount
+		}
+		if probability >= bestCase {
+			row.BestCase += deal.Amount
+		}
+	}
+
+	result := make([]models.ForecastRow, 0, len(rows))
+	for _, row := range rows {
+		result = append(result, *row)
+	}
+	sortForecastRows(result)
+	return result, nil
+}
+
+// groupForecastRows : monthly owner rows added up per period of interval and per owner, team or organization
+func groupForecastRows(rows []models.ForecastRow, interval, groupBy string) []models.ForecastRow {
+	grouped := map[string]*models.ForecastRow{}
+	for _, row := range rows {
+		start, _, _, err := helper.ParsePeriod(row.Period)
+		if err != nil {
+			continue
+		}
+		target := models.ForecastRow{Period: helper.PeriodOf(start, interval), Currency: row.Currency}
+		switch groupBy {
+		case FORECAST_BY_OWNER:
+			target.OwnerId, target.Team = row.OwnerId, row.Team
+		case FORECAST_BY_TEAM:
+			target.Team = row.Team
+		}
+
+		key := target.Period + "|" + target.OwnerId + "|" + target.Team + "|" + target.Currency
+		sum, ok := grouped[key]
+		if !ok {
+			sum = &target
+			grouped[key] = sum
+		}
+		sum.Deals += row.Deals
+		sum.Won += row.Won
+		sum.Pipeline += row.Pipeline
+		sum.Weighted += row.Weighted
+		sum.Committed += row.Committed
+		sum.BestCase += row.BestCase
+	}
+
+	result := make([]models.ForecastRow, 0, len(grouped))
+	for _, row := range grouped {
+		result = append(result, *row)
+	}
+	sortForecastRows(result)
+	return result
+}
+
+func sortForecastRows(rows []models.ForecastRow) {
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if a.Period != b.Period {
+			return a.Period < b.Period
+		}
+		if a.Team != b.Team {
+			return a.Team < b.Team
+		}
+		if a.OwnerId != b.OwnerId {
+			return a.OwnerId < b.OwnerId
+		}
+		return a.Currency < b.Currency
+	})
+}
+
+// filterForecastRows : rows of ownerId and team, when given
+func filterForecastRows(rows []models.ForecastRow, ownerId, team string) []models.ForecastRow {
+	filtered := []models.ForecastRow{}
+	for _, row := range rows {
+		if (ownerId == "" || row.OwnerId == ownerId) && (team == "" || row.Team == team) {
+			filtered = append(filtered, row)
+		}
+	}
+	return filtered
+}
+
+// quotaTarget : who a quota or row is for, an owner, a team or the organization
+func quotaTarget(ownerId, team string) string {
+	switch {
+	case ownerId != "":
+		return "owner:" + ownerId
+	case team != "":
+		return "team:" + team
+	}
+	return "org"
+}
+
+// attachQuotas : set the quota of each row and its attainment (won / quota). Quarters
+// without a quota of their own use the sum of their months' quotas.
+func attachQuotas(ctx context.Context, orgId string, rows []models.ForecastRow) error {
+	cursor, err := QuotaCollection.Find(ctx, bson.M{utils.ORG_ID: orgId})
+	if err != nil {
+		return err
+	}
+	var quotas []models.Quota
+	if err := cursor.All(ctx, &quotas); err != nil {
+		return err
+	}
+	amounts := map[string]float64{}
+	for _, quota := range quotas {
+		amounts[quotaTarget(quota.OwnerId, quota.Team)+"|"+quota.Period+"|"+quota.Currency] = quota.Amount
+	}
+
+	for i := range rows {
+		row := &rows[i]
+		target := quotaTarget(row.OwnerId, row.Team) + "|"
+		// rows of an owner are matched on the owner alone, the team is only informative
+		if row.OwnerId != "" {
+			target = quotaTarget(row.OwnerId, "") + "|"
+		}
+		amount, ok := amounts[target+row.Period+"|"+row.Currency]
+		if start, end, interval, _ := helper.ParsePeriod(row.Period); !ok && interval == helper.PERIOD_QUARTER {
+			for month := start; month.Before(end); month = month.AddDate(0, 1, 0) {
+				if monthly, found := amounts[target+helper.PeriodOf(month, helper.PERIOD_MONTH)+"|"+row.Currency]; found {
+					amount += monthly
+					ok = true
+				}
+			}
+		}
+		if !ok {
+			continue
+		}
+		quota := amount
+		row.Quota = &quota
+		if amount > 0 {
+			attainment := row.Won / amount
+			row.Attainment = &attainment
+		}
+	}
+	return nil
+}
+
+// forecastScope : owner and team the caller may see, users only ever see their own deals.
+// A user token without a uid is rejected, an empty owner would mean every owner.
+func forecastScope(c *gin.Context) (groupBy, ownerId, team string, err error) {
+	groupBy = c.DefaultQuery("group_by", FORECAST_BY_OWNER)
+	if groupBy != FORECAST_BY_OWNER && groupBy != FORECAST_BY_TEAM && groupBy != FORECAST_BY_ORG {
+		return "", "", "", fmt.Errorf("group_by must be owner, team or org")
+	}
+	if helper.CheckUserType(c, utils.ROLE_ADMIN) != nil {
+		uid := c.GetString("uid")
+		if uid == "" {
+			return "", "", "", fmt.Errorf("UnAuthenticated to access this resource")
+		}
+		return FORECAST_BY_OWNER, uid, "", nil
+	}
+	return groupBy, c.Query("owner_id"), c.Query("team"), nil
+}
+
+// GetForecast : Forecast per owner, team or the organization (?group_by=) for the periods
+// ?from= to ?to= (2024-09 or 2024-Q3, the current quarter by default), against quotas.
+// Users see their own forecast only.
+func GetForecast() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckStaff(c); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		orgId := helper.TenantId(c)
+		if orgId == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "org_id is required"})
+			return
+		}
+		groupBy, ownerId, team, err := forecastScope(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		fromPeriod := c.DefaultQuery("from", helper.PeriodOf(time.Now(), helper.PERIOD_QUARTER))
+		from, _, interval, err := helper.ParsePeriod(fromPeriod)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		toPeriod := c.DefaultQuery("to", fromPeriod)
+		_, to, toInterval, err := helper.ParsePeriod(toPeriod)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if toInterval != interval || !to.After(from) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from and to must be periods of the same kind, from first"})
+			return
+		}
+
+		rows, err := forecastRows(ctx, orgId, c.Query("pipeline_id"), from, to)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while computing the forecast"})
+			return
+		}
+		rows = filterForecastRows(rows, ownerId, team)
+
+		grouped := groupForecastRows(rows, interval, groupBy)
+		totals := groupForecastRows(rows, interval, FORECAST_BY_ORG)
+		for _, set := range [][]models.ForecastRow{grouped, totals} {
+			if err := attachQuotas(ctx, orgId, set); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while reading quotas"})
+				return
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"interval": interval,
+			"from":     fromPeriod,
+			"to":       toPeriod,
+			"group_by": groupBy,
+			"rows":     grouped,
+			"totals":   totals,
+		})
+	}
+}
+
+// SetQuota : Set the quota of an owner_id, a team or (with neither) the organization for a
+// period, replacing the one it had (only admin can access)
+func SetQuota() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var quota models.Quota
+		if err := c.BindJSON(&quota); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		quota.Team = strings.TrimSpace(quota.Team)
+		if validationErr := QuotaValidate.Struct(quota); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+		if _, _, _, err := helper.ParsePeriod(quota.Period); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		quota.Period = strings.ToUpper(quota.Period)
+
+		quota.OrgId = helper.TenantId(c)
+		if quota.OrgId == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "org_id is required"})
+			return
+		}
+		if quota.OwnerId != "" {
+			count, err := UserCollection.CountDocuments(ctx, helper.NotDeleted(bson.M{utils.ORG_ID: quota.OrgId, utils.USER_ID: quota.OwnerId}))
+			if err != nil || count == 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("owner %s not found", quota.OwnerId)})
+				return
+			}
+		}
+		quota.Currency = strings.ToUpper(quota.Currency)
+		if quota.Currency == "" {
+			quota.Currency = dealCurrency()
+		}
+
+		filter := bson.M{utils.ORG_ID: quota.OrgId, "owner_id": quota.OwnerId, "team": quota.Team, "period": quota.Period, "currency": quota.Currency}
+		// empty owner or team are stored without the field
+		for _, field := range []string{"owner_id", "team"} {
+			if filter[field] == "" {
+				filter[field] = bson.M{"$exists": false}
+			}
+		}
+		var before *models.Quota
+		var existing models.Quota
+		if err := QuotaCollection.FindOne(ctx, filter).Decode(&existing); err == nil {
+			before = &existing
+			quota.ID, quota.QuotaId, quota.CreatedAt = existing.ID, existing.QuotaId, existing.CreatedAt
+		} else {
+			quota.ID = primitive.NewObjectID()
+			quota.QuotaId = quota.ID.Hex()
+			quota.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		}
+		quota.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+		if _, err := QuotaCollection.ReplaceOne(ctx, bson.M{"_id": quota.ID}, quota, options.Replace().SetUpsert(true)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Quota was not saved"})
+			return
+		}
+
+		action := utils.ACTION_CREATE
+		var beforeAudit interface{}
+		if before != nil {
+			action, beforeAudit = utils.ACTION_UPDATE, *before
+		}
+		helper.RecordAudit(c, models.AuditLog{
+			Action:     action,
+			Resource:   utils.RESOURCE_QUOTAS,
+			ResourceId: quota.QuotaId,
+		}, beforeAudit, quota)
+
+		c.JSON(http.StatusOK, quota)
+	}
+}
+
+// GetQuotas : List quotas, filtered by ?period=, ?owner_id= and ?team= (only admin can access)
+func GetQuotas() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		filter := bson.M{}
+		for _, param := range []string{"period", "owner_id", "team"} {
+			if value := c.Query(param); value != "" {
+				filter[param] = value
+			}
+		}
+		if period, ok := filter["period"].(string); ok {
+			filter["period"] = strings.ToUpper(period)
+		}
+
+		opts := options.Find().SetSort(bson.D{{Key: "period", Value: 1}, {Key: "team", Value: 1}, {Key: "owner_id", Value: 1}})
+		cursor, err := QuotaCollection.Find(ctx, helper.TenantFilter(c, filter), opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing quotas"})
+			return
+		}
+
+		var quotas []models.Quota
+		if err = cursor.All(ctx, &quotas); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while decoding quota data"})
+			return
+		}
+
+		if len(quotas) == 0 {
+			c.JSON(http.StatusOK, gin.H{"error": "no quotas available"})
+			return
+		}
+
+		c.JSON(http.StatusOK, quotas)
+	}
+}
+
+// DeleteQuota : Delete a quota (only admin can access)
+func DeleteQuota() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var quota models.Quota
+		if err := QuotaCollection.FindOneAndDelete(ctx, helper.TenantFilter(c, bson.M{"quota_id": c.Param("quota_id")})).Decode(&quota); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "quota not found"})
+			return
+		}
+		helper.RecordAudit(c, models.AuditLog{
+			Action:     utils.ACTION_DELETE,
+			Resource:   utils.RESOURCE_QUOTAS,
+			ResourceId: quota.QuotaId,
+		}, quota, nil)
+
+		c.JSON(http.StatusOK, gin.H{"message": "quota deleted successfully"})
+	}
+}
+
+// takeForecastSnapshot : store the monthly owner forecast of orgId from the start of the
+// current quarter, replacing any snapshot already taken this week
+func takeForecastSnapshot(ctx context.Context, orgId string, now time.Time) (models.ForecastSnapshot, error) {
+	from := helper.PeriodStart(now, helper.PERIOD_QUARTER)
+	rows, err := forecastRows(ctx, orgId, "", from, from.AddDate(0, forecastSnapshotMonths, 0))
+	if err != nil {
+		return models.ForecastSnapshot{}, err
+	}
+
+	snapshot := models.ForecastSnapshot{OrgId: orgId, Week: helper.ISOWeek(now), Rows: rows}
+	snapshot.TakenAt, _ = time.Parse(time.RFC3339, now.Format(time.RFC3339))
+	var existing models.ForecastSnapshot
+	if err := ForecastSnapshotCollection.FindOne(ctx, bson.M{utils.ORG_ID: orgId, "week": snapshot.Week}).Decode(&existing); err == nil {
+		snapshot.ID, snapshot.SnapshotId = existing.ID, existing.SnapshotId
+	} else {
+		snapshot.ID = primitive.NewObjectID()
+		snapshot.SnapshotId = snapshot.ID.Hex()
+	}
+
+	_, err = ForecastSnapshotCollection.ReplaceOne(ctx, bson.M{"_id": snapshot.ID}, snapshot, options.Replace().SetUpsert(true))
+	return snapshot, err
+}
+
+// StartForecastSnapshotJob : take the weekly forecast snapshot of every organization,
+// checking every FORECAST_SNAPSHOT_INTERVAL_HOURS (default 1) for organizations that have
+// none for the current week yet
+func StartForecastSnapshotJob() {
+	intervalHours := envInt("FORECAST_SNAPSHOT_INTERVAL_HOURS", 1)
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(intervalHours) * time.Hour)
+		defer ticker.Stop()
+
+		for {
+			RunForecastSnapshots(time.Now())
+			<-ticker.C
+		}
+	}()
+}
+
+// RunForecastSnapshots : snapshot the organizations without a snapshot for the week of now
+func RunForecastSnapshots(now time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	cursor, err := OrganizationCollection.Find(ctx, helper.NotDeleted(bson.M{}), options.Find().SetProjection(bson.M{utils.ORG_ID: 1}))
+	if err != nil {
+		log.Printf("error listing organizations for forecast snapshots: %v", err)
+		return
+	}
+	var orgs []models.Organization
+	if err := cursor.All(ctx, &orgs); err != nil {
+		log.Printf("error listing organizations for forecast snapshots: %v", err)
+		return
+	}
+
+	week := helper.ISOWeek(now)
+	for _, org := range orgs {
+		count, err := ForecastSnapshotCollection.CountDocuments(ctx, bson.M{utils.ORG_ID: org.OrgId, "week": week})
+		if err != nil || count > 0 {
+			continue
+		}
+		if _, err := takeForecastSnapshot(ctx, org.OrgId, now); err != nil {
+			log.Printf("error taking forecast snapshot of %s: %v", org.OrgId, err)
+		}
+	}
+}
+
+// TakeForecastSnapshot : Take this week's forecast snapshot now, replacing the one already
+// taken this week (only admin can access)
+func TakeForecastSnapshot() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		orgId := helper.TenantId(c)
+		if orgId == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "org_id is required"})
+			return
+		}
+
+		snapshot, err := takeForecastSnapshot(ctx, orgId, time.Now())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while taking the forecast snapshot"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"snapshot_id": snapshot.SnapshotId, "week": snapshot.Week, "taken_at": snapshot.TakenAt, "rows": len(snapshot.Rows)})
+	}
+}
+
+// forecastDriftRow : forecast of a period as a snapshot saw it, with the change since the previous snapshot
+type forecastDriftRow struct {
+	models.ForecastRow
+	WeightedChange  float64 `json:"weighted_change"`
+	CommittedChange float64 `json:"committed_change"`
+	BestCaseChange  float64 `json:"best_case_change"`
+}
+
+// GetForecastDrift : How the forecast of ?period= (2024-09 or 2024-Q3) changed over the weekly
+// snapshots of the last ?weeks= (default 26), grouped like the forecast. Users see their own only.
+func GetForecastDrift() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckStaff(c); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		orgId := helper.TenantId(c)
+		if orgId == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "org_id is required"})
+			return
+		}
+		groupBy, ownerId, team, err := forecastScope(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		period := strings.ToUpper(c.DefaultQuery("period", helper.PeriodOf(time.Now(), helper.PERIOD_QUARTER)))
+		start, end, interval, err := helper.ParsePeriod(period)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		weeks, err := strconv.Atoi(c.DefaultQuery("weeks", strconv.Itoa(defaultDriftWeeks)))
+		if err != nil || weeks <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "weeks must be a positive number"})
+			return
+		}
+
+		opts := options.Find().SetSort(bson.D{{Key: "taken_at", Value: -1}}).SetLimit(int64(weeks))
+		cursor, err := ForecastSnapshotCollection.Find(ctx, bson.M{utils.ORG_ID: orgId}, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing forecast snapshots"})
+			return
+		}
+		var snapshots []models.ForecastSnapshot
+		if err := cursor.All(ctx, &snapshots); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while decoding forecast snapshots"})
+			return
+		}
+
+		result := []gin.H{}
+		previous := map[string]models.ForecastRow{}
+		// oldest first, so each snapshot is compared with the one before it
+		for i := len(snapshots) - 1; i >= 0; i-- {
+			snapshot := snapshots[i]
+			var inPeriod []models.ForecastRow
+			for _, row := range snapshot.Rows {
+				if month, _, _, err := helper.ParsePeriod(row.Period); err == nil && !month.Before(start) && month.Before(end) {
+					inPeriod = append(inPeriod, row)
+				}
+			}
+			rows := groupForecastRows(filterForecastRows(inPeriod, ownerId, team), interval, groupBy)
+
+			drift := make([]forecastDriftRow, len(rows))
+			current := map[string]models.ForecastRow{}
+			for j, row := range rows {
+				key := row.OwnerId + "|" + row.Team + "|" + row.Currency
+				before := previous[key]
+				drift[j] = forecastDriftRow{
+					ForecastRow:     row,
+					WeightedChange:  row.Weighted - before.Weighted,
+					CommittedChange: row.Committed - before.Committed,
+					BestCaseChange:  row.BestCase - before.BestCase,
+				}
+				current[key] = row
+			}
+			previous = current
+			result = append(result, gin.H{"week": snapshot.Week, "taken_at": snapshot.TakenAt, "rows": drift})
+		}
+
+		c.JSON(http.StatusOK, gin.H{"period": period, "group_by": groupBy, "snapshots": result})
+	}
+}
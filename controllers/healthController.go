@@ -0,0 +1,604 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/nirmal/crm/database"
+	helper "github.com/nirmal/crm/helpers"
+	"github.com/nirmal/crm/models"
+	"github.com/nirmal/crm/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Health score factors
+const (
+	HEALTH_TICKET_VOLUME       = "ticket_volume"
+	HEALTH_OPEN_TICKET_AGE     = "open_ticket_age"
+	HEALTH_SLA_BREACHES        = "sla_breaches"
+	HEALTH_INTERACTION_RECENCY = "interaction_recency"
+	HEALTH_RESOLUTION_TIME     = "resolution_time"
+)
+
+const (
+	defaultHealthIntervalHours = 24
+	defaultHealthHistoryDays   = 30
+	maxHealthHistoryDays       = 365
+	// healthWindowDays : days of tickets counted for volume, breaches and resolution times
+	healthWindowDays = 90
+	// healthVolumeDays : days of tickets counted for the ticket volume
+	healthVolumeDays = 30
+	// healthQuietDays : days without an interaction before recency costs points
+	healthQuietDays = 14
+	healthDayLayout = "2006-01-02"
+	// healthWriteBatch : customers whose health is written in one bulk write
+	healthWriteBatch = 1000
+)
+
+// defaultHealthSettings : settings of organizations that never changed them
+var defaultHealthSettings = models.HealthSettings{AlertBelow: 40, DropPoints: 20, DropDays: 7, SlaHours: 48}
+
+var HealthValidate = validator.New()
+var HealthSettingsCollection *mongo.Collection = database.OpenCollection("Cluster0", "health_settings")
+var HealthScoreCollection *mongo.Collection = database.OpenCollection("Cluster0", "health_scores")
+
+// healthSettings : health settings of orgId, the defaults when it has none
+func healthSettings(ctx context.Context, orgId string) (models.HealthSettings, error) {
+	var settings models.HealthSettings
+	err := HealthSettingsCollection.FindOne(ctx, bson.M{utils.ORG_ID: orgId}).Decode(&settings)
+	if err == mongo.ErrNoDocuments {
+		settings = defaultHealthSettings
+		settings.OrgId = orgId
+		return settings, nil
+	}
+	return settings, err
+}
+
+// healthInputs : what the service knows about a customer's support and contact history
+type healthInputs struct {
+	recentTickets   int64
+	oldestOpen      time.Time
+	breaches        int64
+	resolved        int64
+	resolutionHours float64
+	lastInteraction time.Time
+}
+
+// healthData : inputs of the customers of orgId in ids, or of all of them when ids is nil.
+// Resolved and closed tickets count as resolved at their resolved_at, tickets resolved before
+// it was recorded at their last update.
+func healthData(ctx context.Context, orgId string, ids []primitive.ObjectID, settings models.HealthSettings, now time.Time) (map[primitive.ObjectID]*healthInputs, error) {
+	data := map[primitive.ObjectID]*healthInputs{}
+	inputs := func(id primitive.ObjectID) *healthInputs {
+		if data[id] == nil {
+			data[id] = &healthInputs{}
+		}
+		return data[id]
+	}
+	sla := time.Duration(settings.SlaHours) * time.Hour
+
+	filter := bson.M{utils.ORG_ID: orgId, "$or": bson.A{
+		bson.M{"status": bson.M{"$in": openTicketStatuses}},
+		bson.M{"created_at": bson.M{"$gte": now.AddDate(0, 0, -healthWindowDays)}},
+	}}
+	if ids != nil {
+		filter[utils.CUSTOMER_ID] = bson.M{"$in": ids}
+	}
+	opts := options.Find().SetProjection(bson.M{utils.CUSTOMER_ID: 1, "status": 1, "created_at": 1, "updated_at": 1, "resolved_at": 1})
+	cursor, err := TicketCollection.Find(ctx, helper.NotDeleted(filter), opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var ticket models.Ticket
+		if err := cursor.Decode(&ticket); err != nil {
+			return nil, err
+		}
+		customer := inputs(ticket.CustomerID)
+		if now.Sub(ticket.CreatedAt) <= healthVolumeDays*24*time.Hour {
+			customer.recentTickets++
+		}
+		if ticket.Status != nil && (*ticket.Status == "open" || *ticket.Status == "in_progress") {
+			if customer.oldestOpen.IsZero() || ticket.CreatedAt.Before(customer.oldestOpen) {
+				customer.oldestOpen = ticket.CreatedAt
+			}
+			if now.Sub(ticket.CreatedAt) > sla {
+				customer.breaches++
+			}
+			continue
+		}
+		resolvedAt := ticket.UpdatedAt
+		if ticket.ResolvedAt != nil {
+			resolvedAt = *ticket.ResolvedAt
+		}
+		resolution := resolvedAt.Sub(ticket.CreatedAt)
+		customer.resolutionHours = (customer.resolutionHours*float64(customer.resolved) + resolution.Hours()) / float64(customer.resolved+1)
+		customer.resolved++
+		if resolution > sla {
+			customer.breaches++
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	// interactions are dated like segments date them, by their start time, and meetings
+	// still to come are not contact yet
+	at := segmentActivity["last_interaction"].at
+	match := bson.M{utils.ORG_ID: orgId, "$expr": bson.M{"$lte": bson.A{at, now}}}
+	if ids != nil {
+		match[utils.CUSTOMER_ID] = bson.M{"$in": ids}
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: helper.NotDeleted(match)}},
+		{{Key: "$group", Value: bson.M{"_id": "$" + utils.CUSTOMER_ID, "last": bson.M{"$max": at}}}},
+	}
+	interactions, err := InteractionCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		CustomerId primitive.ObjectID `bson:"_id"`
+		Last       time.Time          `bson:"last"`
+	}
+	if err := interactions.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		inputs(row.CustomerId).lastInteraction = row.Last
+	}
+	return data, nil
+}
+
+// healthFactor : factor measuring value, costing penalty points
+func healthFactor(factor string, value float64, penalty int) models.HealthFactor {
+	value = math.Round(value*10) / 10
+	return models.HealthFactor{Factor: factor, Value: &value, Penalty: penalty}
+}
+
+// computeHealth : health score of a customer, 100 less the penalty of each factor, at least 0.
+// Tickets in the last 30 days beyond two cost 5 each (at most 20), the oldest open ticket 1
+// per day (at most 20), SLA breaches 10 each (at most 30), each day past 14 without an
+// interaction 1 (at most 20, 20 if never contacted) and resolving slower than the SLA on
+// average 10, or 5 past half of it.
+func computeHealth(data *healthInputs, settings models.HealthSettings, now time.Time) (int, []models.HealthFactor) {
+	if data == nil {
+		data = &healthInputs{}
+	}
+	factors := []models.HealthFactor{
+		healthFactor(HEALTH_TICKET_VOLUME, float64(data.recentTickets), int(math.Min(20, 5*math.Max(0, float64(data.recentTickets-2))))),
+		healthFactor(HEALTH_SLA_BREACHES, float64(data.breaches), int(math.Min(30, 10*float64(data.breaches)))),
+	}
+
+	openAge := models.HealthFactor{Factor: HEALTH_OPEN_TICKET_AGE}
+	if !data.oldestOpen.IsZero() {
+		days := now.Sub(data.oldestOpen).Hours() / 24
+		openAge = healthFactor(HEALTH_OPEN_TICKET_AGE, days, int(math.Min(20, math.Floor(days))))
+	}
+	factors = append(factors, openAge)
+
+	recency := models.HealthFactor{Factor: HEALTH_INTERACTION_RECENCY, Penalty: 20}
+	if !data.lastInteraction.IsZero() {
+		days := now.Sub(data.lastInteraction).Hours() / 24
+		recency = healthFactor(HEALTH_INTERACTION_RECENCY, days, int(math.Min(20, math.Max(0, math.Floor(days-healthQuietDays)))))
+	}
+	factors = append(factors, recency)
+
+	resolution := models.HealthFactor{Factor: HEALTH_RESOLUTION_TIME}
+	if data.resolved > 0 {
+		penalty := 0
+		if data.resolutionHours > float64(settings.SlaHours) {
+			penalty = 10
+		} else if data.resolutionHours > float64(settings.SlaHours)/2 {
+			penalty = 5
+		}
+		resolution = healthFactor(HEALTH_RESOLUTION_TIME, data.resolutionHours, penalty)
+	}
+	factors = append(factors, resolution)
+
+	score := 100
+	for _, factor := range factors {
+		score -= factor.Penalty
+	}
+	if score < 0 {
+		score = 0
+	}
+	return score, factors
+}
+
+// healthAlertRecipients : who hears about the health of customer, the owner of its account,
+// else the user of its latest interaction, else the admins of its organization
+func healthAlertRecipients(ctx context.Context, customer models.Customer) ([]models.User, error) {
+	filter := bson.M{}
+	if customer.AccountId != nil {
+		var account models.Account
+		err := AccountCollection.FindOne(ctx, helper.NotDeleted(bson.M{utils.ACCOUNT_ID: *customer.AccountId})).Decode(&account)
+		if err == nil && account.OwnerId != nil {
+			filter[utils.USER_ID] = *account.OwnerId
+		}
+	}
+	if len(filter) == 0 {
+		var interaction models.Interaction
+		opts := options.FindOne().SetSort(bson.D{{Key: "created_at", Value: -1}})
+		if err := InteractionCollection.FindOne(ctx, helper.NotDeleted(bson.M{utils.CUSTOMER_ID: customer.ID}), opts).Decode(&interaction); err == nil {
+			filter["_id"] = interaction.UserID
+		}
+	}
+	if len(filter) == 0 {
+		filter["role"] = utils.ROLE_ADMIN
+	}
+	filter[utils.ORG_ID] = customer.OrgId
+
+	cursor, err := UserCollection.Find(ctx, helper.NotDeleted(filter), options.Find().SetProjection(bson.M{utils.USER_ID: 1, utils.ORG_ID: 1, "email": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var users []models.User
+	err = cursor.All(ctx, &users)
+	return users, err
+}
+
+// healthAlert : alert the owners of customer when its score fell below the threshold, or
+// dropped sharply since DropDays ago without an alert about it since
+func healthAlert(ctx context.Context, customer models.Customer, score int, settings models.HealthSettings, now time.Time) error {
+	name := customer.CustomerId
+	if customer.Name != nil {
+		name = *customer.Name
+	}
+	notification := models.Notification{Resource: utils.RESOURCE_CUSTOMERS, ResourceId: customer.CustomerId}
+
+	// a customer scored for the first time crosses the threshold as well, unless the whole
+	// organization is scored for the first time (see scoreHealth)
+	if score < settings.AlertBelow && (customer.HealthScore == nil || *customer.HealthScore >= settings.AlertBelow) {
+		notification.Kind = utils.NOTIFY_HEALTH_BELOW
+		notification.Title = fmt.Sprintf("%s is at risk", name)
+		notification.Body = fmt.Sprintf("The health score of %s fell to %d, below %d.", name, score, settings.AlertBelow)
+	} else {
+		var past models.HealthScore
+		day := now.AddDate(0, 0, -settings.DropDays).Format(healthDayLayout)
+		err := HealthScoreCollection.FindOne(ctx, bson.M{utils.CUSTOMER_ID: customer.CustomerId, "day": day}).Decode(&past)
+		if err != nil || past.Score-score < settings.DropPoints {
+			return nil
+		}
+		since := now.AddDate(0, 0, -settings.DropDays)
+		count, err := NotificationCollection.CountDocuments(ctx, bson.M{"kind": utils.NOTIFY_HEALTH_DROP, "resource_id": customer.CustomerId, "created_at": bson.M{"$gte": since}})
+		if err != nil || count > 0 {
+			return err
+		}
+		notification.Kind = utils.NOTIFY_HEALTH_DROP
+		notification.Title = fmt.Sprintf("Health of %s dropped", name)
+		notification.Body = fmt.Sprintf("The health score of %s dropped from %d to %d in %d days.", name, past.Score, score, settings.DropDays)
+	}
+
+	users, err := healthAlertRecipients(ctx, customer)
+	if err != nil {
+		return err
+	}
+	notifyUsers(ctx, users, notification)
+	return nil
+}
+
+// healthResult : health computed for a customer, waiting to be written
+type healthResult struct {
+	customer models.Customer
+	score    int
+	factors  []models.HealthFactor
+}
+
+// scoreHealth : compute today's health score of the customers of orgId in ids, or of all of
+// them when ids is nil, store it in their history and alert their owners. Leads have no
+// health score. The first run of an organization only seeds the scores, customers already
+// at risk then are not alerted about. Returns the number of customers scored.
+func scoreHealth(ctx context.Context, orgId string, ids []primitive.ObjectID, now time.Time) (int, error) {
+	settings, err := healthSettings(ctx, orgId)
+	if err != nil {
+		return 0, err
+	}
+	data, err := healthData(ctx, orgId, ids, settings, now)
+	if err != nil {
+		return 0, err
+	}
+	scoredBefore, err := HealthScoreCollection.CountDocuments(ctx, bson.M{utils.ORG_ID: orgId}, options.Count().SetLimit(1))
+	if err != nil {
+		return 0, err
+	}
+	seeding := scoredBefore == 0
+
+	filter := bson.M{utils.ORG_ID: orgId, "lifecycle": bson.M{"$nin": leadStages}}
+	if ids != nil {
+		filter["_id"] = bson.M{"$in": ids}
+	}
+	opts := options.Find().SetProjection(bson.M{utils.CUSTOMER_ID: 1, utils.ORG_ID: 1, "name": 1, utils.ACCOUNT_ID: 1, "health_score": 1})
+	cursor, err := CustomerCollection.Find(ctx, helper.NotDeleted(filter), opts)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	scored := 0
+	batch := make([]healthResult, 0, healthWriteBatch)
+	for cursor.Next(ctx) {
+		var customer models.Customer
+		if err := cursor.Decode(&customer); err != nil {
+			return scored, err
+		}
+		score, factors := computeHealth(data[customer.ID], settings, now)
+		batch = append(batch, healthResult{customer: customer, score: score, factors: factors})
+		if len(batch) == healthWriteBatch {
+			if err := writeHealth(ctx, orgId, batch, settings, now, seeding); err != nil {
+				return scored, err
+			}
+			scored += len(batch)
+			batch = batch[:0]
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return scored, err
+	}
+	if len(batch) > 0 {
+		if err := writeHealth(ctx, orgId, batch, settings, now, seeding); err != nil {
+			return scored, err
+		}
+		scored += len(batch)
+	}
+	return scored, nil
+}
+
+// writeHealth : store the health of a batch of customers of orgId in their history and on
+// them with two bulk writes, then alert their owners unless seeding
+func writeHealth(ctx context.Context, orgId string, batch []healthResult, settings models.HealthSettings, now time.Time, seeding bool) error {
+	day := now.Format(healthDayLayout)
+	computedAt, _ := time.Parse(time.RFC3339, now.Format(time.RFC3339))
+	history := make([]mongo.WriteModel, 0, len(batch))
+	scores := make([]mongo.WriteModel, 0, len(batch))
+	for _, result := range batch {
+		entry := models.HealthScore{OrgId: orgId, CustomerId: result.customer.CustomerId, Day: day, Score: result.score, Factors: result.factors, ComputedAt: computedAt}
+		// one entry per customer and day, the latest computation of the day wins
+		history = append(history, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{utils.CUSTOMER_ID: result.customer.CustomerId, "day": day}).
+			SetUpdate(bson.M{"$set": entry, "$setOnInsert": bson.M{"_id": primitive.NewObjectID()}}).
+			SetUpsert(true))
+		// health is derived data, writing it neither bumps the version nor updated_at
+		scores = append(scores, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": result.customer.ID}).
+			SetUpdate(bson.M{"$set": bson.M{"health_score": result.score}}))
+	}
+	if _, err := HealthScoreCollection.BulkWrite(ctx, history, options.BulkWrite().SetOrdered(false)); err != nil {
+		return err
+	}
+	if _, err := CustomerCollection.BulkWrite(ctx, scores, options.BulkWrite().SetOrdered(false)); err != nil {
+		return err
+	}
+
+	if seeding {
+		return nil
+	}
+	for _, result := range batch {
+		if err := healthAlert(ctx, result.customer, result.score, settings, now); err != nil {
+			log.Printf("error alerting about the health of %s: %v", result.customer.CustomerId, err)
+		}
+	}
+	return nil
+}
+
+// StartHealthScoreJob : compute the health scores of every organization every
+// HEALTH_SCORE_INTERVAL_HOURS (default 24), keeping one history entry per customer and day
+func StartHealthScoreJob() {
+	intervalHours := envInt("HEALTH_SCORE_INTERVAL_HOURS", defaultHealthIntervalHours)
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(intervalHours) * time.Hour)
+		defer ticker.Stop()
+
+		for {
+			RunHealthScores(time.Now())
+			<-ticker.C
+		}
+	}()
+}
+
+// RunHealthScores : compute the health scores of every organization at now
+func RunHealthScores(now time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	cursor, err := OrganizationCollection.Find(ctx, helper.NotDeleted(bson.M{}), options.Find().SetProjection(bson.M{utils.ORG_ID: 1}))
+	if err != nil {
+		log.Printf("error listing organizations for health scores: %v", err)
+		return
+	}
+	var orgs []models.Organization
+	if err := cursor.All(ctx, &orgs); err != nil {
+		log.Printf("error listing organizations for health scores: %v", err)
+		return
+	}
+
+	for _, org := range orgs {
+		orgCtx, orgCancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		if _, err := scoreHealth(orgCtx, org.OrgId, nil, now); err != nil {
+			log.Printf("error computing health scores of %s: %v", org.OrgId, err)
+		}
+		orgCancel()
+	}
+}
+
+// GetHealthSettings : Get the thresholds of health alerts and the SLA (only admin can access)
+func GetHealthSettings() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		orgId := helper.TenantId(c)
+		if orgId == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "org_id is required"})
+			return
+		}
+		settings, err := healthSettings(ctx, orgId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while reading health settings"})
+			return
+		}
+
+		c.JSON(http.StatusOK, settings)
+	}
+}
+
+// SetHealthSettings : Set the thresholds of health alerts and the SLA, fields left out keep
+// their current value (only admin can access)
+func SetHealthSettings() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		orgId := helper.TenantId(c)
+		if orgId == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "org_id is required"})
+			return
+		}
+		before, err := healthSettings(ctx, orgId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while reading health settings"})
+			return
+		}
+
+		settings := before
+		if err := c.BindJSON(&settings); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		settings.ID, settings.OrgId = before.ID, orgId
+		if settings.ID.IsZero() {
+			settings.ID = primitive.NewObjectID()
+		}
+		if validationErr := HealthValidate.Struct(settings); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+		settings.UpdatedBy = c.GetString("uid")
+		settings.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+		if _, err := HealthSettingsCollection.ReplaceOne(ctx, bson.M{utils.ORG_ID: orgId}, settings, options.Replace().SetUpsert(true)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Health settings were not saved"})
+			return
+		}
+		helper.RecordAudit(c, models.AuditLog{
+			Action:     utils.ACTION_UPDATE,
+			Resource:   utils.RESOURCE_HEALTH,
+			ResourceId: orgId,
+		}, before, settings)
+
+		c.JSON(http.StatusOK, settings)
+	}
+}
+
+// RecomputeHealth : Compute today's health scores of every customer now, as a background job
+// (only admin can access)
+func RecomputeHealth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		orgId := helper.TenantId(c)
+		if orgId == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "org_id is required"})
+			return
+		}
+
+		job, err := startJob(c, utils.JOB_HEALTH, utils.RESOURCE_CUSTOMERS, "", func(ctx context.Context, c *gin.Context, run *jobRun) error {
+			scored, err := scoreHealth(ctx, orgId, nil, time.Now())
+			run.progress(scored, 0)
+			run.job.Summary = map[string]interface{}{"scored": scored}
+			return err
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		respondJobStarted(c, job)
+	}
+}
+
+// GetCustomerHealth : Get a customer's health score as computed now, with its factors, and
+// its daily history for the last ?days= (30 by default) (staff only)
+func GetCustomerHealth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckStaff(c); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		days := defaultHealthHistoryDays
+		if value := c.Query("days"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 1 || parsed > maxHealthHistoryDays {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("days must be between 1 and %d", maxHealthHistoryDays)})
+				return
+			}
+			days = parsed
+		}
+
+		customer, _, err := findLifecycleCustomer(ctx, c)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "customer not found"})
+			return
+		}
+		settings, err := healthSettings(ctx, customer.OrgId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while reading health settings"})
+			return
+		}
+		now := time.Now()
+		data, err := healthData(ctx, customer.OrgId, []primitive.ObjectID{customer.ID}, settings, now)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while computing health score"})
+			return
+		}
+		score, factors := computeHealth(data[customer.ID], settings, now)
+
+		filter := bson.M{utils.CUSTOMER_ID: customer.CustomerId, "day": bson.M{"$gte": now.AddDate(0, 0, -days).Format(healthDayLayout)}}
+		cursor, err := HealthScoreCollection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "day", Value: 1}}).SetProjection(bson.M{"factors": 0}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing health history"})
+			return
+		}
+		history := []models.HealthScore{}
+		if err := cursor.All(ctx, &history); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while decoding health history"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"customer_id":  customer.CustomerId,
+			"score":        score,
+			"factors":      factors,
+			"health_score": customer.HealthScore,
+			"alert_below":  settings.AlertBelow,
+			"history":      history,
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nirmal/crm/models"
+)
+
+func TestComputeHealth(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	settings := models.HealthSettings{SlaHours: 24}
+	daysAgo := func(days float64) time.Time {
+		return now.Add(-time.Duration(days * 24 * float64(time.Hour)))
+	}
+
+	tests := []struct {
+		name      string
+		data      *healthInputs
+		score     int
+		penalties map[string]int
+	}{
+		{"never contacted", nil, 80, map[string]int{HEALTH_INTERACTION_RECENCY: 20}},
+		{"healthy", &healthInputs{recentTickets: 2, lastInteraction: daysAgo(3)}, 100, nil},
+		{"ticket volume", &healthInputs{recentTickets: 4, lastInteraction: daysAgo(3)}, 90, map[string]int{HEALTH_TICKET_VOLUME: 10}},
+		{"ticket volume capped", &healthInputs{recentTickets: 12, lastInteraction: daysAgo(3)}, 80, map[string]int{HEALTH_TICKET_VOLUME: 20}},
+		{"open ticket age", &healthInputs{oldestOpen: daysAgo(5.5), lastInteraction: daysAgo(3)}, 95, map[string]int{HEALTH_OPEN_TICKET_AGE: 5}},
+		{"open ticket age capped", &healthInputs{oldestOpen: daysAgo(40), lastInteraction: daysAgo(3)}, 80, map[string]int{HEALTH_OPEN_TICKET_AGE: 20}},
+		{"sla breaches", &healthInputs{breaches: 2, lastInteraction: daysAgo(3)}, 80, map[string]int{HEALTH_SLA_BREACHES: 20}},
+		{"sla breaches capped", &healthInputs{breaches: 5, lastInteraction: daysAgo(3)}, 70, map[string]int{HEALTH_SLA_BREACHES: 30}},
+		{"quiet for two weeks", &healthInputs{lastInteraction: daysAgo(14)}, 100, nil},
+		{"quiet for three weeks", &healthInputs{lastInteraction: daysAgo(20.5)}, 94, map[string]int{HEALTH_INTERACTION_RECENCY: 6}},
+		{"quiet for months", &healthInputs{lastInteraction: daysAgo(90)}, 80, map[string]int{HEALTH_INTERACTION_RECENCY: 20}},
+		{"resolved within half the sla", &healthInputs{resolved: 3, resolutionHours: 12, lastInteraction: daysAgo(3)}, 100, nil},
+		{"resolved past half the sla", &healthInputs{resolved: 3, resolutionHours: 13, lastInteraction: daysAgo(3)}, 95, map[string]int{HEALTH_RESOLUTION_TIME: 5}},
+		{"resolved past the sla", &healthInputs{resolved: 3, resolutionHours: 30, lastInteraction: daysAgo(3)}, 90, map[string]int{HEALTH_RESOLUTION_TIME: 10}},
+		// resolution hours without resolved tickets are not counted
+		{"nothing resolved", &healthInputs{resolutionHours: 30, lastInteraction: daysAgo(3)}, 100, nil},
+		{
+			"every factor at its worst",
+			&healthInputs{recentTickets: 20, oldestOpen: daysAgo(60), breaches: 9, resolved: 1, resolutionHours: 100},
+			0,
+			map[string]int{HEALTH_TICKET_VOLUME: 20, HEALTH_OPEN_TICKET_AGE: 20, HEALTH_SLA_BREACHES: 30, HEALTH_INTERACTION_RECENCY: 20, HEALTH_RESOLUTION_TIME: 10},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			score, factors := computeHealth(tt.data, settings, now)
+			if score != tt.score {
+				t.Errorf("score = %d, want %d", score, tt.score)
+			}
+			if len(factors) != 5 {
+				t.Fatalf("factors = %v, want all five", factors)
+			}
+			for _, factor := range factors {
+				if factor.Penalty != tt.penalties[factor.Factor] {
+					t.Errorf("%s penalty = %d, want %d", factor.Factor, factor.Penalty, tt.penalties[factor.Factor])
+				}
+			}
+		})
+	}
+}
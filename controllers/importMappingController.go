@@ -0,0 +1,205 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/nirmal/crm/database"
+	helper "github.com/nirmal/crm/helpers"
+	"github.com/nirmal/crm/models"
+	"github.com/nirmal/crm/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ImportMappingValidate = validator.New()
+var ImportMappingCollection *mongo.Collection = database.OpenCollection("Cluster0", "import_mappings")
+
+// importTargetColumns : columns an import file may be mapped to for resource
+func importTargetColumns(resource string) map[string]bool {
+	targets := map[string]bool{}
+	if entity, ok := findDataEntity(resource); ok {
+		for _, column := range entity.columns {
+			targets[column] = true
+		}
+	}
+	switch resource {
+	case utils.RESOURCE_CUSTOMERS, utils.RESOURCE_USERS:
+		targets["password"] = true
+	case utils.RESOURCE_INTERACTIONS:
+		targets["customer_email"] = true
+		targets["customer_external_id"] = true
+	}
+	return targets
+}
+
+// importMappingColumns : columns of the saved mapping profile name for resource,
+// nil when no profile is requested
+func importMappingColumns(ctx context.Context, c *gin.Context, resource, name string) (map[string]string, error) {
+	if name == "" {
+		return nil, nil
+	}
+	var mapping models.ImportMapping
+	filter := helper.TenantFilter(c, bson.M{"name": name, "resource": resource})
+	if err := ImportMappingCollection.FindOne(ctx, filter).Decode(&mapping); err != nil {
+		return nil, fmt.Errorf("mapping %q not found for %s", name, resource)
+	}
+	return mapping.Columns, nil
+}
+
+// applyImportMapping : rename the file headers of record to export column names,
+// headers without a mapping are kept as they are
+func applyImportMapping(record map[string]string, columns map[string]string) map[string]string {
+	if len(columns) == 0 {
+		return record
+	}
+	mapped := make(map[string]string, len(record))
+	for column, value := range record {
+		if _, ok := columns[column]; !ok {
+			mapped[column] = value
+		}
+	}
+	// mapped headers win over a file column that already has the target name
+	for column, value := range record {
+		if target, ok := columns[column]; ok {
+			mapped[target] = value
+		}
+	}
+	return mapped
+}
+
+// CreateImportMapping : Save a column mapping profile (only admin can access)
+func CreateImportMapping() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var mapping models.ImportMapping
+		if err := c.BindJSON(&mapping); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if validationErr := ImportMappingValidate.Struct(mapping); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		mapping.OrgId = helper.TenantId(c)
+		if mapping.OrgId == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "org_id is required"})
+			return
+		}
+
+		// headers are matched the way import readers normalise them
+		targets := importTargetColumns(mapping.Resource)
+		columns := make(map[string]string, len(mapping.Columns))
+		for header, target := range mapping.Columns {
+			target = strings.ToLower(strings.TrimSpace(target))
+			if !targets[target] {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown %s column %q", mapping.Resource, target)})
+				return
+			}
+			columns[strings.ToLower(strings.TrimSpace(header))] = target
+		}
+		mapping.Columns = columns
+
+		count, err := ImportMappingCollection.CountDocuments(ctx, bson.M{utils.ORG_ID: mapping.OrgId, "name": mapping.Name, "resource": mapping.Resource})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while checking for mapping name"})
+			return
+		}
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "this mapping already exists"})
+			return
+		}
+
+		mapping.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		mapping.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		mapping.ID = primitive.NewObjectID()
+		mapping.MappingId = mapping.ID.Hex()
+
+		if _, err := ImportMappingCollection.InsertOne(ctx, mapping); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Mapping was not created"})
+			return
+		}
+
+		c.JSON(http.StatusOK, mapping)
+	}
+}
+
+// GetImportMappings : List the saved column mapping profiles, optionally for one ?resource= (only admin can access)
+func GetImportMappings() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		filter := bson.M{}
+		if resource := c.Query("resource"); resource != "" {
+			filter["resource"] = resource
+		}
+
+		opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}})
+		cursor, err := ImportMappingCollection.Find(ctx, helper.TenantFilter(c, filter), opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		defer cursor.Close(ctx)
+
+		var mappings []models.ImportMapping
+		if err := cursor.All(ctx, &mappings); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if len(mappings) == 0 {
+			c.JSON(http.StatusOK, gin.H{"error": "no mappings available"})
+			return
+		}
+
+		c.JSON(http.StatusOK, mappings)
+	}
+}
+
+// DeleteImportMapping : Delete a saved column mapping profile (only admin can access)
+func DeleteImportMapping() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		result, err := ImportMappingCollection.DeleteOne(ctx, helper.TenantFilter(c, bson.M{"mapping_id": c.Param("mapping_id")}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if result.DeletedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "mapping not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Mapping deleted successfully"})
+	}
+}
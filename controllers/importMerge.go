@@ -0,0 +1,221 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	helper "github.com/nirmal/crm/helpers"
+	"github.com/nirmal/crm/models"
+	"github.com/nirmal/crm/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// importOptions : how import rows that match existing records are handled
+type importOptions struct {
+	mode  string
+	key   string
+	rules map[string]string
+}
+
+// importUpdate : an existing record and the fields an import row changes on it
+type importUpdate struct {
+	importRow
+	existing bson.M
+	set      bson.M
+}
+
+// parseImportOptions : read ?mode=, ?key=, ?merge= and ?merge_fields= for entity.
+// merge is the default rule, merge_fields overrides it per field as field:rule pairs.
+func parseImportOptions(c *gin.Context, entity dataEntity) (importOptions, error) {
+	opts := importOptions{
+		mode:  c.DefaultQuery("mode", utils.IMPORT_INSERT_ONLY),
+		rules: map[string]string{},
+	}
+
+	switch opts.mode {
+	case utils.IMPORT_INSERT_ONLY, utils.IMPORT_UPDATE_EXISTING, utils.IMPORT_UPSERT:
+	default:
+		return opts, fmt.Errorf("invalid mode %q, expected insert_only, update_existing or upsert", opts.mode)
+	}
+	if len(entity.matchKeys) == 0 {
+		if opts.mode != utils.IMPORT_INSERT_ONLY {
+			return opts, fmt.Errorf("mode %s is not supported for %s", opts.mode, entity.resource)
+		}
+		return opts, nil
+	}
+
+	opts.key = c.DefaultQuery("key", entity.matchKeys[0])
+	validKey := false
+	for _, key := range entity.matchKeys {
+		validKey = validKey || key == opts.key
+	}
+	if !validKey {
+		return opts, fmt.Errorf("invalid key %q, expected one of %s", opts.key, strings.Join(entity.matchKeys, ", "))
+	}
+
+	defaultRule := c.DefaultQuery("merge", utils.MERGE_OVERWRITE)
+	if !validMergeRule(defaultRule) {
+		return opts, fmt.Errorf("invalid merge rule %q", defaultRule)
+	}
+	for field := range entity.mergeFields {
+		opts.rules[field] = defaultRule
+	}
+	if fields := c.Query("merge_fields"); fields != "" {
+		for _, pair := range strings.Split(fields, ",") {
+			field, rule, _ := strings.Cut(strings.TrimSpace(pair), ":")
+			if _, ok := entity.mergeFields[field]; !ok {
+				return opts, fmt.Errorf("field %q cannot be merged", field)
+			}
+			if !validMergeRule(rule) {
+				return opts, fmt.Errorf("invalid merge rule %q for %s", rule, field)
+			}
+			opts.rules[field] = rule
+		}
+	}
+	return opts, nil
+}
+
+func validMergeRule(rule string) bool {
+	return rule == utils.MERGE_OVERWRITE || rule == utils.MERGE_KEEP_EXISTING || rule == utils.MERGE_FILL_BLANKS
+}
+
+// findImportMatch : live record of the caller's organization with the same key value as row,
+// nil when the row has no value for the key or nothing matches
+func findImportMatch(ctx context.Context, c *gin.Context, entity dataEntity, key string, row map[string]string) (bson.M, error) {
+	value := row[key]
+	if value == "" {
+		return nil, nil
+	}
+	var existing bson.M
+	err := entity.collection.FindOne(ctx, helper.TenantFilter(c, helper.NotDeleted(bson.M{key: value}))).Decode(&existing)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	return existing, err
+}
+
+// mergeImportRow : fields of existing to update from row following the merge rules.
+// Blank cells never clear stored values, and credentials are never changed by an import.
+func mergeImportRow(ctx context.Context, c *gin.Context, entity dataEntity, existing bson.M, row map[string]string, rules map[string]string) (bson.M, error) {
+	set := bson.M{}
+	for field, tag := range entity.mergeFields {
+		if field == customFieldsColumn {
+			orgId, _ := existing[utils.ORG_ID].(string)
+			values, err := importCustomFieldValues(ctx, c, orgId, entity.resource, row, true)
+			if err != nil {
+				return nil, err
+			}
+			mergeImportCustomFields(set, existing, values, rules[field])
+			continue
+		}
+		value := row[field]
+		if value == "" {
+			continue
+		}
+		current, _ := existing[field].(string)
+		switch rules[field] {
+		case utils.MERGE_KEEP_EXISTING:
+			continue
+		case utils.MERGE_FILL_BLANKS:
+			if current != "" {
+				continue
+			}
+		}
+		if value == current {
+			continue
+		}
+		if tag != "" {
+			if err := customerValidate.Var(value, tag); err != nil {
+				return nil, fmt.Errorf("invalid %s %q", field, value)
+			}
+		}
+		set[field] = value
+	}
+
+	for _, field := range entity.uniqueFields {
+		value, ok := set[field]
+		if !ok {
+			continue
+		}
+		count, err := entity.collection.CountDocuments(ctx, bson.M{field: value, "_id": bson.M{"$ne": existing["_id"]}})
+		if err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			return nil, fmt.Errorf("%s %s already exists", field, value)
+		}
+	}
+	return set, nil
+}
+
+// mergeImportCustomFields : add the custom field values of a row to set, each key
+// following rule on its own
+func mergeImportCustomFields(set bson.M, existing bson.M, values map[string]interface{}, rule string) {
+	current, _ := existing[customFieldsColumn].(bson.M)
+	for key, value := range values {
+		stored, ok := current[key]
+		switch {
+		case rule == utils.MERGE_KEEP_EXISTING:
+			continue
+		case rule == utils.MERGE_FILL_BLANKS && ok && stored != "":
+			continue
+		case ok && stored == value:
+			continue
+		}
+		set[customFieldsColumn+"."+key] = value
+	}
+}
+
+// applyImportUpdate : write the merged fields of update, as long as the record was not
+// changed since it was matched
+func applyImportUpdate(ctx context.Context, c *gin.Context, entity dataEntity, update importUpdate) error {
+	version := importVersion(update.existing["version"])
+	set := bson.M{}
+	before := bson.M{}
+	for field, value := range update.set {
+		set[field] = value
+		if key, ok := strings.CutPrefix(field, customFieldsColumn+"."); ok {
+			current, _ := update.existing[customFieldsColumn].(bson.M)
+			before[field] = current[key]
+			continue
+		}
+		before[field] = update.existing[field]
+	}
+	set["updated_at"], _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+	result, err := entity.collection.UpdateOne(ctx,
+		helper.VersionFilter(bson.M{"_id": update.existing["_id"]}, version),
+		bson.M{"$set": set, "$inc": bson.M{"version": 1}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return helper.ErrVersionConflict
+	}
+
+	resourceId, _ := update.existing[entity.idField].(string)
+	helper.RecordAudit(c, models.AuditLog{
+		Action:     utils.ACTION_UPDATE,
+		Resource:   entity.resource,
+		ResourceId: resourceId,
+	}, before, update.set)
+	return nil
+}
+
+// importVersion : stored version of a raw document, records written before versioning are version 0
+func importVersion(value interface{}) int64 {
+	switch v := value.(type) {
+	case int32:
+		return int64(v)
+	case int64:
+		return v
+	case float64:
+		return int64(v)
+	}
+	return 0
+}
@@ -0,0 +1,181 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nirmal/crm/utils"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestParseImportOptions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	customerRules := func(rule string, overrides map[string]string) map[string]string {
+		rules := map[string]string{}
+		for field := range customerDataEntity.mergeFields {
+			rules[field] = rule
+		}
+		for field, rule := range overrides {
+			rules[field] = rule
+		}
+		return rules
+	}
+	notesEntity := dataEntity{resource: utils.RESOURCE_NOTES}
+
+	tests := []struct {
+		name   string
+		entity dataEntity
+		query  string
+		want   importOptions
+		err    bool
+	}{
+		{
+			"defaults",
+			customerDataEntity, "",
+			importOptions{mode: utils.IMPORT_INSERT_ONLY, key: "email", rules: customerRules(utils.MERGE_OVERWRITE, nil)},
+			false,
+		},
+		{
+			"upsert by external id keeping existing values",
+			customerDataEntity, "mode=upsert&key=external_id&merge=keep_existing",
+			importOptions{mode: utils.IMPORT_UPSERT, key: "external_id", rules: customerRules(utils.MERGE_KEEP_EXISTING, nil)},
+			false,
+		},
+		{
+			"rules per field",
+			customerDataEntity, "mode=update_existing&merge=fill_blanks&merge_fields=name:overwrite,%20phone:keep_existing",
+			importOptions{mode: utils.IMPORT_UPDATE_EXISTING, key: "email", rules: customerRules(utils.MERGE_FILL_BLANKS, map[string]string{"name": utils.MERGE_OVERWRITE, "phone": utils.MERGE_KEEP_EXISTING})},
+			false,
+		},
+		{
+			"entity without match keys",
+			notesEntity, "",
+			importOptions{mode: utils.IMPORT_INSERT_ONLY, rules: map[string]string{}},
+			false,
+		},
+		{"entity without match keys cannot upsert", notesEntity, "mode=upsert", importOptions{}, true},
+		{"invalid mode", customerDataEntity, "mode=replace", importOptions{}, true},
+		{"invalid key", customerDataEntity, "mode=upsert&key=phone", importOptions{}, true},
+		{"invalid merge rule", customerDataEntity, "mode=upsert&merge=newest", importOptions{}, true},
+		{"field that cannot be merged", customerDataEntity, "mode=upsert&merge_fields=password:overwrite", importOptions{}, true},
+		{"invalid field rule", customerDataEntity, "mode=upsert&merge_fields=name:newest", importOptions{}, true},
+		{"field without a rule", customerDataEntity, "mode=upsert&merge_fields=name", importOptions{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPost, "/import/customer_data?"+tt.query, nil)
+
+			got, err := parseImportOptions(c, tt.entity)
+			if tt.err {
+				if err == nil {
+					t.Errorf("parseImportOptions(%s) = %+v, want an error", tt.query, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseImportOptions(%s) = %+v, want %+v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeImportRow(t *testing.T) {
+	// without unique fields or an organization to look up custom fields for, the merge never reads the database
+	entity := customerDataEntity
+	entity.uniqueFields = nil
+	existing := bson.M{
+		"name":             "Jane Doe",
+		"email":            "jane@example.com",
+		"company":          "",
+		"phone":            "555 0100",
+		customFieldsColumn: bson.M{"plan": "pro", "region": ""},
+	}
+	row := map[string]string{
+		"name":                   "Jane Smith",
+		"email":                  "jane@example.com",
+		"company":                "Acme",
+		"phone":                  "",
+		"external_id":            "ext-1",
+		"password":               "secret",
+		"custom_fields.plan":     "free",
+		"custom_fields.region":   "eu",
+		"custom_fields.seats":    "10",
+		"custom_fields.discount": "",
+	}
+	rules := func(rule string) map[string]string {
+		rules := map[string]string{}
+		for field := range entity.mergeFields {
+			rules[field] = rule
+		}
+		return rules
+	}
+
+	tests := []struct {
+		name  string
+		row   map[string]string
+		rules map[string]string
+		want  bson.M
+	}{
+		{
+			// blank cells and unchanged values are left alone
+			"overwrite",
+			row, rules(utils.MERGE_OVERWRITE),
+			bson.M{"name": "Jane Smith", "company": "Acme", "external_id": "ext-1", "custom_fields.plan": "free", "custom_fields.region": "eu", "custom_fields.seats": "10"},
+		},
+		{
+			"fill blanks",
+			row, rules(utils.MERGE_FILL_BLANKS),
+			bson.M{"company": "Acme", "external_id": "ext-1", "custom_fields.region": "eu", "custom_fields.seats": "10"},
+		},
+		{"keep existing", row, rules(utils.MERGE_KEEP_EXISTING), bson.M{}},
+		{
+			"rules per field",
+			row,
+			map[string]string{"name": utils.MERGE_KEEP_EXISTING, "email": utils.MERGE_OVERWRITE, "company": utils.MERGE_KEEP_EXISTING, "phone": utils.MERGE_OVERWRITE, "external_id": utils.MERGE_FILL_BLANKS, customFieldsColumn: utils.MERGE_OVERWRITE},
+			bson.M{"external_id": "ext-1", "custom_fields.plan": "free", "custom_fields.region": "eu", "custom_fields.seats": "10"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			got, err := mergeImportRow(context.Background(), c, entity, existing, tt.row, tt.rules)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeImportRow = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	if _, err := mergeImportRow(context.Background(), c, entity, existing, map[string]string{"email": "not-an-email"}, rules(utils.MERGE_OVERWRITE)); err == nil {
+		t.Error("mergeImportRow with an invalid email succeeded, want an error")
+	}
+}
+
+func TestImportVersion(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  int64
+	}{
+		{int32(3), 3},
+		{int64(4), 4},
+		{float64(5), 5},
+		{nil, 0},
+		{"6", 0},
+	}
+	for _, tt := range tests {
+		if got := importVersion(tt.value); got != tt.want {
+			t.Errorf("importVersion(%#v) = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+}
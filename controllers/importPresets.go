@@ -0,0 +1,256 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	helper "github.com/nirmal/crm/helpers"
+	"github.com/nirmal/crm/utils"
+)
+
+// customFieldsColumn : prefix of import columns stored as custom fields, custom_fields.<key>
+const customFieldsColumn = "custom_fields"
+
+// importPreset : built in mapping of another CRM's export layout to import columns.
+// Columns are keyed by the export header as import readers normalise it. Several headers
+// may feed the same column, the first non empty one wins. Headers the preset does not know
+// are kept as custom fields instead of being dropped.
+type importPreset struct {
+	Name     string            `json:"name"`
+	Resource string            `json:"resource"`
+	Columns  map[string]string `json:"columns"`
+}
+
+// first_name and last_name make up name, customer_email and customer_external_id
+// link an activity to an already imported contact. Companies import as accounts.
+var importPresets = []importPreset{
+	{Name: "hubspot_contacts", Resource: utils.RESOURCE_CUSTOMERS, Columns: map[string]string{
+		"record id":          "external_id",
+		"first name":         "first_name",
+		"last name":          "last_name",
+		"email":              "email",
+		"phone number":       "phone",
+		"company name":       "company",
+		"associated company": "company",
+		"create date":        "created_at",
+	}},
+	{Name: "hubspot_companies", Resource: utils.RESOURCE_ACCOUNTS, Columns: map[string]string{
+		"company name":        "name",
+		"name":                "name",
+		"company domain name": "domain",
+		"website url":         "domain",
+		"industry":            "industry",
+		"street address":      "street",
+		"city":                "city",
+		"state/region":        "state",
+		"postal code":         "postal_code",
+		"country/region":      "country",
+		"create date":         "created_at",
+	}},
+	{Name: "hubspot_activities", Resource: utils.RESOURCE_INTERACTIONS, Columns: map[string]string{
+		"activity date":         "start_time",
+		"subject":               "title",
+		"call title":            "title",
+		"meeting name":          "title",
+		"activity type":         "title",
+		"notes":                 "description",
+		"call notes":            "description",
+		"body":                  "description",
+		"associated contact":    "customer_email",
+		"associated contact id": "customer_external_id",
+		"create date":           "created_at",
+	}},
+	{Name: "salesforce_contacts", Resource: utils.RESOURCE_CUSTOMERS, Columns: map[string]string{
+		"contact id":   "external_id",
+		"id":           "external_id",
+		"first name":   "first_name",
+		"last name":    "last_name",
+		"full name":    "name",
+		"email":        "email",
+		"phone":        "phone",
+		"mobile":       "phone",
+		"account name": "company",
+		"created date": "created_at",
+	}},
+	{Name: "salesforce_accounts", Resource: utils.RESOURCE_ACCOUNTS, Columns: map[string]string{
+		"account name":            "name",
+		"website":                 "domain",
+		"industry":                "industry",
+		"billing street":          "street",
+		"billing city":            "city",
+		"billing state/province":  "state",
+		"billing zip/postal code": "postal_code",
+		"billing country":         "country",
+		"created date":            "created_at",
+	}},
+	{Name: "salesforce_activities", Resource: utils.RESOURCE_INTERACTIONS, Columns: map[string]string{
+		"subject":       "title",
+		"comments":      "description",
+		"description":   "description",
+		"full comments": "description",
+		"date":          "start_time",
+		"activity date": "start_time",
+		"start":         "start_time",
+		"contact id":    "customer_external_id",
+		"who id":        "customer_external_id",
+		"email":         "customer_email",
+		"created date":  "created_at",
+	}},
+	{Name: "zoho_contacts", Resource: utils.RESOURCE_CUSTOMERS, Columns: map[string]string{
+		"record id":    "external_id",
+		"contact id":   "external_id",
+		"first name":   "first_name",
+		"last name":    "last_name",
+		"full name":    "name",
+		"email":        "email",
+		"phone":        "phone",
+		"mobile":       "phone",
+		"account name": "company",
+		"created time": "created_at",
+	}},
+	{Name: "zoho_accounts", Resource: utils.RESOURCE_ACCOUNTS, Columns: map[string]string{
+		"account name":    "name",
+		"website":         "domain",
+		"industry":        "industry",
+		"billing street":  "street",
+		"billing city":    "city",
+		"billing state":   "state",
+		"billing code":    "postal_code",
+		"billing country": "country",
+		"created time":    "created_at",
+	}},
+	{Name: "zoho_activities", Resource: utils.RESOURCE_INTERACTIONS, Columns: map[string]string{
+		"subject":         "title",
+		"description":     "description",
+		"call start time": "start_time",
+		"start datetime":  "start_time",
+		"from":            "start_time",
+		"due date":        "start_time",
+		"contact name.id": "customer_external_id",
+		"contact id":      "customer_external_id",
+		"email":           "customer_email",
+		"created time":    "created_at",
+	}},
+}
+
+// findImportPreset : preset name for resource, nil when no preset is requested
+func findImportPreset(resource, name string) (*importPreset, error) {
+	if name == "" {
+		return nil, nil
+	}
+	for i := range importPresets {
+		if importPresets[i].Name == name {
+			if importPresets[i].Resource != resource {
+				return nil, fmt.Errorf("preset %s imports %s, not %s", name, importPresets[i].Resource, resource)
+			}
+			return &importPresets[i], nil
+		}
+	}
+	return nil, fmt.Errorf("unknown preset %q", name)
+}
+
+// reader : rows of r translated to import columns
+func (p *importPreset) reader(r helper.ImportReader) helper.ImportReader {
+	return presetImportReader{ImportReader: r, preset: p}
+}
+
+// presetImportReader : rows of another CRM's export as import rows. Contacts exported
+// from other CRMs carry no credentials, so customers get a generated password.
+type presetImportReader struct {
+	helper.ImportReader
+	preset *importPreset
+}
+
+func (r presetImportReader) Next() (map[string]string, error) {
+	record, err := r.ImportReader.Next()
+	if err != nil {
+		return nil, err
+	}
+
+	// headers are visited in order so the same file always maps the same way
+	headers := make([]string, 0, len(record))
+	for header := range record {
+		headers = append(headers, header)
+	}
+	sort.Strings(headers)
+
+	row := map[string]string{}
+	for _, header := range headers {
+		value := record[header]
+		target, ok := r.preset.Columns[header]
+		if !ok {
+			if key := customFieldKey(header); key != "" && value != "" {
+				row[customFieldsColumn+"."+key] = value
+			}
+			continue
+		}
+		if row[target] == "" {
+			row[target] = value
+		}
+	}
+
+	if r.preset.Resource != utils.RESOURCE_CUSTOMERS {
+		return row, nil
+	}
+	if row["name"] == "" {
+		row["name"] = strings.TrimSpace(row["first_name"] + " " + row["last_name"])
+	}
+	delete(row, "first_name")
+	delete(row, "last_name")
+	if row["password"], err = importPassword(); err != nil {
+		return nil, err
+	}
+	return row, nil
+}
+
+var customFieldKeyPattern = regexp.MustCompile(`[^a-z0-9]+`)
+
+// customFieldKey : header as a custom field key, "Lead Status" becomes lead_status
+func customFieldKey(header string) string {
+	return strings.Trim(customFieldKeyPattern.ReplaceAllString(strings.ToLower(header), "_"), "_")
+}
+
+// importCustomFields : custom_fields.<key> columns of row, nil when there are none
+func importCustomFields(row map[string]string) map[string]interface{} {
+	var fields map[string]interface{}
+	for column, value := range row {
+		key, ok := strings.CutPrefix(column, customFieldsColumn+".")
+		if !ok || key == "" || value == "" {
+			continue
+		}
+		if fields == nil {
+			fields = map[string]interface{}{}
+		}
+		fields[key] = value
+	}
+	return fields
+}
+
+// GetImportPresets : List the built in import presets for other CRMs' exports,
+// optionally for one ?resource= (only admin can access)
+func GetImportPresets() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		presets := []importPreset{}
+		for _, preset := range importPresets {
+			if resource := c.Query("resource"); resource == "" || resource == preset.Resource {
+				presets = append(presets, preset)
+			}
+		}
+
+		if len(presets) == 0 {
+			c.JSON(http.StatusOK, gin.H{"error": "no presets available"})
+			return
+		}
+
+		c.JSON(http.StatusOK, presets)
+	}
+}
@@ -0,0 +1,226 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	helper "github.com/nirmal/crm/helpers"
+	"github.com/nirmal/crm/models"
+	"github.com/nirmal/crm/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// deleteRelation : records in another collection that reference the record being deleted
+type deleteRelation struct {
+	resource   string
+	collection *mongo.Collection
+	foreignKey string
+	idField    string
+	policy     string
+	// versioned : records carry a version, bumped by every update
+	versioned bool
+}
+
+// errDeleteBlocked : related records forbid the delete under their relation's policy
+var errDeleteBlocked = errors.New("cannot delete")
+
+// RelationImpact : what deleting a record does to one of its relations
+type RelationImpact struct {
+	Resource string   `json:"resource"`
+	Policy   string   `json:"policy"`
+	Count    int      `json:"count"`
+	Ids      []string `json:"ids"`
+}
+
+// deletePolicy : policy configured for a relation, soft deleting dependents by default
+func deletePolicy(envKey string) string {
+	switch policy := os.Getenv(envKey); policy {
+	case utils.POLICY_RESTRICT, utils.POLICY_CASCADE, utils.POLICY_REASSIGN, utils.POLICY_SOFT_DELETE:
+		return policy
+	}
+	return utils.POLICY_SOFT_DELETE
+}
+
+// customerDeleteRelations : interactions and tickets owned by a customer
+func customerDeleteRelations() []deleteRelation {
+	return []deleteRelation{
+		{utils.RESOURCE_INTERACTIONS, InteractionCollection, utils.CUSTOMER_ID, "interaction_id", deletePolicy("ON_DELETE_CUSTOMER_INTERACTIONS"), false},
+		{utils.RESOURCE_TICKETS, TicketCollection, utils.CUSTOMER_ID, "ticket_id", deletePolicy("ON_DELETE_CUSTOMER_TICKETS"), true},
+	}
+}
+
+// userDeleteRelations : interactions owned by a user
+func userDeleteRelations() []deleteRelation {
+	return []deleteRelation{
+		{utils.RESOURCE_INTERACTIONS, InteractionCollection, utils.USER_ID, "interaction_id", deletePolicy("ON_DELETE_USER_INTERACTIONS"), false},
+	}
+}
+
+// update : update of the relation's records, without the version bump when they have no version
+func (r deleteRelation) update(update bson.M) bson.M {
+	if r.versioned {
+		return update
+	}
+	return helper.Unversioned(update)
+}
+
+// planDelete : list the live records each relation would touch
+func planDelete(ctx context.Context, relations []deleteRelation, parentId primitive.ObjectID) ([]RelationImpact, error) {
+	var impacts []RelationImpact
+	for _, relation := range relations {
+		opts := options.Find().SetProjection(bson.M{relation.idField: 1})
+		cursor, err := relation.collection.Find(ctx, helper.NotDeleted(bson.M{relation.foreignKey: parentId}), opts)
+		if err != nil {
+			return nil, err
+		}
+
+		var docs []bson.M
+		if err = cursor.All(ctx, &docs); err != nil {
+			return nil, err
+		}
+
+		impact := RelationImpact{Resource: relation.resource, Policy: relation.policy, Ids: []string{}}
+		for _, doc := range docs {
+			if id, ok := doc[relation.idField].(string); ok {
+				impact.Ids = append(impact.Ids, id)
+			}
+		}
+		impact.Count = len(docs)
+		impacts = append(impacts, impact)
+	}
+	return impacts, nil
+}
+
+// checkDeletePlan : reject a delete that a restrict policy forbids or a reassign policy cannot satisfy
+func checkDeletePlan(impacts []RelationImpact, reassignTo *primitive.ObjectID) error {
+	for _, impact := range impacts {
+		if impact.Count == 0 {
+			continue
+		}
+		switch impact.Policy {
+		case utils.POLICY_RESTRICT:
+			return fmt.Errorf("%w: %d %s still reference this record", errDeleteBlocked, impact.Count, impact.Resource)
+		case utils.POLICY_REASSIGN:
+			if reassignTo == nil {
+				return fmt.Errorf("%w: reassign_to is required to move %d %s", errDeleteBlocked, impact.Count, impact.Resource)
+			}
+		}
+	}
+	return nil
+}
+
+// resolveReassignTarget : validate the reassign_to query parameter against live records of the parent collection
+func resolveReassignTarget(ctx context.Context, c *gin.Context, collection *mongo.Collection, idField, parentId string) (*primitive.ObjectID, error) {
+	target := c.Query("reassign_to")
+	if target == "" {
+		return nil, nil
+	}
+	if target == parentId {
+		return nil, fmt.Errorf("cannot reassign to the record being deleted")
+	}
+	targetId, err := primitive.ObjectIDFromHex(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid reassign_to id")
+	}
+	count, err := collection.CountDocuments(ctx, helper.TenantFilter(c, helper.NotDeleted(bson.M{idField: target})))
+	if err != nil || count == 0 {
+		return nil, fmt.Errorf("reassign_to record not found")
+	}
+	return &targetId, nil
+}
+
+// applyDeleteRelations : run the policy of every relation, meant to be called inside the delete
+// transaction. Soft deleted dependents get softDelete, the update that trashed their parent, so
+// they carry its deleted_at and deleted_by and are restored with it. planDelete ran before the
+// transaction, so relations that block the delete are counted again here and fail it with
+// errDeleteBlocked when records were added since.
+func applyDeleteRelations(ctx context.Context, c *gin.Context, relations []deleteRelation, parentId primitive.ObjectID, reassignTo *primitive.ObjectID, softDelete bson.M) error {
+	for _, relation := range relations {
+		filter := helper.NotDeleted(bson.M{relation.foreignKey: parentId})
+
+		var err error
+		switch {
+		case relation.policy == utils.POLICY_RESTRICT || (relation.policy == utils.POLICY_REASSIGN && reassignTo == nil):
+			var count int64
+			if count, err = relation.collection.CountDocuments(ctx, filter); err == nil {
+				err = checkDeletePlan([]RelationImpact{{Resource: relation.resource, Policy: relation.policy, Count: int(count)}}, reassignTo)
+				if err != nil {
+					return err
+				}
+			}
+		case relation.policy == utils.POLICY_CASCADE:
+			_, err = relation.collection.DeleteMany(ctx, filter)
+		case relation.policy == utils.POLICY_REASSIGN:
+			_, err = relation.collection.UpdateMany(ctx, filter, relation.update(bson.M{
+				"$set": bson.M{relation.foreignKey: *reassignTo, "updated_at": time.Now()},
+				"$inc": bson.M{"version": 1},
+			}))
+		case relation.policy == utils.POLICY_SOFT_DELETE:
+			_, err = relation.collection.UpdateMany(ctx, filter, relation.update(softDelete))
+		}
+		if err != nil {
+			return fmt.Errorf("applying %s policy to %s: %w", relation.policy, relation.resource, err)
+		}
+	}
+	return nil
+}
+
+// restoreDeleteRelations : take the dependents trashed together with parent, those carrying its
+// deleted_at and deleted_by, back out of the trash. Meant to be called inside the restore
+// transaction, before parent itself is restored.
+func restoreDeleteRelations(ctx context.Context, relations []deleteRelation, parent bson.M) ([]RelationImpact, error) {
+	var impacts []RelationImpact
+	for _, relation := range relations {
+		filter := bson.M{relation.foreignKey: parent["_id"], "deleted_at": parent["deleted_at"], "deleted_by": parent["deleted_by"]}
+		opts := options.Find().SetProjection(bson.M{relation.idField: 1})
+		cursor, err := relation.collection.Find(ctx, filter, opts)
+		if err != nil {
+			return nil, err
+		}
+		var docs []bson.M
+		if err = cursor.All(ctx, &docs); err != nil {
+			return nil, err
+		}
+
+		impact := RelationImpact{Resource: relation.resource, Policy: utils.POLICY_SOFT_DELETE, Ids: []string{}}
+		for _, doc := range docs {
+			if id, ok := doc[relation.idField].(string); ok {
+				impact.Ids = append(impact.Ids, id)
+			}
+		}
+		impact.Count = len(docs)
+		if impact.Count > 0 {
+			if _, err := relation.collection.UpdateMany(ctx, filter, relation.update(helper.RestoreUpdate())); err != nil {
+				return nil, fmt.Errorf("restoring %s: %w", relation.resource, err)
+			}
+		}
+		impacts = append(impacts, impact)
+	}
+	return impacts, nil
+}
+
+// auditDeleteImpacts : record what happened to the dependents of a deleted record
+func auditDeleteImpacts(c *gin.Context, parentResource, parentId string, impacts []RelationImpact, reassignTo *primitive.ObjectID) {
+	for _, impact := range impacts {
+		if impact.Count == 0 {
+			continue
+		}
+		action := utils.ACTION_DELETE
+		after := bson.M{"policy": impact.Policy, "ids": impact.Ids, parentResource: parentId}
+		if impact.Policy == utils.POLICY_REASSIGN && reassignTo != nil {
+			action = utils.ACTION_UPDATE
+			after["reassigned_to"] = reassignTo.Hex()
+		}
+		helper.RecordAudit(c, models.AuditLog{
+			Action:   action,
+			Resource: impact.Resource,
+		}, nil, after)
+	}
+}
@@ -0,0 +1,49 @@
+package controllers
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nirmal/crm/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCheckDeletePlan(t *testing.T) {
+	target := primitive.NewObjectID()
+
+	tests := []struct {
+		name       string
+		impact     RelationImpact
+		reassignTo *primitive.ObjectID
+		blocked    bool
+	}{
+		{"restrict without references", RelationImpact{Policy: utils.POLICY_RESTRICT}, nil, false},
+		{"restrict with references", RelationImpact{Policy: utils.POLICY_RESTRICT, Count: 2}, nil, true},
+		{"reassign without target", RelationImpact{Policy: utils.POLICY_REASSIGN, Count: 1}, nil, true},
+		{"reassign with target", RelationImpact{Policy: utils.POLICY_REASSIGN, Count: 1}, &target, false},
+		{"soft delete", RelationImpact{Policy: utils.POLICY_SOFT_DELETE, Count: 3}, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkDeletePlan([]RelationImpact{tt.impact}, tt.reassignTo)
+			if errors.Is(err, errDeleteBlocked) != tt.blocked {
+				t.Errorf("checkDeletePlan() = %v, want blocked %v", err, tt.blocked)
+			}
+		})
+	}
+}
+
+func TestDeleteRelationUpdate(t *testing.T) {
+	update := bson.M{"$set": bson.M{"updated_at": 1}, "$inc": bson.M{"version": 1}}
+
+	for _, relation := range append(customerDeleteRelations(), userDeleteRelations()...) {
+		_, bumped := relation.update(update)["$inc"]
+		if want := relation.resource == utils.RESOURCE_TICKETS; bumped != want {
+			t.Errorf("%s update bumps version = %v, want %v", relation.resource, bumped, want)
+		}
+		if _, ok := relation.update(update)["$set"]; !ok {
+			t.Errorf("%s update lost its $set", relation.resource)
+		}
+	}
+}
@@ -64,7 +64,7 @@ func CreateInteractionAndSendEmail() gin.HandlerFunc {
 		interaction.CustomerID = customerID
 
 		var customer models.Customer
-		err = CustomerCollection.FindOne(ctx, bson.M{utils.CUSTOMER_ID: customerIDStr}).Decode(&customer)
+		err = CustomerCollection.FindOne(ctx, helpers.TenantFilter(c, helpers.NotDeleted(bson.M{utils.CUSTOMER_ID: customerIDStr}))).Decode(&customer)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
@@ -74,6 +74,12 @@ func CreateInteractionAndSendEmail() gin.HandlerFunc {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "customer not found"})
 			return
 		}
+		interaction.OrgId = customer.OrgId
+		interaction.CustomFields, err = checkCustomFields(ctx, c, interaction.OrgId, utils.RESOURCE_INTERACTIONS, interaction.CustomFields, false, false)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 
 		resultInsertionNumber, insertErr := InteractionCollection.InsertOne(ctx, interaction)
 		if insertErr != nil {
@@ -81,6 +87,12 @@ func CreateInteractionAndSendEmail() gin.HandlerFunc {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
 			return
 		}
+		helpers.RecordAudit(c, models.AuditLog{
+			Action:     utils.ACTION_CREATE,
+			Resource:   utils.RESOURCE_INTERACTIONS,
+			ResourceId: interaction.InteractionId,
+		}, nil, interaction)
+		rescoreCustomers(ctx, c, interaction.CustomerID)
 
 		userEmail := c.GetString("email")
 		customerEmail := customer.Email
@@ -123,9 +135,16 @@ func GetAllInteractions() gin.HandlerFunc {
 			return
 		}
 
+		// Filter by custom fields, ?cf.<key>=
+		filter := bson.M{}
+		if err := customFieldFilter(ctx, c, utils.RESOURCE_INTERACTIONS, filter); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
 		var interactions []models.Interaction
 
-		cursor, err := InteractionCollection.Find(ctx, bson.M{})
+		cursor, err := InteractionCollection.Find(ctx, helpers.TenantFilter(c, helpers.NotDeleted(filter)))
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing users"})
 			return
@@ -166,7 +185,7 @@ func GetInteractionsByUserID() gin.HandlerFunc {
 
 		var interactions []models.Interaction
 
-		cursor, err := InteractionCollection.Find(ctx, bson.M{"user_id": userId})
+		cursor, err := InteractionCollection.Find(ctx, helpers.TenantFilter(c, helpers.NotDeleted(bson.M{"user_id": userId})))
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing interactions"})
 			return
@@ -208,7 +227,7 @@ func DeleteInteraction() gin.HandlerFunc {
 		// check if interaction exists and belongs to the user
 		var interaction models.Interaction
 
-		err = InteractionCollection.FindOne(ctx, bson.M{"_id": interactionId}).Decode(&interaction)
+		err = InteractionCollection.FindOne(ctx, helpers.TenantFilter(c, helpers.NotDeleted(bson.M{"_id": interactionId}))).Decode(&interaction)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching interaction"})
 			return
@@ -219,16 +238,22 @@ func DeleteInteraction() gin.HandlerFunc {
 			return
 		}
 
-		result, err := InteractionCollection.DeleteOne(ctx, bson.M{"_id": interactionId})
+		result, err := InteractionCollection.UpdateOne(ctx, helpers.TenantFilter(c, helpers.NotDeleted(bson.M{"_id": interactionId})), helpers.Unversioned(helpers.SoftDeleteUpdate(c)))
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while deleting interaction"})
 			return
 		}
 
-		if result.DeletedCount == 0 {
+		if result.ModifiedCount == 0 {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Interaction not found"})
 			return
 		}
+		helpers.RecordAudit(c, models.AuditLog{
+			Action:     utils.ACTION_DELETE,
+			Resource:   utils.RESOURCE_INTERACTIONS,
+			ResourceId: interactionIdStr,
+		}, interaction, nil)
+		rescoreCustomers(ctx, c, interaction.CustomerID)
 
 		c.JSON(http.StatusOK, gin.H{"message": "Interaction deleted successfully"})
 	}
@@ -0,0 +1,485 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nirmal/crm/database"
+	helper "github.com/nirmal/crm/helpers"
+	"github.com/nirmal/crm/models"
+	"github.com/nirmal/crm/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultJobResultTTLHours = 24
+	// progress is written to the database at most this often
+	jobProgressInterval = time.Second
+	// running jobs record that their server is alive this often
+	jobHeartbeatInterval = 30 * time.Second
+	// jobs without a heartbeat for this long lost their server and are failed
+	jobStaleAfter = 5 * time.Minute
+)
+
+var JobCollection *mongo.Collection = database.OpenCollection("Cluster0", "jobs")
+
+// JobResultBucket : result files of jobs, in the database so that any server can hand them out
+var JobResultBucket = database.OpenBucket("Cluster0", "job_results")
+
+// jobOwner : identifies this server process on the jobs it runs
+var jobOwner = jobOwnerId()
+
+func jobOwnerId() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "crm"
+	}
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), primitive.NewObjectID().Hex())
+}
+
+// runningJobs : cancel funcs of the jobs running in this process
+var runningJobs = struct {
+	sync.Mutex
+	cancels map[string]context.CancelFunc
+}{cancels: map[string]context.CancelFunc{}}
+
+// jobRun : a job being executed, passed to the job's work func
+type jobRun struct {
+	job      models.Job
+	lastSave time.Time
+}
+
+// progress : record how many rows have been processed and rejected so far
+func (r *jobRun) progress(processed, errors int) {
+	r.job.Processed, r.job.Errors = int64(processed), int64(errors)
+	if time.Since(r.lastSave) < jobProgressInterval {
+		return
+	}
+	r.lastSave = time.Now()
+	r.save(bson.M{"processed": r.job.Processed, "errors": r.job.Errors})
+}
+
+// createResult : create the downloadable result file of the job, offered to clients as name.
+// The file is complete once the stream is closed.
+func (r *jobRun) createResult(name string) (*gridfs.UploadStream, error) {
+	id := primitive.NewObjectID()
+	stream, err := JobResultBucket.OpenUploadStreamWithID(id, name)
+	if err != nil {
+		return nil, err
+	}
+	r.job.ResultId, r.job.ResultName = &id, name
+	return stream, nil
+}
+
+// transition : move the job from status to the fields of set, unless its status changed
+// meanwhile, as when it was cancelled through another server. Reports whether it moved.
+func (r *jobRun) transition(status string, set bson.M) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	result, err := JobCollection.UpdateOne(ctx, bson.M{"job_id": r.job.JobId, "status": status}, bson.M{"$set": set})
+	if err != nil {
+		log.Printf("error saving job %s: %v", r.job.JobId, err)
+		return false
+	}
+	return result.MatchedCount > 0
+}
+
+func (r *jobRun) save(set bson.M) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := JobCollection.UpdateOne(ctx, bson.M{"job_id": r.job.JobId}, bson.M{"$set": set}); err != nil {
+		log.Printf("error saving job %s: %v", r.job.JobId, err)
+	}
+}
+
+// startJob : record a queued job and run work in the background. work gets a copy of the
+// request context, since c itself must not be used once the handler has returned.
+func startJob(c *gin.Context, kind, resource, format string, work func(ctx context.Context, c *gin.Context, run *jobRun) error) (models.Job, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	job := models.Job{
+		OrgId:     helper.TenantId(c),
+		Kind:      kind,
+		Resource:  resource,
+		Format:    format,
+		Status:    utils.JOB_QUEUED,
+		Owner:     jobOwner,
+		CreatedBy: c.GetString("uid"),
+	}
+	job.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	job.HeartbeatAt = &job.CreatedAt
+	job.ID = primitive.NewObjectID()
+	job.JobId = job.ID.Hex()
+	if _, err := JobCollection.InsertOne(ctx, job); err != nil {
+		return job, err
+	}
+
+	jobCtx, jobCancel := context.WithCancel(context.Background())
+	runningJobs.Lock()
+	runningJobs.cancels[job.JobId] = jobCancel
+	runningJobs.Unlock()
+
+	go executeJob(jobCtx, jobCancel, c.Copy(), job, work)
+	return job, nil
+}
+
+// executeJob : run work and record how the job ended
+func executeJob(ctx context.Context, cancel context.CancelFunc, c *gin.Context, job models.Job, work func(ctx context.Context, c *gin.Context, run *jobRun) error) {
+	run := &jobRun{job: job, lastSave: time.Now()}
+	defer func() {
+		runningJobs.Lock()
+		delete(runningJobs.cancels, job.JobId)
+		runningJobs.Unlock()
+		cancel()
+	}()
+
+	started := time.Now()
+	// a job cancelled while it was queued never starts
+	if !run.transition(utils.JOB_QUEUED, bson.M{"status": utils.JOB_RUNNING, "started_at": started, "heartbeat_at": started}) {
+		return
+	}
+	stopHeartbeat := make(chan struct{})
+	defer close(stopHeartbeat)
+	go jobHeartbeat(job.JobId, cancel, stopHeartbeat)
+
+	err := func() (err error) {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				err = fmt.Errorf("job panicked: %v", recovered)
+			}
+		}()
+		return work(ctx, c, run)
+	}()
+
+	finished := time.Now()
+	expires := finished.Add(time.Duration(envInt("JOB_RESULT_TTL_HOURS", defaultJobResultTTLHours)) * time.Hour)
+	set := bson.M{
+		"status":      utils.JOB_COMPLETED,
+		"processed":   run.job.Processed,
+		"errors":      run.job.Errors,
+		"summary":     run.job.Summary,
+		"finished_at": finished,
+		"expires_at":  expires,
+	}
+	switch {
+	case ctx.Err() == context.Canceled:
+		set["status"] = utils.JOB_CANCELLED
+		removeJobResult(run.job.ResultId)
+		run.job.ResultId, run.job.ResultName = nil, ""
+	case err != nil:
+		set["status"] = utils.JOB_FAILED
+		set["error"] = err.Error()
+	}
+	set["result_id"] = run.job.ResultId
+	set["result_name"] = run.job.ResultName
+	// a cancel or failure recorded meanwhile is kept, and the result of the job dropped
+	if !run.transition(utils.JOB_RUNNING, set) {
+		removeJobResult(run.job.ResultId)
+	}
+}
+
+// jobHeartbeat : record that the job's server is alive until stop is closed, and cancel the
+// job when it was cancelled through another server
+func jobHeartbeat(jobId string, cancel context.CancelFunc, stop <-chan struct{}) {
+	ticker := time.NewTicker(jobHeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		ctx, done := context.WithTimeout(context.Background(), 10*time.Second)
+		var job models.Job
+		err := JobCollection.FindOneAndUpdate(ctx, bson.M{"job_id": jobId, "owner": jobOwner},
+			bson.M{"$set": bson.M{"heartbeat_at": time.Now()}}).Decode(&job)
+		done()
+		if err != nil {
+			log.Printf("error recording heartbeat of job %s: %v", jobId, err)
+			continue
+		}
+		if job.Status == utils.JOB_CANCELLED {
+			cancel()
+		}
+	}
+}
+
+// FailStaleJobs : fail the queued and running jobs whose server stopped sending heartbeats,
+// leaving the jobs of servers still alive alone
+func FailStaleJobs() {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	stale := time.Now().Add(-jobStaleAfter)
+	_, err := JobCollection.UpdateMany(ctx,
+		bson.M{
+			"status": bson.M{"$in": bson.A{utils.JOB_QUEUED, utils.JOB_RUNNING}},
+			"$or": bson.A{
+				bson.M{"heartbeat_at": bson.M{"$lt": stale}},
+				// jobs from before heartbeats were recorded
+				bson.M{"heartbeat_at": bson.M{"$exists": false}, "created_at": bson.M{"$lt": stale}},
+			},
+		},
+		bson.M{"$set": bson.M{"status": utils.JOB_FAILED, "error": "interrupted, its server stopped", "finished_at": time.Now()}},
+	)
+	if err != nil {
+		log.Printf("error failing interrupted jobs: %v", err)
+	}
+}
+
+func removeJobResult(id *primitive.ObjectID) {
+	if id == nil {
+		return
+	}
+	if err := JobResultBucket.Delete(*id); err != nil && err != gridfs.ErrFileNotFound {
+		log.Printf("error removing job result %s: %v", id.Hex(), err)
+	}
+}
+
+// respondJobStarted : 202 response pointing the client at the job status
+func respondJobStarted(c *gin.Context, job models.Job) {
+	c.Header("Location", "/jobs/"+job.JobId)
+	c.JSON(http.StatusAccepted, gin.H{"job_id": job.JobId, "status": job.Status})
+}
+
+// StartJobCleanupJob : run FailStaleJobs every heartbeat interval and RemoveExpiredJobResults
+// every hour in the background
+func StartJobCleanupJob() {
+	go func() {
+		ticker := time.NewTicker(jobHeartbeatInterval)
+		defer ticker.Stop()
+
+		for {
+			FailStaleJobs()
+			<-ticker.C
+		}
+	}()
+
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+
+		for {
+			RemoveExpiredJobResults()
+			<-ticker.C
+		}
+	}()
+}
+
+// RemoveExpiredJobResults : delete result files whose download window has passed
+func RemoveExpiredJobResults() {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	filter := bson.M{"expires_at": bson.M{"$lte": time.Now()}, "result_id": bson.M{"$type": "objectId"}}
+	cursor, err := JobCollection.Find(ctx, filter)
+	if err != nil {
+		log.Printf("error finding expired job results: %v", err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var job models.Job
+		if err := cursor.Decode(&job); err != nil {
+			log.Printf("error decoding job: %v", err)
+			continue
+		}
+		removeJobResult(job.ResultId)
+		if _, err := JobCollection.UpdateOne(ctx, bson.M{"_id": job.ID}, bson.M{"$unset": bson.M{"result_id": ""}}); err != nil {
+			log.Printf("error expiring job %s: %v", job.JobId, err)
+		}
+	}
+}
+
+// findJob : job of the caller's organization by the :job_id param
+func findJob(ctx context.Context, c *gin.Context) (models.Job, error) {
+	var job models.Job
+	err := JobCollection.FindOne(ctx, helper.TenantFilter(c, bson.M{"job_id": c.Param("job_id")})).Decode(&job)
+	return job, err
+}
+
+// GetJobs : List background jobs, newest first, optionally by ?kind= and ?status= (only admin can access)
+func GetJobs() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		filter := bson.M{}
+		if kind := c.Query("kind"); kind != "" {
+			filter["kind"] = kind
+		}
+		if status := c.Query("status"); status != "" {
+			filter["status"] = status
+		}
+
+		opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(100)
+		cursor, err := JobCollection.Find(ctx, helper.TenantFilter(c, filter), opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		defer cursor.Close(ctx)
+
+		var jobs []models.Job
+		if err := cursor.All(ctx, &jobs); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if len(jobs) == 0 {
+			c.JSON(http.StatusOK, gin.H{"error": "no jobs available"})
+			return
+		}
+
+		c.JSON(http.StatusOK, jobs)
+	}
+}
+
+// GetJob : Status and progress of a background job (only admin can access)
+func GetJob() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		job, err := findJob(ctx, c)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "job not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, job)
+	}
+}
+
+// GetJobResult : Download the result file of a finished job (only admin can access)
+func GetJobResult() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		job, err := findJob(ctx, c)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "job not found"})
+			return
+		}
+
+		if job.Status == utils.JOB_QUEUED || job.Status == utils.JOB_RUNNING {
+			c.JSON(http.StatusConflict, gin.H{"error": "job has not finished yet"})
+			return
+		}
+		if job.ExpiresAt != nil && time.Now().After(*job.ExpiresAt) {
+			c.JSON(http.StatusGone, gin.H{"error": "job result has expired"})
+			return
+		}
+		if job.ResultId == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "job has no result file"})
+			return
+		}
+
+		stream, err := JobResultBucket.OpenDownloadStream(*job.ResultId)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "job result not found"})
+			return
+		}
+		defer stream.Close()
+		contentType := mime.TypeByExtension(filepath.Ext(job.ResultName))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		c.DataFromReader(http.StatusOK, stream.GetFile().Length, contentType, stream,
+			map[string]string{"Content-Disposition": fmt.Sprintf("attachment; filename=%q", job.ResultName)})
+	}
+}
+
+// CancelJob : Stop a queued or running job, nothing more is written once it stops (only admin can access)
+func CancelJob() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		job, err := findJob(ctx, c)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "job not found"})
+			return
+		}
+
+		if job.Status != utils.JOB_QUEUED && job.Status != utils.JOB_RUNNING {
+			c.JSON(http.StatusConflict, gin.H{"error": "job has already finished"})
+			return
+		}
+
+		runningJobs.Lock()
+		cancelJob, running := runningJobs.cancels[job.JobId]
+		runningJobs.Unlock()
+		if running {
+			cancelJob()
+		} else {
+			// running on another server, which stops it at its next heartbeat, or on none
+			_, err := JobCollection.UpdateOne(ctx, bson.M{"job_id": job.JobId},
+				bson.M{"$set": bson.M{"status": utils.JOB_CANCELLED, "finished_at": time.Now()}})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		c.JSON(http.StatusAccepted, gin.H{"message": "Job cancellation requested"})
+	}
+}
+
+// spoolJobUpload : copy an uploaded import file to disk, the request body is gone once the
+// handler returns. Uploads stay on this server: a job always runs on the server that started
+// it, and the jobs of a server that stops are failed rather than picked up elsewhere.
+func spoolJobUpload(input io.Reader) (*os.File, error) {
+	file, err := os.CreateTemp("", "crm-upload-*")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(file, input); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	return file, nil
+}
@@ -0,0 +1,485 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/nirmal/crm/database"
+	helper "github.com/nirmal/crm/helpers"
+	"github.com/nirmal/crm/models"
+	"github.com/nirmal/crm/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const defaultFunnelDays = 90
+
+var LeadValidate = validator.New()
+
+// lifecycleStages : lifecycle stages in funnel order
+var lifecycleStages = []string{utils.LIFECYCLE_LEAD, utils.LIFECYCLE_MQL, utils.LIFECYCLE_SQL, utils.LIFECYCLE_CUSTOMER, utils.LIFECYCLE_CHURNED}
+
+// leadStages : stages of prospects, who have no portal login yet
+var leadStages = []string{utils.LIFECYCLE_LEAD, utils.LIFECYCLE_MQL, utils.LIFECYCLE_SQL}
+
+// customerLifecycle : lifecycle stage of customer, customers from before lifecycles are customers
+func customerLifecycle(customer models.Customer) string {
+	if customer.Lifecycle == "" {
+		return utils.LIFECYCLE_CUSTOMER
+	}
+	return customer.Lifecycle
+}
+
+// isLeadStage : whether stage is one of the prospect stages
+func isLeadStage(stage string) bool {
+	for _, lead := range leadStages {
+		if stage == lead {
+			return true
+		}
+	}
+	return false
+}
+
+// lifecycleFilter : customers currently in stage
+func lifecycleFilter(stage string) bson.M {
+	if stage == utils.LIFECYCLE_CUSTOMER {
+		return bson.M{"lifecycle": bson.M{"$in": bson.A{stage, nil}}}
+	}
+	return bson.M{"lifecycle": stage}
+}
+
+// lifecycleChange : move from one stage to another by actorId, now
+func lifecycleChange(from, to, reason, actorId string) models.LifecycleChange {
+	change := models.LifecycleChange{From: from, To: to, Reason: reason, ChangedBy: actorId}
+	change.ChangedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	return change
+}
+
+// leadRequest : a prospect, a customer without a portal login
+type leadRequest struct {
+	Name         *string                `json:"name" validate:"required"`
+	Email        *string                `json:"email" validate:"required,email"`
+	Company      *string                `json:"company"`
+	Phone        *string                `json:"phone"`
+	ExternalId   *string                `json:"external_id"`
+	AccountId    *string                `json:"account_id"`
+	Tags         []string               `json:"tags"`
+	CustomFields map[string]interface{} `json:"custom_fields"`
+	Lifecycle    string                 `json:"lifecycle" validate:"omitempty,oneof=lead mql sql"`
+}
+
+// CreateLead : Record a lead (or an MQL or SQL) without credentials, it gets a portal login
+// once converted (staff only)
+func CreateLead() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckStaff(c); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var request leadRequest
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := LeadValidate.Struct(request); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+		if request.Lifecycle == "" {
+			request.Lifecycle = utils.LIFECYCLE_LEAD
+		}
+
+		lead := models.Customer{
+			OrgId:      helper.TenantId(c),
+			Name:       request.Name,
+			Email:      request.Email,
+			Company:    request.Company,
+			Phone:      request.Phone,
+			ExternalId: request.ExternalId,
+			AccountId:  emptyAsNil(stringValue(request.AccountId)),
+			Lifecycle:  request.Lifecycle,
+		}
+		if lead.OrgId == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "org_id is required"})
+			return
+		}
+		if lead.AccountId != nil && !importReferenceExists(ctx, c, AccountCollection, bson.M{utils.ACCOUNT_ID: *lead.AccountId}) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("account %s not found", *lead.AccountId)})
+			return
+		}
+		var err error
+		if lead.Tags, err = normalizeTags(request.Tags); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if lead.CustomFields, err = checkCustomFields(ctx, c, lead.OrgId, utils.RESOURCE_CUSTOMERS, request.CustomFields, false, false); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		count, err := CustomerCollection.CountDocuments(ctx, bson.M{"email": lead.Email})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while checking for email"})
+			return
+		}
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "this email already exists"})
+			return
+		}
+
+		lead.ID = primitive.NewObjectID()
+		lead.CustomerId = lead.ID.Hex()
+		lead.History = []models.LifecycleChange{lifecycleChange("", lead.Lifecycle, "", c.GetString("uid"))}
+		lead.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		lead.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		lead.Version = 1
+
+		if _, err := CustomerCollection.InsertOne(ctx, lead); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Lead was not created"})
+			return
+		}
+		helper.RecordAudit(c, models.AuditLog{
+			Action:     utils.ACTION_CREATE,
+			Resource:   utils.RESOURCE_CUSTOMERS,
+			ResourceId: lead.CustomerId,
+		}, nil, lead)
+		rescoreCustomers(ctx, c, lead.ID)
+
+		c.JSON(http.StatusCreated, lead)
+	}
+}
+
+// stringValue : value of s, empty when nil
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// GetLeads : List the leads, MQLs and SQLs, or those of one ?lifecycle=, newest first unless
+// sorted by ?sort= (staff only)
+func GetLeads() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckStaff(c); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		filter := bson.M{"lifecycle": bson.M{"$in": leadStages}}
+		if stage := c.Query("lifecycle"); stage != "" {
+			if !isLeadStage(stage) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "lifecycle must be lead, mql or sql"})
+				return
+			}
+			filter = lifecycleFilter(stage)
+		}
+
+		sort, err := customerSort(c, bson.D{{Key: "created_at", Value: -1}})
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		opts := options.Find().SetProjection(bson.M{"password": 0, "token": 0}).SetSort(sort)
+		cursor, err := CustomerCollection.Find(ctx, helper.TenantFilter(c, helper.NotDeleted(filter)), opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing leads"})
+			return
+		}
+
+		var leads []models.Customer
+		if err = cursor.All(ctx, &leads); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while decoding lead data"})
+			return
+		}
+
+		if len(leads) == 0 {
+			c.JSON(http.StatusOK, gin.H{"error": "no leads available"})
+			return
+		}
+
+		c.JSON(http.StatusOK, leads)
+	}
+}
+
+// findLifecycleCustomer : the caller's customer of the customer_id path parameter and the filter matching it
+func findLifecycleCustomer(ctx context.Context, c *gin.Context) (models.Customer, bson.M, error) {
+	filter := helper.TenantFilter(c, helper.NotDeleted(bson.M{utils.CUSTOMER_ID: c.Param(utils.CUSTOMER_ID)}))
+	var customer models.Customer
+	err := CustomerCollection.FindOne(ctx, filter).Decode(&customer)
+	return customer, filter, err
+}
+
+// UpdateLifecycle : Move a lead or customer to another lifecycle stage with an optional
+// reason. Leads become customers by being converted (staff only)
+func UpdateLifecycle() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckStaff(c); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var request struct {
+			Lifecycle string `json:"lifecycle" validate:"required,oneof=lead mql sql customer churned"`
+			Reason    string `json:"reason" validate:"max=500"`
+		}
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := LeadValidate.Struct(request); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		before, filter, err := findLifecycleCustomer(ctx, c)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "customer not found"})
+			return
+		}
+		if !helper.IfMatch(c, helper.ETag(before.CustomerId, before.Version)) {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": helper.ErrVersionConflict.Error()})
+			return
+		}
+		from := customerLifecycle(before)
+		if from == request.Lifecycle {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("customer is already %s", from)})
+			return
+		}
+		if request.Lifecycle == utils.LIFECYCLE_CUSTOMER && before.Password == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "leads become customers by converting them"})
+			return
+		}
+
+		change := lifecycleChange(from, request.Lifecycle, request.Reason, c.GetString("uid"))
+		update := bson.M{
+			"$set":  bson.M{"lifecycle": request.Lifecycle, "updated_at": time.Now()},
+			"$push": bson.M{"lifecycle_history": change},
+			"$inc":  bson.M{"version": 1},
+		}
+		var after models.Customer
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After).SetProjection(bson.M{"password": 0, "token": 0})
+		if err := CustomerCollection.FindOneAndUpdate(ctx, helper.VersionFilter(filter, before.Version), update, opts).Decode(&after); err != nil {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": helper.ErrVersionConflict.Error()})
+			return
+		}
+		c.Header("ETag", helper.ETag(after.CustomerId, after.Version))
+		helper.RecordAudit(c, models.AuditLog{
+			Action:     utils.ACTION_UPDATE,
+			Resource:   utils.RESOURCE_CUSTOMERS,
+			ResourceId: after.CustomerId,
+		}, bson.M{"lifecycle": from}, bson.M{"lifecycle": after.Lifecycle, "reason": request.Reason})
+		rescoreCustomers(ctx, c, after.ID)
+
+		c.JSON(http.StatusOK, after)
+	}
+}
+
+// convertLeadRequest : portal password of a converted lead, generated when empty, and the deal to open for it
+type convertLeadRequest struct {
+	Password *string      `json:"password" validate:"omitempty,min=2,max=100"`
+	Deal     *models.Deal `json:"deal"`
+}
+
+// ConvertLead : Turn a lead into a customer with a portal login, optionally opening a deal
+// for it. A generated password is returned once (staff only)
+func ConvertLead() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckStaff(c); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var request convertLeadRequest
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := LeadValidate.Struct(request); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		before, filter, err := findLifecycleCustomer(ctx, c)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "lead not found"})
+			return
+		}
+		if before.Password != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "customer already has a portal login"})
+			return
+		}
+		if !helper.IfMatch(c, helper.ETag(before.CustomerId, before.Version)) {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": helper.ErrVersionConflict.Error()})
+			return
+		}
+
+		if request.Deal != nil {
+			request.Deal.CustomerId = &before.CustomerId
+			if err := prepareDeal(ctx, c, request.Deal); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		response := gin.H{}
+		password := stringValue(request.Password)
+		if password == "" {
+			if password, err = importPassword(); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while generating a password"})
+				return
+			}
+			response["password"] = password
+		}
+
+		from := customerLifecycle(before)
+		update := bson.M{
+			"$set":  bson.M{"password": HashPassword(password), "lifecycle": utils.LIFECYCLE_CUSTOMER, "updated_at": time.Now()},
+			"$push": bson.M{"lifecycle_history": lifecycleChange(from, utils.LIFECYCLE_CUSTOMER, "converted", c.GetString("uid"))},
+			"$inc":  bson.M{"version": 1},
+		}
+		err = database.WithTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+			result, err := CustomerCollection.UpdateOne(sessCtx, helper.VersionFilter(filter, before.Version), update)
+			if err != nil {
+				return err
+			}
+			if result.MatchedCount == 0 {
+				return helper.ErrVersionConflict
+			}
+			if request.Deal != nil {
+				_, err = DealCollection.InsertOne(sessCtx, *request.Deal)
+			}
+			return err
+		})
+		if errors.Is(err, helper.ErrVersionConflict) {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while converting lead"})
+			return
+		}
+
+		var after models.Customer
+		opts := options.FindOne().SetProjection(bson.M{"password": 0, "token": 0})
+		if err := CustomerCollection.FindOne(ctx, filter, opts).Decode(&after); err == nil {
+			c.Header("ETag", helper.ETag(after.CustomerId, after.Version))
+		}
+		helper.RecordAudit(c, models.AuditLog{
+			Action:     utils.ACTION_UPDATE,
+			Resource:   utils.RESOURCE_CUSTOMERS,
+			ResourceId: before.CustomerId,
+		}, bson.M{"lifecycle": from}, bson.M{"lifecycle": utils.LIFECYCLE_CUSTOMER, "converted": true})
+		rescoreCustomers(ctx, c, before.ID)
+		response["customer"] = after
+		if request.Deal != nil {
+			helper.RecordAudit(c, models.AuditLog{
+				Action:     utils.ACTION_CREATE,
+				Resource:   utils.RESOURCE_DEALS,
+				ResourceId: request.Deal.DealId,
+			}, nil, *request.Deal)
+			response["deal"] = request.Deal
+		}
+
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// funnelStage : customers in a lifecycle stage now, and those who entered it in the period
+type funnelStage struct {
+	Stage   string `json:"stage"`
+	Current int64  `json:"current"`
+	Entered int64  `json:"entered"`
+	// Conversion is entered over the entries of the stage before it, in funnel order
+	Conversion *float64 `json:"conversion,omitempty"`
+}
+
+// GetLifecycleFunnel : Count the customers in each lifecycle stage, and how many entered each
+// stage between ?from= and ?to= (RFC3339 or 2006-01-02, the last 90 days by default) (staff only)
+func GetLifecycleFunnel() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckStaff(c); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		to, from := time.Now(), time.Now().AddDate(0, 0, -defaultFunnelDays)
+		for param, at := range map[string]*time.Time{"from": &from, "to": &to} {
+			if value := c.Query(param); value != "" {
+				parsed, ok := parseDealDate(value)
+				if !ok {
+					c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid %s, expected RFC3339 or 2006-01-02", param)})
+					return
+				}
+				*at = parsed
+			}
+		}
+
+		pipeline := mongo.Pipeline{
+			{{Key: "$match", Value: helper.TenantFilter(c, helper.NotDeleted(bson.M{"lifecycle_history.changed_at": bson.M{"$gte": from, "$lte": to}}))}},
+			{{Key: "$unwind", Value: "$lifecycle_history"}},
+			{{Key: "$match", Value: bson.M{"lifecycle_history.changed_at": bson.M{"$gte": from, "$lte": to}}}},
+			// a customer entering a stage twice counts once
+			{{Key: "$group", Value: bson.M{"_id": bson.M{"stage": "$lifecycle_history.to", "customer": "$_id"}}}},
+			{{Key: "$group", Value: bson.M{"_id": "$_id.stage", "entered": bson.M{"$sum": 1}}}},
+		}
+		cursor, err := CustomerCollection.Aggregate(ctx, pipeline)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while counting stage changes"})
+			return
+		}
+		var rows []struct {
+			Stage   string `bson:"_id"`
+			Entered int64  `bson:"entered"`
+		}
+		if err := cursor.All(ctx, &rows); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while counting stage changes"})
+			return
+		}
+		entered := map[string]int64{}
+		for _, row := range rows {
+			entered[row.Stage] = row.Entered
+		}
+
+		stages := make([]funnelStage, 0, len(lifecycleStages))
+		for i, stage := range lifecycleStages {
+			current, err := CustomerCollection.CountDocuments(ctx, helper.TenantFilter(c, helper.NotDeleted(lifecycleFilter(stage))))
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while counting customers"})
+				return
+			}
+			row := funnelStage{Stage: stage, Current: current, Entered: entered[stage]}
+			// churned customers leave the funnel rather than move down it
+			if i > 0 && stage != utils.LIFECYCLE_CHURNED && entered[lifecycleStages[i-1]] > 0 {
+				conversion := float64(row.Entered) / float64(entered[lifecycleStages[i-1]])
+				row.Conversion = &conversion
+			}
+			stages = append(stages, row)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"from": from, "to": to, "stages": stages})
+	}
+}
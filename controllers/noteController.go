@@ -0,0 +1,373 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/nirmal/crm/database"
+	helper "github.com/nirmal/crm/helpers"
+	"github.com/nirmal/crm/models"
+	"github.com/nirmal/crm/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var NoteValidate = validator.New()
+var NoteCollection *mongo.Collection = database.OpenCollection("Cluster0", "notes")
+
+// noteResources : resources notes can be written on
+var noteResources = map[string]trashResource{
+	utils.RESOURCE_CUSTOMERS: {CustomerCollection, utils.CUSTOMER_ID, true},
+	utils.RESOURCE_ACCOUNTS:  {AccountCollection, utils.ACCOUNT_ID, true},
+	utils.RESOURCE_DEALS:     {DealCollection, "deal_id", true},
+}
+
+var errNotesStaffOnly = errors.New("notes are only available to staff")
+
+// noteReader : staff member reading notes, with the team their team notes are shared in
+type noteReader struct {
+	userId string
+	team   string
+	admin  bool
+}
+
+// currentNoteReader : the calling staff member, customers never read notes
+func currentNoteReader(ctx context.Context, c *gin.Context) (noteReader, error) {
+	if c.GetString("role") == "" || c.GetString("uid") == "" {
+		return noteReader{}, errNotesStaffOnly
+	}
+	reader := noteReader{userId: c.GetString("uid"), admin: helper.CheckUserType(c, utils.ROLE_ADMIN) == nil}
+
+	var user models.User
+	opts := options.FindOne().SetProjection(bson.M{"team": 1})
+	if err := UserCollection.FindOne(ctx, bson.M{utils.USER_ID: reader.userId}, opts).Decode(&user); err == nil && user.Team != nil {
+		reader.team = *user.Team
+	}
+	return reader, nil
+}
+
+// filter : notes the reader may see. Private notes are seen by their author only, team notes
+// also by the author's team and admins, staff notes by everyone on the staff.
+func (r noteReader) filter() bson.M {
+	visible := bson.A{bson.M{"visibility": utils.NOTE_STAFF}, bson.M{"author_id": r.userId}}
+	switch {
+	case r.admin:
+		visible = append(visible, bson.M{"visibility": utils.NOTE_TEAM})
+	case r.team != "":
+		visible = append(visible, bson.M{"visibility": utils.NOTE_TEAM, "author_team": r.team})
+	}
+	return bson.M{"$or": visible}
+}
+
+// canEdit : whether the reader may change the body and visibility of note
+func (r noteReader) canEdit(note models.Note) bool {
+	return note.AuthorId == r.userId
+}
+
+// checkNoteTarget : whether the record a note is written on is live in the caller's organization
+func checkNoteTarget(ctx context.Context, c *gin.Context, resource, resourceId string) error {
+	target, ok := noteResources[resource]
+	if !ok {
+		return fmt.Errorf("notes cannot be written on %s", resource)
+	}
+	count, err := target.collection.CountDocuments(ctx, helper.TenantFilter(c, helper.NotDeleted(bson.M{target.idField: resourceId})))
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return fmt.Errorf("%s %s not found", resource, resourceId)
+	}
+	return nil
+}
+
+// resourceNotes : notes on a record the reader may see, pinned first then newest first, without their history
+func resourceNotes(ctx context.Context, c *gin.Context, reader noteReader, resource, resourceId string) ([]models.Note, error) {
+	filter := bson.M{"resource": resource, "resource_id": resourceId, "$and": bson.A{reader.filter()}}
+	opts := options.Find().
+		SetProjection(bson.M{"history": 0}).
+		SetSort(bson.D{{Key: "pinned", Value: -1}, {Key: "created_at", Value: -1}})
+	cursor, err := NoteCollection.Find(ctx, helper.TenantFilter(c, helper.NotDeleted(filter)), opts)
+	if err != nil {
+		return nil, err
+	}
+	notes := []models.Note{}
+	err = cursor.All(ctx, &notes)
+	return notes, err
+}
+
+// findNote : the note of the note_id path parameter if the reader may see it, and the filter matching it
+func findNote(ctx context.Context, c *gin.Context, reader noteReader) (models.Note, bson.M, error) {
+	filter := helper.TenantFilter(c, helper.NotDeleted(bson.M{"note_id": c.Param("note_id")}))
+	var note models.Note
+	err := NoteCollection.FindOne(ctx, bson.M{"$and": bson.A{filter, reader.filter()}}).Decode(&note)
+	return note, filter, err
+}
+
+// CreateNote : Write a note on a customer, account or deal, visible to all staff unless
+// visibility is private or team (staff only)
+func CreateNote() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		reader, err := currentNoteReader(ctx, c)
+		if err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+
+		var note models.Note
+		if err := c.BindJSON(&note); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if note.Visibility == "" {
+			note.Visibility = utils.NOTE_STAFF
+		}
+		if validationErr := NoteValidate.Struct(note); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		note.OrgId = helper.TenantId(c)
+		if note.OrgId == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "org_id is required"})
+			return
+		}
+		if err := checkNoteTarget(ctx, c, note.Resource, note.ResourceId); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if note.Visibility == utils.NOTE_TEAM && reader.team == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "team notes need you to be in a team"})
+			return
+		}
+
+		note.ID = primitive.NewObjectID()
+		note.NoteId = note.ID.Hex()
+		note.AuthorId = reader.userId
+		note.AuthorTeam = reader.team
+		note.History = nil
+		note.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		note.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		note.Version = 1
+
+		if _, err := NoteCollection.InsertOne(ctx, note); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Note was not created"})
+			return
+		}
+		helper.RecordAudit(c, models.AuditLog{
+			Action:     utils.ACTION_CREATE,
+			Resource:   utils.RESOURCE_NOTES,
+			ResourceId: note.NoteId,
+		}, nil, note)
+
+		c.JSON(http.StatusCreated, note)
+	}
+}
+
+// GetNotes : List the notes on ?resource= and ?resource_id= the caller may see, pinned first (staff only)
+func GetNotes() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		reader, err := currentNoteReader(ctx, c)
+		if err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+
+		resource, resourceId := c.Query("resource"), c.Query("resource_id")
+		if resource == "" || resourceId == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "resource and resource_id are required"})
+			return
+		}
+		if err := checkNoteTarget(ctx, c, resource, resourceId); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+
+		notes, err := resourceNotes(ctx, c, reader, resource, resourceId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing notes"})
+			return
+		}
+
+		if len(notes) == 0 {
+			c.JSON(http.StatusOK, gin.H{"error": "no notes available"})
+			return
+		}
+
+		c.JSON(http.StatusOK, notes)
+	}
+}
+
+// GetNote : Get a note with its edit history (staff only)
+func GetNote() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		reader, err := currentNoteReader(ctx, c)
+		if err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+
+		note, _, err := findNote(ctx, c, reader)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "note not found"})
+			return
+		}
+
+		etag := helper.ETag(note.NoteId, note.Version)
+		c.Header("ETag", etag)
+		if helper.IfNoneMatch(c, etag) {
+			c.Status(http.StatusNotModified)
+			return
+		}
+
+		c.JSON(http.StatusOK, note)
+	}
+}
+
+// noteUpdate : fields of a note update, absent ones stay unchanged
+type noteUpdate struct {
+	Body       *string `json:"body" validate:"omitempty,min=1,max=20000"`
+	Visibility *string `json:"visibility" validate:"omitempty,oneof=private team staff"`
+	Pinned     *bool   `json:"pinned"`
+}
+
+// UpdateNote : Edit a note, keeping its previous body in the history. Only the author
+// changes the body and visibility, the author or an admin pins it (staff only)
+func UpdateNote() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		reader, err := currentNoteReader(ctx, c)
+		if err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+
+		before, filter, err := findNote(ctx, c, reader)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "note not found"})
+			return
+		}
+		if !helper.IfMatch(c, helper.ETag(before.NoteId, before.Version)) {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": helper.ErrVersionConflict.Error()})
+			return
+		}
+
+		var update noteUpdate
+		if err := c.BindJSON(&update); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := NoteValidate.Struct(update); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+		if (update.Body != nil || update.Visibility != nil) && !reader.canEdit(before) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only the author can edit a note"})
+			return
+		}
+		if update.Pinned != nil && !reader.canEdit(before) && !reader.admin {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only the author or an admin can pin a note"})
+			return
+		}
+
+		note := before
+		now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		if update.Body != nil && *update.Body != *before.Body {
+			note.History = append(append([]models.NoteVersion{}, before.History...), models.NoteVersion{Body: *before.Body, EditedBy: reader.userId, EditedAt: now})
+			note.Body = update.Body
+		}
+		if update.Visibility != nil {
+			if *update.Visibility == utils.NOTE_TEAM && reader.team == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "team notes need you to be in a team"})
+				return
+			}
+			note.Visibility = *update.Visibility
+			note.AuthorTeam = reader.team
+		}
+		if update.Pinned != nil {
+			note.Pinned = *update.Pinned
+		}
+		note.UpdatedAt = now
+		note.Version++
+
+		result, err := NoteCollection.ReplaceOne(ctx, helper.VersionFilter(filter, before.Version), note)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while updating note"})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": helper.ErrVersionConflict.Error()})
+			return
+		}
+		c.Header("ETag", helper.ETag(note.NoteId, note.Version))
+		helper.RecordAudit(c, models.AuditLog{
+			Action:     utils.ACTION_UPDATE,
+			Resource:   utils.RESOURCE_NOTES,
+			ResourceId: note.NoteId,
+		}, before, note)
+
+		c.JSON(http.StatusOK, note)
+	}
+}
+
+// DeleteNote : Move a note to the trash, by its author or an admin (staff only)
+func DeleteNote() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		reader, err := currentNoteReader(ctx, c)
+		if err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+
+		before, filter, err := findNote(ctx, c, reader)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "note not found"})
+			return
+		}
+		if !reader.canEdit(before) && !reader.admin {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only the author or an admin can delete a note"})
+			return
+		}
+		if !helper.IfMatch(c, helper.ETag(before.NoteId, before.Version)) {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": helper.ErrVersionConflict.Error()})
+			return
+		}
+
+		result, err := NoteCollection.UpdateOne(ctx, helper.VersionFilter(filter, before.Version), helper.SoftDeleteUpdate(c))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while deleting note"})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": helper.ErrVersionConflict.Error()})
+			return
+		}
+		helper.RecordAudit(c, models.AuditLog{
+			Action:     utils.ACTION_DELETE,
+			Resource:   utils.RESOURCE_NOTES,
+			ResourceId: before.NoteId,
+		}, before, nil)
+
+		c.JSON(http.StatusOK, gin.H{"message": "note deleted successfully"})
+	}
+}
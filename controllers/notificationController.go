@@ -0,0 +1,110 @@
+package controllers
+
+import (
+	"context"
+	"html"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nirmal/crm/database"
+	helper "github.com/nirmal/crm/helpers"
+	"github.com/nirmal/crm/models"
+	"github.com/nirmal/crm/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const maxNotifications = 200
+
+var NotificationCollection *mongo.Collection = database.OpenCollection("Cluster0", "notifications")
+
+// notifyUsers : store notification for each of users and email it to them when SMTP is
+// configured. Failures are logged, the notification is still shown in the app.
+func notifyUsers(ctx context.Context, users []models.User, notification models.Notification) {
+	notification.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	for _, user := range users {
+		notification.ID = primitive.NewObjectID()
+		notification.NotificationId = notification.ID.Hex()
+		notification.OrgId = user.OrgId
+		notification.UserId = user.UserId
+		if _, err := NotificationCollection.InsertOne(ctx, notification); err != nil {
+			log.Printf("error notifying user %s: %v", user.UserId, err)
+			continue
+		}
+		if os.Getenv("SMTP_HOST") == "" || user.Email == nil {
+			continue
+		}
+		body := "<p>" + html.EscapeString(notification.Body) + "</p>"
+		if err := utils.SendEmail(*user.Email, notification.Title, body); err != nil {
+			log.Printf("error emailing notification %s: %v", notification.NotificationId, err)
+		}
+	}
+}
+
+// GetNotifications : List the caller's notifications, newest first, only unread ones with
+// ?unread=true (staff only)
+func GetNotifications() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckStaff(c); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		filter := bson.M{utils.USER_ID: c.GetString("uid")}
+		if c.Query("unread") == "true" {
+			filter["read_at"] = bson.M{"$exists": false}
+		}
+		opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(maxNotifications)
+		cursor, err := NotificationCollection.Find(ctx, helper.TenantFilter(c, filter), opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing notifications"})
+			return
+		}
+
+		var notifications []models.Notification
+		if err = cursor.All(ctx, &notifications); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while decoding notification data"})
+			return
+		}
+
+		if len(notifications) == 0 {
+			c.JSON(http.StatusOK, gin.H{"error": "no notifications available"})
+			return
+		}
+
+		c.JSON(http.StatusOK, notifications)
+	}
+}
+
+// ReadNotification : Mark one of the caller's notifications as read (staff only)
+func ReadNotification() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckStaff(c); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		filter := helper.TenantFilter(c, bson.M{"notification_id": c.Param("notification_id"), utils.USER_ID: c.GetString("uid")})
+		var notification models.Notification
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		// reading it again keeps the first read time
+		update := bson.M{"$min": bson.M{"read_at": time.Now()}}
+		if err := NotificationCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&notification); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "notification not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, notification)
+	}
+}
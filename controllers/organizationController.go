@@ -0,0 +1,240 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/nirmal/crm/database"
+	helper "github.com/nirmal/crm/helpers"
+	"github.com/nirmal/crm/models"
+	"github.com/nirmal/crm/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	OrganizationDatabaseName   = "Cluster0"
+	OrganizationCollectionName = "organizations"
+)
+
+var OrganizationValidate = validator.New()
+var OrganizationCollection *mongo.Collection = database.OpenCollection(OrganizationDatabaseName, OrganizationCollectionName)
+
+// organizationExists : check that an organization id refers to a live organization
+func organizationExists(ctx context.Context, orgId string) bool {
+	if orgId == "" {
+		return false
+	}
+	count, err := OrganizationCollection.CountDocuments(ctx, helper.NotDeleted(bson.M{utils.ORG_ID: orgId}))
+	return err == nil && count > 0
+}
+
+// CreateOrganization : Create a new organization (only super admin can access)
+func CreateOrganization() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_SUPER_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var org models.Organization
+		if err := c.BindJSON(&org); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if validationErr := OrganizationValidate.Struct(org); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		count, err := OrganizationCollection.CountDocuments(ctx, helper.NotDeleted(bson.M{"name": org.Name}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while checking for organization name"})
+			return
+		}
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "this organization already exists"})
+			return
+		}
+
+		org.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		org.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		org.ID = primitive.NewObjectID()
+		org.OrgId = org.ID.Hex()
+		org.Version = 1
+
+		if _, err := OrganizationCollection.InsertOne(ctx, org); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Organization was not created"})
+			return
+		}
+		helper.RecordAudit(c, models.AuditLog{
+			OrgId:      org.OrgId,
+			Action:     utils.ACTION_CREATE,
+			Resource:   utils.RESOURCE_ORGS,
+			ResourceId: org.OrgId,
+		}, nil, org)
+
+		c.JSON(http.StatusCreated, gin.H{"organization": org, "message": "Organization created successfully"})
+	}
+}
+
+// GetOrganizations : List all organizations (only super admin can access)
+func GetOrganizations() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_SUPER_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		cursor, err := OrganizationCollection.Find(ctx, helper.NotDeleted(bson.M{}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing organizations"})
+			return
+		}
+
+		var orgs []models.Organization
+		if err = cursor.All(ctx, &orgs); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while decoding organization data"})
+			return
+		}
+
+		if len(orgs) == 0 {
+			c.JSON(http.StatusOK, gin.H{"error": "no organizations available"})
+			return
+		}
+
+		c.JSON(http.StatusOK, orgs)
+	}
+}
+
+// GetOrganization : Get an organization, super admin or a member of it
+func GetOrganization() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orgId := c.Param(utils.ORG_ID)
+		if c.GetString("role") != utils.ROLE_SUPER_ADMIN && c.GetString(utils.ORG_ID) != orgId {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "UnAuthenticated to access this resource"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var org models.Organization
+		if err := OrganizationCollection.FindOne(ctx, helper.NotDeleted(bson.M{utils.ORG_ID: orgId})).Decode(&org); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "organization not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, org)
+	}
+}
+
+// UpdateOrganization : Rename an organization (only super admin can access)
+func UpdateOrganization() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_SUPER_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var org models.Organization
+		if err := c.BindJSON(&org); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		orgId := c.Param(utils.ORG_ID)
+		filter := helper.NotDeleted(bson.M{utils.ORG_ID: orgId})
+
+		var before models.Organization
+		if err := OrganizationCollection.FindOne(ctx, filter).Decode(&before); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "organization not found"})
+			return
+		}
+
+		updateObj := bson.M{"updated_at": time.Now()}
+		if org.Name != nil {
+			updateObj["name"] = org.Name
+		}
+
+		update := bson.M{"$set": updateObj, "$inc": bson.M{"version": 1}}
+		if _, err := OrganizationCollection.UpdateOne(ctx, filter, update); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while updating organization"})
+			return
+		}
+
+		var after models.Organization
+		if err := OrganizationCollection.FindOne(ctx, filter).Decode(&after); err == nil {
+			helper.RecordAudit(c, models.AuditLog{
+				OrgId:      orgId,
+				Action:     utils.ACTION_UPDATE,
+				Resource:   utils.RESOURCE_ORGS,
+				ResourceId: orgId,
+			}, before, after)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "organization updated successfully"})
+	}
+}
+
+// DeleteOrganization : Move an organization to the trash (only super admin can access)
+// refused while the organization still has users or customers
+func DeleteOrganization() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_SUPER_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		orgId := c.Param(utils.ORG_ID)
+		filter := helper.NotDeleted(bson.M{utils.ORG_ID: orgId})
+
+		var before models.Organization
+		if err := OrganizationCollection.FindOne(ctx, filter).Decode(&before); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "organization not found"})
+			return
+		}
+
+		for _, collection := range []*mongo.Collection{UserCollection, CustomerCollection} {
+			count, err := collection.CountDocuments(ctx, helper.NotDeleted(bson.M{utils.ORG_ID: orgId}))
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while checking organization members"})
+				return
+			}
+			if count > 0 {
+				c.JSON(http.StatusConflict, gin.H{"error": "organization still has users or customers"})
+				return
+			}
+		}
+
+		if _, err := OrganizationCollection.UpdateOne(ctx, filter, helper.SoftDeleteUpdate(c)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while deleting organization"})
+			return
+		}
+		helper.RecordAudit(c, models.AuditLog{
+			OrgId:      orgId,
+			Action:     utils.ACTION_DELETE,
+			Resource:   utils.RESOURCE_ORGS,
+			ResourceId: orgId,
+		}, before, nil)
+
+		c.JSON(http.StatusOK, gin.H{"message": "organization deleted successfully"})
+	}
+}
@@ -0,0 +1,385 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/nirmal/crm/database"
+	helper "github.com/nirmal/crm/helpers"
+	"github.com/nirmal/crm/models"
+	"github.com/nirmal/crm/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var PipelineValidate = validator.New()
+var PipelineCollection *mongo.Collection = database.OpenCollection("Cluster0", "pipelines")
+
+// preparePipelineStages : give new stages an id and open stages their type. Stages keep the
+// id they had in previous, so deals stay in them across renames and reorders.
+func preparePipelineStages(stages []models.PipelineStage, previous []models.PipelineStage) error {
+	known := map[string]bool{}
+	for _, stage := range previous {
+		known[stage.StageId] = true
+	}
+
+	names := map[string]bool{}
+	open := false
+	for i := range stages {
+		stage := &stages[i]
+		stage.Name = strings.TrimSpace(stage.Name)
+		if names[strings.ToLower(stage.Name)] {
+			return fmt.Errorf("stage %s is listed twice", stage.Name)
+		}
+		names[strings.ToLower(stage.Name)] = true
+
+		if stage.StageId == "" {
+			stage.StageId = primitive.NewObjectID().Hex()
+		} else if !known[stage.StageId] {
+			return fmt.Errorf("unknown stage_id %s", stage.StageId)
+		}
+
+		switch stage.Type {
+		case "":
+			stage.Type = utils.DEAL_OPEN
+		case utils.DEAL_WON:
+			stage.Probability = 100
+		case utils.DEAL_LOST:
+			stage.Probability = 0
+		}
+		open = open || stage.Type == utils.DEAL_OPEN
+	}
+	if !open {
+		return fmt.Errorf("a pipeline needs at least one open stage")
+	}
+	return nil
+}
+
+// pipelineStage : stage of pipeline by id
+func pipelineStage(pipeline models.Pipeline, stageId string) (models.PipelineStage, bool) {
+	for _, stage := range pipeline.Stages {
+		if stage.StageId == stageId {
+			return stage, true
+		}
+	}
+	return models.PipelineStage{}, false
+}
+
+// findPipeline : live pipeline of organization orgId, its default pipeline when pipelineId is empty
+func findPipeline(ctx context.Context, orgId, pipelineId string) (models.Pipeline, error) {
+	filter := bson.M{utils.ORG_ID: orgId, "pipeline_id": pipelineId}
+	if pipelineId == "" {
+		filter = bson.M{utils.ORG_ID: orgId, "is_default": true}
+	}
+	var pipeline models.Pipeline
+	err := PipelineCollection.FindOne(ctx, helper.NotDeleted(filter)).Decode(&pipeline)
+	if err == mongo.ErrNoDocuments {
+		if pipelineId == "" {
+			return pipeline, fmt.Errorf("no pipeline configured, an admin has to create one first")
+		}
+		return pipeline, fmt.Errorf("pipeline %s not found", pipelineId)
+	}
+	return pipeline, err
+}
+
+// makeDefaultPipeline : make pipelineId the only default pipeline of orgId
+func makeDefaultPipeline(ctx context.Context, orgId, pipelineId string) error {
+	_, err := PipelineCollection.UpdateMany(ctx,
+		bson.M{utils.ORG_ID: orgId, "pipeline_id": bson.M{"$ne": pipelineId}, "is_default": true},
+		bson.M{"$set": bson.M{"is_default": false, "updated_at": time.Now()}, "$inc": bson.M{"version": 1}})
+	return err
+}
+
+// CreatePipeline : Create a pipeline, the first one of an organization becomes its default (only admin can access)
+func CreatePipeline() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var pipeline models.Pipeline
+		if err := c.BindJSON(&pipeline); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if validationErr := PipelineValidate.Struct(pipeline); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+		if err := preparePipelineStages(pipeline.Stages, nil); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		pipeline.OrgId = helper.TenantId(c)
+		if pipeline.OrgId == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "org_id is required"})
+			return
+		}
+
+		count, err := PipelineCollection.CountDocuments(ctx, helper.NotDeleted(bson.M{utils.ORG_ID: pipeline.OrgId}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while checking for pipelines"})
+			return
+		}
+		pipeline.IsDefault = pipeline.IsDefault || count == 0
+
+		pipeline.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		pipeline.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		pipeline.ID = primitive.NewObjectID()
+		pipeline.PipelineId = pipeline.ID.Hex()
+		pipeline.Version = 1
+
+		if _, err := PipelineCollection.InsertOne(ctx, pipeline); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Pipeline was not created"})
+			return
+		}
+		if pipeline.IsDefault {
+			if err := makeDefaultPipeline(ctx, pipeline.OrgId, pipeline.PipelineId); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while changing the default pipeline"})
+				return
+			}
+		}
+		helper.RecordAudit(c, models.AuditLog{
+			Action:     utils.ACTION_CREATE,
+			Resource:   utils.RESOURCE_PIPELINES,
+			ResourceId: pipeline.PipelineId,
+		}, nil, pipeline)
+
+		c.JSON(http.StatusCreated, pipeline)
+	}
+}
+
+// GetPipelines : List the pipelines and their stages, the default one first
+func GetPipelines() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckStaff(c); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		opts := options.Find().SetSort(bson.D{{Key: "is_default", Value: -1}, {Key: "name", Value: 1}})
+		cursor, err := PipelineCollection.Find(ctx, helper.TenantFilter(c, helper.NotDeleted(bson.M{})), opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing pipelines"})
+			return
+		}
+
+		var pipelines []models.Pipeline
+		if err = cursor.All(ctx, &pipelines); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while decoding pipeline data"})
+			return
+		}
+
+		if len(pipelines) == 0 {
+			c.JSON(http.StatusOK, gin.H{"error": "no pipelines available"})
+			return
+		}
+
+		c.JSON(http.StatusOK, pipelines)
+	}
+}
+
+// GetPipeline : Get a pipeline by ID
+func GetPipeline() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckStaff(c); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var pipeline models.Pipeline
+		err := PipelineCollection.FindOne(ctx, helper.TenantFilter(c, helper.NotDeleted(bson.M{"pipeline_id": c.Param("pipeline_id")}))).Decode(&pipeline)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "pipeline not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, pipeline)
+	}
+}
+
+// pipelineUpdate : fields of a pipeline update, absent ones stay unchanged
+type pipelineUpdate struct {
+	Name        *string                `json:"name" validate:"omitempty,min=1,max=100"`
+	Stages      []models.PipelineStage `json:"stages" validate:"omitempty,min=1,max=50,dive"`
+	IsDefault   *bool                  `json:"is_default"`
+	WonReasons  *[]string              `json:"won_reasons"`
+	LostReasons *[]string              `json:"lost_reasons"`
+}
+
+// UpdatePipeline : Rename, restage or make default a pipeline. Stages keep their stage_id, new
+// stages are sent without one; stages holding deals cannot be removed or change type (only admin can access)
+func UpdatePipeline() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		filter := helper.TenantFilter(c, helper.NotDeleted(bson.M{"pipeline_id": c.Param("pipeline_id")}))
+		var before models.Pipeline
+		if err := PipelineCollection.FindOne(ctx, filter).Decode(&before); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "pipeline not found"})
+			return
+		}
+
+		var changes pipelineUpdate
+		if err := c.BindJSON(&changes); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := PipelineValidate.Struct(changes); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		updateObj := bson.M{"updated_at": time.Now()}
+		if changes.Name != nil {
+			updateObj["name"] = changes.Name
+		}
+		if changes.Stages != nil {
+			if err := preparePipelineStages(changes.Stages, before.Stages); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			if err := checkStagesInUse(ctx, before, changes.Stages); err != nil {
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+				return
+			}
+			updateObj["stages"] = changes.Stages
+		}
+		if changes.IsDefault != nil {
+			if !*changes.IsDefault && before.IsDefault {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "make another pipeline the default instead"})
+				return
+			}
+			updateObj["is_default"] = *changes.IsDefault
+		}
+		if changes.WonReasons != nil {
+			updateObj["won_reasons"] = *changes.WonReasons
+		}
+		if changes.LostReasons != nil {
+			updateObj["lost_reasons"] = *changes.LostReasons
+		}
+
+		update := bson.M{"$set": updateObj, "$inc": bson.M{"version": 1}}
+		if _, err := PipelineCollection.UpdateOne(ctx, filter, update); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while updating pipeline"})
+			return
+		}
+		if changes.IsDefault != nil && *changes.IsDefault {
+			if err := makeDefaultPipeline(ctx, before.OrgId, before.PipelineId); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while changing the default pipeline"})
+				return
+			}
+		}
+
+		var after models.Pipeline
+		if err := PipelineCollection.FindOne(ctx, filter).Decode(&after); err == nil {
+			helper.RecordAudit(c, models.AuditLog{
+				Action:     utils.ACTION_UPDATE,
+				Resource:   utils.RESOURCE_PIPELINES,
+				ResourceId: after.PipelineId,
+			}, before, after)
+		}
+
+		c.JSON(http.StatusOK, after)
+	}
+}
+
+// checkStagesInUse : stages of pipeline that still hold live deals must stay, with their type
+func checkStagesInUse(ctx context.Context, pipeline models.Pipeline, stages []models.PipelineStage) error {
+	kept := map[string]string{}
+	for _, stage := range stages {
+		kept[stage.StageId] = stage.Type
+	}
+	for _, stage := range pipeline.Stages {
+		if stageType, ok := kept[stage.StageId]; ok && stageType == stage.Type {
+			continue
+		}
+		count, err := DealCollection.CountDocuments(ctx, helper.NotDeleted(bson.M{utils.ORG_ID: pipeline.OrgId, "pipeline_id": pipeline.PipelineId, "stage_id": stage.StageId}))
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("stage %s still has %d deals, move them first", stage.Name, count)
+		}
+	}
+	return nil
+}
+
+// DeletePipeline : Move a pipeline to the trash, refused while it has deals, and for the
+// default pipeline while there are others (only admin can access)
+func DeletePipeline() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		filter := helper.TenantFilter(c, helper.NotDeleted(bson.M{"pipeline_id": c.Param("pipeline_id")}))
+		var before models.Pipeline
+		if err := PipelineCollection.FindOne(ctx, filter).Decode(&before); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "pipeline not found"})
+			return
+		}
+
+		count, err := DealCollection.CountDocuments(ctx, helper.NotDeleted(bson.M{utils.ORG_ID: before.OrgId, "pipeline_id": before.PipelineId}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while checking for deals"})
+			return
+		}
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "pipeline still has deals"})
+			return
+		}
+		if before.IsDefault {
+			others, err := PipelineCollection.CountDocuments(ctx, helper.NotDeleted(bson.M{utils.ORG_ID: before.OrgId, "pipeline_id": bson.M{"$ne": before.PipelineId}}))
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while checking for pipelines"})
+				return
+			}
+			if others > 0 {
+				c.JSON(http.StatusConflict, gin.H{"error": "make another pipeline the default first"})
+				return
+			}
+		}
+
+		if _, err := PipelineCollection.UpdateOne(ctx, filter, helper.SoftDeleteUpdate(c)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while deleting pipeline"})
+			return
+		}
+		helper.RecordAudit(c, models.AuditLog{
+			Action:     utils.ACTION_DELETE,
+			Resource:   utils.RESOURCE_PIPELINES,
+			ResourceId: before.PipelineId,
+		}, before, nil)
+
+		c.JSON(http.StatusOK, gin.H{"message": "pipeline deleted successfully"})
+	}
+}
@@ -0,0 +1,651 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/nirmal/crm/database"
+	helper "github.com/nirmal/crm/helpers"
+	"github.com/nirmal/crm/models"
+	"github.com/nirmal/crm/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Scoring rule kinds
+const (
+	SCORE_MATCH        = "match"
+	SCORE_INTERACTIONS = "interactions"
+	SCORE_OPEN_TICKETS = "open_tickets"
+)
+
+const (
+	defaultLeadScoreIntervalHours = 24
+	// scoreWriteBatch : customers whose score is written in one bulk write
+	scoreWriteBatch = 1000
+)
+
+var ScoringValidate = validator.New()
+var ScoringRuleCollection *mongo.Collection = database.OpenCollection("Cluster0", "scoring_rules")
+
+// openTicketStatuses : statuses of tickets still waiting on the support team
+var openTicketStatuses = bson.A{"open", "in_progress"}
+
+// scheduleEngine : engine the contexts of background scoring belong to, they serve no requests
+var scheduleEngine = gin.New()
+
+// scoringContext : request context the background scoring of orgId acts in
+func scoringContext(orgId string) *gin.Context {
+	c := gin.CreateTestContextOnly(httptest.NewRecorder(), scheduleEngine)
+	c.Request = &http.Request{Method: http.MethodPost, URL: &url.URL{Path: "/scoring/recompute"}, Header: http.Header{}}
+	c.Set("role", utils.ROLE_ADMIN)
+	c.Set(utils.ORG_ID, orgId)
+	return c
+}
+
+// scoringRules : enabled scoring rules of orgId, oldest first
+func scoringRules(ctx context.Context, orgId string) ([]models.ScoringRule, error) {
+	filter := helper.NotDeleted(bson.M{utils.ORG_ID: orgId, "enabled": bson.M{"$ne": false}})
+	cursor, err := ScoringRuleCollection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	var rules []models.ScoringRule
+	err = cursor.All(ctx, &rules)
+	return rules, err
+}
+
+// scoreHit : a customer matching a scoring rule, with the interactions or tickets counted
+type scoreHit struct {
+	Count int64
+	Last  time.Time
+}
+
+// scoreHits : customers of orgId matching rule at now, only those of ids unless ids is nil
+func scoreHits(ctx context.Context, c *gin.Context, orgId string, rule models.ScoringRule, ids []primitive.ObjectID, now time.Time) (map[primitive.ObjectID]scoreHit, error) {
+	hits := map[primitive.ObjectID]scoreHit{}
+
+	if rule.Kind == SCORE_MATCH {
+		condition, err := compileSegmentRule(ctx, c, orgId, *rule.Condition)
+		if err != nil {
+			return nil, err
+		}
+		filter := bson.M{"$and": bson.A{bson.M{utils.ORG_ID: orgId}, condition}}
+		if ids != nil {
+			filter["_id"] = bson.M{"$in": ids}
+		}
+		cursor, err := findCustomers(ctx, helper.NotDeleted(filter), options.Find().SetProjection(bson.M{"_id": 1}))
+		if err != nil {
+			return nil, err
+		}
+		var customers []models.Customer
+		if err := cursor.All(ctx, &customers); err != nil {
+			return nil, err
+		}
+		for _, customer := range customers {
+			hits[customer.ID] = scoreHit{}
+		}
+		return hits, nil
+	}
+
+	collection, match := InteractionCollection, bson.M{utils.ORG_ID: orgId}
+	if rule.Kind == SCORE_OPEN_TICKETS {
+		collection = TicketCollection
+		match["status"] = bson.M{"$in": openTicketStatuses}
+	} else if rule.WithinDays > 0 {
+		match["created_at"] = bson.M{"$gte": now.AddDate(0, 0, -rule.WithinDays)}
+	}
+	if ids != nil {
+		match[utils.CUSTOMER_ID] = bson.M{"$in": ids}
+	}
+	minCount := rule.MinCount
+	if minCount < 1 {
+		minCount = 1
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: helper.NotDeleted(match)}},
+		{{Key: "$group", Value: bson.M{"_id": "$" + utils.CUSTOMER_ID, "count": bson.M{"$sum": 1}, "last": bson.M{"$max": "$created_at"}}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gte": minCount}}}},
+	}
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		CustomerId primitive.ObjectID `bson:"_id"`
+		Count      int64              `bson:"count"`
+		Last       time.Time          `bson:"last"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		hits[row.CustomerId] = scoreHit{Count: row.Count, Last: row.Last}
+	}
+	return hits, nil
+}
+
+// scoreAward : points rule gives for hit at now, halved every half_life_days since the
+// latest counted interaction or ticket
+func scoreAward(rule models.ScoringRule, hit scoreHit, now time.Time) int {
+	if rule.Kind == SCORE_MATCH || rule.HalfLifeDays == 0 || hit.Last.IsZero() {
+		return rule.Points
+	}
+	days := math.Max(0, now.Sub(hit.Last).Hours()/24)
+	return int(math.Round(float64(rule.Points) * math.Pow(0.5, days/float64(rule.HalfLifeDays))))
+}
+
+// writeScores : store the score of each customer of orgId in ids, or of all of them when ids
+// is nil, customers without hits scoring 0. Returns the number of customers scored.
+func writeScores(ctx context.Context, orgId string, rules []models.ScoringRule, hits []map[primitive.ObjectID]scoreHit, ids []primitive.ObjectID, now time.Time) (int, error) {
+	if ids == nil {
+		cursor, err := CustomerCollection.Find(ctx, helper.NotDeleted(bson.M{utils.ORG_ID: orgId}), options.Find().SetProjection(bson.M{"_id": 1}))
+		if err != nil {
+			return 0, err
+		}
+		var customers []models.Customer
+		if err := cursor.All(ctx, &customers); err != nil {
+			return 0, err
+		}
+		ids = []primitive.ObjectID{}
+		for _, customer := range customers {
+			ids = append(ids, customer.ID)
+		}
+	}
+
+	scoredAt, _ := time.Parse(time.RFC3339, now.Format(time.RFC3339))
+	// scores are derived data, writing them neither bumps the version nor updated_at
+	for start := 0; start < len(ids); start += scoreWriteBatch {
+		end := start + scoreWriteBatch
+		if end > len(ids) {
+			end = len(ids)
+		}
+		writes := make([]mongo.WriteModel, 0, end-start)
+		for _, id := range ids[start:end] {
+			score := 0
+			for i, rule := range rules {
+				if hit, ok := hits[i][id]; ok {
+					score += scoreAward(rule, hit, now)
+				}
+			}
+			writes = append(writes, mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"_id": id, utils.ORG_ID: orgId}).
+				SetUpdate(bson.M{"$set": bson.M{"lead_score": score, "scored_at": scoredAt}}))
+		}
+		if _, err := CustomerCollection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false)); err != nil {
+			return start, err
+		}
+	}
+	return len(ids), nil
+}
+
+// scoreCustomers : recompute the scores of the customers of orgId in ids, or of all of them
+// when ids is nil
+func scoreCustomers(ctx context.Context, c *gin.Context, orgId string, ids []primitive.ObjectID) (int, error) {
+	now := time.Now()
+	rules, err := scoringRules(ctx, orgId)
+	if err != nil {
+		return 0, err
+	}
+	hits := make([]map[primitive.ObjectID]scoreHit, len(rules))
+	for i, rule := range rules {
+		if hits[i], err = scoreHits(ctx, c, orgId, rule, ids, now); err != nil {
+			return 0, fmt.Errorf("scoring rule %s: %w", rule.RuleId, err)
+		}
+	}
+	return writeScores(ctx, orgId, rules, hits, ids, now)
+}
+
+// rescoreCustomers : recompute the scores of customers after a change to them or their
+// activity. Failures are logged, the nightly run catches up on them.
+func rescoreCustomers(ctx context.Context, c *gin.Context, ids ...primitive.ObjectID) {
+	cursor, err := CustomerCollection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}}, options.Find().SetProjection(bson.M{utils.ORG_ID: 1}))
+	if err != nil {
+		log.Printf("error rescoring customers: %v", err)
+		return
+	}
+	var customers []models.Customer
+	if err := cursor.All(ctx, &customers); err != nil {
+		log.Printf("error rescoring customers: %v", err)
+		return
+	}
+	byOrg := map[string][]primitive.ObjectID{}
+	for _, customer := range customers {
+		byOrg[customer.OrgId] = append(byOrg[customer.OrgId], customer.ID)
+	}
+	for orgId, orgIds := range byOrg {
+		if _, err := scoreCustomers(ctx, c, orgId, orgIds); err != nil {
+			log.Printf("error rescoring customers of %s: %v", orgId, err)
+		}
+	}
+}
+
+// rescoreOrganizationLater : recompute every score of orgId in the background, after its
+// scoring rules changed
+func rescoreOrganizationLater(orgId string) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		defer cancel()
+		if _, err := scoreCustomers(ctx, scoringContext(orgId), orgId, nil); err != nil {
+			log.Printf("error rescoring customers of %s: %v", orgId, err)
+		}
+	}()
+}
+
+// StartLeadScoringJob : recompute the lead scores of every organization every
+// LEAD_SCORE_INTERVAL_HOURS (default 24), so decayed points and recency windows stay current
+func StartLeadScoringJob() {
+	intervalHours := envInt("LEAD_SCORE_INTERVAL_HOURS", defaultLeadScoreIntervalHours)
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(intervalHours) * time.Hour)
+		defer ticker.Stop()
+
+		for {
+			RunLeadScoring()
+			<-ticker.C
+		}
+	}()
+}
+
+// RunLeadScoring : recompute the lead scores of every organization
+func RunLeadScoring() {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	cursor, err := OrganizationCollection.Find(ctx, helper.NotDeleted(bson.M{}), options.Find().SetProjection(bson.M{utils.ORG_ID: 1}))
+	if err != nil {
+		log.Printf("error listing organizations for lead scoring: %v", err)
+		return
+	}
+	var orgs []models.Organization
+	if err := cursor.All(ctx, &orgs); err != nil {
+		log.Printf("error listing organizations for lead scoring: %v", err)
+		return
+	}
+
+	for _, org := range orgs {
+		orgCtx, orgCancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		if _, err := scoreCustomers(orgCtx, scoringContext(org.OrgId), org.OrgId, nil); err != nil {
+			log.Printf("error scoring customers of %s: %v", org.OrgId, err)
+		}
+		orgCancel()
+	}
+}
+
+// checkScoringRule : validate rule of organization orgId, a match rule needs a condition and
+// only activity rules count or decay
+func checkScoringRule(ctx context.Context, c *gin.Context, rule models.ScoringRule) error {
+	if err := ScoringValidate.Struct(rule); err != nil {
+		return err
+	}
+	if rule.Kind == SCORE_MATCH {
+		if rule.Condition == nil {
+			return fmt.Errorf("match rules need a condition")
+		}
+		if rule.MinCount != 0 || rule.WithinDays != 0 || rule.HalfLifeDays != 0 {
+			return fmt.Errorf("min_count, within_days and half_life_days only apply to interactions and open_tickets rules")
+		}
+		_, err := compileSegmentRule(ctx, c, rule.OrgId, *rule.Condition)
+		return err
+	}
+	if rule.Condition != nil {
+		return fmt.Errorf("only match rules have a condition")
+	}
+	if rule.Kind == SCORE_OPEN_TICKETS && rule.WithinDays != 0 {
+		return fmt.Errorf("within_days only applies to interactions rules")
+	}
+	return nil
+}
+
+// CreateScoringRule : Add a lead scoring rule, scores are recomputed in the background
+// (only admin can access)
+func CreateScoringRule() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var rule models.ScoringRule
+		if err := c.BindJSON(&rule); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		rule.OrgId = helper.TenantId(c)
+		if rule.OrgId == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "org_id is required"})
+			return
+		}
+		if rule.Enabled == nil {
+			enabled := true
+			rule.Enabled = &enabled
+		}
+		if err := checkScoringRule(ctx, c, rule); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		rule.ID = primitive.NewObjectID()
+		rule.RuleId = rule.ID.Hex()
+		rule.CreatedBy = c.GetString("uid")
+		rule.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		rule.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		rule.Version = 1
+
+		if _, err := ScoringRuleCollection.InsertOne(ctx, rule); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Scoring rule was not created"})
+			return
+		}
+		helper.RecordAudit(c, models.AuditLog{
+			Action:     utils.ACTION_CREATE,
+			Resource:   utils.RESOURCE_SCORING,
+			ResourceId: rule.RuleId,
+		}, nil, rule)
+		rescoreOrganizationLater(rule.OrgId)
+
+		c.JSON(http.StatusCreated, rule)
+	}
+}
+
+// GetScoringRules : List the lead scoring rules (only admin can access)
+func GetScoringRules() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+		cursor, err := ScoringRuleCollection.Find(ctx, helper.TenantFilter(c, helper.NotDeleted(bson.M{})), opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing scoring rules"})
+			return
+		}
+
+		var rules []models.ScoringRule
+		if err = cursor.All(ctx, &rules); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while decoding scoring rule data"})
+			return
+		}
+
+		if len(rules) == 0 {
+			c.JSON(http.StatusOK, gin.H{"error": "no scoring rules available"})
+			return
+		}
+
+		c.JSON(http.StatusOK, rules)
+	}
+}
+
+// findScoringRule : the caller's scoring rule of the rule_id path parameter and the filter matching it
+func findScoringRule(ctx context.Context, c *gin.Context) (models.ScoringRule, bson.M, error) {
+	filter := helper.TenantFilter(c, helper.NotDeleted(bson.M{"rule_id": c.Param("rule_id")}))
+	var rule models.ScoringRule
+	err := ScoringRuleCollection.FindOne(ctx, filter).Decode(&rule)
+	return rule, filter, err
+}
+
+// GetScoringRule : Get a lead scoring rule (only admin can access)
+func GetScoringRule() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		rule, _, err := findScoringRule(ctx, c)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "scoring rule not found"})
+			return
+		}
+		etag := helper.ETag(rule.RuleId, rule.Version)
+		c.Header("ETag", etag)
+		if helper.IfNoneMatch(c, etag) {
+			c.Status(http.StatusNotModified)
+			return
+		}
+
+		c.JSON(http.StatusOK, rule)
+	}
+}
+
+// scoringRuleUpdate : fields of a scoring rule to change, the ones left out are kept
+type scoringRuleUpdate struct {
+	Name         *string             `json:"name"`
+	Kind         *string             `json:"kind"`
+	Condition    *models.SegmentRule `json:"condition"`
+	MinCount     *int64              `json:"min_count"`
+	WithinDays   *int                `json:"within_days"`
+	Points       *int                `json:"points"`
+	HalfLifeDays *int                `json:"half_life_days"`
+	Enabled      *bool               `json:"enabled"`
+}
+
+// UpdateScoringRule : Change a lead scoring rule, scores are recomputed in the background
+// (only admin can access)
+func UpdateScoringRule() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		before, filter, err := findScoringRule(ctx, c)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "scoring rule not found"})
+			return
+		}
+		if !helper.IfMatch(c, helper.ETag(before.RuleId, before.Version)) {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": helper.ErrVersionConflict.Error()})
+			return
+		}
+
+		var request scoringRuleUpdate
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		rule := before
+		if request.Name != nil {
+			rule.Name = request.Name
+		}
+		if request.Kind != nil {
+			rule.Kind = *request.Kind
+		}
+		if request.Condition != nil {
+			rule.Condition = request.Condition
+		}
+		if request.MinCount != nil {
+			rule.MinCount = *request.MinCount
+		}
+		if request.WithinDays != nil {
+			rule.WithinDays = *request.WithinDays
+		}
+		if request.Points != nil {
+			rule.Points = *request.Points
+		}
+		if request.HalfLifeDays != nil {
+			rule.HalfLifeDays = *request.HalfLifeDays
+		}
+		if request.Enabled != nil {
+			rule.Enabled = request.Enabled
+		}
+		// switching to an activity rule drops the condition of the match rule
+		if rule.Kind != SCORE_MATCH && request.Condition == nil {
+			rule.Condition = nil
+		}
+		if err := checkScoringRule(ctx, c, rule); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		rule.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		rule.Version++
+
+		result, err := ScoringRuleCollection.ReplaceOne(ctx, helper.VersionFilter(filter, before.Version), rule)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while updating scoring rule"})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": helper.ErrVersionConflict.Error()})
+			return
+		}
+		c.Header("ETag", helper.ETag(rule.RuleId, rule.Version))
+		helper.RecordAudit(c, models.AuditLog{
+			Action:     utils.ACTION_UPDATE,
+			Resource:   utils.RESOURCE_SCORING,
+			ResourceId: rule.RuleId,
+		}, before, rule)
+		rescoreOrganizationLater(rule.OrgId)
+
+		c.JSON(http.StatusOK, rule)
+	}
+}
+
+// DeleteScoringRule : Move a lead scoring rule to the trash, scores are recomputed in the
+// background (only admin can access)
+func DeleteScoringRule() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		before, filter, err := findScoringRule(ctx, c)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "scoring rule not found"})
+			return
+		}
+		if !helper.IfMatch(c, helper.ETag(before.RuleId, before.Version)) {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": helper.ErrVersionConflict.Error()})
+			return
+		}
+
+		result, err := ScoringRuleCollection.UpdateOne(ctx, helper.VersionFilter(filter, before.Version), helper.SoftDeleteUpdate(c))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while deleting scoring rule"})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": helper.ErrVersionConflict.Error()})
+			return
+		}
+		helper.RecordAudit(c, models.AuditLog{
+			Action:     utils.ACTION_DELETE,
+			Resource:   utils.RESOURCE_SCORING,
+			ResourceId: before.RuleId,
+		}, before, nil)
+		rescoreOrganizationLater(before.OrgId)
+
+		c.JSON(http.StatusOK, gin.H{"message": "scoring rule deleted successfully"})
+	}
+}
+
+// RecomputeScores : Recompute the lead score of every customer now, as a background job
+// (only admin can access)
+func RecomputeScores() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		orgId := helper.TenantId(c)
+		if orgId == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "org_id is required"})
+			return
+		}
+
+		job, err := startJob(c, utils.JOB_SCORING, utils.RESOURCE_CUSTOMERS, "", func(ctx context.Context, c *gin.Context, run *jobRun) error {
+			scored, err := scoreCustomers(ctx, c, orgId, nil)
+			run.progress(scored, 0)
+			run.job.Summary = map[string]interface{}{"scored": scored}
+			return err
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		respondJobStarted(c, job)
+	}
+}
+
+// GetCustomerScore : Explain a customer's lead score, rule by rule, as computed now (staff only)
+func GetCustomerScore() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckStaff(c); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		customer, _, err := findLifecycleCustomer(ctx, c)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "customer not found"})
+			return
+		}
+		rules, err := scoringRules(ctx, customer.OrgId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing scoring rules"})
+			return
+		}
+
+		now := time.Now()
+		score, lines := 0, []models.ScoreLine{}
+		for _, rule := range rules {
+			hits, err := scoreHits(ctx, c, customer.OrgId, rule, []primitive.ObjectID{customer.ID}, now)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Error occurred while evaluating scoring rule %s", rule.RuleId)})
+				return
+			}
+			line := models.ScoreLine{RuleId: rule.RuleId, Name: *rule.Name, Kind: rule.Kind, Points: rule.Points}
+			if hit, ok := hits[customer.ID]; ok {
+				line.Matched = true
+				line.Count = hit.Count
+				if !hit.Last.IsZero() {
+					line.LastActivity = &hit.Last
+				}
+				line.Awarded = scoreAward(rule, hit, now)
+				score += line.Awarded
+			}
+			lines = append(lines, line)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"customer_id": customer.CustomerId,
+			"score":       score,
+			"lead_score":  customer.LeadScore,
+			"scored_at":   customer.ScoredAt,
+			"rules":       lines,
+		})
+	}
+}
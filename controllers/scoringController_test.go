@@ -0,0 +1,37 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nirmal/crm/models"
+)
+
+func TestScoreAward(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		rule models.ScoringRule
+		last time.Time
+		want int
+	}{
+		{"no half life", models.ScoringRule{Kind: SCORE_INTERACTIONS, Points: 20}, now.AddDate(0, 0, -30), 20},
+		{"match rules do not decay", models.ScoringRule{Kind: SCORE_MATCH, Points: 20, HalfLifeDays: 7}, now.AddDate(0, 0, -30), 20},
+		{"no latest hit", models.ScoringRule{Kind: SCORE_INTERACTIONS, Points: 20, HalfLifeDays: 7}, time.Time{}, 20},
+		{"hit today", models.ScoringRule{Kind: SCORE_INTERACTIONS, Points: 20, HalfLifeDays: 7}, now, 20},
+		{"one half life", models.ScoringRule{Kind: SCORE_INTERACTIONS, Points: 20, HalfLifeDays: 7}, now.AddDate(0, 0, -7), 10},
+		{"two half lives", models.ScoringRule{Kind: SCORE_OPEN_TICKETS, Points: 20, HalfLifeDays: 7}, now.AddDate(0, 0, -14), 5},
+		{"rounded", models.ScoringRule{Kind: SCORE_INTERACTIONS, Points: 10, HalfLifeDays: 10}, now.AddDate(0, 0, -5), 7},
+		{"negative points decay too", models.ScoringRule{Kind: SCORE_OPEN_TICKETS, Points: -40, HalfLifeDays: 30}, now.AddDate(0, 0, -30), -20},
+		{"long gone", models.ScoringRule{Kind: SCORE_INTERACTIONS, Points: 20, HalfLifeDays: 1}, now.AddDate(0, 0, -30), 0},
+		// a hit dated after now, by clock skew, is worth no more than the full points
+		{"hit in the future", models.ScoringRule{Kind: SCORE_INTERACTIONS, Points: 20, HalfLifeDays: 7}, now.Add(time.Hour), 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := scoreAward(tt.rule, scoreHit{Count: 1, Last: tt.last}, now); got != tt.want {
+				t.Errorf("scoreAward = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
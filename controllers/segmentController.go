@@ -0,0 +1,1011 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/nirmal/crm/database"
+	helper "github.com/nirmal/crm/helpers"
+	"github.com/nirmal/crm/models"
+	"github.com/nirmal/crm/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	maxSegmentDepth = 5
+	maxSegmentRules = 50
+	// segmentPreviewLimit : customers returned by a segment preview
+	segmentPreviewLimit = 50
+	// segmentNamePlaceholder : replaced by the customer's name in segment emails
+	segmentNamePlaceholder = "{{name}}"
+)
+
+// Segment rule operators
+const (
+	SEGMENT_EQ              = "eq"
+	SEGMENT_NE              = "ne"
+	SEGMENT_CONTAINS        = "contains"
+	SEGMENT_NOT_CONTAINS    = "not_contains"
+	SEGMENT_IN              = "in"
+	SEGMENT_NOT_IN          = "not_in"
+	SEGMENT_ALL             = "all"
+	SEGMENT_EXISTS          = "exists"
+	SEGMENT_NOT_EXISTS      = "not_exists"
+	SEGMENT_GT              = "gt"
+	SEGMENT_GTE             = "gte"
+	SEGMENT_LT              = "lt"
+	SEGMENT_LTE             = "lte"
+	SEGMENT_BEFORE          = "before"
+	SEGMENT_AFTER           = "after"
+	SEGMENT_WITHIN_DAYS     = "within_days"
+	SEGMENT_OLDER_THAN_DAYS = "older_than_days"
+)
+
+var SegmentValidate = validator.New()
+var SegmentCollection *mongo.Collection = database.OpenCollection("Cluster0", "segments")
+
+var errSegmentNotFound = errors.New("segment not found")
+
+// segmentTextFields : customer fields segments can test as text
+var segmentTextFields = map[string]bool{"name": true, "email": true, "company": true, "phone": true, "external_id": true, utils.ACCOUNT_ID: true}
+
+// segmentDateFields : customer fields segments can test as dates
+var segmentDateFields = map[string]bool{"created_at": true, "updated_at": true}
+
+// segmentActivitySource : records of a customer making up an activity field, and when each happened
+type segmentActivitySource struct {
+	collection *mongo.Collection
+	at         interface{}
+}
+
+// segmentActivity : activity fields segments can test. Interactions happened at their start
+// time (their creation when they have none), tickets when they were opened.
+var segmentActivity = map[string]segmentActivitySource{
+	"last_interaction": {collection: InteractionCollection, at: bson.M{"$ifNull": bson.A{"$start_time", "$created_at"}}},
+	"last_ticket":      {collection: TicketCollection, at: "$created_at"},
+}
+
+// segmentActivityColumn : field a lookup adds to customers for the activity field, a list
+// holding one {at} document with the latest past activity, empty when there is none
+func segmentActivityColumn(field string) string {
+	return "_" + field
+}
+
+// segmentCompiler : turns the rule of a segment of organization orgId into a customer filter
+type segmentCompiler struct {
+	ctx   context.Context
+	c     *gin.Context
+	orgId string
+	now   time.Time
+	rules int
+}
+
+// compileSegmentRule : customer filter selecting the customers of orgId matching rule.
+// Activity tests read the fields segmentActivityLookups adds, so customers are read with
+// findCustomers and countCustomers. Day ranges are fixed when compiling.
+func compileSegmentRule(ctx context.Context, c *gin.Context, orgId string, rule models.SegmentRule) (bson.M, error) {
+	compiler := &segmentCompiler{ctx: ctx, c: c, orgId: orgId, now: time.Now()}
+	return compiler.compile(rule, 1)
+}
+
+func (s *segmentCompiler) compile(rule models.SegmentRule, depth int) (bson.M, error) {
+	s.rules++
+	if s.rules > maxSegmentRules {
+		return nil, fmt.Errorf("segments can have at most %d rules", maxSegmentRules)
+	}
+	if depth > maxSegmentDepth {
+		return nil, fmt.Errorf("segment rules can be nested at most %d deep", maxSegmentDepth)
+	}
+
+	if len(rule.All) > 0 || len(rule.Any) > 0 {
+		if rule.Field != "" || (len(rule.All) > 0 && len(rule.Any) > 0) {
+			return nil, fmt.Errorf("a segment rule is either all, any or a field test")
+		}
+		operator, rules := "$and", rule.All
+		if len(rule.Any) > 0 {
+			operator, rules = "$or", rule.Any
+		}
+		conditions := bson.A{}
+		for _, child := range rules {
+			condition, err := s.compile(child, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			conditions = append(conditions, condition)
+		}
+		return bson.M{operator: conditions}, nil
+	}
+
+	var condition bson.M
+	var err error
+	switch field := rule.Field; {
+	case field == "":
+		return nil, fmt.Errorf("a segment rule needs all, any or a field")
+	case strings.HasPrefix(field, customFieldFilterPrefix):
+		condition, err = s.customField(strings.TrimPrefix(field, customFieldFilterPrefix), rule)
+	case field == "tags":
+		condition, err = s.tags(rule)
+	case segmentActivity[field].collection != nil:
+		condition, err = s.activity(field, rule)
+	case segmentDateFields[field]:
+		condition, err = s.date(field, rule)
+	case segmentTextFields[field]:
+		condition, err = s.text(field, rule)
+	default:
+		return nil, fmt.Errorf("unknown segment field %q", field)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %v", rule.Field, rule.Op, err)
+	}
+	return condition, nil
+}
+
+// text : case insensitive test of a text field
+func (s *segmentCompiler) text(field string, rule models.SegmentRule) (bson.M, error) {
+	switch rule.Op {
+	case SEGMENT_EXISTS:
+		return bson.M{field: bson.M{"$nin": bson.A{nil, ""}}}, nil
+	case SEGMENT_NOT_EXISTS:
+		return bson.M{field: bson.M{"$in": bson.A{nil, ""}}}, nil
+	case SEGMENT_IN, SEGMENT_NOT_IN:
+		values, err := segmentStrings(rule.Value)
+		if err != nil {
+			return nil, err
+		}
+		patterns := bson.A{}
+		for _, value := range values {
+			patterns = append(patterns, primitive.Regex{Pattern: "^" + regexp.QuoteMeta(value) + "$", Options: "i"})
+		}
+		operator := map[string]string{SEGMENT_IN: "$in", SEGMENT_NOT_IN: "$nin"}[rule.Op]
+		return bson.M{field: bson.M{operator: patterns}}, nil
+	}
+
+	value, err := segmentString(rule.Value)
+	if err != nil {
+		return nil, err
+	}
+	switch rule.Op {
+	case SEGMENT_EQ, SEGMENT_NE:
+		return segmentMatch(field, "^"+regexp.QuoteMeta(value)+"$", rule.Op == SEGMENT_NE), nil
+	case SEGMENT_CONTAINS, SEGMENT_NOT_CONTAINS:
+		return segmentMatch(field, regexp.QuoteMeta(value), rule.Op == SEGMENT_NOT_CONTAINS), nil
+	}
+	return nil, fmt.Errorf("unsupported operator")
+}
+
+// segmentMatch : field matching pattern ignoring case, or not matching it
+func segmentMatch(field, pattern string, negate bool) bson.M {
+	regex := primitive.Regex{Pattern: pattern, Options: "i"}
+	if negate {
+		return bson.M{field: bson.M{"$not": regex}}
+	}
+	return bson.M{field: regex}
+}
+
+// date : test of a date field against an RFC3339 time or a number of days back from now
+func (s *segmentCompiler) date(field string, rule models.SegmentRule) (bson.M, error) {
+	switch rule.Op {
+	case SEGMENT_BEFORE, SEGMENT_AFTER:
+		value, err := segmentString(rule.Value)
+		if err != nil {
+			return nil, err
+		}
+		at, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, fmt.Errorf("expected an RFC3339 time")
+		}
+		operator := map[string]string{SEGMENT_BEFORE: "$lt", SEGMENT_AFTER: "$gt"}[rule.Op]
+		return bson.M{field: bson.M{operator: at}}, nil
+	case SEGMENT_WITHIN_DAYS, SEGMENT_OLDER_THAN_DAYS:
+		since, err := s.since(rule.Value)
+		if err != nil {
+			return nil, err
+		}
+		operator := map[string]string{SEGMENT_WITHIN_DAYS: "$gte", SEGMENT_OLDER_THAN_DAYS: "$lt"}[rule.Op]
+		return bson.M{field: bson.M{operator: since}}, nil
+	}
+	return nil, fmt.Errorf("unsupported operator")
+}
+
+// since : start of the last value days
+func (s *segmentCompiler) since(value interface{}) (time.Time, error) {
+	days, ok := segmentNumber(value)
+	if !ok || days <= 0 || days != float64(int(days)) {
+		return time.Time{}, fmt.Errorf("expected a positive number of days")
+	}
+	return s.now.AddDate(0, 0, -int(days)), nil
+}
+
+// tags : test of the customer's tags
+func (s *segmentCompiler) tags(rule models.SegmentRule) (bson.M, error) {
+	switch rule.Op {
+	case SEGMENT_EXISTS:
+		return bson.M{"tags.0": bson.M{"$exists": true}}, nil
+	case SEGMENT_NOT_EXISTS:
+		return bson.M{"tags.0": bson.M{"$exists": false}}, nil
+	}
+
+	values, err := segmentStrings(rule.Value)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := normalizeTags(values)
+	if err != nil {
+		return nil, err
+	}
+	switch rule.Op {
+	case SEGMENT_CONTAINS, SEGMENT_ALL:
+		return bson.M{"tags": bson.M{"$all": tags}}, nil
+	case SEGMENT_NOT_CONTAINS, SEGMENT_NOT_IN:
+		return bson.M{"tags": bson.M{"$nin": tags}}, nil
+	case SEGMENT_IN:
+		return bson.M{"tags": bson.M{"$in": tags}}, nil
+	}
+	return nil, fmt.Errorf("unsupported operator")
+}
+
+// customField : test of a custom field, values typed by its definition
+func (s *segmentCompiler) customField(key string, rule models.SegmentRule) (bson.M, error) {
+	definitions, err := customFieldDefinitions(s.ctx, s.c, s.orgId, utils.RESOURCE_CUSTOMERS)
+	if err != nil {
+		return nil, err
+	}
+	field, ok := definitions[key]
+	if !ok {
+		return nil, fmt.Errorf("unknown custom field %q", key)
+	}
+	column := customFieldsColumn + "." + key
+
+	switch rule.Op {
+	case SEGMENT_EXISTS, SEGMENT_NOT_EXISTS:
+		return bson.M{column: bson.M{"$exists": rule.Op == SEGMENT_EXISTS}}, nil
+	case SEGMENT_IN, SEGMENT_NOT_IN:
+		texts, err := segmentStrings(rule.Value)
+		if err != nil {
+			return nil, err
+		}
+		values := bson.A{}
+		for _, text := range texts {
+			value, err := customFieldFilterValue(field, text)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		operator := map[string]string{SEGMENT_IN: "$in", SEGMENT_NOT_IN: "$nin"}[rule.Op]
+		return bson.M{column: bson.M{operator: values}}, nil
+	}
+
+	text, err := segmentString(rule.Value)
+	if err != nil {
+		return nil, err
+	}
+	if field.Type == FIELD_TEXT {
+		// text matches like the customer's own text fields
+		return s.text(column, models.SegmentRule{Op: rule.Op, Value: text})
+	}
+	value, err := customFieldFilterValue(field, text)
+	if err != nil {
+		return nil, err
+	}
+	switch rule.Op {
+	case SEGMENT_EQ:
+		return bson.M{column: value}, nil
+	case SEGMENT_NE:
+		return bson.M{column: bson.M{"$ne": value}}, nil
+	case SEGMENT_GT, SEGMENT_GTE, SEGMENT_LT, SEGMENT_LTE:
+		if field.Type != FIELD_NUMBER && field.Type != FIELD_DATE {
+			return nil, fmt.Errorf("custom field %s cannot be compared", key)
+		}
+		return bson.M{column: bson.M{"$" + rule.Op: value}}, nil
+	}
+	return nil, fmt.Errorf("unsupported operator")
+}
+
+// activity : whether the customer has interactions (or tickets) at all, or in the last days
+func (s *segmentCompiler) activity(field string, rule models.SegmentRule) (bson.M, error) {
+	column := segmentActivityColumn(field)
+	switch rule.Op {
+	case SEGMENT_EXISTS:
+		return bson.M{column + ".0": bson.M{"$exists": true}}, nil
+	case SEGMENT_NOT_EXISTS:
+		return bson.M{column + ".0": bson.M{"$exists": false}}, nil
+	case SEGMENT_WITHIN_DAYS, SEGMENT_OLDER_THAN_DAYS:
+		since, err := s.since(rule.Value)
+		if err != nil {
+			return nil, err
+		}
+		if rule.Op == SEGMENT_WITHIN_DAYS {
+			return bson.M{column + ".at": bson.M{"$gte": since}}, nil
+		}
+		// older than: no activity since, which includes customers that never had any
+		return bson.M{column + ".at": bson.M{"$not": bson.M{"$gte": since}}}, nil
+	}
+	return nil, fmt.Errorf("unsupported operator")
+}
+
+// segmentActivityLookups : lookups adding the activity fields filter reads to customers, joined
+// on the customer's id, none when filter tests no activity
+func segmentActivityLookups(filter bson.M) mongo.Pipeline {
+	fields := make([]string, 0, len(segmentActivity))
+	for field := range segmentActivity {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	var lookups mongo.Pipeline
+	for _, field := range fields {
+		column, source := segmentActivityColumn(field), segmentActivity[field]
+		if !filterReads(filter, column) {
+			continue
+		}
+		// only activity that already happened counts as the latest, scheduled meetings do not
+		at := bson.M{"$cond": bson.A{bson.M{"$lte": bson.A{source.at, "$$NOW"}}, source.at, nil}}
+		lookups = append(lookups, bson.D{{Key: "$lookup", Value: bson.M{
+			"from": source.collection.Name(),
+			"let":  bson.M{"customer": "$_id"},
+			"pipeline": bson.A{
+				bson.M{"$match": helper.NotDeleted(bson.M{"$expr": bson.M{"$eq": bson.A{"$" + utils.CUSTOMER_ID, "$$customer"}}})},
+				bson.M{"$group": bson.M{"_id": nil, "at": bson.M{"$max": at}}},
+			},
+			"as": column,
+		}}})
+	}
+	return lookups
+}
+
+// filterReads : whether filter tests column or a field below it
+func filterReads(filter interface{}, column string) bool {
+	switch v := filter.(type) {
+	case bson.M:
+		for key, value := range v {
+			if key == column || strings.HasPrefix(key, column+".") || filterReads(value, column) {
+				return true
+			}
+		}
+	case bson.A:
+		for _, value := range v {
+			if filterReads(value, column) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// readsActivity : whether filter tests any activity field
+func readsActivity(filter interface{}) bool {
+	for field := range segmentActivity {
+		if filterReads(filter, segmentActivityColumn(field)) {
+			return true
+		}
+	}
+	return false
+}
+
+// activityPrefilter : the tests of filter that read no activity, run before the lookups so
+// only those customers are joined
+func activityPrefilter(filter bson.M) bson.M {
+	pre := bson.M{}
+	for key, value := range filter {
+		if conditions, ok := value.(bson.A); ok && key == "$and" {
+			kept := bson.A{}
+			for _, condition := range conditions {
+				if !readsActivity(condition) {
+					kept = append(kept, condition)
+				}
+			}
+			if len(kept) > 0 {
+				pre[key] = kept
+			}
+		} else if !readsActivity(bson.M{key: value}) {
+			pre[key] = value
+		}
+	}
+	return pre
+}
+
+// activityPipeline : customers matching a filter that tests activity, joined with the
+// activity it reads, which is left out of the customers returned
+func activityPipeline(filter bson.M, lookups mongo.Pipeline) mongo.Pipeline {
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: activityPrefilter(filter)}}}
+	pipeline = append(pipeline, lookups...)
+	pipeline = append(pipeline, bson.D{{Key: "$match", Value: filter}})
+	unset := bson.M{}
+	for field := range segmentActivity {
+		unset[segmentActivityColumn(field)] = 0
+	}
+	return append(pipeline, bson.D{{Key: "$project", Value: unset}})
+}
+
+// findCustomers : customers matching filter, which may test activity. Only the sort, skip,
+// limit and projection of opts apply.
+func findCustomers(ctx context.Context, filter bson.M, opts *options.FindOptions) (*mongo.Cursor, error) {
+	lookups := segmentActivityLookups(filter)
+	if len(lookups) == 0 {
+		return CustomerCollection.Find(ctx, filter, opts)
+	}
+	pipeline := activityPipeline(filter, lookups)
+	if opts != nil {
+		if opts.Sort != nil {
+			pipeline = append(pipeline, bson.D{{Key: "$sort", Value: opts.Sort}})
+		}
+		if opts.Skip != nil {
+			pipeline = append(pipeline, bson.D{{Key: "$skip", Value: *opts.Skip}})
+		}
+		if opts.Limit != nil && *opts.Limit > 0 {
+			pipeline = append(pipeline, bson.D{{Key: "$limit", Value: *opts.Limit}})
+		}
+		if opts.Projection != nil {
+			pipeline = append(pipeline, bson.D{{Key: "$project", Value: opts.Projection}})
+		}
+	}
+	return CustomerCollection.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+}
+
+// countCustomers : number of customers matching filter, which may test activity
+func countCustomers(ctx context.Context, filter bson.M) (int64, error) {
+	lookups := segmentActivityLookups(filter)
+	if len(lookups) == 0 {
+		return CustomerCollection.CountDocuments(ctx, filter)
+	}
+	pipeline := append(activityPipeline(filter, lookups), bson.D{{Key: "$count", Value: "count"}})
+	cursor, err := CustomerCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, err
+	}
+	var rows []struct {
+		Count int64 `bson:"count"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil || len(rows) == 0 {
+		return 0, err
+	}
+	return rows[0].Count, nil
+}
+
+// segmentString : rule value that must be text, numbers and booleans are taken as written
+func segmentString(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+	if number, ok := segmentNumber(value); ok {
+		return strconv.FormatFloat(number, 'f', -1, 64), nil
+	}
+	return "", fmt.Errorf("expected a single value")
+}
+
+// segmentStrings : rule value that must be a list, a single value is a list of one
+func segmentStrings(value interface{}) ([]string, error) {
+	var items []interface{}
+	switch v := value.(type) {
+	case []interface{}:
+		items = v
+	case primitive.A:
+		items = v
+	default:
+		items = []interface{}{v}
+	}
+	values := make([]string, 0, len(items))
+	for _, item := range items {
+		text, err := segmentString(item)
+		if err != nil {
+			return nil, fmt.Errorf("expected a list of values")
+		}
+		values = append(values, text)
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("expected at least one value")
+	}
+	return values, nil
+}
+
+// segmentNumber : rule value as a number, as decoded from JSON or stored in BSON
+func segmentNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// segmentFilter : customer filter of the caller's segment segmentId, evaluated now
+func segmentFilter(ctx context.Context, c *gin.Context, segmentId string) (bson.M, models.Segment, error) {
+	return tenantSegmentFilter(ctx, c, helper.TenantFilter(c, bson.M{}), segmentId)
+}
+
+// tenantSegmentFilter : customer filter of segment segmentId, looked up among the segments
+// tenant selects, evaluated now. c is nil outside of a request.
+func tenantSegmentFilter(ctx context.Context, c *gin.Context, tenant bson.M, segmentId string) (bson.M, models.Segment, error) {
+	lookup := helper.NotDeleted(bson.M{"segment_id": segmentId})
+	for key, value := range tenant {
+		lookup[key] = value
+	}
+	var segment models.Segment
+	err := SegmentCollection.FindOne(ctx, lookup).Decode(&segment)
+	if err != nil {
+		return nil, segment, errSegmentNotFound
+	}
+	filter, err := compileSegmentRule(ctx, c, segment.OrgId, *segment.Rule)
+	if err != nil {
+		return nil, segment, fmt.Errorf("segment %s: %v", segmentId, err)
+	}
+	return bson.M{"$and": bson.A{bson.M{utils.ORG_ID: segment.OrgId}, filter}}, segment, nil
+}
+
+// segmentErrorStatus : status for an error of segmentFilter
+func segmentErrorStatus(err error) int {
+	if errors.Is(err, errSegmentNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusBadRequest
+}
+
+// customerTagSegmentFilter : narrow filter to the customers carrying every tag of ?tag=
+// (comma separated) and to the members of ?segment=, a segment among those tenant selects.
+// c is nil outside of a request.
+func customerTagSegmentFilter(ctx context.Context, c *gin.Context, tenant bson.M, query url.Values, filter bson.M) error {
+	if tag := query.Get("tag"); tag != "" {
+		tags, err := normalizeTags(strings.Split(tag, ","))
+		if err != nil {
+			return err
+		}
+		filter["tags"] = bson.M{"$all": tags}
+	}
+	if segmentId := query.Get("segment"); segmentId != "" {
+		segment, _, err := tenantSegmentFilter(ctx, c, tenant, segmentId)
+		if err != nil {
+			return err
+		}
+		conditions, _ := filter["$and"].(bson.A)
+		filter["$and"] = append(conditions, segment)
+	}
+	return nil
+}
+
+// checkSegment : validate segment and its rule for organization orgId
+func checkSegment(ctx context.Context, c *gin.Context, segment models.Segment) error {
+	if err := SegmentValidate.Struct(segment); err != nil {
+		return err
+	}
+	if _, err := compileSegmentRule(ctx, c, segment.OrgId, *segment.Rule); err != nil {
+		return err
+	}
+
+	filter := bson.M{
+		utils.ORG_ID: segment.OrgId,
+		"name":       primitive.Regex{Pattern: "^" + regexp.QuoteMeta(*segment.Name) + "$", Options: "i"},
+		"segment_id": bson.M{"$ne": segment.SegmentId},
+	}
+	count, err := SegmentCollection.CountDocuments(ctx, helper.NotDeleted(filter))
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("segment %s already exists", *segment.Name)
+	}
+	return nil
+}
+
+// CreateSegment : Save a segment of customers (only admin can access)
+func CreateSegment() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var segment models.Segment
+		if err := c.BindJSON(&segment); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		segment.OrgId = helper.TenantId(c)
+		if segment.OrgId == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "org_id is required"})
+			return
+		}
+		segment.ID = primitive.NewObjectID()
+		segment.SegmentId = segment.ID.Hex()
+		if err := checkSegment(ctx, c, segment); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		segment.CreatedBy = c.GetString("uid")
+		segment.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		segment.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		segment.Version = 1
+
+		if _, err := SegmentCollection.InsertOne(ctx, segment); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Segment was not created"})
+			return
+		}
+		helper.RecordAudit(c, models.AuditLog{
+			Action:     utils.ACTION_CREATE,
+			Resource:   utils.RESOURCE_SEGMENTS,
+			ResourceId: segment.SegmentId,
+		}, nil, segment)
+
+		c.JSON(http.StatusCreated, segment)
+	}
+}
+
+// GetSegments : List segments
+func GetSegments() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckStaff(c); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}})
+		cursor, err := SegmentCollection.Find(ctx, helper.TenantFilter(c, helper.NotDeleted(bson.M{})), opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing segments"})
+			return
+		}
+
+		var segments []models.Segment
+		if err = cursor.All(ctx, &segments); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while decoding segment data"})
+			return
+		}
+
+		if len(segments) == 0 {
+			c.JSON(http.StatusOK, gin.H{"error": "no segments available"})
+			return
+		}
+
+		c.JSON(http.StatusOK, segments)
+	}
+}
+
+// findSegment : the caller's segment of the segment_id path parameter and the filter matching it
+func findSegment(ctx context.Context, c *gin.Context) (models.Segment, bson.M, error) {
+	filter := helper.TenantFilter(c, helper.NotDeleted(bson.M{"segment_id": c.Param("segment_id")}))
+	var segment models.Segment
+	err := SegmentCollection.FindOne(ctx, filter).Decode(&segment)
+	return segment, filter, err
+}
+
+// GetSegment : Get a segment by ID
+func GetSegment() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckStaff(c); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		segment, _, err := findSegment(ctx, c)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "segment not found"})
+			return
+		}
+
+		etag := helper.ETag(segment.SegmentId, segment.Version)
+		c.Header("ETag", etag)
+		if helper.IfNoneMatch(c, etag) {
+			c.Status(http.StatusNotModified)
+			return
+		}
+
+		c.JSON(http.StatusOK, segment)
+	}
+}
+
+// UpdateSegment : Change the name, description or rule of a segment (only admin can access)
+func UpdateSegment() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		before, filter, err := findSegment(ctx, c)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "segment not found"})
+			return
+		}
+		if !helper.IfMatch(c, helper.ETag(before.SegmentId, before.Version)) {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": helper.ErrVersionConflict.Error()})
+			return
+		}
+
+		var request models.Segment
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		segment := before
+		if request.Name != nil {
+			segment.Name = request.Name
+		}
+		if request.Description != nil {
+			segment.Description = request.Description
+		}
+		if request.Rule != nil {
+			segment.Rule = request.Rule
+		}
+		if err := checkSegment(ctx, c, segment); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		segment.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		segment.Version++
+
+		result, err := SegmentCollection.ReplaceOne(ctx, helper.VersionFilter(filter, before.Version), segment)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while updating segment"})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": helper.ErrVersionConflict.Error()})
+			return
+		}
+		c.Header("ETag", helper.ETag(segment.SegmentId, segment.Version))
+		helper.RecordAudit(c, models.AuditLog{
+			Action:     utils.ACTION_UPDATE,
+			Resource:   utils.RESOURCE_SEGMENTS,
+			ResourceId: segment.SegmentId,
+		}, before, segment)
+
+		c.JSON(http.StatusOK, segment)
+	}
+}
+
+// DeleteSegment : Move a segment to the trash, refused while export schedules target it (only admin can access)
+func DeleteSegment() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		before, filter, err := findSegment(ctx, c)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "segment not found"})
+			return
+		}
+		if !helper.IfMatch(c, helper.ETag(before.SegmentId, before.Version)) {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": helper.ErrVersionConflict.Error()})
+			return
+		}
+
+		count, err := ExportScheduleCollection.CountDocuments(ctx, bson.M{utils.ORG_ID: before.OrgId, "filter.segment": before.SegmentId})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while checking export schedules"})
+			return
+		}
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "segment is the target of export schedules"})
+			return
+		}
+
+		result, err := SegmentCollection.UpdateOne(ctx, helper.VersionFilter(filter, before.Version), helper.SoftDeleteUpdate(c))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while deleting segment"})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": helper.ErrVersionConflict.Error()})
+			return
+		}
+		helper.RecordAudit(c, models.AuditLog{
+			Action:     utils.ACTION_DELETE,
+			Resource:   utils.RESOURCE_SEGMENTS,
+			ResourceId: before.SegmentId,
+		}, before, nil)
+
+		c.JSON(http.StatusOK, gin.H{"message": "segment deleted successfully"})
+	}
+}
+
+// segmentMembers : count of the customers matching filter and the first limit of them by name, all when limit is 0
+func segmentMembers(ctx context.Context, filter bson.M, limit int64) (int64, []models.Customer, error) {
+	filter = helper.NotDeleted(filter)
+	count, err := countCustomers(ctx, filter)
+	if err != nil {
+		return 0, nil, err
+	}
+	opts := options.Find().SetProjection(bson.M{"password": 0, "token": 0}).SetSort(bson.D{{Key: "name", Value: 1}})
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+	cursor, err := findCustomers(ctx, filter, opts)
+	if err != nil {
+		return 0, nil, err
+	}
+	customers := []models.Customer{}
+	err = cursor.All(ctx, &customers)
+	return count, customers, err
+}
+
+// GetSegmentCustomers : List the customers currently matching a segment
+func GetSegmentCustomers() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckStaff(c); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter, segment, err := segmentFilter(ctx, c, c.Param("segment_id"))
+		if err != nil {
+			c.JSON(segmentErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		count, customers, err := segmentMembers(ctx, filter, 0)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing segment customers"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"segment_id": segment.SegmentId, "count": count, "customers": customers})
+	}
+}
+
+// PreviewSegment : Count the customers a rule matches, with the first of them, without saving it
+func PreviewSegment() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckStaff(c); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var request struct {
+			Rule *models.SegmentRule `json:"rule" validate:"required"`
+		}
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := SegmentValidate.Struct(request); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		orgId := helper.TenantId(c)
+		if orgId == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "org_id is required"})
+			return
+		}
+		filter, err := compileSegmentRule(ctx, c, orgId, *request.Rule)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		count, customers, err := segmentMembers(ctx, bson.M{"$and": bson.A{bson.M{utils.ORG_ID: orgId}, filter}}, segmentPreviewLimit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while previewing segment"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"count": count, "customers": customers})
+	}
+}
+
+// segmentEmailRequest : email sent to every customer of a segment
+type segmentEmailRequest struct {
+	Subject string `json:"subject" validate:"required,max=200"`
+	// Body is HTML, {{name}} is replaced by the customer's name
+	Body string `json:"body" validate:"required"`
+}
+
+// SendSegmentEmail : Email every customer of a segment in a background job (only admin can access)
+func SendSegmentEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var request segmentEmailRequest
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := SegmentValidate.Struct(request); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		// members are the customers of the segment when the email is sent
+		filter, segment, err := segmentFilter(ctx, c, c.Param("segment_id"))
+		if err != nil {
+			c.JSON(segmentErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		job, err := startJob(c, utils.JOB_EMAIL, utils.RESOURCE_SEGMENTS, "", func(ctx context.Context, c *gin.Context, run *jobRun) error {
+			opts := options.Find().SetProjection(bson.M{"name": 1, "email": 1})
+			cursor, err := findCustomers(ctx, helper.NotDeleted(filter), opts)
+			if err != nil {
+				return err
+			}
+			defer cursor.Close(ctx)
+
+			sent, failed := 0, 0
+			for cursor.Next(ctx) {
+				var customer models.Customer
+				if err := cursor.Decode(&customer); err != nil || customer.Email == nil {
+					failed++
+					continue
+				}
+				name := ""
+				if customer.Name != nil {
+					name = *customer.Name
+				}
+				body := strings.ReplaceAll(request.Body, segmentNamePlaceholder, html.EscapeString(name))
+				if err := utils.SendEmail(*customer.Email, request.Subject, body); err != nil {
+					failed++
+				} else {
+					sent++
+				}
+				run.progress(sent+failed, failed)
+			}
+			run.job.Summary = map[string]interface{}{"segment_id": segment.SegmentId, "sent": sent, "failed": failed}
+			return cursor.Err()
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while starting the email job"})
+			return
+		}
+		helper.RecordAudit(c, models.AuditLog{
+			Action:     utils.ACTION_EMAIL,
+			Resource:   utils.RESOURCE_SEGMENTS,
+			ResourceId: segment.SegmentId,
+		}, nil, bson.M{"subject": request.Subject, "job_id": job.JobId})
+
+		respondJobStarted(c, job)
+	}
+}
@@ -0,0 +1,194 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	helper "github.com/nirmal/crm/helpers"
+	"github.com/nirmal/crm/models"
+	"github.com/nirmal/crm/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	maxTagLength = 50
+	// tagSeparator : separates the tags of a customer in export and import columns
+	tagSeparator = ";"
+)
+
+// normalizeTags : tags trimmed and lower cased, without duplicates, in the order given
+func normalizeTags(tags []string) ([]string, error) {
+	normalized := []string{}
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" {
+			return nil, fmt.Errorf("tags cannot be empty")
+		}
+		if len(tag) > maxTagLength {
+			return nil, fmt.Errorf("tag %q is longer than %d characters", tag, maxTagLength)
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized, nil
+}
+
+// splitTags : tags of an export or import column, separated by ; or ,
+func splitTags(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool { return r == ';' || r == ',' })
+}
+
+// customerTagsRequest : tags to add to or remove from customers, picked by id or by segment
+type customerTagsRequest struct {
+	CustomerIds []string `json:"customer_ids"`
+	SegmentId   string   `json:"segment_id"`
+	Tags        []string `json:"tags" validate:"required,min=1"`
+}
+
+// TagCustomers : Add tags to customers (only admin can access)
+func TagCustomers() gin.HandlerFunc {
+	return updateCustomerTags(true)
+}
+
+// UntagCustomers : Remove tags from customers (only admin can access)
+func UntagCustomers() gin.HandlerFunc {
+	return updateCustomerTags(false)
+}
+
+// updateCustomerTags : add (or remove) the request's tags on the customers of customer_ids or of a segment
+func updateCustomerTags(add bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var request customerTagsRequest
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := customerValidate.Struct(request); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+		tags, err := normalizeTags(request.Tags)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var filter bson.M
+		switch {
+		case len(request.CustomerIds) > 0 && request.SegmentId != "":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "customer_ids and segment_id cannot be combined"})
+			return
+		case len(request.CustomerIds) > 0:
+			filter = bson.M{utils.CUSTOMER_ID: bson.M{"$in": request.CustomerIds}}
+		case request.SegmentId != "":
+			if filter, _, err = segmentFilter(ctx, c, request.SegmentId); err != nil {
+				c.JSON(segmentErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "customer_ids or segment_id is required"})
+			return
+		}
+
+		// only the customers the update changes
+		if add {
+			filter = bson.M{"$and": bson.A{filter, bson.M{"$nor": bson.A{bson.M{"tags": bson.M{"$all": tags}}}}}}
+		} else {
+			filter = bson.M{"$and": bson.A{filter, bson.M{"tags": bson.M{"$in": tags}}}}
+		}
+		cursor, err := findCustomers(ctx, helper.TenantFilter(c, helper.NotDeleted(filter)), options.Find().SetProjection(bson.M{utils.CUSTOMER_ID: 1, "tags": 1}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while finding customers"})
+			return
+		}
+		var customers []models.Customer
+		if err = cursor.All(ctx, &customers); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while decoding customer data"})
+			return
+		}
+
+		update := bson.M{"$pull": bson.M{"tags": bson.M{"$in": tags}}}
+		if add {
+			update = bson.M{"$addToSet": bson.M{"tags": bson.M{"$each": tags}}}
+		}
+		update["$set"] = bson.M{"updated_at": time.Now()}
+		update["$inc"] = bson.M{"version": 1}
+
+		updated := []primitive.ObjectID{}
+		for _, customer := range customers {
+			var after models.Customer
+			err := CustomerCollection.FindOneAndUpdate(ctx, bson.M{"_id": customer.ID}, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&after)
+			if err != nil {
+				rescoreCustomers(ctx, c, updated...)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while updating tags", "updated": len(updated)})
+				return
+			}
+			helper.RecordAudit(c, models.AuditLog{
+				Action:     utils.ACTION_UPDATE,
+				Resource:   utils.RESOURCE_CUSTOMERS,
+				ResourceId: customer.CustomerId,
+			}, bson.M{"tags": customer.Tags}, bson.M{"tags": after.Tags})
+			updated = append(updated, customer.ID)
+		}
+		rescoreCustomers(ctx, c, updated...)
+
+		c.JSON(http.StatusOK, gin.H{"updated": len(updated), "message": "tags updated successfully"})
+	}
+}
+
+// GetCustomerTags : List the tags in use with the number of customers carrying each
+func GetCustomerTags() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckStaff(c); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		pipeline := mongo.Pipeline{
+			{{Key: "$match", Value: helper.TenantFilter(c, helper.NotDeleted(bson.M{}))}},
+			{{Key: "$unwind", Value: "$tags"}},
+			{{Key: "$group", Value: bson.M{"_id": "$tags", "customers": bson.M{"$sum": 1}}}},
+			{{Key: "$sort", Value: bson.D{{Key: "customers", Value: -1}, {Key: "_id", Value: 1}}}},
+			{{Key: "$project", Value: bson.M{"_id": 0, "tag": "$_id", "customers": 1}}},
+		}
+		cursor, err := CustomerCollection.Aggregate(ctx, pipeline)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing tags"})
+			return
+		}
+
+		var tags []bson.M
+		if err = cursor.All(ctx, &tags); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while decoding tags"})
+			return
+		}
+
+		if len(tags) == 0 {
+			c.JSON(http.StatusOK, gin.H{"error": "no tags available"})
+			return
+		}
+
+		c.JSON(http.StatusOK, tags)
+	}
+}
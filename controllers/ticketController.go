@@ -9,6 +9,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
 	"github.com/nirmal/crm/database"
+	helper "github.com/nirmal/crm/helpers"
 	"github.com/nirmal/crm/models"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -55,10 +56,10 @@ func CreateTicket() gin.HandlerFunc {
 			return
 		}
 
-		filter := bson.M{
+		filter := helper.TenantFilter(c, helper.NotDeleted(bson.M{
 			"_id":         interactionId,
 			utils.CUSTOMER_ID: customerId,
-		}
+		}))
 
 		var interaction models.Interaction
 		err = InteractionCollection.FindOne(ctx, filter).Decode(&interaction)
@@ -68,11 +69,22 @@ func CreateTicket() gin.HandlerFunc {
 		}
 
 		ticket.CustomerID = customerId
+		ticket.OrgId = interaction.OrgId
+		ticket.CustomFields, err = checkCustomFields(ctx, c, ticket.OrgId, utils.RESOURCE_TICKETS, ticket.CustomFields, false, false)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		ticket.InteractionID = interactionId
 		ticket.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		ticket.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		ticket.ResolvedAt = nil
+		if ticketResolved(ticket.Status) {
+			ticket.ResolvedAt = &ticket.CreatedAt
+		}
 		ticket.ID = primitive.NewObjectID()
 		ticket.TicketId = ticket.ID.Hex()
+		ticket.Version = 1
 
 		resultInsertionNumber, insertErr := TicketCollection.InsertOne(ctx, ticket)
 		if insertErr != nil {
@@ -80,11 +92,22 @@ func CreateTicket() gin.HandlerFunc {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
 			return
 		}
+		helper.RecordAudit(c, models.AuditLog{
+			Action:     utils.ACTION_CREATE,
+			Resource:   utils.RESOURCE_TICKETS,
+			ResourceId: ticket.TicketId,
+		}, nil, ticket)
+		rescoreCustomers(ctx, c, ticket.CustomerID)
 
 		c.JSON(http.StatusCreated, resultInsertionNumber)
 	}
 }
 
+// ticketResolved : whether status is one a ticket is done in, resolved or closed
+func ticketResolved(status *string) bool {
+	return status != nil && (*status == "resolved" || *status == "closed")
+}
+
 // Upadate ticket status and description
 func UpdateTicket() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -112,18 +135,24 @@ func UpdateTicket() gin.HandlerFunc {
 			return
 		}
 
-		filter := bson.M{
+		filter := helper.TenantFilter(c, helper.NotDeleted(bson.M{
 			"_id":         ticketId,
 			utils.CUSTOMER_ID: customerId,
-		}
+		}))
 
 		// Check if ticket exists
-		err = TicketCollection.FindOne(ctx, filter).Err()
+		var before models.Ticket
+		err = TicketCollection.FindOne(ctx, filter).Decode(&before)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching interaction"})
 			return
 		}
 
+		if !helper.IfMatch(c, helper.ETag(before.TicketId, before.Version)) {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": helper.ErrVersionConflict.Error()})
+			return
+		}
+
 		updateObj := bson.M{}
        // Update ticket status
 		if ticket.Status != nil {
@@ -136,14 +165,57 @@ func UpdateTicket() gin.HandlerFunc {
 
 		updateObj["updated_at"] = time.Now()
 
-		update := bson.M{"$set": updateObj}
+		// resolved_at is when the ticket was resolved or closed, later writes leave it alone
+		// and reopening the ticket clears it
+		unset := bson.M{}
+		if ticketResolved(ticket.Status) && !ticketResolved(before.Status) {
+			updateObj["resolved_at"] = updateObj["updated_at"]
+		} else if ticket.Status != nil && !ticketResolved(ticket.Status) {
+			unset["resolved_at"] = ""
+		}
+
+		update := bson.M{"$set": updateObj, "$inc": bson.M{"version": 1}}
+		// Custom fields are set one by one, null clears a value
+		if ticket.CustomFields != nil {
+			customFields, err := checkCustomFields(ctx, c, before.OrgId, utils.RESOURCE_TICKETS, ticket.CustomFields, true, false)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			set, unsetFields := customFieldUpdate(customFields)
+			for key, value := range set {
+				updateObj[key] = value
+			}
+			for key, value := range unsetFields {
+				unset[key] = value
+			}
+		}
+		if len(unset) > 0 {
+			update["$unset"] = unset
+		}
 
-		_, err = TicketCollection.UpdateOne(ctx, filter, update)
+		result, err := TicketCollection.UpdateOne(ctx, helper.VersionFilter(filter, before.Version), update)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while updating ticket"})
 			return
 		}
 
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": helper.ErrVersionConflict.Error()})
+			return
+		}
+
+		var after models.Ticket
+		if err := TicketCollection.FindOne(ctx, filter).Decode(&after); err == nil {
+			c.Header("ETag", helper.ETag(after.TicketId, after.Version))
+			helper.RecordAudit(c, models.AuditLog{
+				Action:     utils.ACTION_UPDATE,
+				Resource:   utils.RESOURCE_TICKETS,
+				ResourceId: ticketIdStr,
+			}, before, after)
+		}
+		rescoreCustomers(ctx, c, before.CustomerID)
+
 		c.JSON(http.StatusOK, gin.H{"message": "ticket updated successfully"})
 
 	}
@@ -155,9 +227,16 @@ func GetAllTickets() gin.HandlerFunc {
 		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
 		defer cancel()
 
+		// Filter by custom fields, ?cf.<key>=
+		filter := bson.M{}
+		if err := customFieldFilter(ctx, c, utils.RESOURCE_TICKETS, filter); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
 		var tickets []models.Ticket
 
-		cursor, err := TicketCollection.Find(ctx, bson.M{})
+		cursor, err := TicketCollection.Find(ctx, helper.TenantFilter(c, helper.NotDeleted(filter)))
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing users"})
 			return
@@ -191,7 +270,7 @@ func GetTicketsByUserID() gin.HandlerFunc {
 		}
 
 		var tickets []models.Ticket
-		cursor, err := TicketCollection.Find(ctx, bson.M{"user_id": userId})
+		cursor, err := TicketCollection.Find(ctx, helper.TenantFilter(c, helper.NotDeleted(bson.M{"user_id": userId})))
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing users"})
 			return
@@ -232,17 +311,39 @@ func DeleteTicket() gin.HandlerFunc {
 			return
 		}
 
-		filter := bson.M{
+		filter := helper.TenantFilter(c, helper.NotDeleted(bson.M{
 			"_id":         ticketId,
 			utils.CUSTOMER_ID: customerId,
+		}))
+
+		var before models.Ticket
+		if err := TicketCollection.FindOne(ctx, filter).Decode(&before); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "ticket not found"})
+			return
+		}
+
+		if !helper.IfMatch(c, helper.ETag(before.TicketId, before.Version)) {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": helper.ErrVersionConflict.Error()})
+			return
 		}
 
-		_, err = TicketCollection.DeleteOne(ctx, filter)
+		result, err := TicketCollection.UpdateOne(ctx, helper.VersionFilter(filter, before.Version), helper.SoftDeleteUpdate(c))
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "ticket deletion failed or ticket not found"})
 			return
 		}
 
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": helper.ErrVersionConflict.Error()})
+			return
+		}
+		helper.RecordAudit(c, models.AuditLog{
+			Action:     utils.ACTION_DELETE,
+			Resource:   utils.RESOURCE_TICKETS,
+			ResourceId: ticketIdStr,
+		}, before, nil)
+		rescoreCustomers(ctx, c, before.CustomerID)
+
 		c.JSON(http.StatusOK, gin.H{"message": "ticket deleted successfully"})
 	}
 }
@@ -0,0 +1,21 @@
+package controllers
+
+import "testing"
+
+func TestTicketResolved(t *testing.T) {
+	tests := []struct {
+		status *string
+		want   bool
+	}{
+		{stringPtr("open"), false},
+		{stringPtr("in_progress"), false},
+		{stringPtr("resolved"), true},
+		{stringPtr("closed"), true},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := ticketResolved(tt.status); got != tt.want {
+			t.Errorf("ticketResolved(%v) = %v, want %v", tt.status, got, tt.want)
+		}
+	}
+}
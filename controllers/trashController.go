@@ -0,0 +1,241 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nirmal/crm/database"
+	helper "github.com/nirmal/crm/helpers"
+	"github.com/nirmal/crm/models"
+	"github.com/nirmal/crm/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	defaultTrashRetentionDays      = 30
+	defaultTrashPurgeIntervalHours = 24
+)
+
+// trashResource : collection and id field of a resource that supports soft delete, and
+// whether its records carry a version
+type trashResource struct {
+	collection *mongo.Collection
+	idField    string
+	versioned  bool
+}
+
+var trashResources = map[string]trashResource{
+	utils.RESOURCE_CUSTOMERS:    {CustomerCollection, utils.CUSTOMER_ID, true},
+	utils.RESOURCE_USERS:        {UserCollection, utils.USER_ID, true},
+	utils.RESOURCE_TICKETS:      {TicketCollection, "ticket_id", true},
+	utils.RESOURCE_INTERACTIONS: {InteractionCollection, "interaction_id", false},
+	utils.RESOURCE_ORGS:         {OrganizationCollection, utils.ORG_ID, true},
+	utils.RESOURCE_ACCOUNTS:     {AccountCollection, utils.ACCOUNT_ID, true},
+	utils.RESOURCE_PIPELINES:    {PipelineCollection, "pipeline_id", true},
+	utils.RESOURCE_DEALS:        {DealCollection, "deal_id", true},
+	utils.RESOURCE_SEGMENTS:     {SegmentCollection, "segment_id", true},
+	utils.RESOURCE_NOTES:        {NoteCollection, "note_id", true},
+	utils.RESOURCE_SCORING:      {ScoringRuleCollection, "rule_id", true},
+}
+
+// trashRelations : relations of a resource whose records are trashed together with it
+var trashRelations = map[string]func() []deleteRelation{
+	utils.RESOURCE_CUSTOMERS: customerDeleteRelations,
+	utils.RESOURCE_USERS:     userDeleteRelations,
+}
+
+// trashFilter : trashed records of resourceName matching filter that the caller may see,
+// notes keep their visibility in the trash
+func trashFilter(ctx context.Context, c *gin.Context, resourceName string, filter bson.M) (bson.M, error) {
+	filter = helper.TenantFilter(c, helper.OnlyDeleted(filter))
+	if resourceName != utils.RESOURCE_NOTES {
+		return filter, nil
+	}
+	reader, err := currentNoteReader(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return bson.M{"$and": bson.A{filter, reader.filter()}}, nil
+}
+
+// GetTrash : List soft deleted records of a resource (only admin can access)
+func GetTrash() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		resourceName := c.Param("resource")
+		resource, ok := trashResources[resourceName]
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid resource"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		filter, err := trashFilter(ctx, c, resourceName, bson.M{})
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		cursor, err := resource.collection.Find(ctx, filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing trash"})
+			return
+		}
+
+		var records []bson.M
+		if err = cursor.All(ctx, &records); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while decoding trash"})
+			return
+		}
+
+		// never hand out credentials, even for deleted records
+		for _, record := range records {
+			delete(record, "password")
+			delete(record, "token")
+		}
+
+		if len(records) == 0 {
+			c.JSON(http.StatusOK, gin.H{"error": "trash is empty"})
+			return
+		}
+
+		c.JSON(http.StatusOK, records)
+	}
+}
+
+// RestoreFromTrash : Restore a soft deleted record (only admin can access)
+func RestoreFromTrash() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		resourceName := c.Param("resource")
+		resource, ok := trashResources[resourceName]
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid resource"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		id := c.Param("id")
+		filter, err := trashFilter(ctx, c, resourceName, bson.M{resource.idField: id})
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var before bson.M
+		if err := resource.collection.FindOne(ctx, filter).Decode(&before); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "record not found in trash"})
+			return
+		}
+		// a merged customer lives on in the survivor, which holds its interactions and tickets now
+		if mergedInto, _ := before["merged_into"].(string); resourceName == utils.RESOURCE_CUSTOMERS && mergedInto != "" {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("customer was merged into %s and cannot be restored", mergedInto)})
+			return
+		}
+
+		// the interactions and tickets trashed with a customer or user come back with it
+		var restored []RelationImpact
+		err = database.WithTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+			restored = nil
+			if relations, ok := trashRelations[resourceName]; ok {
+				var err error
+				if restored, err = restoreDeleteRelations(sessCtx, relations(), before); err != nil {
+					return err
+				}
+			}
+			update := helper.RestoreUpdate()
+			if !resource.versioned {
+				update = helper.Unversioned(update)
+			}
+			_, err := resource.collection.UpdateOne(sessCtx, filter, update)
+			return err
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while restoring record"})
+			return
+		}
+
+		helper.RecordAudit(c, models.AuditLog{
+			Action:     utils.ACTION_RESTORE,
+			Resource:   resourceName,
+			ResourceId: id,
+		}, bson.M{"deleted_at": before["deleted_at"], "deleted_by": before["deleted_by"]}, bson.M{})
+		for _, impact := range restored {
+			if impact.Count == 0 {
+				continue
+			}
+			helper.RecordAudit(c, models.AuditLog{
+				Action:   utils.ACTION_RESTORE,
+				Resource: impact.Resource,
+			}, nil, bson.M{"ids": impact.Ids, resource.idField: id})
+		}
+
+		response := gin.H{"message": "record restored successfully"}
+		if restored != nil {
+			response["restored"] = restored
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// StartTrashPurgeJob : periodically hard delete records that have been in the trash
+// longer than TRASH_RETENTION_DAYS (default 30), every TRASH_PURGE_INTERVAL_HOURS (default 24)
+func StartTrashPurgeJob() {
+	retentionDays := envInt("TRASH_RETENTION_DAYS", defaultTrashRetentionDays)
+	intervalHours := envInt("TRASH_PURGE_INTERVAL_HOURS", defaultTrashPurgeIntervalHours)
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(intervalHours) * time.Hour)
+		defer ticker.Stop()
+
+		for {
+			PurgeTrash(time.Duration(retentionDays) * 24 * time.Hour)
+			<-ticker.C
+		}
+	}()
+}
+
+// PurgeTrash : hard delete every trashed record older than the retention period
+func PurgeTrash(retention time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	cutoff := time.Now().Add(-retention)
+	for name, resource := range trashResources {
+		result, err := resource.collection.DeleteMany(ctx, bson.M{"deleted_at": bson.M{"$lte": cutoff}})
+		if err != nil {
+			log.Printf("error purging trash for %s: %v", name, err)
+			continue
+		}
+		if result.DeletedCount > 0 {
+			log.Printf("purged %d %s from trash", result.DeletedCount, name)
+		}
+	}
+}
+
+// envInt : read a positive integer setting from the environment
+func envInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
@@ -2,9 +2,11 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -50,6 +52,118 @@ func VerifyPassword(userPassword, foundUserPassword string) (bool, string) {
 	return check, msg
 }
 
+// checkSignUpRole : validate the role and organization of a self registering user.
+// Signing up only makes USERs of an existing organization. Admins are created or promoted
+// by an ADMIN of their organization or a SUPER_ADMIN, and the SUPER_ADMIN with the
+// create-super-admin command.
+func checkSignUpRole(ctx context.Context, user *models.User) string {
+	if *user.Role != utils.ROLE_USER {
+		return "only USER accounts can sign up, ask an admin of your organization to create or promote you"
+	}
+
+	if !organizationExists(ctx, user.OrgId) {
+		return "organization not found"
+	}
+	return ""
+}
+
+// insertUser : store a new user with a hashed password, unless its email is taken.
+// Returns the status to answer with.
+func insertUser(ctx context.Context, user *models.User) (int, error) {
+	count, err := UserCollection.CountDocuments(ctx, bson.M{"email": user.Email})
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("Error occurred while checking for email")
+	}
+	if count > 0 {
+		return http.StatusConflict, fmt.Errorf("this email already exists")
+	}
+
+	password := HashPassword(*user.Password)
+	user.Password = &password
+	user.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	user.UpdatedAt = user.CreatedAt
+	user.ID = primitive.NewObjectID()
+	user.UserId = user.ID.Hex()
+	user.Version = 1
+
+	if _, err := UserCollection.InsertOne(ctx, user); err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("User item was not created")
+	}
+	return http.StatusCreated, nil
+}
+
+// CreateSuperAdmin : Create a SUPER_ADMIN, who belongs to no organization. Only run from the
+// command line by whoever operates the database.
+func CreateSuperAdmin(ctx context.Context, name, email, password string) (models.User, error) {
+	role := utils.ROLE_SUPER_ADMIN
+	user := models.User{Name: &name, Email: &email, Password: &password, Role: &role}
+	if err := userValidate.Struct(user); err != nil {
+		return user, err
+	}
+	if _, err := insertUser(ctx, &user); err != nil {
+		return user, err
+	}
+	user.Password = nil
+	return user, nil
+}
+
+// CreateUser : Create an ADMIN or USER in the caller's organization, a SUPER_ADMIN picks the
+// organization with ?org_id= (only admin can access)
+func CreateUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var user models.User
+		if err := c.BindJSON(&user); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := userValidate.Struct(user); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+		if *user.Role != utils.ROLE_ADMIN && *user.Role != utils.ROLE_USER {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "role must be ADMIN or USER"})
+			return
+		}
+
+		user.OrgId = helper.TenantId(c)
+		if user.OrgId == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "org_id is required"})
+			return
+		}
+		if !organizationExists(ctx, user.OrgId) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "organization not found"})
+			return
+		}
+		if user.Team != nil {
+			team := strings.TrimSpace(*user.Team)
+			user.Team = &team
+		}
+		user.Token = nil
+
+		if status, err := insertUser(ctx, &user); err != nil {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		helper.RecordAudit(c, models.AuditLog{
+			OrgId:      user.OrgId,
+			Action:     utils.ACTION_CREATE,
+			Resource:   utils.RESOURCE_USERS,
+			ResourceId: user.UserId,
+		}, nil, user)
+
+		user.Password = nil
+		c.JSON(http.StatusCreated, gin.H{"user": user, "message": "User created successfully"})
+	}
+}
+
 // UserSignUp godoc
 func UserSignUp() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -68,6 +182,11 @@ func UserSignUp() gin.HandlerFunc {
 			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
 			return
 		}
+		// Check the user may take the requested role in the requested organization
+		if msg := checkSignUpRole(ctx, &user); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
         // Check if email already exists
 		count, err := UserCollection.CountDocuments(ctx, bson.M{"email": user.Email})
 		if err != nil {
@@ -87,8 +206,9 @@ func UserSignUp() gin.HandlerFunc {
 		user.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		user.ID = primitive.NewObjectID()
 		user.UserId = user.ID.Hex()
+		user.Version = 1
         // Generate user token
-		token, _ := helper.GenerateUserToken(*user.Email, *user.Name, user.UserId, *user.Role)
+		token, _ := helper.GenerateUserToken(*user.Email, *user.Name, user.UserId, *user.Role, user.OrgId)
 		user.Token = &token
         // Insert user into database
 		_, err = UserCollection.InsertOne(ctx, user)
@@ -97,6 +217,15 @@ func UserSignUp() gin.HandlerFunc {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
 			return
 		}
+		helper.RecordAudit(c, models.AuditLog{
+			OrgId:      user.OrgId,
+			ActorId:    user.UserId,
+			ActorType:  utils.ACTOR_USER,
+			ActorRole:  *user.Role,
+			Action:     utils.ACTION_CREATE,
+			Resource:   utils.RESOURCE_USERS,
+			ResourceId: user.UserId,
+		}, nil, user)
 		// Return response
 		c.JSON(http.StatusCreated, gin.H{"user":user,"message":"User created successfully"})
 	}
@@ -116,7 +245,7 @@ func UserLogIn() gin.HandlerFunc {
 			return
 		}
         // Find user by email
-		err := UserCollection.FindOne(ctx, bson.M{"email": user.Email}).Decode(&foundUser)
+		err := UserCollection.FindOne(ctx, helper.NotDeleted(bson.M{"email": user.Email})).Decode(&foundUser)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "email or password is incorrect"})
 			return
@@ -133,7 +262,7 @@ func UserLogIn() gin.HandlerFunc {
 			return
 		}
 		// Generate user token
-		token, err := helper.GenerateUserToken(*foundUser.Email, *foundUser.Name, foundUser.UserId, *foundUser.Role)
+		token, err := helper.GenerateUserToken(*foundUser.Email, *foundUser.Name, foundUser.UserId, *foundUser.Role, foundUser.OrgId)
 		if err != nil || token == "" {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
@@ -147,6 +276,15 @@ func UserLogIn() gin.HandlerFunc {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		helper.RecordAudit(c, models.AuditLog{
+			OrgId:      foundUser.OrgId,
+			ActorId:    foundUser.UserId,
+			ActorType:  utils.ACTOR_USER,
+			ActorRole:  *foundUser.Role,
+			Action:     utils.ACTION_LOGIN,
+			Resource:   utils.RESOURCE_USERS,
+			ResourceId: foundUser.UserId,
+		}, nil, nil)
 
 		c.JSON(http.StatusOK, gin.H{"token": token, "user": foundUser,"msg":"User logged in successfully"})
 	}
@@ -165,7 +303,7 @@ func GetUsers() gin.HandlerFunc {
 
 		var users []models.User
 
-		cursor, err := UserCollection.Find(ctx, bson.M{})
+		cursor, err := UserCollection.Find(ctx, helper.TenantFilter(c, helper.NotDeleted(bson.M{})))
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing users"})
 			return
@@ -199,7 +337,7 @@ func GetUser() gin.HandlerFunc {
 		defer cancel()
 
 		var user models.User
-		err := UserCollection.FindOne(ctx, bson.M{utils.USER_ID: userId}).Decode(&user)
+		err := UserCollection.FindOne(ctx, helper.TenantFilter(c, helper.NotDeleted(bson.M{utils.USER_ID: userId}))).Decode(&user)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		}
@@ -209,6 +347,13 @@ func GetUser() gin.HandlerFunc {
 			return
 		}
 
+		etag := helper.ETag(user.UserId, user.Version)
+		c.Header("ETag", etag)
+		if helper.IfNoneMatch(c, etag) {
+			c.Status(http.StatusNotModified)
+			return
+		}
+
 		c.JSON(http.StatusOK, user)
 	}
 }
@@ -248,17 +393,65 @@ func UpdateUser() gin.HandlerFunc {
 			updateObj["password"] = password
 		}
 
+		// only organization admins may promote or demote users within their organization
+		if user.Role != nil {
+			if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			if *user.Role != utils.ROLE_ADMIN && *user.Role != utils.ROLE_USER {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "role must be ADMIN or USER"})
+				return
+			}
+			updateObj["role"] = user.Role
+		}
+
+		// the sales team a user forecasts with, set by admins, empty for none
+		if user.Team != nil {
+			if err := helper.CheckUserType(c, utils.ROLE_ADMIN); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			updateObj["team"] = strings.TrimSpace(*user.Team)
+		}
+
 		updateObj["updated_at"] = time.Now()
 
-		filter := bson.M{utils.USER_ID: bson.M{"$eq": userId}}
-		update := bson.M{"$set": updateObj}
+		filter := helper.TenantFilter(c, helper.NotDeleted(bson.M{utils.USER_ID: bson.M{"$eq": userId}}))
+		update := bson.M{"$set": updateObj, "$inc": bson.M{"version": 1}}
 
-		_, err := UserCollection.UpdateOne(ctx, filter, update)
+		var before models.User
+		if err := UserCollection.FindOne(ctx, filter).Decode(&before); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found !!!"})
+			return
+		}
+
+		if !helper.IfMatch(c, helper.ETag(before.UserId, before.Version)) {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": helper.ErrVersionConflict.Error()})
+			return
+		}
+
+		result, err := UserCollection.UpdateOne(ctx, helper.VersionFilter(filter, before.Version), update)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while updating user"})
 			return
 		}
 
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": helper.ErrVersionConflict.Error()})
+			return
+		}
+
+		var after models.User
+		if err := UserCollection.FindOne(ctx, filter).Decode(&after); err == nil {
+			c.Header("ETag", helper.ETag(after.UserId, after.Version))
+			helper.RecordAudit(c, models.AuditLog{
+				Action:     utils.ACTION_UPDATE,
+				Resource:   utils.RESOURCE_USERS,
+				ResourceId: userId,
+			}, before, after)
+		}
+
 		c.JSON(http.StatusOK, gin.H{"message": "user updated successfully"})
 	}
 }
@@ -276,16 +469,83 @@ func DeleteUser() gin.HandlerFunc {
 		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
-		result, err := UserCollection.DeleteOne(ctx, bson.M{utils.USER_ID: userId})
+		var before models.User
+		if err := UserCollection.FindOne(ctx, helper.TenantFilter(c, helper.NotDeleted(bson.M{utils.USER_ID: userId}))).Decode(&before); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found !!!"})
+			return
+		}
+
+		if !helper.IfMatch(c, helper.ETag(before.UserId, before.Version)) {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": helper.ErrVersionConflict.Error()})
+			return
+		}
+
+		// Work out what happens to the user's interactions
+		reassignTo, err := resolveReassignTarget(ctx, c, UserCollection, utils.USER_ID, userId)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		relations := userDeleteRelations()
+		impacts, err := planDelete(ctx, relations, before.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while checking related records"})
+			return
+		}
+		planErr := checkDeletePlan(impacts, reassignTo)
+
+		if c.Query("dry_run") == "true" {
+			response := gin.H{"dry_run": true, "allowed": planErr == nil, "affected": impacts}
+			if planErr != nil {
+				response["reason"] = planErr.Error()
+			}
+			c.JSON(http.StatusOK, response)
+			return
+		}
+		if planErr != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": planErr.Error(), "affected": impacts})
+			return
+		}
+
+		// the user and its soft deleted interactions share one stamp, so they are restored together
+		softDelete := helper.SoftDeleteUpdate(c)
+		var result *mongo.UpdateResult
+		err = database.WithTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+			var err error
+			filter := helper.VersionFilter(helper.TenantFilter(c, helper.NotDeleted(bson.M{utils.USER_ID: userId})), before.Version)
+			result, err = UserCollection.UpdateOne(sessCtx, filter, softDelete)
+			if err != nil {
+				return err
+			}
+			if result.MatchedCount == 0 {
+				return helper.ErrVersionConflict
+			}
+			return applyDeleteRelations(sessCtx, c, relations, before.ID, reassignTo, softDelete)
+		})
+		if errors.Is(err, helper.ErrVersionConflict) {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()})
+			return
+		}
+		// records added since the plan was checked
+		if errors.Is(err, errDeleteBlocked) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while deleting user"})
 			return
 		}
 
-		if result.DeletedCount == 0 {
+		if result.ModifiedCount == 0 {
 			c.JSON(http.StatusNotFound, gin.H{"error": "User not found !!!"})
 			return
 		}
+		helper.RecordAudit(c, models.AuditLog{
+			Action:     utils.ACTION_DELETE,
+			Resource:   utils.RESOURCE_USERS,
+			ResourceId: userId,
+		}, before, nil)
+		auditDeleteImpacts(c, utils.USER_ID, userId, impacts, reassignTo)
 
 		c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
 	}
@@ -5,8 +5,10 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sync"
 	"time"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
@@ -16,14 +18,38 @@ import (
 
 
 
+var (
+	sharedClient *mongo.Client
+	clientOnce   sync.Once
+)
+
 // OpenCollection opens a collection from the specified database
 func OpenCollection(databaseName, collectionName string) *mongo.Collection {
-	client := DBInstance()
+	client := Client()
 
 	collection := client.Database(databaseName).Collection(collectionName)
 	return collection
 }
 
+// OpenBucket opens a GridFS bucket, for files every server must be able to read
+func OpenBucket(databaseName, bucketName string) *gridfs.Bucket {
+	bucket, err := gridfs.NewBucket(Client().Database(databaseName), options.GridFSBucket().SetName(bucketName))
+	if err != nil {
+		log.Fatalf("error opening GridFS bucket %s: %v", bucketName, err)
+	}
+	return bucket
+}
+
+// Client returns the MongoDB client shared by all collections, connecting on first use.
+// Sharing one client lets sessions and transactions span collections. Collections are
+// opened while packages load, so the server is only checked by CheckConnection.
+func Client() *mongo.Client {
+	clientOnce.Do(func() {
+		sharedClient = DBInstance()
+	})
+	return sharedClient
+}
+
 
 // DBInstance initializes a new MongoDB client instance
 func DBInstance() *mongo.Client {
@@ -31,29 +57,62 @@ func DBInstance() *mongo.Client {
 	// Get MongoDB URI from environment variables
 	MONGO_URI := os.Getenv("MONGO_URI")
 	if MONGO_URI == "" {
-		// can set some Default value like below or 
-		// MONGO_URI = "mongodb://localhost:27017"
-		log.Fatal("MONGO_URI not found in environment variables")
-		return nil
+		// tests load the packages without a server, CheckConnection insists on MONGO_URI
+		MONGO_URI = "mongodb://localhost:27017"
 	}
 
 	// Create a new context with a timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	// Connect to MongoDB
+	// Connect to MongoDB, the driver dials the server when it is first used
 	client, err := mongo.Connect(ctx, options.Client().ApplyURI(MONGO_URI))
 	if err != nil {
 		log.Fatalf("error connecting to MongoDB: %v", err)
 	}
+	return client
+}
+
+// CheckConnection exits unless MONGO_URI is set and the server answers
+func CheckConnection() {
+	if os.Getenv("MONGO_URI") == "" {
+		log.Fatal("MONGO_URI not found in environment variables")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
 	// verify connection
-	err = client.Ping(ctx, nil)
-	if err != nil {
+	if err := Client().Ping(ctx, nil); err != nil {
 		log.Fatalf("error pinging MongoDB: %v", err)
 	}
 
 	fmt.Println("Connected to MongoDB!")
-	return client
 }
 
+// WithTransaction runs fn inside a MongoDB transaction on the shared client.
+// The transaction is committed when fn returns nil and aborted otherwise.
+func WithTransaction(ctx context.Context, fn func(sessCtx mongo.SessionContext) error) error {
+	session, err := Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessCtx)
+	})
+	return err
+}
+
+// WithSnapshot runs fn in a session whose reads all see the data as it was when the first
+// one ran. MongoDB keeps a snapshot for minSnapshotHistoryWindowInSeconds (300 by default).
+func WithSnapshot(ctx context.Context, fn func(sessCtx mongo.SessionContext) error) error {
+	session, err := Client().StartSession(options.Session().SetSnapshot(true))
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	return mongo.WithSession(ctx, session, fn)
+}
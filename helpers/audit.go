@@ -0,0 +1,134 @@
+package helpers
+
+import (
+	"context"
+	"log"
+	"reflect"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nirmal/crm/database"
+	"github.com/nirmal/crm/models"
+	"github.com/nirmal/crm/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	auditDatabaseName   = "Cluster0"
+	auditCollectionName = "audit_logs"
+)
+
+var AuditCollection *mongo.Collection = database.OpenCollection(auditDatabaseName, auditCollectionName)
+
+// fields whose values must never be written to the audit trail
+var redactedAuditFields = map[string]bool{
+	"password": true,
+	"token":    true,
+}
+
+// RecordAudit : Append an audit entry for the current request.
+// before/after are the resource states around the change (either may be nil).
+// The actor is taken from the JWT claims unless entry.ActorId is already set.
+func RecordAudit(c *gin.Context, entry models.AuditLog, before, after interface{}) {
+	WriteAudit(RequestAuditEntry(c, entry), before, after)
+}
+
+// RequestAuditEntry : entry completed with the actor, organization and client of the current request
+func RequestAuditEntry(c *gin.Context, entry models.AuditLog) models.AuditLog {
+	if entry.ActorId == "" {
+		entry.ActorId, entry.ActorType = auditActor(c)
+	}
+	if entry.OrgId == "" {
+		entry.OrgId = TenantId(c)
+	}
+	if entry.ActorRole == "" {
+		entry.ActorRole = c.GetString("role")
+	}
+	entry.RequestId = c.GetString(utils.REQUEST_ID)
+	entry.ClientIP = c.ClientIP()
+	return entry
+}
+
+// WriteAudit : Append an audit entry whose actor is already set, for work done outside of a request
+func WriteAudit(entry models.AuditLog, before, after interface{}) {
+	entry.Changes = AuditDiff(before, after)
+	entry.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	entry.ID = primitive.NewObjectID()
+	entry.AuditId = entry.ID.Hex()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	// audit failures are logged but never fail the request itself
+	if _, err := AuditCollection.InsertOne(ctx, entry); err != nil {
+		log.Printf("error writing audit log for %s %s/%s: %v", entry.Action, entry.Resource, entry.ResourceId, err)
+	}
+}
+
+// AuditDiff : field level diff between two resource states, with secrets redacted
+func AuditDiff(before, after interface{}) map[string]models.FieldChange {
+	beforeDoc := toAuditDoc(before)
+	afterDoc := toAuditDoc(after)
+
+	changes := map[string]models.FieldChange{}
+	for key, from := range beforeDoc {
+		to, ok := afterDoc[key]
+		if ok && reflect.DeepEqual(from, to) {
+			continue
+		}
+		changes[key] = auditChange(key, from, to)
+	}
+	for key, to := range afterDoc {
+		if _, ok := beforeDoc[key]; !ok {
+			changes[key] = auditChange(key, nil, to)
+		}
+	}
+
+	if len(changes) == 0 {
+		return nil
+	}
+	return changes
+}
+
+func auditChange(key string, from, to interface{}) models.FieldChange {
+	if redactedAuditFields[key] {
+		if from != nil {
+			from = "[REDACTED]"
+		}
+		if to != nil {
+			to = "[REDACTED]"
+		}
+	}
+	return models.FieldChange{From: from, To: to}
+}
+
+// toAuditDoc : flatten a model into a bson document keyed by its stored field names
+func toAuditDoc(v interface{}) bson.M {
+	doc := bson.M{}
+	if v == nil || reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil() {
+		return doc
+	}
+	data, err := bson.Marshal(v)
+	if err != nil {
+		return doc
+	}
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		return bson.M{}
+	}
+	// bookkeeping fields only add noise to the diff
+	delete(doc, "_id")
+	delete(doc, "updated_at")
+	return doc
+}
+
+// auditActor : identify who is making the request from the token claims
+func auditActor(c *gin.Context) (actorId, actorType string) {
+	if uid := c.GetString("uid"); uid != "" {
+		return uid, utils.ACTOR_USER
+	}
+	if cid := c.GetString("cid"); cid != "" {
+		return cid, utils.ACTOR_CUSTOMER
+	}
+	return "", ""
+}
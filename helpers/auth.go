@@ -12,6 +12,11 @@ func CheckUserType(c *gin.Context, role string) (err error) {
 	// get user role from context
 	userRole := c.GetString("role")
 
+	// SUPER_ADMIN can do everything an ADMIN can
+	if userRole == utils.ROLE_SUPER_ADMIN && role == utils.ROLE_ADMIN {
+		return nil
+	}
+
 	if userRole != role {
 		err = fmt.Errorf("UnAuthenticated to access this resource")
 		return err
@@ -19,6 +24,15 @@ func CheckUserType(c *gin.Context, role string) (err error) {
 	return nil
 }
 
+// any user (staff, never a customer) can access this resource...
+func CheckStaff(c *gin.Context) (err error) {
+	if c.GetString("role") == "" {
+		err = fmt.Errorf("UnAuthenticated to access this resource")
+		return err
+	}
+	return nil
+}
+
 // user can access this resource only via his token or he is an ADMIN...
 func MatchUserTypeToUid(c *gin.Context, userId string) (err error) {
 	// get user id & role from context
@@ -0,0 +1,662 @@
+package helpers
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/nirmal/crm/database"
+	"github.com/nirmal/crm/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// BACKUP_FORMAT_VERSION : version of the archive layout, bumped on incompatible changes
+	BACKUP_FORMAT_VERSION = 1
+
+	backupDatabaseName = "Cluster0"
+	backupManifestFile = "manifest.json"
+	backupBatchSize    = 1000
+	// backupTransactionLimit : most documents the transaction restoring one collection writes,
+	// the records a forced restore deletes included, keeping it well within MongoDB's 60s limit
+	backupTransactionLimit = 100000
+)
+
+// BackupCollections : collections saved in a backup, parents before the records referencing them.
+// Background jobs and export runs are left out, their files are not part of the archive.
+var BackupCollections = []string{"organizations", "users", "accounts", "customers", "interactions", "tickets", "pipelines", "deals", "segments", "notes", "scoring_rules", "health_settings", "health_scores", "notifications", "duplicate_dismissals", "quotas", "forecast_snapshots", "import_mappings", "export_schedules", "custom_fields", "audit_logs"}
+
+// backupSecretFields : fields of users and customers left out of backups, signed in sessions
+// are not worth keeping and would let anyone holding the archive act as those accounts
+var backupSecretFields = map[string]bool{"token": true, "refresh_token": true}
+
+var (
+	// ErrBackupInvalid : the archive is damaged or was not produced by this application
+	ErrBackupInvalid = errors.New("invalid backup archive")
+	// ErrBackupTargetNotEmpty : restoring would mix the archive with existing records
+	ErrBackupTargetNotEmpty = errors.New("restore target is not empty")
+	// ErrBackupTooLarge : a collection holds more documents than one transaction restores
+	ErrBackupTooLarge = errors.New("backup too large to restore")
+)
+
+// BackupManifest : contents of a backup archive, stored in it as manifest.json
+type BackupManifest struct {
+	FormatVersion int                `json:"format_version"`
+	CreatedAt     time.Time          `json:"created_at"`
+	Database      string             `json:"database"`
+	OrgId         string             `json:"org_id,omitempty"`
+	Collections   []BackupCollection `json:"collections"`
+}
+
+// BackupCollection : one collection of a backup, as MongoDB extended JSON lines
+type BackupCollection struct {
+	Name   string `json:"name"`
+	File   string `json:"file"`
+	Count  int64  `json:"count"`
+	SHA256 string `json:"sha256"`
+}
+
+// RestoreOptions : where and how a backup is restored
+type RestoreOptions struct {
+	// Force replaces the records already in the restore scope instead of refusing
+	Force bool
+	// NewOrgName restores an organization backup as a new organization with fresh ids
+	NewOrgName string
+	// DryRun only validates the archive and the target
+	DryRun bool
+}
+
+// RestoreResult : what a restore wrote, or would write on a dry run
+type RestoreResult struct {
+	Manifest BackupManifest   `json:"manifest"`
+	OrgId    string           `json:"org_id,omitempty"`
+	Restored map[string]int64 `json:"restored"`
+	// RenamedEmails : emails of a new organization copy already taken, by collection
+	RenamedEmails map[string]map[string]string `json:"renamed_emails,omitempty"`
+	DryRun        bool                         `json:"dry_run,omitempty"`
+}
+
+// WriteBackup : write every backed up collection to w as a gzipped tar archive with the
+// manifest last. With orgId only that organization's records are included, trashed ones too.
+// Collections are read from one snapshot, so references between them are consistent.
+func WriteBackup(ctx context.Context, w io.Writer, orgId string) (BackupManifest, error) {
+	manifest := BackupManifest{
+		FormatVersion: BACKUP_FORMAT_VERSION,
+		CreatedAt:     time.Now().UTC(),
+		Database:      backupDatabaseName,
+		OrgId:         orgId,
+	}
+
+	gz := gzip.NewWriter(w)
+	archive := tar.NewWriter(gz)
+	err := database.WithSnapshot(ctx, func(sessCtx mongo.SessionContext) error {
+		for _, name := range BackupCollections {
+			entry, err := writeBackupCollection(sessCtx, archive, name, backupScope(name, orgId))
+			if err != nil {
+				return fmt.Errorf("backing up %s: %w", name, err)
+			}
+			manifest.Collections = append(manifest.Collections, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return manifest, err
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return manifest, err
+	}
+	if err := writeTarFile(archive, backupManifestFile, data); err != nil {
+		return manifest, err
+	}
+	if err := archive.Close(); err != nil {
+		return manifest, err
+	}
+	return manifest, gz.Close()
+}
+
+// writeBackupCollection : spool the collection to a temporary file, tar needs its size up front
+func writeBackupCollection(ctx context.Context, archive *tar.Writer, name string, filter bson.M) (BackupCollection, error) {
+	entry := BackupCollection{Name: name, File: path.Join("collections", name+".ndjson")}
+
+	spool, err := os.CreateTemp("", "crm-backup-*")
+	if err != nil {
+		return entry, err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	hash := sha256.New()
+	out := bufio.NewWriter(io.MultiWriter(spool, hash))
+	cursor, err := database.OpenCollection(backupDatabaseName, name).Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return entry, err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var doc interface{} = cursor.Current
+		if name == "users" || name == "customers" {
+			if doc, err = withoutSecrets(cursor.Current); err != nil {
+				return entry, err
+			}
+		}
+		line, err := bson.MarshalExtJSON(doc, true, false)
+		if err != nil {
+			return entry, err
+		}
+		out.Write(line)
+		if err := out.WriteByte('\n'); err != nil {
+			return entry, err
+		}
+		entry.Count++
+	}
+	if err := cursor.Err(); err != nil {
+		return entry, err
+	}
+	if err := out.Flush(); err != nil {
+		return entry, err
+	}
+	entry.SHA256 = hex.EncodeToString(hash.Sum(nil))
+
+	size, err := spool.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return entry, err
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return entry, err
+	}
+	if err := archive.WriteHeader(&tar.Header{Name: entry.File, Mode: 0o600, Size: size, ModTime: time.Now()}); err != nil {
+		return entry, err
+	}
+	_, err = io.Copy(archive, spool)
+	return entry, err
+}
+
+// withoutSecrets : document without its backupSecretFields
+func withoutSecrets(raw bson.Raw) (bson.D, error) {
+	var doc bson.D
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	kept := doc[:0]
+	for _, field := range doc {
+		if !backupSecretFields[field.Key] {
+			kept = append(kept, field)
+		}
+	}
+	return kept, nil
+}
+
+func writeTarFile(archive *tar.Writer, name string, data []byte) error {
+	if err := archive.WriteHeader(&tar.Header{Name: name, Mode: 0o600, Size: int64(len(data)), ModTime: time.Now()}); err != nil {
+		return err
+	}
+	_, err := archive.Write(data)
+	return err
+}
+
+// backupScope : records of collection belonging to the backup, everything without orgId
+func backupScope(collection, orgId string) bson.M {
+	if orgId == "" {
+		return bson.M{}
+	}
+	return bson.M{utils.ORG_ID: orgId}
+}
+
+// RestoreBackup : validate the archive against its manifest, check the target and restore it.
+// The archive is read more than once, so it must be seekable. Each collection is restored in
+// its own transaction, parents first; when one fails, those restored before it are kept and
+// the error names them.
+func RestoreBackup(ctx context.Context, archive io.ReadSeeker, opts RestoreOptions) (RestoreResult, error) {
+	result := RestoreResult{Restored: map[string]int64{}, DryRun: opts.DryRun}
+
+	manifest, err := verifyBackup(archive)
+	if err != nil {
+		return result, err
+	}
+	result.Manifest = manifest
+	result.OrgId = manifest.OrgId
+
+	var remap *backupRemap
+	if opts.NewOrgName != "" {
+		if manifest.OrgId == "" {
+			return result, fmt.Errorf("%w: only an organization backup can be restored as a new organization", ErrBackupInvalid)
+		}
+		if remap, err = newBackupRemap(ctx, archive, manifest.OrgId); err != nil {
+			return result, err
+		}
+		result.OrgId = remap.orgId
+		for collection, renamed := range remap.emails {
+			if len(renamed) > 0 {
+				if result.RenamedEmails == nil {
+					result.RenamedEmails = map[string]map[string]string{}
+				}
+				result.RenamedEmails[collection] = renamed
+			}
+		}
+	} else if err := checkRestoreTarget(ctx, manifest, opts.Force); err != nil {
+		return result, err
+	}
+	// a new organization copy deletes nothing, only its own documents count
+	if remap != nil {
+		if err := checkRestoreSize(manifest, nil); err != nil {
+			return result, err
+		}
+	}
+
+	if opts.DryRun {
+		for _, entry := range manifest.Collections {
+			result.Restored[entry.Name] = entry.Count
+		}
+		return result, nil
+	}
+
+	// every document was read back by verifyBackup, and the records a forced restore replaces
+	// are deleted in the transaction writing the collection, so a failed collection is unchanged.
+	// Files follow the manifest's order, parents before the records referencing them.
+	var restored []string
+	err = eachBackupFile(archive, func(name string, content io.Reader) error {
+		entry, ok := manifestEntry(manifest, name)
+		if !ok {
+			return nil
+		}
+		attempts := 0
+		err := database.WithTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+			// the file cannot be read again, so a transaction retried by the driver is given up
+			if attempts++; attempts > 1 {
+				return fmt.Errorf("restoring %s was interrupted", entry.Name)
+			}
+			if opts.Force && remap == nil {
+				collection := database.OpenCollection(backupDatabaseName, entry.Name)
+				if _, err := collection.DeleteMany(sessCtx, backupScope(entry.Name, manifest.OrgId)); err != nil {
+					return fmt.Errorf("clearing %s: %w", entry.Name, err)
+				}
+			}
+			return restoreBackupCollection(sessCtx, entry, content, remap, opts.NewOrgName)
+		})
+		if err != nil {
+			if len(restored) > 0 {
+				return fmt.Errorf("%w (%s were restored before, restore with force to replace them)", err, strings.Join(restored, ", "))
+			}
+			return err
+		}
+		restored = append(restored, entry.Name)
+		result.Restored[entry.Name] = entry.Count
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	if remap != nil && !remap.hasOrganization {
+		now := time.Now().UTC()
+		org := bson.M{"_id": remap.orgObjectId, utils.ORG_ID: remap.orgId, "name": opts.NewOrgName, "created_at": now, "updated_at": now, "version": 1}
+		if _, err := database.OpenCollection(backupDatabaseName, "organizations").InsertOne(ctx, org); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// verifyBackup : read the manifest and check every collection file against its count and checksum
+func verifyBackup(archive io.ReadSeeker) (BackupManifest, error) {
+	var manifest BackupManifest
+	found := false
+	type digest struct {
+		count int64
+		sum   string
+		err   error
+	}
+	digests := map[string]digest{}
+
+	err := eachBackupFile(archive, func(name string, content io.Reader) error {
+		if name == backupManifestFile {
+			found = true
+			if err := json.NewDecoder(content).Decode(&manifest); err != nil {
+				return fmt.Errorf("%w: unreadable manifest: %v", ErrBackupInvalid, err)
+			}
+			return nil
+		}
+		// files the manifest does not list are ignored, so unreadable documents only count there
+		hash := sha256.New()
+		lines, docErr := countDocuments(name, io.TeeReader(content, hash))
+		if docErr != nil && !errors.Is(docErr, ErrBackupInvalid) {
+			return docErr
+		}
+		if docErr != nil {
+			// keep hashing the rest of the file, the checksum is reported before the document
+			io.Copy(hash, content)
+		}
+		digests[name] = digest{count: lines, sum: hex.EncodeToString(hash.Sum(nil)), err: docErr}
+		return nil
+	})
+	if err != nil {
+		return manifest, err
+	}
+
+	if !found {
+		return manifest, fmt.Errorf("%w: missing %s", ErrBackupInvalid, backupManifestFile)
+	}
+	if manifest.FormatVersion != BACKUP_FORMAT_VERSION {
+		return manifest, fmt.Errorf("%w: unsupported format version %d", ErrBackupInvalid, manifest.FormatVersion)
+	}
+	for _, entry := range manifest.Collections {
+		if !isBackupCollection(entry.Name) {
+			return manifest, fmt.Errorf("%w: unknown collection %s", ErrBackupInvalid, entry.Name)
+		}
+		got, ok := digests[entry.File]
+		if !ok {
+			return manifest, fmt.Errorf("%w: missing %s", ErrBackupInvalid, entry.File)
+		}
+		if got.sum != entry.SHA256 || (got.err == nil && got.count != entry.Count) {
+			return manifest, fmt.Errorf("%w: %s does not match the manifest", ErrBackupInvalid, entry.File)
+		}
+		if got.err != nil {
+			return manifest, got.err
+		}
+	}
+	return manifest, nil
+}
+
+// checkRestoreTarget : refuse to restore over records already in the archive's scope, unless
+// forced, and collections whose transaction would write too many documents
+func checkRestoreTarget(ctx context.Context, manifest BackupManifest, force bool) error {
+	var occupied []string
+	existing := map[string]int64{}
+	for _, entry := range manifest.Collections {
+		count, err := database.OpenCollection(backupDatabaseName, entry.Name).CountDocuments(ctx, backupScope(entry.Name, manifest.OrgId))
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			occupied = append(occupied, entry.Name)
+		}
+		existing[entry.Name] = count
+	}
+	if !force && len(occupied) > 0 {
+		return fmt.Errorf("%w: %s already hold records, restore with force to replace them", ErrBackupTargetNotEmpty, strings.Join(occupied, ", "))
+	}
+	return checkRestoreSize(manifest, existing)
+}
+
+// checkRestoreSize : refuse archives a collection of which, with the existing records a forced
+// restore deletes, is more than one transaction can write
+func checkRestoreSize(manifest BackupManifest, existing map[string]int64) error {
+	for _, entry := range manifest.Collections {
+		if writes := entry.Count + existing[entry.Name]; writes > backupTransactionLimit {
+			return fmt.Errorf("%w: restoring %s writes %d documents, at most %d are restored at once", ErrBackupTooLarge, entry.Name, writes, backupTransactionLimit)
+		}
+	}
+	return nil
+}
+
+// restoreBackupCollection : insert the documents of one collection file in batches
+func restoreBackupCollection(ctx context.Context, entry BackupCollection, content io.Reader, remap *backupRemap, newOrgName string) error {
+	collection := database.OpenCollection(backupDatabaseName, entry.Name)
+	batch := make([]interface{}, 0, backupBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		_, err := collection.InsertMany(ctx, batch)
+		batch = batch[:0]
+		return err
+	}
+
+	scanner := backupScanner(content)
+	for scanner.Scan() {
+		var doc bson.D
+		if err := bson.UnmarshalExtJSON(scanner.Bytes(), true, &doc); err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrBackupInvalid, entry.File, err)
+		}
+		if remap != nil {
+			doc = remap.document(doc)
+			doc = remap.email(entry.Name, doc)
+			if entry.Name == "organizations" {
+				doc = setDocumentField(doc, "name", newOrgName)
+			}
+		}
+		batch = append(batch, doc)
+		if len(batch) == backupBatchSize {
+			if err := flush(); err != nil {
+				return fmt.Errorf("restoring %s: %w", entry.Name, err)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return fmt.Errorf("restoring %s: %w", entry.Name, err)
+	}
+	return nil
+}
+
+// backupRemap : fresh ids for every record of an organization backup, so a copy can live
+// next to the original. References are rewritten wherever the old id appears.
+type backupRemap struct {
+	orgId           string
+	orgObjectId     primitive.ObjectID
+	hasOrganization bool
+	ids             map[primitive.ObjectID]primitive.ObjectID
+	hexes           map[string]string
+	// emails : new email of users and customers whose email is taken, by collection
+	emails map[string]map[string]string
+}
+
+// newBackupRemap : allocate new ids for every document of the archive. Customers and users
+// sign in by email, so emails already taken by live records, such as those of the organization
+// the backup was taken from, get a +restored-<id> tag in the copy.
+func newBackupRemap(ctx context.Context, archive io.ReadSeeker, orgId string) (*backupRemap, error) {
+	remap := &backupRemap{ids: map[primitive.ObjectID]primitive.ObjectID{}, hexes: map[string]string{}, emails: map[string]map[string]string{}}
+	emails := map[string][]string{}
+
+	err := eachBackupFile(archive, func(name string, content io.Reader) error {
+		collection := strings.TrimSuffix(path.Base(name), ".ndjson")
+		if name == backupManifestFile || !isBackupCollection(collection) {
+			return nil
+		}
+		scanner := backupScanner(content)
+		for scanner.Scan() {
+			var doc bson.M
+			if err := bson.UnmarshalExtJSON(scanner.Bytes(), true, &doc); err != nil {
+				return fmt.Errorf("%w: %s: %v", ErrBackupInvalid, name, err)
+			}
+			if id, ok := doc["_id"].(primitive.ObjectID); ok {
+				remap.add(id)
+			}
+			if collection == "organizations" {
+				remap.hasOrganization = true
+			}
+			if email, ok := doc["email"].(string); ok && (collection == "users" || collection == "customers") {
+				emails[collection] = append(emails[collection], email)
+			}
+		}
+		return scanner.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the organization id is the hex of its _id, it may be missing if only members were saved
+	if _, ok := remap.hexes[orgId]; !ok {
+		if id, err := primitive.ObjectIDFromHex(orgId); err == nil {
+			remap.add(id)
+		} else {
+			remap.hexes[orgId] = primitive.NewObjectID().Hex()
+		}
+	}
+	remap.orgId = remap.hexes[orgId]
+	remap.orgObjectId, _ = primitive.ObjectIDFromHex(remap.orgId)
+
+	for collection, list := range emails {
+		taken, err := database.OpenCollection(backupDatabaseName, collection).Distinct(ctx, "email", NotDeleted(bson.M{"email": bson.M{"$in": list}}))
+		if err != nil {
+			return nil, err
+		}
+		remap.emails[collection] = map[string]string{}
+		for _, email := range taken {
+			if email, ok := email.(string); ok {
+				remap.emails[collection][email] = restoredEmail(email, remap.orgId)
+			}
+		}
+	}
+	return remap, nil
+}
+
+// restoredEmail : email tagged with the organization copy it belongs to
+func restoredEmail(email, orgId string) string {
+	tag := "+restored-" + orgId[len(orgId)-8:]
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok {
+		return email + tag
+	}
+	return local + tag + "@" + domain
+}
+
+// email : doc of collection with its email replaced when it is taken
+func (r *backupRemap) email(collection string, doc bson.D) bson.D {
+	for i := range doc {
+		if doc[i].Key != "email" {
+			continue
+		}
+		if email, ok := doc[i].Value.(string); ok {
+			if renamed, ok := r.emails[collection][email]; ok {
+				doc[i].Value = renamed
+			}
+		}
+	}
+	return doc
+}
+
+func (r *backupRemap) add(id primitive.ObjectID) {
+	fresh := primitive.NewObjectID()
+	r.ids[id] = fresh
+	r.hexes[id.Hex()] = fresh.Hex()
+}
+
+// document : doc with every remapped ObjectID and id string replaced
+func (r *backupRemap) document(doc bson.D) bson.D {
+	for i := range doc {
+		doc[i].Value = r.value(doc[i].Value)
+	}
+	return doc
+}
+
+func (r *backupRemap) value(value interface{}) interface{} {
+	switch v := value.(type) {
+	case primitive.ObjectID:
+		if fresh, ok := r.ids[v]; ok {
+			return fresh
+		}
+	case string:
+		if fresh, ok := r.hexes[v]; ok {
+			return fresh
+		}
+	case bson.D:
+		return r.document(v)
+	case bson.A:
+		for i := range v {
+			v[i] = r.value(v[i])
+		}
+	}
+	return value
+}
+
+func setDocumentField(doc bson.D, key string, value interface{}) bson.D {
+	for i := range doc {
+		if doc[i].Key == key {
+			doc[i].Value = value
+			return doc
+		}
+	}
+	return append(doc, bson.E{Key: key, Value: value})
+}
+
+// eachBackupFile : call fn for every file of the archive, from the start
+func eachBackupFile(archive io.ReadSeeker, fn func(name string, content io.Reader) error) error {
+	if _, err := archive.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	gz, err := gzip.NewReader(archive)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrBackupInvalid, err)
+	}
+	defer gz.Close()
+
+	files := tar.NewReader(gz)
+	for {
+		header, err := files.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrBackupInvalid, err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := fn(header.Name, files); err != nil {
+			return err
+		}
+	}
+}
+
+func manifestEntry(manifest BackupManifest, file string) (BackupCollection, bool) {
+	for _, entry := range manifest.Collections {
+		if entry.File == file {
+			return entry, true
+		}
+	}
+	return BackupCollection{}, false
+}
+
+func isBackupCollection(name string) bool {
+	for _, collection := range BackupCollections {
+		if collection == name {
+			return true
+		}
+	}
+	return false
+}
+
+// backupScanner : line scanner allowing documents up to MongoDB's 16MB limit
+func backupScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 17*1024*1024)
+	return scanner
+}
+
+// countDocuments : number of documents of a collection file, checking each one can be restored
+func countDocuments(name string, r io.Reader) (int64, error) {
+	var lines int64
+	scanner := backupScanner(r)
+	for scanner.Scan() {
+		var doc bson.D
+		if err := bson.UnmarshalExtJSON(scanner.Bytes(), true, &doc); err != nil {
+			return lines, fmt.Errorf("%w: %s line %d: %v", ErrBackupInvalid, name, lines+1, err)
+		}
+		lines++
+	}
+	return lines, scanner.Err()
+}
+
+// BackupFileName : download name of a backup taken at t
+func BackupFileName(t time.Time) string {
+	return "crm-backup-" + t.UTC().Format("20060102T150405Z") + ".tar.gz"
+}
@@ -0,0 +1,37 @@
+package helpers
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckRestoreSize(t *testing.T) {
+	manifest := BackupManifest{Collections: []BackupCollection{
+		{Name: "customers", Count: 60000},
+		{Name: "tickets", Count: 1000},
+	}}
+
+	tests := []struct {
+		name     string
+		existing map[string]int64
+		tooLarge bool
+	}{
+		{"new organization copy", nil, false},
+		{"empty target", map[string]int64{"customers": 0}, false},
+		{"forced over records", map[string]int64{"customers": 40000, "tickets": 500}, false},
+		{"forced over too many records", map[string]int64{"customers": 40001}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkRestoreSize(manifest, tt.existing)
+			if errors.Is(err, ErrBackupTooLarge) != tt.tooLarge {
+				t.Errorf("checkRestoreSize() = %v, want too large %v", err, tt.tooLarge)
+			}
+		})
+	}
+
+	large := BackupManifest{Collections: []BackupCollection{{Name: "audit_logs", Count: backupTransactionLimit + 1}}}
+	if err := checkRestoreSize(large, nil); !errors.Is(err, ErrBackupTooLarge) {
+		t.Errorf("checkRestoreSize() = %v, want ErrBackupTooLarge", err)
+	}
+}
@@ -0,0 +1,62 @@
+package helpers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"* * * FOO *",
+		"* * * * 8",
+		"@sometimes",
+	} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("ParseCron(%q) succeeded, want an error", expr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	// Saturday
+	saturday := time.Date(2024, 6, 1, 10, 7, 30, 0, time.UTC)
+	tests := []struct {
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"*/15 * * * *", saturday, time.Date(2024, 6, 1, 10, 15, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 6, 1, 10, 15, 0, 0, time.UTC), time.Date(2024, 6, 1, 10, 30, 0, 0, time.UTC)},
+		{"5/20 * * * *", saturday, time.Date(2024, 6, 1, 10, 25, 0, 0, time.UTC)},
+		{"30 2 * * 1-5", saturday, time.Date(2024, 6, 3, 2, 30, 0, 0, time.UTC)},
+		{"@daily", saturday, time.Date(2024, 6, 2, 0, 0, 0, 0, time.UTC)},
+		{"@HOURLY", saturday, time.Date(2024, 6, 1, 11, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", saturday, time.Date(2024, 6, 2, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * sun", saturday, time.Date(2024, 6, 2, 0, 0, 0, 0, time.UTC)},
+		{"0 12 * JAN,jul *", saturday, time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)},
+		{"0 0 1,15 * *", saturday, time.Date(2024, 6, 15, 0, 0, 0, 0, time.UTC)},
+		// day of month and day of week both restricted: either matches
+		{"0 9 15 * MON", saturday, time.Date(2024, 6, 3, 9, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", saturday, time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", saturday, time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			schedule, err := ParseCron(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := schedule.Next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("Next(%s) = %s, want %s", tt.from, got, tt.want)
+			}
+		})
+	}
+}
@@ -20,6 +20,7 @@ type SignedCustomerDetails struct {
 	Email string
 	Name  string
 	Cid   string
+	OrgId string
 	jwt.StandardClaims
 }
 
@@ -33,11 +34,12 @@ var CustomerCollection *mongo.Collection = database.OpenCollection(customerDatab
 var CUSTOMER_SECRET_KEY string = os.Getenv("CUSTOMER_SECRET_KEY") // secret key
 
 // GenerateCustomerToken : Generate a new customer token
-func GenerateCustomerToken(email, name, cId string) (signedToken string, err error) {
+func GenerateCustomerToken(email, name, cId, orgId string) (signedToken string, err error) {
 	claims := &SignedCustomerDetails{
 		Email: email,
 		Name:  name,
 		Cid:   cId,
+		OrgId: orgId,
 		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: time.Now().Local().Add(time.Hour * time.Duration(24)).Unix(),
 		},
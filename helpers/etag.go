@@ -0,0 +1,66 @@
+package helpers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ErrVersionConflict : the record changed between reading it and writing it
+var ErrVersionConflict = errors.New("record was modified by someone else, fetch it again and retry")
+
+// ETag : entity tag for a record at a given version
+func ETag(id string, version int64) string {
+	return fmt.Sprintf("\"%s-%d\"", id, version)
+}
+
+// IfMatch : true when the request has no If-Match header or one of its tags matches etag.
+// If-Match uses strong comparison, so weak W/ tags never match.
+func IfMatch(c *gin.Context, etag string) bool {
+	header := c.GetHeader("If-Match")
+	if header == "" {
+		return true
+	}
+	return etagListContains(header, etag, false)
+}
+
+// IfNoneMatch : true when the client already holds the current representation, weak tags
+// included
+func IfNoneMatch(c *gin.Context, etag string) bool {
+	header := c.GetHeader("If-None-Match")
+	if header == "" {
+		return false
+	}
+	return etagListContains(header, etag, true)
+}
+
+func etagListContains(header, etag string, weak bool) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if weak {
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// VersionFilter : copy of filter that only matches the record while it is still at version.
+// Records written before versioning have no version field and count as version 0.
+func VersionFilter(filter bson.M, version int64) bson.M {
+	versioned := bson.M{}
+	for key, value := range filter {
+		versioned[key] = value
+	}
+	if version == 0 {
+		versioned["version"] = bson.M{"$in": bson.A{0, nil}}
+	} else {
+		versioned["version"] = version
+	}
+	return versioned
+}
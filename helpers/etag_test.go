@@ -0,0 +1,96 @@
+package helpers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestETag(t *testing.T) {
+	if got, want := ETag("abc", 3), `"abc-3"`; got != want {
+		t.Errorf("ETag = %s, want %s", got, want)
+	}
+}
+
+func TestIfMatch(t *testing.T) {
+	etag := ETag("abc", 3)
+	tests := []struct {
+		name   string
+		header string
+		want   bool
+	}{
+		{"no header", "", true},
+		{"same tag", `"abc-3"`, true},
+		{"older version", `"abc-2"`, false},
+		{"one of a list", `"abc-2", "abc-3"`, true},
+		{"any", "*", true},
+		{"weak tag", `W/"abc-3"`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IfMatch(etagContext("If-Match", tt.header), etag); got != tt.want {
+				t.Errorf("IfMatch(%q) = %v, want %v", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIfNoneMatch(t *testing.T) {
+	etag := ETag("abc", 3)
+	tests := []struct {
+		name   string
+		header string
+		want   bool
+	}{
+		{"no header", "", false},
+		{"same tag", `"abc-3"`, true},
+		{"older version", `"abc-2"`, false},
+		{"one of a list", `"abc-2", "abc-3"`, true},
+		{"any", "*", true},
+		{"weak tag", `W/"abc-3"`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IfNoneMatch(etagContext("If-None-Match", tt.header), etag); got != tt.want {
+				t.Errorf("IfNoneMatch(%q) = %v, want %v", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVersionFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		version int64
+		want    bson.M
+	}{
+		{"versioned record", 4, bson.M{"customer_id": "abc", "version": int64(4)}},
+		{"record written before versioning", 0, bson.M{"customer_id": "abc", "version": bson.M{"$in": bson.A{0, nil}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter := bson.M{"customer_id": "abc"}
+			got := VersionFilter(filter, tt.version)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("VersionFilter = %v, want %v", got, tt.want)
+			}
+			if _, ok := filter["version"]; ok {
+				t.Error("VersionFilter changed the filter it was given")
+			}
+		})
+	}
+}
+
+// etagContext : gin context of a request carrying header, none when value is empty
+func etagContext(header, value string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	if value != "" {
+		c.Request.Header.Set(header, value)
+	}
+	return c
+}
@@ -0,0 +1,237 @@
+package helpers
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Export formats
+const (
+	FORMAT_CSV    = "csv"
+	FORMAT_JSON   = "json"
+	FORMAT_NDJSON = "ndjson"
+	FORMAT_XLSX   = "xlsx"
+	FORMAT_VCARD  = "vcf"
+)
+
+// ExportWriter : writes exported records one by one, so exports never hold a whole collection in memory
+type ExportWriter interface {
+	// WriteRecord writes one record, fields in column order
+	WriteRecord(record bson.D) error
+	// Close flushes buffered output and terminates the document
+	Close() error
+}
+
+// ValidExportFormat : true for the formats NewExportWriter understands
+func ValidExportFormat(format string) bool {
+	switch format {
+	case FORMAT_CSV, FORMAT_JSON, FORMAT_NDJSON, FORMAT_XLSX, FORMAT_VCARD:
+		return true
+	}
+	return false
+}
+
+// NewExportWriter : writer for format, columns is the header row used by CSV and XLSX
+// and name the XLSX sheet name. vCards are written as version 3.0, see NewVCardWriter.
+func NewExportWriter(format string, w io.Writer, name string, columns []string) (ExportWriter, error) {
+	switch format {
+	case FORMAT_CSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(columns); err != nil {
+			return nil, err
+		}
+		return &csvExportWriter{writer: writer}, nil
+	case FORMAT_JSON:
+		return &jsonExportWriter{writer: bufio.NewWriter(w), array: true}, nil
+	case FORMAT_NDJSON:
+		return &jsonExportWriter{writer: bufio.NewWriter(w)}, nil
+	case FORMAT_XLSX:
+		return newXLSXExportWriter(w, name, columns)
+	case FORMAT_VCARD:
+		return NewVCardWriter(w, VCARD_V3)
+	}
+	return nil, fmt.Errorf("invalid format %q, expected csv, json, ndjson, xlsx or vcf", format)
+}
+
+// ExportResponse : prepare the response for streaming an export download named name.
+// With ?gzip=true the download itself is a .gz file, otherwise the body is gzip
+// encoded when the client sends Accept-Encoding: gzip. The returned func must be
+// called once everything has been written.
+func ExportResponse(c *gin.Context, name, format string) (io.Writer, func() error) {
+	filename := name + "." + format
+	c.Header("Content-Type", ExportContentType(format))
+
+	var out io.Writer = c.Writer
+	finish := func() error { return nil }
+	compress := c.Query("gzip") == "true"
+	if compress {
+		filename += ".gz"
+		c.Header("Content-Type", "application/gzip")
+	} else if strings.Contains(c.GetHeader("Accept-Encoding"), "gzip") {
+		compress = true
+		c.Header("Content-Encoding", "gzip")
+		c.Header("Vary", "Accept-Encoding")
+	}
+	if compress {
+		gz := gzip.NewWriter(c.Writer)
+		out = gz
+		finish = gz.Close
+	}
+
+	c.Header("Content-Disposition", "attachment;filename="+filename)
+	return out, finish
+}
+
+// ExportContentType : MIME type of an export format
+func ExportContentType(format string) string {
+	switch format {
+	case FORMAT_CSV:
+		return "text/csv"
+	case FORMAT_NDJSON:
+		return "application/x-ndjson"
+	case FORMAT_XLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	case FORMAT_VCARD:
+		return "text/vcard"
+	}
+	return "application/json"
+}
+
+type csvExportWriter struct {
+	writer *csv.Writer
+}
+
+func (w *csvExportWriter) WriteRecord(record bson.D) error {
+	row := make([]string, len(record))
+	for i, field := range record {
+		row[i] = ExportCell(field.Value)
+	}
+	return w.writer.Write(row)
+}
+
+func (w *csvExportWriter) Close() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+// jsonExportWriter : JSON array (array=true) or newline delimited JSON objects
+type jsonExportWriter struct {
+	writer  *bufio.Writer
+	array   bool
+	written int
+}
+
+func (w *jsonExportWriter) WriteRecord(record bson.D) error {
+	object, err := exportObject(record)
+	if err != nil {
+		return err
+	}
+
+	if w.array {
+		separator := ","
+		if w.written == 0 {
+			separator = "["
+		}
+		if _, err := w.writer.WriteString(separator); err != nil {
+			return err
+		}
+	}
+	if _, err := w.writer.Write(object); err != nil {
+		return err
+	}
+	if !w.array {
+		if err := w.writer.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+	w.written++
+	return nil
+}
+
+func (w *jsonExportWriter) Close() error {
+	if w.array {
+		closing := "]"
+		if w.written == 0 {
+			closing = "[]"
+		}
+		if _, err := w.writer.WriteString(closing); err != nil {
+			return err
+		}
+	}
+	return w.writer.Flush()
+}
+
+// exportObject : JSON object keeping the column order of the record
+func exportObject(record bson.D) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, field := range record {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(field.Key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(exportValue(field.Value))
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// exportValue : normalise stored values to what clients expect in JSON
+func exportValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case *string:
+		if v == nil {
+			return nil
+		}
+		return *v
+	case primitive.ObjectID:
+		if v.IsZero() {
+			return nil
+		}
+		return v.Hex()
+	case primitive.DateTime:
+		return v.Time().UTC().Format(time.RFC3339)
+	case time.Time:
+		if v.IsZero() {
+			return nil
+		}
+		return v.UTC().Format(time.RFC3339)
+	}
+	return value
+}
+
+// ExportCell : text representation of a value for a CSV cell
+func ExportCell(value interface{}) string {
+	switch v := exportValue(value).(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		// no exponents, 1000000 stays 1000000
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
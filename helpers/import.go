@@ -0,0 +1,119 @@
+package helpers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ImportReader : reads imported records one by one as column name -> raw value
+type ImportReader interface {
+	// Next returns the next record, or io.EOF once the input is exhausted
+	Next() (map[string]string, error)
+}
+
+// NewImportReader : reader for format. CSV and XLSX input must start with a header row,
+// JSON input is an array of objects, NDJSON one object per line and vCard input
+// yields customer rows.
+func NewImportReader(format string, r io.Reader) (ImportReader, error) {
+	switch format {
+	case FORMAT_CSV:
+		reader := csv.NewReader(r)
+		// rows may be shorter or longer than the header, missing cells read as empty
+		reader.FieldsPerRecord = -1
+		header, err := reader.Read()
+		if err != nil {
+			return nil, fmt.Errorf("reading CSV header: %w", err)
+		}
+		for i := range header {
+			header[i] = importHeader(header[i])
+		}
+		return &csvImportReader{reader: reader, header: header}, nil
+	case FORMAT_XLSX:
+		return newXLSXImportReader(r)
+	case FORMAT_VCARD:
+		return newVCardImportReader(r), nil
+	case FORMAT_JSON:
+		decoder := json.NewDecoder(r)
+		decoder.UseNumber()
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, fmt.Errorf("reading JSON: %w", err)
+		}
+		if delim, ok := token.(json.Delim); !ok || delim != '[' {
+			return nil, fmt.Errorf("JSON import must be an array of objects")
+		}
+		return &jsonImportReader{decoder: decoder, array: true}, nil
+	case FORMAT_NDJSON:
+		decoder := json.NewDecoder(r)
+		decoder.UseNumber()
+		return &jsonImportReader{decoder: decoder}, nil
+	}
+	return nil, fmt.Errorf("invalid format %q, expected csv, json, ndjson, xlsx or vcf", format)
+}
+
+// importHeader : column name of a header cell, matched case insensitively
+func importHeader(cell string) string {
+	return strings.ToLower(strings.TrimSpace(strings.TrimPrefix(cell, "\ufeff")))
+}
+
+type csvImportReader struct {
+	reader *csv.Reader
+	header []string
+}
+
+func (r *csvImportReader) Next() (map[string]string, error) {
+	row, err := r.reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	record := make(map[string]string, len(r.header))
+	for i, column := range r.header {
+		if i < len(row) {
+			record[column] = strings.TrimSpace(row[i])
+		} else {
+			record[column] = ""
+		}
+	}
+	return record, nil
+}
+
+type jsonImportReader struct {
+	decoder *json.Decoder
+	array   bool
+}
+
+func (r *jsonImportReader) Next() (map[string]string, error) {
+	if r.array && !r.decoder.More() {
+		return nil, io.EOF
+	}
+
+	var object map[string]interface{}
+	if err := r.decoder.Decode(&object); err != nil {
+		return nil, err
+	}
+
+	record := make(map[string]string, len(object))
+	for key, value := range object {
+		record[strings.ToLower(key)] = importCell(value)
+	}
+	return record, nil
+}
+
+// importCell : raw text of a decoded JSON value
+func importCell(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return strings.TrimSpace(v)
+	case json.Number:
+		return v.String()
+	case map[string]interface{}, []interface{}:
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,85 @@
+package helpers
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// phoneMatchDigits : trailing digits two phone numbers must share, so country and trunk
+// prefixes such as +44 or 0 do not matter
+const phoneMatchDigits = 9
+
+// NormalizeEmail : email lower cased, without a +tag, and without the dots Gmail ignores
+func NormalizeEmail(email string) string {
+	email = strings.ToLower(strings.TrimSpace(email))
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok {
+		return email
+	}
+	local, _, _ = strings.Cut(local, "+")
+	if domain == "gmail.com" || domain == "googlemail.com" {
+		local = strings.ReplaceAll(local, ".", "")
+		domain = "gmail.com"
+	}
+	return local + "@" + domain
+}
+
+// NormalizePhone : last digits of a phone number, empty when it has too few to compare
+func NormalizePhone(phone string) string {
+	digits := strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, phone)
+	if len(digits) < phoneMatchDigits {
+		return ""
+	}
+	return digits[len(digits)-phoneMatchDigits:]
+}
+
+// NameTokens : words of a name or company lower cased without punctuation, sorted so word
+// order does not matter
+func NameTokens(name string) []string {
+	tokens := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	sort.Strings(tokens)
+	return tokens
+}
+
+// NameSimilarity : similarity of two names from 0 to 1, one less their edit distance over the
+// length of the longer one, comparing their sorted words
+func NameSimilarity(a, b string) float64 {
+	x, y := []rune(strings.Join(NameTokens(a), " ")), []rune(strings.Join(NameTokens(b), " "))
+	if len(x) == 0 || len(y) == 0 {
+		return 0
+	}
+	longest := len(x)
+	if len(y) > longest {
+		longest = len(y)
+	}
+	return 1 - float64(editDistance(x, y))/float64(longest)
+}
+
+// editDistance : Levenshtein distance of a and b
+func editDistance(a, b []rune) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
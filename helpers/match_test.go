@@ -0,0 +1,71 @@
+package helpers
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNormalizeEmail(t *testing.T) {
+	tests := []struct {
+		email string
+		want  string
+	}{
+		{" Jane.Doe@Example.com ", "jane.doe@example.com"},
+		{"jane+newsletter@example.com", "jane@example.com"},
+		{"Jane.Doe+crm@gmail.com", "janedoe@gmail.com"},
+		{"jane.doe@googlemail.com", "janedoe@gmail.com"},
+		// dots only matter outside Gmail
+		{"jane.doe@outlook.com", "jane.doe@outlook.com"},
+		{"not-an-email", "not-an-email"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := NormalizeEmail(tt.email); got != tt.want {
+			t.Errorf("NormalizeEmail(%q) = %q, want %q", tt.email, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizePhone(t *testing.T) {
+	tests := []struct {
+		phone string
+		want  string
+	}{
+		{"+44 20 7946 0018", "079460018"},
+		{"020 7946 0018", "079460018"},
+		{"(020) 7946-0018", "079460018"},
+		{"207946001", "207946001"},
+		// too few digits to compare
+		{"12345678", ""},
+		{"ext. 12", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := NormalizePhone(tt.phone); got != tt.want {
+			t.Errorf("NormalizePhone(%q) = %q, want %q", tt.phone, got, tt.want)
+		}
+	}
+}
+
+func TestNameSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want float64
+	}{
+		{"John Smith", "John Smith", 1},
+		{"Smith, John", "john smith", 1},
+		{"John Smith", "Jon Smith", 0.9},
+		{"Acme Inc.", "ACME inc", 1},
+		{"abc", "xyz", 0},
+		{"John Smith", "", 0},
+		{"", "", 0},
+	}
+	for _, tt := range tests {
+		if got := NameSimilarity(tt.a, tt.b); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("NameSimilarity(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+		if got := NameSimilarity(tt.b, tt.a); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("NameSimilarity(%q, %q) = %v, want %v", tt.b, tt.a, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package helpers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nirmal/crm/database"
+	"github.com/nirmal/crm/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// DEFAULT_ORG_NAME : organization that records from before organizations existed move into
+const DEFAULT_ORG_NAME = "Default"
+
+// AssignOrgResult : organization records were assigned to, and how many of each collection
+type AssignOrgResult struct {
+	OrgId    string           `json:"org_id"`
+	Created  bool             `json:"created,omitempty"`
+	Assigned map[string]int64 `json:"assigned"`
+}
+
+// AssignOrganization : give every record written before organizations existed, so without
+// an org_id field, to the organization orgId, or to the one named name, created when missing.
+// SUPER_ADMINs keep belonging to no organization. Running it again only picks up records
+// that still have no organization.
+func AssignOrganization(ctx context.Context, orgId, name string) (AssignOrgResult, error) {
+	result := AssignOrgResult{Assigned: map[string]int64{}}
+	organizations := database.OpenCollection(backupDatabaseName, "organizations")
+
+	var org bson.M
+	filter := NotDeleted(bson.M{"name": name})
+	if orgId != "" {
+		filter = NotDeleted(bson.M{utils.ORG_ID: orgId})
+	}
+	err := organizations.FindOne(ctx, filter).Decode(&org)
+	switch {
+	case err == nil:
+		result.OrgId, _ = org[utils.ORG_ID].(string)
+	case !errors.Is(err, mongo.ErrNoDocuments):
+		return result, err
+	case orgId != "":
+		return result, fmt.Errorf("organization %s not found", orgId)
+	default:
+		now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		id := primitive.NewObjectID()
+		org = bson.M{"_id": id, utils.ORG_ID: id.Hex(), "name": name, "created_at": now, "updated_at": now, "version": 1}
+		if _, err := organizations.InsertOne(ctx, org); err != nil {
+			return result, err
+		}
+		result.OrgId, result.Created = id.Hex(), true
+	}
+
+	for _, name := range BackupCollections {
+		if name == "organizations" {
+			continue
+		}
+		filter := bson.M{utils.ORG_ID: bson.M{"$exists": false}}
+		if name == "users" {
+			filter["role"] = bson.M{"$ne": utils.ROLE_SUPER_ADMIN}
+		}
+		updated, err := database.OpenCollection(backupDatabaseName, name).UpdateMany(ctx, filter, bson.M{"$set": bson.M{utils.ORG_ID: result.OrgId}})
+		if err != nil {
+			return result, fmt.Errorf("assigning %s: %w", name, err)
+		}
+		result.Assigned[name] = updated.ModifiedCount
+	}
+	return result, nil
+}
@@ -0,0 +1,54 @@
+package helpers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Forecast period intervals
+const (
+	PERIOD_MONTH   = "month"
+	PERIOD_QUARTER = "quarter"
+)
+
+// PeriodOf : period of interval that t falls in, as "2024-09" or "2024-Q3"
+func PeriodOf(t time.Time, interval string) string {
+	t = t.UTC()
+	if interval == PERIOD_QUARTER {
+		return fmt.Sprintf("%d-Q%d", t.Year(), (int(t.Month())-1)/3+1)
+	}
+	return t.Format("2006-01")
+}
+
+// ParsePeriod : first instant, the instant after the last, and interval of a period
+// written as "2024-09" or "2024-Q3"
+func ParsePeriod(period string) (start, end time.Time, interval string, err error) {
+	if year, quarter, ok := strings.Cut(strings.ToUpper(period), "-Q"); ok {
+		y, yearErr := strconv.Atoi(year)
+		q, quarterErr := strconv.Atoi(quarter)
+		if yearErr != nil || quarterErr != nil || q < 1 || q > 4 {
+			return start, end, "", fmt.Errorf("invalid period %q, expected 2006-01 or 2006-Q1", period)
+		}
+		start = time.Date(y, time.Month(3*(q-1)+1), 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 3, 0), PERIOD_QUARTER, nil
+	}
+	start, err = time.Parse("2006-01", period)
+	if err != nil {
+		return start, end, "", fmt.Errorf("invalid period %q, expected 2006-01 or 2006-Q1", period)
+	}
+	return start, start.AddDate(0, 1, 0), PERIOD_MONTH, nil
+}
+
+// PeriodStart : first instant of the period of interval that t falls in
+func PeriodStart(t time.Time, interval string) time.Time {
+	start, _, _, _ := ParsePeriod(PeriodOf(t, interval))
+	return start
+}
+
+// ISOWeek : ISO 8601 week of t, as "2024-W37"
+func ISOWeek(t time.Time) string {
+	year, week := t.UTC().ISOWeek()
+	return fmt.Sprintf("%d-W%02d", year, week)
+}
@@ -0,0 +1,83 @@
+package helpers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPeriodOf(t *testing.T) {
+	tests := []struct {
+		at       time.Time
+		interval string
+		want     string
+	}{
+		{time.Date(2024, 9, 15, 12, 0, 0, 0, time.UTC), PERIOD_MONTH, "2024-09"},
+		{time.Date(2024, 9, 15, 12, 0, 0, 0, time.UTC), PERIOD_QUARTER, "2024-Q3"},
+		{time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), PERIOD_QUARTER, "2024-Q1"},
+		{time.Date(2024, 12, 31, 23, 59, 0, 0, time.UTC), PERIOD_QUARTER, "2024-Q4"},
+		// periods are in UTC
+		{time.Date(2024, 10, 1, 1, 0, 0, 0, time.FixedZone("CEST", 2*60*60)), PERIOD_QUARTER, "2024-Q3"},
+	}
+	for _, tt := range tests {
+		if got := PeriodOf(tt.at, tt.interval); got != tt.want {
+			t.Errorf("PeriodOf(%s, %s) = %s, want %s", tt.at, tt.interval, got, tt.want)
+		}
+	}
+}
+
+func TestParsePeriod(t *testing.T) {
+	tests := []struct {
+		period   string
+		start    time.Time
+		end      time.Time
+		interval string
+	}{
+		{"2024-09", time.Date(2024, 9, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC), PERIOD_MONTH},
+		{"2024-12", time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC), time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), PERIOD_MONTH},
+		{"2024-Q3", time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC), PERIOD_QUARTER},
+		{"2024-q4", time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC), time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), PERIOD_QUARTER},
+	}
+	for _, tt := range tests {
+		start, end, interval, err := ParsePeriod(tt.period)
+		if err != nil {
+			t.Errorf("ParsePeriod(%s): %v", tt.period, err)
+			continue
+		}
+		if !start.Equal(tt.start) || !end.Equal(tt.end) || interval != tt.interval {
+			t.Errorf("ParsePeriod(%s) = %s, %s, %s, want %s, %s, %s", tt.period, start, end, interval, tt.start, tt.end, tt.interval)
+		}
+	}
+
+	for _, period := range []string{"", "2024", "2024-13", "2024-Q0", "2024-Q5", "Q3-2024", "24-09"} {
+		if _, _, _, err := ParsePeriod(period); err == nil {
+			t.Errorf("ParsePeriod(%q) succeeded, want an error", period)
+		}
+	}
+}
+
+func TestPeriodStart(t *testing.T) {
+	at := time.Date(2024, 8, 20, 15, 30, 0, 0, time.UTC)
+	if got, want := PeriodStart(at, PERIOD_MONTH), time.Date(2024, 8, 1, 0, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("PeriodStart(month) = %s, want %s", got, want)
+	}
+	if got, want := PeriodStart(at, PERIOD_QUARTER), time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("PeriodStart(quarter) = %s, want %s", got, want)
+	}
+}
+
+func TestISOWeek(t *testing.T) {
+	tests := []struct {
+		at   time.Time
+		want string
+	}{
+		{time.Date(2024, 9, 11, 0, 0, 0, 0, time.UTC), "2024-W37"},
+		// the first days of January may belong to the last week of the year before
+		{time.Date(2021, 1, 3, 0, 0, 0, 0, time.UTC), "2020-W53"},
+		{time.Date(2024, 12, 30, 0, 0, 0, 0, time.UTC), "2025-W01"},
+	}
+	for _, tt := range tests {
+		if got := ISOWeek(tt.at); got != tt.want {
+			t.Errorf("ISOWeek(%s) = %s, want %s", tt.at, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package helpers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/nirmal/crm/utils"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// TenantFilter : restrict a filter to the caller's organization.
+// SUPER_ADMIN sees every organization unless it narrows the query with ?org_id=
+func TenantFilter(c *gin.Context, filter bson.M) bson.M {
+	if c.GetString("role") == utils.ROLE_SUPER_ADMIN {
+		if orgId := c.Query(utils.ORG_ID); orgId != "" {
+			filter[utils.ORG_ID] = orgId
+		}
+		return filter
+	}
+	filter[utils.ORG_ID] = c.GetString(utils.ORG_ID)
+	return filter
+}
+
+// TenantId : organization that records created by the caller belong to.
+// SUPER_ADMIN has no organization of its own and picks one with ?org_id=
+func TenantId(c *gin.Context) string {
+	if c.GetString("role") == utils.ROLE_SUPER_ADMIN {
+		return c.Query(utils.ORG_ID)
+	}
+	return c.GetString(utils.ORG_ID)
+}
@@ -0,0 +1,54 @@
+package helpers
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// NotDeleted : restrict a filter to records that are not in the trash
+func NotDeleted(filter bson.M) bson.M {
+	filter["deleted_at"] = bson.M{"$exists": false}
+	return filter
+}
+
+// OnlyDeleted : restrict a filter to records that are in the trash
+func OnlyDeleted(filter bson.M) bson.M {
+	filter["deleted_at"] = bson.M{"$exists": true}
+	return filter
+}
+
+// SoftDeleteUpdate : update that moves a record to the trash on behalf of the current actor
+func SoftDeleteUpdate(c *gin.Context) bson.M {
+	actorId, _ := auditActor(c)
+	deletedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	return bson.M{
+		"$set": bson.M{
+			"deleted_at": deletedAt,
+			"deleted_by": actorId,
+			"updated_at": deletedAt,
+		},
+		"$inc": bson.M{"version": 1},
+	}
+}
+
+// RestoreUpdate : update that takes a record back out of the trash
+func RestoreUpdate() bson.M {
+	return bson.M{
+		"$unset": bson.M{"deleted_at": "", "deleted_by": ""},
+		"$set":   bson.M{"updated_at": time.Now()},
+		"$inc":   bson.M{"version": 1},
+	}
+}
+
+// Unversioned : update without its version bump, for records that carry no version
+func Unversioned(update bson.M) bson.M {
+	kept := bson.M{}
+	for operator, fields := range update {
+		if operator != "$inc" {
+			kept[operator] = fields
+		}
+	}
+	return kept
+}
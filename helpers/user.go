@@ -21,6 +21,7 @@ type SignedUserDetails struct {
 	Name  string
 	Uid   string
 	Role  string
+	OrgId string
 	jwt.StandardClaims
 }
 
@@ -33,13 +34,14 @@ var UserCollection *mongo.Collection = database.OpenCollection(userDatabaseName,
 var USER_SECRET_KEY string = os.Getenv("USER_SECRET_KEY") // secret key
 
 // GenerateUserToken : Generate a new user token
-func GenerateUserToken(email, name, uId, role string) (signedToken string, err error) {
+func GenerateUserToken(email, name, uId, role, orgId string) (signedToken string, err error) {
 	// Create the Claims
 	claims := &SignedUserDetails{
 		Email: email,
 		Name:  name,
 		Uid:   uId,
 		Role:  role,
+		OrgId: orgId,
 		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: time.Now().Local().Add(time.Hour * time.Duration(24)).Unix(), // token expires in 24 hours
 		},
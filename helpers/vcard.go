@@ -0,0 +1,252 @@
+package helpers
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// vCard versions
+const (
+	VCARD_V3 = "3.0"
+	VCARD_V4 = "4.0"
+)
+
+// vCard content lines longer than this many octets are folded
+const vcardLineLength = 75
+
+// NewVCardWriter : writes each exported customer record as a vCard of version (3.0 or 4.0).
+// Records are read by export column name: name, email, phone, company, customer_id and updated_at.
+func NewVCardWriter(w io.Writer, version string) (ExportWriter, error) {
+	if version != VCARD_V3 && version != VCARD_V4 {
+		return nil, fmt.Errorf("invalid vCard version %q, expected 3.0 or 4.0", version)
+	}
+	return &vcardWriter{writer: bufio.NewWriter(w), version: version}, nil
+}
+
+type vcardWriter struct {
+	writer  *bufio.Writer
+	version string
+}
+
+func (w *vcardWriter) WriteRecord(record bson.D) error {
+	fields := map[string]string{}
+	for _, field := range record {
+		fields[field.Key] = ExportCell(field.Value)
+	}
+
+	lines := []string{"BEGIN:VCARD", "VERSION:" + w.version}
+	if uid := fields["customer_id"]; uid != "" {
+		lines = append(lines, "UID:"+vcardEscape(uid))
+	}
+	name := fields["name"]
+	lines = append(lines, "FN:"+vcardEscape(name))
+	if w.version == VCARD_V3 {
+		// N is required in 3.0, the last word is taken as the family name
+		given, family := name, ""
+		if i := strings.LastIndex(name, " "); i > 0 {
+			given, family = name[:i], name[i+1:]
+		}
+		lines = append(lines, "N:"+vcardEscape(family)+";"+vcardEscape(given)+";;;")
+	}
+	if email := fields["email"]; email != "" {
+		if w.version == VCARD_V3 {
+			lines = append(lines, "EMAIL;TYPE=INTERNET:"+vcardEscape(email))
+		} else {
+			lines = append(lines, "EMAIL:"+vcardEscape(email))
+		}
+	}
+	if phone := fields["phone"]; phone != "" {
+		if w.version == VCARD_V3 {
+			lines = append(lines, "TEL;TYPE=WORK,VOICE:"+vcardEscape(phone))
+		} else {
+			lines = append(lines, "TEL;VALUE=text;TYPE=work:"+vcardEscape(phone))
+		}
+	}
+	if company := fields["company"]; company != "" {
+		lines = append(lines, "ORG:"+vcardEscape(company))
+	}
+	if updated, err := time.Parse(time.RFC3339, fields["updated_at"]); err == nil {
+		lines = append(lines, "REV:"+updated.UTC().Format("20060102T150405Z"))
+	}
+	lines = append(lines, "END:VCARD")
+
+	for _, line := range lines {
+		if _, err := w.writer.WriteString(vcardFold(line)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *vcardWriter) Close() error {
+	return w.writer.Flush()
+}
+
+// vcardEscape : escape a text value, vCard separates values with ; and ,
+func vcardEscape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, ",", `\,`, ";", `\;`, "\r\n", `\n`, "\n", `\n`).Replace(value)
+}
+
+// vcardFold : content line terminated by CRLF, folded so no line exceeds vcardLineLength octets
+func vcardFold(line string) string {
+	var folded strings.Builder
+	length := 0
+	for _, r := range line {
+		size := len(string(r))
+		if length+size > vcardLineLength {
+			folded.WriteString("\r\n ")
+			length = 1
+		}
+		folded.WriteRune(r)
+		length += size
+	}
+	folded.WriteString("\r\n")
+	return folded.String()
+}
+
+// vcardImportReader : contacts of a vCard file (2.1, 3.0 or 4.0) as customer import rows,
+// FN, EMAIL, TEL and ORG become name, email, phone and company
+type vcardImportReader struct {
+	scanner *bufio.Scanner
+	// pending is a line read ahead to find the end of a folded line
+	pending *string
+}
+
+func newVCardImportReader(r io.Reader) ImportReader {
+	return &vcardImportReader{scanner: bufio.NewScanner(r)}
+}
+
+func (r *vcardImportReader) Next() (map[string]string, error) {
+	var record map[string]string
+	var family, given string
+	for {
+		line, err := r.line()
+		if err != nil {
+			if err == io.EOF && record != nil {
+				return nil, fmt.Errorf("vCard is missing END:VCARD")
+			}
+			return nil, err
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		name, params, value := vcardProperty(line)
+		switch {
+		case name == "BEGIN" && strings.EqualFold(value, "VCARD"):
+			record = map[string]string{"name": "", "email": "", "phone": "", "company": ""}
+			family, given = "", ""
+		case record == nil:
+			continue
+		case name == "END" && strings.EqualFold(value, "VCARD"):
+			if record["name"] == "" {
+				record["name"] = strings.TrimSpace(given + " " + family)
+			}
+			return record, nil
+		case name == "FN" && record["name"] == "":
+			record["name"] = vcardUnescape(value)
+		case name == "N":
+			parts := vcardSplit(value)
+			if len(parts) > 0 {
+				family = vcardUnescape(parts[0])
+			}
+			if len(parts) > 1 {
+				given = vcardUnescape(parts[1])
+			}
+		case name == "EMAIL" && (record["email"] == "" || vcardPreferred(params)):
+			record["email"] = vcardUnescape(value)
+		case name == "TEL" && (record["phone"] == "" || vcardPreferred(params)):
+			record["phone"] = strings.TrimPrefix(vcardUnescape(value), "tel:")
+		case name == "ORG" && record["company"] == "":
+			if parts := vcardSplit(value); len(parts) > 0 {
+				record["company"] = vcardUnescape(parts[0])
+			}
+		}
+	}
+}
+
+// line : next unfolded content line
+func (r *vcardImportReader) line() (string, error) {
+	var line string
+	if r.pending != nil {
+		line, r.pending = *r.pending, nil
+	} else if r.scanner.Scan() {
+		line = r.scanner.Text()
+	} else if err := r.scanner.Err(); err != nil {
+		return "", err
+	} else {
+		return "", io.EOF
+	}
+
+	for r.scanner.Scan() {
+		next := r.scanner.Text()
+		if strings.HasPrefix(next, " ") || strings.HasPrefix(next, "\t") {
+			line += next[1:]
+			continue
+		}
+		r.pending = &next
+		break
+	}
+	return strings.TrimSuffix(line, "\r"), r.scanner.Err()
+}
+
+// vcardProperty : upper cased name without group, raw parameters and value of a content line
+func vcardProperty(line string) (name, params, value string) {
+	head, value, _ := strings.Cut(line, ":")
+	name, params, _ = strings.Cut(head, ";")
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+	return strings.ToUpper(strings.TrimSpace(name)), strings.ToUpper(params), value
+}
+
+// vcardPreferred : true for PREF (2.1), TYPE=pref (3.0) or PREF=1 (4.0) values
+func vcardPreferred(params string) bool {
+	for _, param := range strings.Split(params, ";") {
+		key, value, _ := strings.Cut(param, "=")
+		switch key {
+		case "PREF":
+			return value == "" || value == "1"
+		case "TYPE":
+			for _, kind := range strings.Split(value, ",") {
+				if kind == "PREF" {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// vcardSplit : components of a structured value, split on unescaped semicolons
+func vcardSplit(value string) []string {
+	var parts []string
+	var part strings.Builder
+	escaped := false
+	for _, r := range value {
+		switch {
+		case escaped:
+			part.WriteRune('\\')
+			part.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == ';':
+			parts = append(parts, part.String())
+			part.Reset()
+		default:
+			part.WriteRune(r)
+		}
+	}
+	return append(parts, part.String())
+}
+
+// vcardUnescape : text value with escapes resolved
+func vcardUnescape(value string) string {
+	return strings.TrimSpace(strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\:`, ":", `\\`, `\`).Replace(value))
+}
@@ -0,0 +1,172 @@
+package helpers
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestVCardWriter(t *testing.T) {
+	record := bson.D{
+		{Key: "customer_id", Value: "c1"},
+		{Key: "name", Value: "Jane van Dyke"},
+		{Key: "email", Value: "jane@example.com"},
+		{Key: "phone", Value: "+1 555 0100"},
+		{Key: "company", Value: "Acme; Sons, Inc"},
+		{Key: "updated_at", Value: time.Date(2024, 6, 1, 10, 7, 30, 0, time.UTC)},
+	}
+	tests := []struct {
+		version string
+		want    string
+	}{
+		{VCARD_V3, "BEGIN:VCARD\r\nVERSION:3.0\r\nUID:c1\r\nFN:Jane van Dyke\r\nN:Dyke;Jane van;;;\r\n" +
+			"EMAIL;TYPE=INTERNET:jane@example.com\r\nTEL;TYPE=WORK,VOICE:+1 555 0100\r\n" +
+			"ORG:Acme\\; Sons\\, Inc\r\nREV:20240601T100730Z\r\nEND:VCARD\r\n"},
+		{VCARD_V4, "BEGIN:VCARD\r\nVERSION:4.0\r\nUID:c1\r\nFN:Jane van Dyke\r\n" +
+			"EMAIL:jane@example.com\r\nTEL;VALUE=text;TYPE=work:+1 555 0100\r\n" +
+			"ORG:Acme\\; Sons\\, Inc\r\nREV:20240601T100730Z\r\nEND:VCARD\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			var out strings.Builder
+			writer, err := NewVCardWriter(&out, tt.version)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := writer.WriteRecord(record); err != nil {
+				t.Fatal(err)
+			}
+			if err := writer.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("vCard =\n%q\nwant\n%q", out.String(), tt.want)
+			}
+		})
+	}
+
+	if _, err := NewVCardWriter(io.Discard, "2.1"); err == nil {
+		t.Error("NewVCardWriter(2.1) succeeded, want an error")
+	}
+}
+
+func TestVCardFold(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want string
+	}{
+		{"short line", "FN:Jane", "FN:Jane\r\n"},
+		{"exactly 75 octets", strings.Repeat("a", 75), strings.Repeat("a", 75) + "\r\n"},
+		{"folded", strings.Repeat("a", 80), strings.Repeat("a", 75) + "\r\n " + strings.Repeat("a", 5) + "\r\n"},
+		// a multi-byte character is never split across lines
+		{"multi-byte", strings.Repeat("a", 74) + "é", strings.Repeat("a", 74) + "\r\n é\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := vcardFold(tt.line); got != tt.want {
+				t.Errorf("vcardFold = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVCardImportReader(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []map[string]string
+	}{
+		{
+			"version 3.0",
+			"BEGIN:VCARD\r\nVERSION:3.0\r\nFN:Jane Doe\r\nEMAIL;TYPE=INTERNET:jane@example.com\r\n" +
+				"TEL;TYPE=WORK:+1 555 0100\r\nORG:Acme\\, Inc;Sales\r\nEND:VCARD\r\n",
+			[]map[string]string{{"name": "Jane Doe", "email": "jane@example.com", "phone": "+1 555 0100", "company": "Acme, Inc"}},
+		},
+		{
+			"version 4.0 with a tel uri and grouped properties",
+			"BEGIN:VCARD\nVERSION:4.0\nitem1.FN:Jane Doe\nTEL;VALUE=uri:tel:+1-555-0100\nEND:VCARD\n",
+			[]map[string]string{{"name": "Jane Doe", "email": "", "phone": "+1-555-0100", "company": ""}},
+		},
+		{
+			"name from N without FN",
+			"BEGIN:VCARD\nVERSION:2.1\nN:Doe;Jane;;;\nEND:VCARD\n",
+			[]map[string]string{{"name": "Jane Doe", "email": "", "phone": "", "company": ""}},
+		},
+		{
+			"preferred email wins",
+			"BEGIN:VCARD\nFN:Jane\nEMAIL:home@example.com\nEMAIL;TYPE=INTERNET,PREF:work@example.com\nEMAIL:other@example.com\nEND:VCARD\n",
+			[]map[string]string{{"name": "Jane", "email": "work@example.com", "phone": "", "company": ""}},
+		},
+		{
+			"folded lines",
+			"BEGIN:VCARD\r\nFN:Jane\r\n  Doe\r\nORG:Acme Wid\r\n\tgets\r\nEND:VCARD\r\n",
+			[]map[string]string{{"name": "Jane Doe", "email": "", "phone": "", "company": "Acme Widgets"}},
+		},
+		{
+			"several cards",
+			"BEGIN:VCARD\nFN:Jane\nEND:VCARD\n\nBEGIN:VCARD\nFN:John\nEND:VCARD\n",
+			[]map[string]string{
+				{"name": "Jane", "email": "", "phone": "", "company": ""},
+				{"name": "John", "email": "", "phone": "", "company": ""},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := newVCardImportReader(strings.NewReader(tt.input))
+			var got []map[string]string
+			for {
+				record, err := reader.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, record)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("records = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	reader := newVCardImportReader(strings.NewReader("BEGIN:VCARD\nFN:Jane\n"))
+	if _, err := reader.Next(); err == nil || err == io.EOF {
+		t.Errorf("Next on a card without END:VCARD = %v, want an error", err)
+	}
+}
+
+func TestVCardRoundTrip(t *testing.T) {
+	record := bson.D{
+		{Key: "name", Value: "Jane Doe"},
+		{Key: "email", Value: "jane@example.com"},
+		{Key: "phone", Value: "+1 555 0100"},
+		{Key: "company", Value: strings.Repeat("Acme; Sons, ", 10) + "Inc"},
+	}
+	for _, version := range []string{VCARD_V3, VCARD_V4} {
+		t.Run(version, func(t *testing.T) {
+			var out strings.Builder
+			writer, _ := NewVCardWriter(&out, version)
+			if err := writer.WriteRecord(record); err != nil {
+				t.Fatal(err)
+			}
+			writer.Close()
+
+			got, err := newVCardImportReader(strings.NewReader(out.String())).Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, field := range record {
+				if got[field.Key] != field.Value {
+					t.Errorf("%s = %q, want %q", field.Key, got[field.Key], field.Value)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,249 @@
+package helpers
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// xlsxDateFormat : number format of date cells in exported workbooks
+	xlsxDateFormat = "yyyy-mm-dd hh:mm:ss"
+	// xlsxTextFormat : built in "@" format, keeps Excel from turning phone numbers into numbers
+	xlsxTextFormat  = 49
+	xlsxColumnWidth = 20
+)
+
+// XLSXWorkbook : Excel workbook streamed one sheet after the other, written to w on Close
+type XLSXWorkbook struct {
+	file      *excelize.File
+	w         io.Writer
+	sheets    int
+	current   *xlsxExportWriter
+	headStyle int
+	dateStyle int
+	textStyle int
+}
+
+// NewXLSXWorkbook : empty workbook that will be written to w
+func NewXLSXWorkbook(w io.Writer) (*XLSXWorkbook, error) {
+	file := excelize.NewFile()
+	book := &XLSXWorkbook{file: file, w: w}
+
+	var err error
+	if book.headStyle, err = file.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}}); err != nil {
+		return nil, err
+	}
+	dateFormat := xlsxDateFormat
+	if book.dateStyle, err = file.NewStyle(&excelize.Style{CustomNumFmt: &dateFormat}); err != nil {
+		return nil, err
+	}
+	if book.textStyle, err = file.NewStyle(&excelize.Style{NumFmt: xlsxTextFormat}); err != nil {
+		return nil, err
+	}
+	return book, nil
+}
+
+// AddSheet : start a new sheet called name with a header row of columns.
+// The previous sheet is finished, so sheets must be written one at a time.
+func (b *XLSXWorkbook) AddSheet(name string, columns []string) (ExportWriter, error) {
+	if err := b.finishSheet(); err != nil {
+		return nil, err
+	}
+
+	// a new file starts with an empty Sheet1, which becomes the first sheet
+	if b.sheets == 0 {
+		if err := b.file.SetSheetName(b.file.GetSheetList()[0], name); err != nil {
+			return nil, err
+		}
+	} else if _, err := b.file.NewSheet(name); err != nil {
+		return nil, err
+	}
+	b.sheets++
+
+	stream, err := b.file.NewStreamWriter(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.SetColWidth(1, len(columns), xlsxColumnWidth); err != nil {
+		return nil, err
+	}
+	header := make([]interface{}, len(columns))
+	for i, column := range columns {
+		header[i] = excelize.Cell{StyleID: b.headStyle, Value: column}
+	}
+	if err := stream.SetRow("A1", header); err != nil {
+		return nil, err
+	}
+
+	b.current = &xlsxExportWriter{book: b, stream: stream, row: 1}
+	return b.current, nil
+}
+
+// Close : finish the last sheet and write the workbook
+func (b *XLSXWorkbook) Close() error {
+	if err := b.finishSheet(); err != nil {
+		return err
+	}
+	return b.file.Write(b.w)
+}
+
+func (b *XLSXWorkbook) finishSheet() error {
+	if b.current == nil {
+		return nil
+	}
+	err := b.current.stream.Flush()
+	b.current = nil
+	return err
+}
+
+// xlsxExportWriter : rows of one workbook sheet, dates become date cells and text stays text
+type xlsxExportWriter struct {
+	book   *XLSXWorkbook
+	stream *excelize.StreamWriter
+	row    int
+	// single sheet writers own their workbook and write it on Close
+	owner bool
+}
+
+func (w *xlsxExportWriter) WriteRecord(record bson.D) error {
+	row := make([]interface{}, len(record))
+	for i, field := range record {
+		if at, ok := exportTime(field.Value); ok {
+			row[i] = excelize.Cell{StyleID: w.book.dateStyle, Value: at}
+			continue
+		}
+		switch v := exportValue(field.Value).(type) {
+		case string:
+			row[i] = excelize.Cell{StyleID: w.book.textStyle, Value: v}
+		default:
+			row[i] = v
+		}
+	}
+
+	w.row++
+	cell, err := excelize.CoordinatesToCellName(1, w.row)
+	if err != nil {
+		return err
+	}
+	return w.stream.SetRow(cell, row)
+}
+
+func (w *xlsxExportWriter) Close() error {
+	if w.owner {
+		return w.book.Close()
+	}
+	if w.book.current != w {
+		return nil
+	}
+	return w.book.finishSheet()
+}
+
+// exportTime : value as a UTC time when it is a date, unset dates are left empty
+func exportTime(value interface{}) (time.Time, bool) {
+	switch v := value.(type) {
+	case time.Time:
+		return v.UTC(), !v.IsZero()
+	case *time.Time:
+		if v == nil {
+			return time.Time{}, false
+		}
+		return v.UTC(), !v.IsZero()
+	case primitive.DateTime:
+		return v.Time().UTC(), true
+	}
+	return time.Time{}, false
+}
+
+// newXLSXExportWriter : single sheet workbook called name, written to w on Close
+func newXLSXExportWriter(w io.Writer, name string, columns []string) (ExportWriter, error) {
+	book, err := NewXLSXWorkbook(w)
+	if err != nil {
+		return nil, err
+	}
+	writer, err := book.AddSheet(name, columns)
+	if err != nil {
+		return nil, err
+	}
+	writer.(*xlsxExportWriter).owner = true
+	return writer, nil
+}
+
+// xlsxImportReader : rows of the first sheet of a workbook, the first row is the header.
+// Cells are read unformatted, so dates arrive as Excel serial numbers (see ExcelTime).
+type xlsxImportReader struct {
+	file   *excelize.File
+	rows   *excelize.Rows
+	header []string
+}
+
+func newXLSXImportReader(r io.Reader) (ImportReader, error) {
+	file, err := excelize.OpenReader(r, excelize.Options{RawCellValue: true})
+	if err != nil {
+		return nil, fmt.Errorf("reading XLSX: %w", err)
+	}
+	sheets := file.GetSheetList()
+	if len(sheets) == 0 {
+		return nil, fmt.Errorf("XLSX workbook has no sheets")
+	}
+	rows, err := file.Rows(sheets[0])
+	if err != nil {
+		return nil, err
+	}
+	if !rows.Next() {
+		return nil, fmt.Errorf("reading XLSX header: %w", io.EOF)
+	}
+	header, err := rows.Columns(excelize.Options{RawCellValue: true})
+	if err != nil {
+		return nil, err
+	}
+	for i := range header {
+		header[i] = importHeader(header[i])
+	}
+	return &xlsxImportReader{file: file, rows: rows, header: header}, nil
+}
+
+func (r *xlsxImportReader) Next() (map[string]string, error) {
+	for r.rows.Next() {
+		cells, err := r.rows.Columns(excelize.Options{RawCellValue: true})
+		if err != nil {
+			return nil, err
+		}
+		// Excel keeps formatted but empty rows around, they are not records
+		if len(strings.Join(cells, "")) == 0 {
+			continue
+		}
+		record := make(map[string]string, len(r.header))
+		for i, column := range r.header {
+			if i < len(cells) {
+				record[column] = strings.TrimSpace(cells[i])
+			} else {
+				record[column] = ""
+			}
+		}
+		return record, nil
+	}
+	if err := r.rows.Error(); err != nil {
+		return nil, err
+	}
+	r.rows.Close()
+	r.file.Close()
+	return nil, io.EOF
+}
+
+// ExcelTime : time of an Excel serial date such as 45293.5, as read from XLSX date cells
+func ExcelTime(value string) (time.Time, bool) {
+	serial, err := strconv.ParseFloat(value, 64)
+	// serials before 1900-03-01 are ambiguous in Excel, later than 9999 are invalid
+	if err != nil || serial < 61 || serial > 2958465 {
+		return time.Time{}, false
+	}
+	at, err := excelize.ExcelDateToTime(serial, false)
+	return at, err == nil
+}
@@ -0,0 +1,140 @@
+package helpers
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestXLSXWorkbook(t *testing.T) {
+	var out bytes.Buffer
+	book, err := NewXLSXWorkbook(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	customers, err := book.AddSheet("customers", []string{"name", "phone", "created_at"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := time.Date(2024, 6, 1, 10, 7, 30, 0, time.UTC)
+	if err := customers.WriteRecord(bson.D{{Key: "name", Value: "Jane"}, {Key: "phone", Value: "0123"}, {Key: "created_at", Value: created}}); err != nil {
+		t.Fatal(err)
+	}
+	tickets, err := book.AddSheet("tickets", []string{"title", "priority"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tickets.WriteRecord(bson.D{{Key: "title", Value: "Broken"}, {Key: "priority", Value: 2}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := book.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := excelize.OpenReader(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if got, want := file.GetSheetList(), []string{"customers", "tickets"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("sheets = %v, want %v", got, want)
+	}
+
+	rows, err := file.GetRows("customers", excelize.Options{RawCellValue: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || !reflect.DeepEqual(rows[0], []string{"name", "phone", "created_at"}) {
+		t.Fatalf("customers rows = %v", rows)
+	}
+	// leading zeros of text cells are kept
+	if rows[1][1] != "0123" {
+		t.Errorf("phone = %q, want 0123", rows[1][1])
+	}
+	// dates are date cells, read back as serial numbers
+	if at, ok := ExcelTime(rows[1][2]); !ok || !at.Equal(created) {
+		t.Errorf("created_at = %q (%s), want %s", rows[1][2], at, created)
+	}
+
+	rows, err = file.GetRows("tickets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]string{{"title", "priority"}, {"Broken", "2"}}; !reflect.DeepEqual(rows, want) {
+		t.Errorf("tickets rows = %v, want %v", rows, want)
+	}
+}
+
+func TestXLSXImportReader(t *testing.T) {
+	file := excelize.NewFile()
+	sheet := file.GetSheetList()[0]
+	for cell, value := range map[string]interface{}{
+		"A1": " Name ", "B1": "EMAIL", "C1": "Created_At",
+		"A2": "Jane", "B2": "jane@example.com", "C2": 45293.5,
+		// row 3 is left empty and skipped
+		"A4": "John",
+	} {
+		if err := file.SetCellValue(sheet, cell, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var data bytes.Buffer
+	if err := file.Write(&data); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := NewImportReader(FORMAT_XLSX, &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []map[string]string{
+		{"name": "Jane", "email": "jane@example.com", "created_at": "45293.5"},
+		{"name": "John", "email": "", "created_at": ""},
+	}
+	var got []map[string]string
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, record)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("records = %v, want %v", got, want)
+	}
+
+	if _, err := NewImportReader(FORMAT_XLSX, bytes.NewReader([]byte("name,email\n"))); err == nil {
+		t.Error("reading a CSV file as XLSX succeeded, want an error")
+	}
+}
+
+func TestExcelTime(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Time
+		ok    bool
+	}{
+		{"45293", time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), true},
+		{"45293.5", time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC), true},
+		{"61", time.Date(1900, 3, 1, 0, 0, 0, 0, time.UTC), true},
+		// before 1900-03-01 Excel counts a 29 February 1900 that never was
+		{"60", time.Time{}, false},
+		{"2958466", time.Time{}, false},
+		{"2024-01-02", time.Time{}, false},
+		{"", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := ExcelTime(tt.value)
+		if ok != tt.ok || !got.Equal(tt.want) {
+			t.Errorf("ExcelTime(%q) = %s, %v, want %s, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"os"
 
 	"github.com/gin-gonic/gin"
+	controller "github.com/nirmal/crm/controllers"
+	"github.com/nirmal/crm/database"
 	"github.com/nirmal/crm/middleware"
 	"github.com/nirmal/crm/routes"
 )
@@ -11,6 +13,14 @@ import (
 // Starting point of the application
 func main() {
 
+	// fail fast when MongoDB is not configured or not reachable
+	database.CheckConnection()
+
+	// maintenance commands such as backup and restore run instead of the server
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
 	PORT := os.Getenv("PORT")
 
 	// if PORT is not set, use default port 8080
@@ -20,6 +30,8 @@ func main() {
 	router := gin.New()
 
 	router.Use(middleware.RateLimiterMiddleware())
+	// middleware to tag each request with an id for the audit trail
+	router.Use(middleware.RequestIdMiddleware())
 	// middleware to log all requests on console
 	router.Use(gin.Logger()) 
 
@@ -35,6 +47,68 @@ func main() {
 	//export/import data in csv or json format
     routes.DataExpImportRoutes(router)
 
+	// audit trail query routes
+	routes.AuditRoutes(router)
+
+	// organization (tenant) management routes
+	routes.OrganizationRoutes(router)
+
+	// trash and restore routes for soft deleted records
+	routes.TrashRoutes(router)
+
+	// background import/export job routes
+	routes.JobRoutes(router)
+
+	// backup and restore of all CRM collections
+	routes.BackupRoutes(router)
+
+	// custom field definitions of customers, tickets and interactions
+	routes.CustomFieldRoutes(router)
+
+	// accounts (companies) and their contacts
+	routes.AccountRoutes(router)
+
+	// sales pipelines and deals
+	routes.DealRoutes(router)
+
+	// revenue forecast, quotas and forecast snapshots
+	routes.ForecastRoutes(router)
+
+	// customer tags and segments
+	routes.SegmentRoutes(router)
+
+	// staff notes on customers, accounts and deals
+	routes.NoteRoutes(router)
+
+	// leads, their lifecycle and conversion
+	routes.LeadRoutes(router)
+
+	// lead scoring rules and score breakdowns
+	routes.ScoringRoutes(router)
+
+	// customer health scores, their settings and notifications
+	routes.HealthRoutes(router)
+	// duplicate customers, dismissing and merging them
+	routes.DuplicateRoutes(router)
+
+	// hard delete trashed records once their retention period is over
+	controller.StartTrashPurgeJob()
+
+	// remove expired job results
+	controller.StartJobCleanupJob()
+
+	// run the scheduled exports when they are due
+	controller.StartExportScheduler()
+
+	// take the weekly forecast snapshots
+	controller.StartForecastSnapshotJob()
+
+	// recompute lead scores nightly, so decay and recency windows stay current
+	controller.StartLeadScoringJob()
+
+	// compute the daily customer health scores and alert on at-risk customers
+	controller.StartHealthScoreJob()
+
 	// Run the server on PORT
 	router.Run(":"+PORT)
 }
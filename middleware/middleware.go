@@ -5,6 +5,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	helper "github.com/nirmal/crm/helpers"
+	"github.com/nirmal/crm/utils"
 )
 
 // AuthenticateUser - middleware to authenticate user
@@ -24,11 +25,18 @@ func AuthenticateUser() gin.HandlerFunc {
 			c.Abort()
 			return
 		}
+		// a customer token signed with the same key parses too, but never carries a role
+		if claims.Role == "" {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "not a user token"})
+			c.Abort()
+			return
+		}
         // set claims in context
 		c.Set("email", claims.Email)
 		c.Set("name", claims.Name)
 		c.Set("role", claims.Role)
 		c.Set("uid", claims.Uid)
+		c.Set(utils.ORG_ID, claims.OrgId)
         // continue
 		c.Next()
 	}
@@ -51,11 +59,53 @@ func AuthenticateCustomer() gin.HandlerFunc {
 			c.Abort()
 			return
 		}
+		// a user token signed with the same key parses too, but never carries a customer id
+		if claims.Cid == "" {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "not a customer token"})
+			c.Abort()
+			return
+		}
 		// set claims in context
 		c.Set("cid", claims.Cid)
 		c.Set("email", claims.Email)
 		c.Set("name", claims.Name)
+		c.Set(utils.ORG_ID, claims.OrgId)
         // continue
 		c.Next()
 	}
 }
+
+// AuthenticateUserOrCustomer - middleware for routes shared by staff and customers, a user
+// token sets the user's claims and role, any other token must be a customer token
+func AuthenticateUserOrCustomer() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// get token from header
+		clientToken := c.Request.Header.Get("token")
+		if clientToken == "" {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "No Authorization header found"})
+			c.Abort()
+			return
+		}
+		// user tokens always carry a role, customer tokens never do
+		if claims, err := helper.ValidateUserToken(clientToken); err == "" && claims.Role != "" {
+			c.Set("email", claims.Email)
+			c.Set("name", claims.Name)
+			c.Set("role", claims.Role)
+			c.Set("uid", claims.Uid)
+			c.Set(utils.ORG_ID, claims.OrgId)
+			c.Next()
+			return
+		}
+		claims, err := helper.ValidateCustomerToken(clientToken)
+		if err != "" {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err})
+			c.Abort()
+			return
+		}
+		c.Set("cid", claims.Cid)
+		c.Set("email", claims.Email)
+		c.Set("name", claims.Name)
+		c.Set(utils.ORG_ID, claims.OrgId)
+		c.Next()
+	}
+}
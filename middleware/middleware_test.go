@@ -0,0 +1,124 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	helper "github.com/nirmal/crm/helpers"
+	"github.com/nirmal/crm/utils"
+)
+
+// withKeys : sign and check staff and customer tokens with userKey and customerKey until the
+// test ends, returning a staff and a customer token
+func withKeys(t *testing.T, userKey, customerKey string) (adminToken, customerToken string) {
+	t.Helper()
+	savedUserKey, savedCustomerKey := helper.USER_SECRET_KEY, helper.CUSTOMER_SECRET_KEY
+	helper.USER_SECRET_KEY, helper.CUSTOMER_SECRET_KEY = userKey, customerKey
+	t.Cleanup(func() { helper.USER_SECRET_KEY, helper.CUSTOMER_SECRET_KEY = savedUserKey, savedCustomerKey })
+
+	adminToken, err := helper.GenerateUserToken("admin@example.com", "Admin", "u1", utils.ROLE_ADMIN, "org1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	customerToken, err = helper.GenerateCustomerToken("jane@example.com", "Jane", "c1", "org1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return adminToken, customerToken
+}
+
+func TestAuthenticateUserOrCustomer(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	// docker-compose signs both kinds of token with one key, they must still be told apart
+	for _, keys := range []struct{ name, user, customer string }{
+		{"separate keys", "user-secret", "customer-secret"},
+		{"shared key", "secret", "secret"},
+	} {
+		t.Run(keys.name, func(t *testing.T) {
+			testAuthenticateUserOrCustomer(t, keys.user, keys.customer)
+		})
+	}
+}
+
+func testAuthenticateUserOrCustomer(t *testing.T, userKey, customerKey string) {
+	adminToken, customerToken := withKeys(t, userKey, customerKey)
+
+	router := gin.New()
+	router.GET("/customers/:customer_id", AuthenticateUserOrCustomer(), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"role": c.GetString("role"), "uid": c.GetString("uid"), "cid": c.GetString("cid"), "org_id": c.GetString(utils.ORG_ID)})
+	})
+
+	tests := []struct {
+		name   string
+		token  string
+		status int
+		want   map[string]string
+	}{
+		{"staff token", adminToken, http.StatusOK, map[string]string{"role": utils.ROLE_ADMIN, "uid": "u1", "cid": "", "org_id": "org1"}},
+		{"customer token", customerToken, http.StatusOK, map[string]string{"role": "", "uid": "", "cid": "c1", "org_id": "org1"}},
+		{"no token", "", http.StatusInternalServerError, nil},
+		{"invalid token", "not-a-token", http.StatusInternalServerError, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/customers/c1", nil)
+			if tt.token != "" {
+				req.Header.Set("token", tt.token)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.status, w.Body.String())
+			}
+			if tt.want == nil {
+				return
+			}
+			var got map[string]string
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			for key, value := range tt.want {
+				if got[key] != value {
+					t.Errorf("%s = %q, want %q", key, got[key], value)
+				}
+			}
+		})
+	}
+}
+
+func TestAuthenticateWithSharedKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	adminToken, customerToken := withKeys(t, "secret", "secret")
+
+	router := gin.New()
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	router.GET("/users", AuthenticateUser(), ok)
+	router.GET("/portal", AuthenticateCustomer(), ok)
+
+	tests := []struct {
+		name   string
+		url    string
+		token  string
+		status int
+	}{
+		{"staff token on staff route", "/users", adminToken, http.StatusOK},
+		{"customer token on staff route", "/users", customerToken, http.StatusInternalServerError},
+		{"customer token on customer route", "/portal", customerToken, http.StatusOK},
+		{"staff token on customer route", "/portal", adminToken, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			req.Header.Set("token", tt.token)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d (%s)", w.Code, tt.status, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/nirmal/crm/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RequestIdMiddleware - tags every request with an ID so audit entries can be correlated
+func RequestIdMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// reuse the caller's request id if one was sent
+		requestId := c.Request.Header.Get("X-Request-ID")
+		if requestId == "" {
+			requestId = primitive.NewObjectID().Hex()
+		}
+
+		c.Set(utils.REQUEST_ID, requestId)
+		c.Header("X-Request-ID", requestId)
+		c.Next()
+	}
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Account model : Company that customers belong to as its contacts
+type Account struct {
+	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	AccountId       string             `bson:"account_id" json:"account_id"`
+	OrgId           string             `bson:"org_id" json:"org_id"`
+	Name            *string            `bson:"name" json:"name" validate:"required,max=200"`
+	Domain          *string            `bson:"domain,omitempty" json:"domain,omitempty" validate:"omitempty,fqdn"`
+	Industry        *string            `bson:"industry,omitempty" json:"industry,omitempty"`
+	Address         *Address           `bson:"address,omitempty" json:"address,omitempty"`
+	ParentAccountId *string            `bson:"parent_account_id,omitempty" json:"parent_account_id,omitempty"`
+	OwnerId         *string            `bson:"owner_id,omitempty" json:"owner_id,omitempty"`
+	CreatedAt       time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt       time.Time          `bson:"updated_at" json:"updated_at"`
+	Version         int64              `bson:"version" json:"version"`
+	DeletedAt       *time.Time         `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+	DeletedBy       string             `bson:"deleted_by,omitempty" json:"deleted_by,omitempty"`
+}
+
+// Address : postal address of an account
+type Address struct {
+	Street     string `bson:"street,omitempty" json:"street,omitempty"`
+	City       string `bson:"city,omitempty" json:"city,omitempty"`
+	State      string `bson:"state,omitempty" json:"state,omitempty"`
+	PostalCode string `bson:"postal_code,omitempty" json:"postal_code,omitempty"`
+	Country    string `bson:"country,omitempty" json:"country,omitempty"`
+}
+
+// AccountSummary : roll-up of an account's contacts, interactions and tickets
+type AccountSummary struct {
+	AccountId       string           `json:"account_id"`
+	Name            string           `json:"name"`
+	AccountIds      []string         `json:"account_ids"`
+	Contacts        int64            `json:"contacts"`
+	Interactions    int64            `json:"interactions"`
+	LastInteraction *time.Time       `json:"last_interaction,omitempty"`
+	Tickets         int64            `json:"tickets"`
+	TicketsByStatus map[string]int64 `json:"tickets_by_status"`
+}
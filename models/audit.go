@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AuditLog model : Append-only record of a data change or privileged action
+type AuditLog struct {
+	ID         primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
+	AuditId    string                 `bson:"audit_id" json:"audit_id"`
+	OrgId      string                 `bson:"org_id" json:"org_id"`
+	ActorId    string                 `bson:"actor_id" json:"actor_id"`
+	ActorType  string                 `bson:"actor_type" json:"actor_type"`
+	ActorRole  string                 `bson:"actor_role,omitempty" json:"actor_role,omitempty"`
+	Action     string                 `bson:"action" json:"action"`
+	Resource   string                 `bson:"resource" json:"resource"`
+	ResourceId string                 `bson:"resource_id,omitempty" json:"resource_id,omitempty"`
+	Changes    map[string]FieldChange `bson:"changes,omitempty" json:"changes,omitempty"`
+	RequestId  string                 `bson:"request_id,omitempty" json:"request_id,omitempty"`
+	ClientIP   string                 `bson:"client_ip,omitempty" json:"client_ip,omitempty"`
+	CreatedAt  time.Time              `bson:"created_at" json:"created_at"`
+}
+
+// FieldChange : previous and new value of a single field
+type FieldChange struct {
+	From interface{} `bson:"from" json:"from"`
+	To   interface{} `bson:"to" json:"to"`
+}
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// CustomField model : Admin defined field of customers, tickets or interactions, values are stored
+// under custom_fields.<key> on the documents
+type CustomField struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	FieldId   string             `bson:"field_id" json:"field_id"`
+	OrgId     string             `bson:"org_id" json:"org_id"`
+	Resource  string             `bson:"resource" json:"resource" validate:"required,oneof=customers tickets interactions"`
+	Key       string             `bson:"key" json:"key" validate:"required,max=64"`
+	Label     *string            `bson:"label" json:"label" validate:"required"`
+	Type      string             `bson:"type" json:"type" validate:"required,oneof=text number date enum boolean"`
+	Required  bool               `bson:"required" json:"required"`
+	Options   []string           `bson:"options,omitempty" json:"options,omitempty" validate:"required_if=Type enum"`
+	Min       *float64           `bson:"min,omitempty" json:"min,omitempty"`
+	Max       *float64           `bson:"max,omitempty" json:"max,omitempty"`
+	Pattern   string             `bson:"pattern,omitempty" json:"pattern,omitempty"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
+}
@@ -8,14 +8,39 @@ import (
 
 // Customer model : Customer related fields
 type Customer struct {
-	CustomerId string             `bson:"customer_id" json:"customer_id"`
-	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	Name       *string            `bson:"name" json:"name" validate:"required"`
-	Email      *string            `bson:"email" json:"email" validate:"email,required"`
-	Password   *string            `bson:"password" json:"password" validate:"required,min=2,max=100"`
-	Company    *string            `bson:"company,omitempty" json:"company,omitempty"`
-	Phone      *string            `bson:"phone,omitempty" json:"phone,omitempty"`
-	Token      *string            `bson:"token,omitempty" json:"token,omitempty"`
-	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
-	UpdatedAt  time.Time          `bson:"updated_at" json:"updated_at"`
+	CustomerId   string                 `bson:"customer_id" json:"customer_id"`
+	OrgId        string                 `bson:"org_id" json:"org_id"`
+	ID           primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
+	Name         *string                `bson:"name" json:"name" validate:"required"`
+	Email        *string                `bson:"email" json:"email" validate:"email,required"`
+	Password     *string                `bson:"password" json:"password" validate:"required,min=2,max=100"`
+	Company      *string                `bson:"company,omitempty" json:"company,omitempty"`
+	Phone        *string                `bson:"phone,omitempty" json:"phone,omitempty"`
+	ExternalId   *string                `bson:"external_id,omitempty" json:"external_id,omitempty"`
+	AccountId    *string                `bson:"account_id,omitempty" json:"account_id,omitempty"`
+	Tags         []string               `bson:"tags,omitempty" json:"tags,omitempty"`
+	Lifecycle    string                 `bson:"lifecycle,omitempty" json:"lifecycle,omitempty"`
+	History      []LifecycleChange      `bson:"lifecycle_history,omitempty" json:"lifecycle_history,omitempty"`
+	LeadScore    *int                   `bson:"lead_score,omitempty" json:"lead_score,omitempty"`
+	ScoredAt     *time.Time             `bson:"scored_at,omitempty" json:"scored_at,omitempty"`
+	HealthScore  *int                   `bson:"health_score,omitempty" json:"health_score,omitempty"`
+	MergedFrom   []string               `bson:"merged_from,omitempty" json:"merged_from,omitempty"`
+	MergedInto   string                 `bson:"merged_into,omitempty" json:"merged_into,omitempty"`
+	CustomFields map[string]interface{} `bson:"custom_fields,omitempty" json:"custom_fields,omitempty"`
+	Token        *string                `bson:"token,omitempty" json:"token,omitempty"`
+	CreatedAt    time.Time              `bson:"created_at" json:"created_at"`
+	UpdatedAt    time.Time              `bson:"updated_at" json:"updated_at"`
+	Version      int64                  `bson:"version" json:"version"`
+	DeletedAt    *time.Time             `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+	DeletedBy    string                 `bson:"deleted_by,omitempty" json:"deleted_by,omitempty"`
+	Notes        []Note                 `bson:"-" json:"notes,omitempty"`
+}
+
+// LifecycleChange : move of a customer from one lifecycle stage to another
+type LifecycleChange struct {
+	From      string    `bson:"from,omitempty" json:"from,omitempty"`
+	To        string    `bson:"to" json:"to"`
+	Reason    string    `bson:"reason,omitempty" json:"reason,omitempty"`
+	ChangedBy string    `bson:"changed_by" json:"changed_by"`
+	ChangedAt time.Time `bson:"changed_at" json:"changed_at"`
 }
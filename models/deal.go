@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Deal model : revenue opportunity with a customer or account, owned by a user
+type Deal struct {
+	ID                primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	DealId            string             `bson:"deal_id" json:"deal_id"`
+	OrgId             string             `bson:"org_id" json:"org_id"`
+	Title             *string            `bson:"title" json:"title" validate:"required,max=200"`
+	CustomerId        *string            `bson:"customer_id,omitempty" json:"customer_id,omitempty"`
+	AccountId         *string            `bson:"account_id,omitempty" json:"account_id,omitempty"`
+	OwnerId           string             `bson:"owner_id" json:"owner_id"`
+	PipelineId        string             `bson:"pipeline_id" json:"pipeline_id"`
+	StageId           string             `bson:"stage_id" json:"stage_id"`
+	Status            string             `bson:"status" json:"status"`
+	Amount            float64            `bson:"amount" json:"amount" validate:"min=0"`
+	Currency          string             `bson:"currency" json:"currency" validate:"omitempty,len=3,alpha"`
+	ExpectedCloseDate *time.Time         `bson:"expected_close_date,omitempty" json:"expected_close_date,omitempty"`
+	Probability       *int               `bson:"probability" json:"probability" validate:"omitempty,min=0,max=100"`
+	CloseReason       *string            `bson:"close_reason,omitempty" json:"close_reason,omitempty"`
+	ClosedAt          *time.Time         `bson:"closed_at,omitempty" json:"closed_at,omitempty"`
+	StageHistory      []DealStageChange  `bson:"stage_history" json:"stage_history"`
+	CreatedAt         time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt         time.Time          `bson:"updated_at" json:"updated_at"`
+	Version           int64              `bson:"version" json:"version"`
+	DeletedAt         *time.Time         `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+	DeletedBy         string             `bson:"deleted_by,omitempty" json:"deleted_by,omitempty"`
+}
+
+// DealStageChange : move of a deal between stages, the first entry is the stage it was created in
+type DealStageChange struct {
+	FromStageId string    `bson:"from_stage_id,omitempty" json:"from_stage_id,omitempty"`
+	ToStageId   string    `bson:"to_stage_id" json:"to_stage_id"`
+	Reason      string    `bson:"reason,omitempty" json:"reason,omitempty"`
+	ChangedBy   string    `bson:"changed_by" json:"changed_by"`
+	ChangedAt   time.Time `bson:"changed_at" json:"changed_at"`
+}
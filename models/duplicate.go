@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DuplicatePair : two customers suspected to be the same person, the older one first, with
+// how alike they are from 0 to 1 and what matched
+type DuplicatePair struct {
+	Customers []Customer `json:"customers"`
+	Score     float64    `json:"score"`
+	Reasons   []string   `json:"reasons"`
+}
+
+// DuplicateDismissal model : pair of customers reviewed and found to be different people,
+// never suggested as duplicates again
+type DuplicateDismissal struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	OrgId       string             `bson:"org_id" json:"org_id"`
+	Pair        string             `bson:"pair" json:"pair"`
+	CustomerIds []string           `bson:"customer_ids" json:"customer_ids"`
+	DismissedBy string             `bson:"dismissed_by" json:"dismissed_by"`
+	DismissedAt time.Time          `bson:"dismissed_at" json:"dismissed_at"`
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ExportSchedule model : Recurring export of an entity to the export directory, run on a cron expression
+type ExportSchedule struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	ScheduleId string             `bson:"schedule_id" json:"schedule_id"`
+	OrgId      string             `bson:"org_id" json:"org_id"`
+	Name       *string            `bson:"name" json:"name" validate:"required"`
+	Cron       *string            `bson:"cron" json:"cron" validate:"required"`
+	Timezone   string             `bson:"timezone" json:"timezone"`
+	Resource   string             `bson:"resource" json:"resource" validate:"required,oneof=customers users tickets interactions"`
+	Format     string             `bson:"format" json:"format" validate:"required,oneof=csv json ndjson xlsx"`
+	Filter     map[string]string  `bson:"filter,omitempty" json:"filter,omitempty"`
+	Compress   bool               `bson:"compress" json:"compress"`
+	Retain     int                `bson:"retain" json:"retain" validate:"gte=0"`
+	Enabled    *bool              `bson:"enabled" json:"enabled"`
+	CreatedBy  string             `bson:"created_by" json:"created_by"`
+	NextRunAt  *time.Time         `bson:"next_run_at,omitempty" json:"next_run_at,omitempty"`
+	LastRunAt  *time.Time         `bson:"last_run_at,omitempty" json:"last_run_at,omitempty"`
+	LastStatus string             `bson:"last_status,omitempty" json:"last_status,omitempty"`
+	LastError  string             `bson:"last_error,omitempty" json:"last_error,omitempty"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt  time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// ExportRun model : One run of an export schedule and the file it wrote
+type ExportRun struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	RunId      string             `bson:"run_id" json:"run_id"`
+	ScheduleId string             `bson:"schedule_id" json:"schedule_id"`
+	OrgId      string             `bson:"org_id" json:"org_id"`
+	Status     string             `bson:"status" json:"status"`
+	File       string             `bson:"file,omitempty" json:"file,omitempty"`
+	Records    int64              `bson:"records" json:"records"`
+	Error      string             `bson:"error,omitempty" json:"error,omitempty"`
+	Pruned     bool               `bson:"pruned,omitempty" json:"pruned,omitempty"`
+	StartedAt  time.Time          `bson:"started_at" json:"started_at"`
+	FinishedAt *time.Time         `bson:"finished_at,omitempty" json:"finished_at,omitempty"`
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Quota model : revenue target of an owner, a team or (with neither) the whole organization for a period
+type Quota struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	QuotaId   string             `bson:"quota_id" json:"quota_id"`
+	OrgId     string             `bson:"org_id" json:"org_id"`
+	OwnerId   string             `bson:"owner_id,omitempty" json:"owner_id,omitempty" validate:"excluded_with=Team"`
+	Team      string             `bson:"team,omitempty" json:"team,omitempty"`
+	Period    string             `bson:"period" json:"period" validate:"required"`
+	Amount    float64            `bson:"amount" json:"amount" validate:"min=0"`
+	Currency  string             `bson:"currency" json:"currency" validate:"omitempty,len=3,alpha"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// ForecastRow : forecast of one owner, team or the organization for a period, in one currency
+type ForecastRow struct {
+	Period     string   `bson:"period" json:"period"`
+	OwnerId    string   `bson:"owner_id,omitempty" json:"owner_id,omitempty"`
+	Team       string   `bson:"team,omitempty" json:"team,omitempty"`
+	Currency   string   `bson:"currency" json:"currency"`
+	Deals      int      `bson:"deals" json:"deals"`
+	Won        float64  `bson:"won" json:"won"`
+	Pipeline   float64  `bson:"pipeline" json:"pipeline"`
+	Weighted   float64  `bson:"weighted" json:"weighted"`
+	Committed  float64  `bson:"committed" json:"committed"`
+	BestCase   float64  `bson:"best_case" json:"best_case"`
+	Quota      *float64 `bson:"quota,omitempty" json:"quota,omitempty"`
+	Attainment *float64 `bson:"attainment,omitempty" json:"attainment,omitempty"`
+}
+
+// ForecastSnapshot model : monthly forecast rows of every owner of an organization as they
+// stood in one week, kept to report how the forecast drifted
+type ForecastSnapshot struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	SnapshotId string             `bson:"snapshot_id" json:"snapshot_id"`
+	OrgId      string             `bson:"org_id" json:"org_id"`
+	Week       string             `bson:"week" json:"week"`
+	TakenAt    time.Time          `bson:"taken_at" json:"taken_at"`
+	Rows       []ForecastRow      `bson:"rows" json:"rows"`
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// HealthSettings model : when the customers of an organization count as at risk. Owners are
+// alerted when a health score falls below AlertBelow, or drops by DropPoints within DropDays.
+// Tickets open or resolved after more than SlaHours breach the SLA.
+type HealthSettings struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	OrgId      string             `bson:"org_id" json:"org_id"`
+	AlertBelow int                `bson:"alert_below" json:"alert_below" validate:"min=1,max=100"`
+	DropPoints int                `bson:"drop_points" json:"drop_points" validate:"min=1,max=100"`
+	DropDays   int                `bson:"drop_days" json:"drop_days" validate:"min=1,max=90"`
+	SlaHours   int                `bson:"sla_hours" json:"sla_hours" validate:"min=1,max=2160"`
+	UpdatedBy  string             `bson:"updated_by,omitempty" json:"updated_by,omitempty"`
+	UpdatedAt  time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// HealthScore model : health score of a customer on one day, with what made it up
+type HealthScore struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	OrgId      string             `bson:"org_id" json:"org_id"`
+	CustomerId string             `bson:"customer_id" json:"customer_id"`
+	Day        string             `bson:"day" json:"day"`
+	Score      int                `bson:"score" json:"score"`
+	Factors    []HealthFactor     `bson:"factors" json:"factors"`
+	ComputedAt time.Time          `bson:"computed_at" json:"computed_at"`
+}
+
+// HealthFactor : one signal of a health score, what was measured and the points it cost.
+// Value is nil when there is nothing to measure, such as a customer never contacted.
+type HealthFactor struct {
+	Factor  string   `bson:"factor" json:"factor"`
+	Value   *float64 `bson:"value" json:"value"`
+	Penalty int      `bson:"penalty" json:"penalty"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ImportMapping model : Saved column mapping profile, renames the headers of an import file to export column names
+type ImportMapping struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	MappingId string             `bson:"mapping_id" json:"mapping_id"`
+	OrgId     string             `bson:"org_id" json:"org_id"`
+	Name      *string            `bson:"name" json:"name" validate:"required"`
+	Resource  string             `bson:"resource" json:"resource" validate:"required,oneof=customers users tickets interactions"`
+	Columns   map[string]string  `bson:"columns" json:"columns" validate:"required,min=1"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
+}
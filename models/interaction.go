@@ -8,13 +8,17 @@ import (
 
 // Interaction model : Interaction/Meet related fields
 type Interaction struct {
-	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	InteractionId string             `bson:"interaction_id" json:"interaction_id"`
-	UserID        primitive.ObjectID `bson:"user_id" json:"user_id"`
-	CustomerID    primitive.ObjectID `bson:"customer_id" json:"customer_id"`
-	Title         *string            `bson:"title,omitempty" json:"title,omitempty"`
-	Description   *string            `bson:"description" json:"description"`
-	StartTime     time.Time          `bson:"start_time,omitempty" json:"start_time,omitempty"`
-	CreatedAt     time.Time          `bson:"created_at" json:"created_at"`
-	UpdatedAt     time.Time          `bson:"updated_at" json:"updated_at"`
+	ID            primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
+	InteractionId string                 `bson:"interaction_id" json:"interaction_id"`
+	OrgId         string                 `bson:"org_id" json:"org_id"`
+	UserID        primitive.ObjectID     `bson:"user_id" json:"user_id"`
+	CustomerID    primitive.ObjectID     `bson:"customer_id" json:"customer_id"`
+	Title         *string                `bson:"title,omitempty" json:"title,omitempty"`
+	Description   *string                `bson:"description" json:"description"`
+	StartTime     time.Time              `bson:"start_time,omitempty" json:"start_time,omitempty"`
+	CustomFields  map[string]interface{} `bson:"custom_fields,omitempty" json:"custom_fields,omitempty"`
+	CreatedAt     time.Time              `bson:"created_at" json:"created_at"`
+	UpdatedAt     time.Time              `bson:"updated_at" json:"updated_at"`
+	DeletedAt     *time.Time             `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+	DeletedBy     string                 `bson:"deleted_by,omitempty" json:"deleted_by,omitempty"`
 }
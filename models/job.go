@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Job model : Import or export running in the background, with its progress and result file,
+// stored in GridFS under ResultId
+type Job struct {
+	ID          primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
+	JobId       string                 `bson:"job_id" json:"job_id"`
+	OrgId       string                 `bson:"org_id" json:"org_id"`
+	Kind        string                 `bson:"kind" json:"kind"`
+	Resource    string                 `bson:"resource" json:"resource"`
+	Format      string                 `bson:"format" json:"format"`
+	Status      string                 `bson:"status" json:"status"`
+	Processed   int64                  `bson:"processed" json:"processed"`
+	Errors      int64                  `bson:"errors" json:"errors"`
+	Summary     map[string]interface{} `bson:"summary,omitempty" json:"summary,omitempty"`
+	Error       string                 `bson:"error,omitempty" json:"error,omitempty"`
+	ResultId    *primitive.ObjectID    `bson:"result_id,omitempty" json:"-"`
+	ResultName  string                 `bson:"result_name,omitempty" json:"result_name,omitempty"`
+	Owner       string                 `bson:"owner,omitempty" json:"-"`
+	CreatedBy   string                 `bson:"created_by" json:"created_by"`
+	CreatedAt   time.Time              `bson:"created_at" json:"created_at"`
+	StartedAt   *time.Time             `bson:"started_at,omitempty" json:"started_at,omitempty"`
+	FinishedAt  *time.Time             `bson:"finished_at,omitempty" json:"finished_at,omitempty"`
+	HeartbeatAt *time.Time             `bson:"heartbeat_at,omitempty" json:"heartbeat_at,omitempty"`
+	ExpiresAt   *time.Time             `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+}
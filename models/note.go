@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Note model : Staff note in markdown on a customer, account or deal, never shown to customers.
+// Team notes are shared with the team the author was in when writing it (AuthorTeam).
+type Note struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	NoteId     string             `bson:"note_id" json:"note_id"`
+	OrgId      string             `bson:"org_id" json:"org_id"`
+	Resource   string             `bson:"resource" json:"resource" validate:"required,oneof=customers accounts deals"`
+	ResourceId string             `bson:"resource_id" json:"resource_id" validate:"required"`
+	Body       *string            `bson:"body" json:"body" validate:"required,min=1,max=20000"`
+	AuthorId   string             `bson:"author_id" json:"author_id"`
+	AuthorTeam string             `bson:"author_team,omitempty" json:"author_team,omitempty"`
+	Visibility string             `bson:"visibility" json:"visibility" validate:"omitempty,oneof=private team staff"`
+	Pinned     bool               `bson:"pinned" json:"pinned"`
+	History    []NoteVersion      `bson:"history,omitempty" json:"history,omitempty"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt  time.Time          `bson:"updated_at" json:"updated_at"`
+	Version    int64              `bson:"version" json:"version"`
+	DeletedAt  *time.Time         `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+	DeletedBy  string             `bson:"deleted_by,omitempty" json:"deleted_by,omitempty"`
+}
+
+// NoteVersion : earlier body of an edited note
+type NoteVersion struct {
+	Body     string    `bson:"body" json:"body"`
+	EditedBy string    `bson:"edited_by" json:"edited_by"`
+	EditedAt time.Time `bson:"edited_at" json:"edited_at"`
+}
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Notification model : in-app message to a user about a record that needs their attention
+type Notification struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	NotificationId string             `bson:"notification_id" json:"notification_id"`
+	OrgId          string             `bson:"org_id" json:"org_id"`
+	UserId         string             `bson:"user_id" json:"user_id"`
+	Kind           string             `bson:"kind" json:"kind"`
+	Resource       string             `bson:"resource" json:"resource"`
+	ResourceId     string             `bson:"resource_id" json:"resource_id"`
+	Title          string             `bson:"title" json:"title"`
+	Body           string             `bson:"body" json:"body"`
+	ReadAt         *time.Time         `bson:"read_at,omitempty" json:"read_at,omitempty"`
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Organization model : Tenant that owns users, customers, interactions and tickets
+type Organization struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	OrgId     string             `bson:"org_id" json:"org_id"`
+	Name      *string            `bson:"name" json:"name" validate:"required"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
+	Version   int64              `bson:"version" json:"version"`
+	DeletedAt *time.Time         `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+	DeletedBy string             `bson:"deleted_by,omitempty" json:"deleted_by,omitempty"`
+}
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Pipeline model : ordered stages a deal moves through, configured per organization
+type Pipeline struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	PipelineId  string             `bson:"pipeline_id" json:"pipeline_id"`
+	OrgId       string             `bson:"org_id" json:"org_id"`
+	Name        *string            `bson:"name" json:"name" validate:"required,max=100"`
+	Stages      []PipelineStage    `bson:"stages" json:"stages" validate:"required,min=1,max=50,dive"`
+	IsDefault   bool               `bson:"is_default" json:"is_default"`
+	WonReasons  []string           `bson:"won_reasons,omitempty" json:"won_reasons,omitempty"`
+	LostReasons []string           `bson:"lost_reasons,omitempty" json:"lost_reasons,omitempty"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at"`
+	Version     int64              `bson:"version" json:"version"`
+	DeletedAt   *time.Time         `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+	DeletedBy   string             `bson:"deleted_by,omitempty" json:"deleted_by,omitempty"`
+}
+
+// PipelineStage : step of a pipeline, won and lost stages close the deals moved into them
+type PipelineStage struct {
+	StageId     string `bson:"stage_id" json:"stage_id"`
+	Name        string `bson:"name" json:"name" validate:"required,max=100"`
+	Type        string `bson:"type" json:"type" validate:"omitempty,oneof=open won lost"`
+	Probability int    `bson:"probability" json:"probability" validate:"min=0,max=100"`
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ScoringRule model : Points a customer gets towards its lead score while it matches the rule.
+// A match rule tests the customer like a segment rule, an interactions rule counts its recent
+// interactions and an open_tickets rule its open tickets. Points of activity rules halve every
+// HalfLifeDays since the customer's latest counted interaction or ticket.
+type ScoringRule struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	RuleId       string             `bson:"rule_id" json:"rule_id"`
+	OrgId        string             `bson:"org_id" json:"org_id"`
+	Name         *string            `bson:"name" json:"name" validate:"required,max=100"`
+	Kind         string             `bson:"kind" json:"kind" validate:"required,oneof=match interactions open_tickets"`
+	Condition    *SegmentRule       `bson:"condition,omitempty" json:"condition,omitempty"`
+	MinCount     int64              `bson:"min_count,omitempty" json:"min_count,omitempty" validate:"min=0"`
+	WithinDays   int                `bson:"within_days,omitempty" json:"within_days,omitempty" validate:"min=0,max=3650"`
+	Points       int                `bson:"points" json:"points" validate:"required,min=-1000,max=1000"`
+	HalfLifeDays int                `bson:"half_life_days,omitempty" json:"half_life_days,omitempty" validate:"min=0,max=3650"`
+	Enabled      *bool              `bson:"enabled" json:"enabled"`
+	CreatedBy    string             `bson:"created_by" json:"created_by"`
+	CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt    time.Time          `bson:"updated_at" json:"updated_at"`
+	Version      int64              `bson:"version" json:"version"`
+	DeletedAt    *time.Time         `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+	DeletedBy    string             `bson:"deleted_by,omitempty" json:"deleted_by,omitempty"`
+}
+
+// ScoreLine : what one scoring rule gave a customer
+type ScoreLine struct {
+	RuleId       string     `json:"rule_id"`
+	Name         string     `json:"name"`
+	Kind         string     `json:"kind"`
+	Matched      bool       `json:"matched"`
+	Count        int64      `json:"count,omitempty"`
+	LastActivity *time.Time `json:"last_activity,omitempty"`
+	Points       int        `json:"points"`
+	Awarded      int        `json:"awarded"`
+}
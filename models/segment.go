@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Segment model : Saved group of customers defined by a rule, its members are evaluated on demand
+type Segment struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	SegmentId   string             `bson:"segment_id" json:"segment_id"`
+	OrgId       string             `bson:"org_id" json:"org_id"`
+	Name        *string            `bson:"name" json:"name" validate:"required,max=100"`
+	Description *string            `bson:"description,omitempty" json:"description,omitempty"`
+	Rule        *SegmentRule       `bson:"rule" json:"rule" validate:"required"`
+	CreatedBy   string             `bson:"created_by" json:"created_by"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at"`
+	Version     int64              `bson:"version" json:"version"`
+	DeletedAt   *time.Time         `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+	DeletedBy   string             `bson:"deleted_by,omitempty" json:"deleted_by,omitempty"`
+}
+
+// SegmentRule : condition of a segment, either a group matching all or any of its rules,
+// or one test of a customer field, tag, custom field or activity
+type SegmentRule struct {
+	All   []SegmentRule `bson:"all,omitempty" json:"all,omitempty"`
+	Any   []SegmentRule `bson:"any,omitempty" json:"any,omitempty"`
+	Field string        `bson:"field,omitempty" json:"field,omitempty"`
+	Op    string        `bson:"op,omitempty" json:"op,omitempty"`
+	Value interface{}   `bson:"value,omitempty" json:"value,omitempty"`
+}
@@ -8,12 +8,18 @@ import (
 
 // Ticket model : Ticket related fields
 type Ticket struct {
-	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	TicketId      string             `bson:"ticket_id" json:"ticket_id"`
-	InteractionID primitive.ObjectID `bson:"interaction_id" json:"interaction_id"`
-	CustomerID    primitive.ObjectID `bson:"customer_id" json:"customer_id"`
-	Status        *string            `bson:"status" json:"status" validate:"required,eq=open|eq=in_progress|eq=resolved|eq=closed"`
-	Description   *string            `bson:"description" json:"description"`
-	CreatedAt     time.Time          `bson:"created_at" json:"created_at"`
-	UpdatedAt     time.Time          `bson:"updated_at" json:"updated_at"`
+	ID            primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
+	TicketId      string                 `bson:"ticket_id" json:"ticket_id"`
+	OrgId         string                 `bson:"org_id" json:"org_id"`
+	InteractionID primitive.ObjectID     `bson:"interaction_id" json:"interaction_id"`
+	CustomerID    primitive.ObjectID     `bson:"customer_id" json:"customer_id"`
+	Status        *string                `bson:"status" json:"status" validate:"required,eq=open|eq=in_progress|eq=resolved|eq=closed"`
+	Description   *string                `bson:"description" json:"description"`
+	CustomFields  map[string]interface{} `bson:"custom_fields,omitempty" json:"custom_fields,omitempty"`
+	CreatedAt     time.Time              `bson:"created_at" json:"created_at"`
+	UpdatedAt     time.Time              `bson:"updated_at" json:"updated_at"`
+	ResolvedAt    *time.Time             `bson:"resolved_at,omitempty" json:"resolved_at,omitempty"`
+	Version       int64                  `bson:"version" json:"version"`
+	DeletedAt     *time.Time             `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+	DeletedBy     string                 `bson:"deleted_by,omitempty" json:"deleted_by,omitempty"`
 }
@@ -0,0 +1,22 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	controller "github.com/nirmal/crm/controllers"
+)
+
+// AccountRoutes - account (company) routes, with the customers linked to them as contacts
+func AccountRoutes(accountRoutes *gin.Engine) {
+	accountRoutes.POST("/accounts", controller.CreateAccount())
+	accountRoutes.GET("/accounts", controller.GetAccounts())
+	accountRoutes.GET("/accounts/:account_id", controller.GetAccount())
+	accountRoutes.PUT("/accounts/:account_id", controller.UpdateAccount())
+	accountRoutes.PATCH("/accounts/:account_id", controller.UpdateAccount())
+	accountRoutes.DELETE("/accounts/:account_id", controller.DeleteAccount())
+
+	// contacts and roll-ups
+	accountRoutes.GET("/accounts/:account_id/contacts", controller.GetAccountContacts())
+	accountRoutes.POST("/accounts/:account_id/contacts", controller.LinkAccountContacts())
+	accountRoutes.DELETE("/accounts/:account_id/contacts/:customer_id", controller.UnlinkAccountContact())
+	accountRoutes.GET("/accounts/:account_id/summary", controller.GetAccountSummary())
+}
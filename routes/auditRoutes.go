@@ -0,0 +1,11 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	controller "github.com/nirmal/crm/controllers"
+)
+
+// AuditRoutes - admin query API over the audit trail
+func AuditRoutes(auditRoutes *gin.Engine) {
+	auditRoutes.GET("/audit_logs", controller.GetAuditLogs())
+}
@@ -0,0 +1,12 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	controller "github.com/nirmal/crm/controllers"
+)
+
+// BackupRoutes - admin route to download a backup archive, super admin route to restore one
+func BackupRoutes(backupRoutes *gin.Engine) {
+	backupRoutes.GET("/backup", controller.Backup())
+	backupRoutes.POST("/restore", controller.Restore())
+}
@@ -0,0 +1,14 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	controller "github.com/nirmal/crm/controllers"
+)
+
+// CustomFieldRoutes - admin routes to define the custom fields of customers, tickets and interactions
+func CustomFieldRoutes(customFieldRoutes *gin.Engine) {
+	customFieldRoutes.POST("/custom_fields", controller.CreateCustomField())
+	customFieldRoutes.GET("/custom_fields", controller.GetCustomFields())
+	customFieldRoutes.PUT("/custom_fields/:field_id", controller.UpdateCustomField())
+	customFieldRoutes.DELETE("/custom_fields/:field_id", controller.DeleteCustomField())
+}
@@ -8,19 +8,25 @@ import (
 
 // CustomerRoutes - routes for customer
 func CustomerRoutes(customerRoutes *gin.Engine) {
+	// customers read themselves and staff any customer of their organization, so these
+	// routes are registered before the customer only middleware
+	customerRoutes.GET("/customers/:customer_id", middleware.AuthenticateUserOrCustomer(), controller.GetCustomer())
+	// listing customers is for admins, a customer token must not reach it as a customer
+	customerRoutes.GET("/customers", middleware.AuthenticateUserOrCustomer(), controller.GetAllCustomers())
+
     // middleware to authenticate customer
 	customerRoutes.Use(middleware.AuthenticateCustomer())
 
 	// customer operations
-	customerRoutes.GET("/customers", controller.GetAllCustomers())
-	customerRoutes.GET("/customers/:cust_id", controller.GetCustomer())
-	customerRoutes.PUT("/customers/:cust_id", controller.UpdateCustomer())
-	customerRoutes.DELETE("/customers/:cust_id", controller.DeleteCustomer())
+	customerRoutes.PUT("/customers/:customer_id", controller.UpdateCustomer())
+	customerRoutes.PATCH("/customers/:customer_id", controller.UpdateCustomer())
+	customerRoutes.DELETE("/customers/:customer_id", controller.DeleteCustomer())
 
 	// get all tickets
 	customerRoutes.GET("/customers/tickets/", controller.GetAllTickets())
 	customerRoutes.POST("/customers/ticket/:interaction_id", controller.CreateTicket())
 	customerRoutes.GET("/customers/ticket/:user_id", controller.GetTicketsByUserID())
 	customerRoutes.PUT("/customers/ticket/:ticket_id", controller.UpdateTicket())
+	customerRoutes.PATCH("/customers/ticket/:ticket_id", controller.UpdateTicket())
 	customerRoutes.DELETE("/customers/ticket/:ticket_id", controller.DeleteTicket())
 }
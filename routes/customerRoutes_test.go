@@ -0,0 +1,58 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	helper "github.com/nirmal/crm/helpers"
+	"github.com/nirmal/crm/utils"
+)
+
+func TestGetCustomersIsForAdmins(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	// docker-compose signs staff and customer tokens with the same key
+	userKey, customerKey := helper.USER_SECRET_KEY, helper.CUSTOMER_SECRET_KEY
+	helper.USER_SECRET_KEY, helper.CUSTOMER_SECRET_KEY = "secret", "secret"
+	defer func() { helper.USER_SECRET_KEY, helper.CUSTOMER_SECRET_KEY = userKey, customerKey }()
+
+	customerToken, err := helper.GenerateCustomerToken("jane@example.com", "Jane", "c1", "org1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	userToken, err := helper.GenerateUserToken("agent@example.com", "Agent", "u1", utils.ROLE_USER, "org1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	CustomerRoutes(router)
+
+	tests := []struct {
+		name   string
+		url    string
+		token  string
+		status int
+	}{
+		{"customer token", "/customers", customerToken, http.StatusBadRequest},
+		{"customer token filtering by segment", "/customers?segment=s1", customerToken, http.StatusBadRequest},
+		{"customer token filtering by tag", "/customers?tag=vip", customerToken, http.StatusBadRequest},
+		{"customer token filtering by custom field", "/customers?cf.plan=pro", customerToken, http.StatusBadRequest},
+		{"user token", "/customers", userToken, http.StatusBadRequest},
+		{"no token", "/customers", "", http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			if tt.token != "" {
+				req.Header.Set("token", tt.token)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d (%s)", w.Code, tt.status, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	controller "github.com/nirmal/crm/controllers"
+)
+
+// DealRoutes - sales pipelines (configured by admins) and the deals moving through them
+func DealRoutes(dealRoutes *gin.Engine) {
+	dealRoutes.POST("/pipelines", controller.CreatePipeline())
+	dealRoutes.GET("/pipelines", controller.GetPipelines())
+	dealRoutes.GET("/pipelines/:pipeline_id", controller.GetPipeline())
+	dealRoutes.PUT("/pipelines/:pipeline_id", controller.UpdatePipeline())
+	dealRoutes.DELETE("/pipelines/:pipeline_id", controller.DeletePipeline())
+
+	dealRoutes.POST("/deals", controller.CreateDeal())
+	dealRoutes.GET("/deals", controller.GetDeals())
+	dealRoutes.GET("/deals/board", controller.GetDealBoard())
+	dealRoutes.GET("/deals/close_reasons", controller.GetDealCloseReasons())
+	dealRoutes.GET("/deals/:deal_id", controller.GetDeal())
+	dealRoutes.PATCH("/deals/:deal_id", controller.UpdateDeal())
+	dealRoutes.DELETE("/deals/:deal_id", controller.DeleteDeal())
+}
@@ -0,0 +1,14 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	controller "github.com/nirmal/crm/controllers"
+)
+
+// DuplicateRoutes - suspected duplicate customers, dismissing them and merging them
+func DuplicateRoutes(duplicateRoutes *gin.Engine) {
+	duplicateRoutes.GET("/customers/duplicates", controller.GetDuplicates())
+	duplicateRoutes.POST("/customers/duplicates/dismiss", controller.DismissDuplicate())
+	duplicateRoutes.GET("/customers/:customer_id/duplicates", controller.GetCustomerDuplicates())
+	duplicateRoutes.POST("/customers/merge", controller.MergeCustomers())
+}
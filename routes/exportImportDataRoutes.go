@@ -9,4 +9,29 @@ func DataExpImportRoutes(dataExpImportRoutes *gin.Engine) {
 	// export data in csv or json format
 	dataExpImportRoutes.GET("/export/customer_data", controller.ExportCustomerData())
 	dataExpImportRoutes.POST("/import/customer_data", controller.ImportCustomerData())
+	dataExpImportRoutes.GET("/export/user_data", controller.ExportUserData())
+	dataExpImportRoutes.POST("/import/user_data", controller.ImportUserData())
+	dataExpImportRoutes.GET("/export/ticket_data", controller.ExportTicketData())
+	dataExpImportRoutes.POST("/import/ticket_data", controller.ImportTicketData())
+	dataExpImportRoutes.GET("/export/interaction_data", controller.ExportInteractionData())
+	dataExpImportRoutes.POST("/import/interaction_data", controller.ImportInteractionData())
+	dataExpImportRoutes.GET("/export/account_data", controller.ExportAccountData())
+	dataExpImportRoutes.POST("/import/account_data", controller.ImportAccountData())
+	dataExpImportRoutes.GET("/export/workbook", controller.ExportWorkbook())
+	dataExpImportRoutes.GET("/export/customers/:customer_id/vcard", controller.ExportCustomerVCard())
+
+	// recurring exports to the export directory
+	dataExpImportRoutes.POST("/export/schedules", controller.CreateExportSchedule())
+	dataExpImportRoutes.GET("/export/schedules", controller.GetExportSchedules())
+	dataExpImportRoutes.PUT("/export/schedules/:schedule_id", controller.UpdateExportSchedule())
+	dataExpImportRoutes.DELETE("/export/schedules/:schedule_id", controller.DeleteExportSchedule())
+	dataExpImportRoutes.GET("/export/schedules/:schedule_id/runs", controller.GetExportRuns())
+
+	// saved column mappings for imports
+	dataExpImportRoutes.POST("/import/mappings", controller.CreateImportMapping())
+	dataExpImportRoutes.GET("/import/mappings", controller.GetImportMappings())
+	dataExpImportRoutes.DELETE("/import/mappings/:mapping_id", controller.DeleteImportMapping())
+
+	// built in mappings for other CRMs' exports
+	dataExpImportRoutes.GET("/import/presets", controller.GetImportPresets())
 }
\ No newline at end of file
@@ -0,0 +1,18 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	controller "github.com/nirmal/crm/controllers"
+)
+
+// ForecastRoutes - revenue forecast from the deal pipeline, quotas and weekly forecast snapshots
+func ForecastRoutes(forecastRoutes *gin.Engine) {
+	forecastRoutes.GET("/forecast", controller.GetForecast())
+
+	forecastRoutes.POST("/forecast/quotas", controller.SetQuota())
+	forecastRoutes.GET("/forecast/quotas", controller.GetQuotas())
+	forecastRoutes.DELETE("/forecast/quotas/:quota_id", controller.DeleteQuota())
+
+	forecastRoutes.POST("/forecast/snapshots", controller.TakeForecastSnapshot())
+	forecastRoutes.GET("/forecast/snapshots", controller.GetForecastDrift())
+}
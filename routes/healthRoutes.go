@@ -0,0 +1,16 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	controller "github.com/nirmal/crm/controllers"
+)
+
+// HealthRoutes - customer health scores, their alert settings and the notifications they raise
+func HealthRoutes(healthRoutes *gin.Engine) {
+	healthRoutes.GET("/health/settings", controller.GetHealthSettings())
+	healthRoutes.PUT("/health/settings", controller.SetHealthSettings())
+	healthRoutes.POST("/health/recompute", controller.RecomputeHealth())
+	healthRoutes.GET("/customers/:customer_id/health", controller.GetCustomerHealth())
+	healthRoutes.GET("/notifications", controller.GetNotifications())
+	healthRoutes.POST("/notifications/:notification_id/read", controller.ReadNotification())
+}
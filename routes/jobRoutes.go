@@ -0,0 +1,14 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	controller "github.com/nirmal/crm/controllers"
+)
+
+// JobRoutes - admin routes to follow, download and cancel background imports and exports
+func JobRoutes(jobRoutes *gin.Engine) {
+	jobRoutes.GET("/jobs", controller.GetJobs())
+	jobRoutes.GET("/jobs/:job_id", controller.GetJob())
+	jobRoutes.GET("/jobs/:job_id/result", controller.GetJobResult())
+	jobRoutes.POST("/jobs/:job_id/cancel", controller.CancelJob())
+}
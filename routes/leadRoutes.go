@@ -0,0 +1,15 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	controller "github.com/nirmal/crm/controllers"
+)
+
+// LeadRoutes - leads, their lifecycle stage and conversion into customers
+func LeadRoutes(leadRoutes *gin.Engine) {
+	leadRoutes.POST("/leads", controller.CreateLead())
+	leadRoutes.GET("/leads", controller.GetLeads())
+	leadRoutes.GET("/leads/funnel", controller.GetLifecycleFunnel())
+	leadRoutes.PATCH("/leads/:customer_id/lifecycle", controller.UpdateLifecycle())
+	leadRoutes.POST("/leads/:customer_id/convert", controller.ConvertLead())
+}
@@ -0,0 +1,15 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	controller "github.com/nirmal/crm/controllers"
+)
+
+// NoteRoutes - staff notes on customers, accounts and deals
+func NoteRoutes(noteRoutes *gin.Engine) {
+	noteRoutes.POST("/notes", controller.CreateNote())
+	noteRoutes.GET("/notes", controller.GetNotes())
+	noteRoutes.GET("/notes/:note_id", controller.GetNote())
+	noteRoutes.PATCH("/notes/:note_id", controller.UpdateNote())
+	noteRoutes.DELETE("/notes/:note_id", controller.DeleteNote())
+}
@@ -0,0 +1,15 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	controller "github.com/nirmal/crm/controllers"
+)
+
+// OrganizationRoutes - tenant management routes, for the super admin
+func OrganizationRoutes(organizationRoutes *gin.Engine) {
+	organizationRoutes.POST("/orgs", controller.CreateOrganization())
+	organizationRoutes.GET("/orgs", controller.GetOrganizations())
+	organizationRoutes.GET("/orgs/:org_id", controller.GetOrganization())
+	organizationRoutes.PUT("/orgs/:org_id", controller.UpdateOrganization())
+	organizationRoutes.DELETE("/orgs/:org_id", controller.DeleteOrganization())
+}
@@ -0,0 +1,17 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	controller "github.com/nirmal/crm/controllers"
+)
+
+// ScoringRoutes - lead scoring rules, recomputing scores and score breakdowns
+func ScoringRoutes(scoringRoutes *gin.Engine) {
+	scoringRoutes.POST("/scoring/rules", controller.CreateScoringRule())
+	scoringRoutes.GET("/scoring/rules", controller.GetScoringRules())
+	scoringRoutes.GET("/scoring/rules/:rule_id", controller.GetScoringRule())
+	scoringRoutes.PATCH("/scoring/rules/:rule_id", controller.UpdateScoringRule())
+	scoringRoutes.DELETE("/scoring/rules/:rule_id", controller.DeleteScoringRule())
+	scoringRoutes.POST("/scoring/recompute", controller.RecomputeScores())
+	scoringRoutes.GET("/customers/:customer_id/score", controller.GetCustomerScore())
+}
@@ -0,0 +1,22 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	controller "github.com/nirmal/crm/controllers"
+)
+
+// SegmentRoutes - customer tags and the saved segments of customers
+func SegmentRoutes(segmentRoutes *gin.Engine) {
+	segmentRoutes.GET("/customers/tags", controller.GetCustomerTags())
+	segmentRoutes.POST("/customers/tag", controller.TagCustomers())
+	segmentRoutes.POST("/customers/untag", controller.UntagCustomers())
+
+	segmentRoutes.POST("/segments", controller.CreateSegment())
+	segmentRoutes.GET("/segments", controller.GetSegments())
+	segmentRoutes.POST("/segments/preview", controller.PreviewSegment())
+	segmentRoutes.GET("/segments/:segment_id", controller.GetSegment())
+	segmentRoutes.PATCH("/segments/:segment_id", controller.UpdateSegment())
+	segmentRoutes.DELETE("/segments/:segment_id", controller.DeleteSegment())
+	segmentRoutes.GET("/segments/:segment_id/customers", controller.GetSegmentCustomers())
+	segmentRoutes.POST("/segments/:segment_id/email", controller.SendSegmentEmail())
+}
@@ -0,0 +1,12 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	controller "github.com/nirmal/crm/controllers"
+)
+
+// TrashRoutes - admin routes to inspect and restore soft deleted records
+func TrashRoutes(trashRoutes *gin.Engine) {
+	trashRoutes.GET("/trash/:resource", controller.GetTrash())
+	trashRoutes.POST("/trash/:resource/:id/restore", controller.RestoreFromTrash())
+}
@@ -13,8 +13,10 @@ func UserRoutes(userRoutes *gin.Engine) {
 
 	// user operations
 	userRoutes.GET("/users", controller.GetUsers())
+	userRoutes.POST("/users", controller.CreateUser())
 	userRoutes.GET("/users/:user_id", controller.GetUser())
 	userRoutes.PUT("/users/:user_id", controller.UpdateUser())
+	userRoutes.PATCH("/users/:user_id", controller.UpdateUser())
 	userRoutes.DELETE("/users/:user_id", controller.DeleteUser())
 
 	// get all interactions, 
@@ -24,7 +26,7 @@ func UserRoutes(userRoutes *gin.Engine) {
 	userRoutes.GET("/user/meetings/", controller.GetInteractionsByUserID())
 
 	// get all interactions by customer id
-	userRoutes.POST("/users/meetings/:cust_id", controller.CreateInteractionAndSendEmail())
+	userRoutes.POST("/users/meetings/:customer_id", controller.CreateInteractionAndSendEmail())
 
 	// delete interaction by meet id
 	userRoutes.DELETE("/users/meetings/:interaction_id", controller.DeleteInteraction())
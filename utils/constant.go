@@ -2,10 +2,119 @@ package utils
 
 // Constants
 const (
-	ROLE_ADMIN  = "ADMIN"
-	ROLE_USER   = "USER"
-	CUSTOMER_ID = "customer_id"
-	USER_ID     = "user_id"
-	RATE_LIMIT  = 1
-	BURST_LIMIT = 5
+	ROLE_SUPER_ADMIN = "SUPER_ADMIN"
+	ROLE_ADMIN       = "ADMIN"
+	ROLE_USER        = "USER"
+	CUSTOMER_ID      = "customer_id"
+	USER_ID          = "user_id"
+	ORG_ID           = "org_id"
+	ACCOUNT_ID       = "account_id"
+	REQUEST_ID       = "request_id"
+	RATE_LIMIT       = 1
+	BURST_LIMIT      = 5
+)
+
+// Audit actions
+const (
+	ACTION_CREATE  = "create"
+	ACTION_UPDATE  = "update"
+	ACTION_DELETE  = "delete"
+	ACTION_LOGIN   = "login"
+	ACTION_EXPORT  = "export"
+	ACTION_IMPORT  = "import"
+	ACTION_RESTORE = "restore"
+	ACTION_EMAIL   = "email"
+	ACTION_MERGE   = "merge"
+)
+
+// Audit actor types and resources
+const (
+	ACTOR_USER     = "user"
+	ACTOR_CUSTOMER = "customer"
+	ACTOR_SCHEDULE = "schedule"
+
+	RESOURCE_CUSTOMERS     = "customers"
+	RESOURCE_USERS         = "users"
+	RESOURCE_TICKETS       = "tickets"
+	RESOURCE_INTERACTIONS  = "interactions"
+	RESOURCE_ORGS          = "organizations"
+	RESOURCE_BACKUP        = "backup"
+	RESOURCE_SCHEDULES     = "export_schedules"
+	RESOURCE_FIELDS        = "custom_fields"
+	RESOURCE_ACCOUNTS      = "accounts"
+	RESOURCE_PIPELINES     = "pipelines"
+	RESOURCE_DEALS         = "deals"
+	RESOURCE_QUOTAS        = "quotas"
+	RESOURCE_SEGMENTS      = "segments"
+	RESOURCE_NOTES         = "notes"
+	RESOURCE_SCORING       = "scoring_rules"
+	RESOURCE_HEALTH        = "health_settings"
+	RESOURCE_HEALTH_SCORES = "health_scores"
+	RESOURCE_NOTIFICATIONS = "notifications"
+)
+
+// Deal statuses, set by the type of the stage a deal is in
+const (
+	DEAL_OPEN = "open"
+	DEAL_WON  = "won"
+	DEAL_LOST = "lost"
+)
+
+// Lifecycle stages of customers, from lead to churned
+const (
+	LIFECYCLE_LEAD     = "lead"
+	LIFECYCLE_MQL      = "mql"
+	LIFECYCLE_SQL      = "sql"
+	LIFECYCLE_CUSTOMER = "customer"
+	LIFECYCLE_CHURNED  = "churned"
+)
+
+// Notification kinds
+const (
+	NOTIFY_HEALTH_BELOW = "health_below"
+	NOTIFY_HEALTH_DROP  = "health_drop"
+)
+
+// Note visibilities
+const (
+	NOTE_PRIVATE = "private"
+	NOTE_TEAM    = "team"
+	NOTE_STAFF   = "staff"
+)
+
+// Delete policies for records that reference a deleted customer or user
+const (
+	POLICY_RESTRICT    = "restrict"
+	POLICY_CASCADE     = "cascade"
+	POLICY_REASSIGN    = "reassign"
+	POLICY_SOFT_DELETE = "soft_delete"
+)
+
+// Import modes for rows that match an existing record
+const (
+	IMPORT_INSERT_ONLY     = "insert_only"
+	IMPORT_UPDATE_EXISTING = "update_existing"
+	IMPORT_UPSERT          = "upsert"
+)
+
+// Merge rules for imported values of fields that already have a value
+const (
+	MERGE_OVERWRITE     = "overwrite"
+	MERGE_KEEP_EXISTING = "keep_existing"
+	MERGE_FILL_BLANKS   = "fill_blanks"
+)
+
+// Background job kinds and states
+const (
+	JOB_IMPORT  = "import"
+	JOB_EXPORT  = "export"
+	JOB_EMAIL   = "email"
+	JOB_SCORING = "scoring"
+	JOB_HEALTH  = "health"
+
+	JOB_QUEUED    = "queued"
+	JOB_RUNNING   = "running"
+	JOB_COMPLETED = "completed"
+	JOB_FAILED    = "failed"
+	JOB_CANCELLED = "cancelled"
 )
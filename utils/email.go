@@ -11,19 +11,6 @@ import (
 
 
 func SendInteractionNotificationWithEmail(interaction models.Interaction, emailTo, meetingStartTime string) error {
-	host := os.Getenv("SMTP_HOST")
-	port := os.Getenv("SMTP_PORT")
-	from := os.Getenv("SMTP_MAIL")
-	password := os.Getenv("SMTP_PASSWORD")
-
-	//	example@example.com		EXAMPLE_PASSWORD	smtp.example.com
-	auth := smtp.PlainAuth(
-		"",
-		from,
-		password,
-		host,
-	)
-
 	subject := fmt.Sprintf("Meeting Notification: %s", *interaction.Title)
 
 	body := fmt.Sprintf(`
@@ -83,6 +70,24 @@ func SendInteractionNotificationWithEmail(interaction models.Interaction, emailT
 	// subject := "Ticket Created: " + *interaction.Title
 	// body := fmt.Sprintf("Dear User,\n\nYour Interaction with ID %s has been created.\n\nDetails:\nDescription: %s\n\nThank you,\nSupport Team", interaction.CustomerID, *interaction.Title, *interaction.Description)
 
+	return SendEmail(emailTo, subject, body)
+}
+
+// SendEmail : send an HTML email to emailTo through the SMTP server of SMTP_HOST and SMTP_PORT
+func SendEmail(emailTo, subject, body string) error {
+	host := os.Getenv("SMTP_HOST")
+	port := os.Getenv("SMTP_PORT")
+	from := os.Getenv("SMTP_MAIL")
+	password := os.Getenv("SMTP_PASSWORD")
+
+	//	example@example.com		EXAMPLE_PASSWORD	smtp.example.com
+	auth := smtp.PlainAuth(
+		"",
+		from,
+		password,
+		host,
+	)
+
 	tlsConfig := &tls.Config{
 		InsecureSkipVerify: true,
 		ServerName:         host,